@@ -35,6 +35,8 @@ type AgentConfiguration struct {
 	Shell                      string
 	Profile                    string
 	RedactedVars               []string
+	RedactedReplacement        string
+	FailOnRedaction            bool
 	AcquireJob                 string
 	TracingBackend             string
 	TracingServiceName         string
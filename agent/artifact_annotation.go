@@ -0,0 +1,81 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/roko"
+)
+
+// DefaultArtifactAnnotationContext is the context of the annotation that
+// uploaded artifacts are listed in, unless another is configured
+const DefaultArtifactAnnotationContext = "artifacts"
+
+// annotate appends a table of the artifacts to the build's annotation, if the
+// uploader has been configured to
+func (a *ArtifactUploader) annotate(ctx context.Context, artifacts []*api.Artifact) error {
+	if !a.conf.Annotate {
+		return nil
+	}
+
+	annotationContext := a.conf.AnnotationContext
+	if annotationContext == "" {
+		annotationContext = DefaultArtifactAnnotationContext
+	}
+
+	annotation := &api.Annotation{
+		Body:    artifactAnnotationBody(artifacts),
+		Style:   "info",
+		Context: annotationContext,
+		Append:  true,
+	}
+
+	// Bound the operation, including its retries, by the client's MaxWait
+	ctx, cancel := a.apiClient.OperationContext(ctx)
+	defer cancel()
+
+	err := roko.NewRetrier(
+		roko.WithMaxAttempts(5),
+		roko.WithStrategy(roko.Constant(1*time.Second)),
+		roko.WithJitter(),
+		roko.WithRand(a.random),
+	).DoWithContext(ctx, func(r *roko.Retrier) error {
+		resp, err := a.apiClient.Annotate(ctx, a.conf.JobID, annotation)
+
+		// Don't bother retrying if the response was one of these statuses
+		if resp != nil && (resp.StatusCode == 401 || resp.StatusCode == 404 || resp.StatusCode == 400) {
+			r.Break()
+			return err
+		}
+		if err != nil {
+			a.logger.Warn("%s (%s)", err, r)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("annotating build with uploaded artifacts: %w", err)
+	}
+
+	a.logger.Debug("Annotated build with %d uploaded artifacts", len(artifacts))
+	return nil
+}
+
+// artifactAnnotationBody renders a Markdown table of the artifacts, linking
+// to each of them
+func artifactAnnotationBody(artifacts []*api.Artifact) string {
+	var b strings.Builder
+	b.WriteString("| Artifact | Size |\n")
+	b.WriteString("| --- | ---: |\n")
+	for _, artifact := range artifacts {
+		// Pipes would end the table cell, brackets the link text, and
+		// spaces and parentheses the link
+		name := strings.NewReplacer("|", `\|`, "[", `\[`, "]", `\]`).Replace(artifact.Path)
+		link := strings.NewReplacer(" ", "%20", "|", "%7C", "(", "%28", ")", "%29").Replace(artifact.Path)
+		fmt.Fprintf(&b, "| [%s](artifact://%s) | %d bytes |\n", name, link, artifact.FileSize)
+	}
+	return b.String()
+}
@@ -0,0 +1,20 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/buildkite/agent/v3/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArtifactAnnotationBody(t *testing.T) {
+	body := artifactAnnotationBody([]*api.Artifact{
+		{Path: "llamas.txt", FileSize: 6},
+		{Path: "reports/a|b [1].xml", FileSize: 1024},
+	})
+
+	assert.Equal(t, "| Artifact | Size |\n"+
+		"| --- | ---: |\n"+
+		"| [llamas.txt](artifact://llamas.txt) | 6 bytes |\n"+
+		"| [reports/a\\|b \\[1\\].xml](artifact://reports/a%7Cb%20[1].xml) | 1024 bytes |\n", body)
+}
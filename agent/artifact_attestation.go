@@ -0,0 +1,201 @@
+package agent
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/buildkite/agent/v3/api"
+)
+
+// DefaultArtifactAttestationName is the path the attestation is uploaded as
+// when Attest is set without an AttestationName
+const DefaultArtifactAttestationName = "attestation.intoto.json"
+
+const (
+	// InTotoStatementType is the _type of an in-toto v1 Statement
+	InTotoStatementType = "https://in-toto.io/Statement/v1"
+
+	// InTotoPayloadType is the DSSE payload type of an in-toto Statement
+	InTotoPayloadType = "application/vnd.in-toto+json"
+
+	// ArtifactUploadPredicateType is the predicate type of the attestations
+	// made by artifact uploads
+	ArtifactUploadPredicateType = "https://buildkite.com/attestations/artifact-upload/v1"
+)
+
+// InTotoStatement is an in-toto v1 Statement, attesting that the subjects
+// were produced by an artifact upload
+type InTotoStatement struct {
+	Type          string                  `json:"_type"`
+	Subject       []InTotoSubject         `json:"subject"`
+	PredicateType string                  `json:"predicateType"`
+	Predicate     ArtifactUploadPredicate `json:"predicate"`
+}
+
+// InTotoSubject is an in-toto v1 ResourceDescriptor for an artifact. Its size
+// is recorded in its annotations, since ResourceDescriptor has no field for it.
+type InTotoSubject struct {
+	Name        string            `json:"name"`
+	Digest      map[string]string `json:"digest"`
+	MediaType   string            `json:"mediaType,omitempty"`
+	Annotations map[string]any    `json:"annotations,omitempty"`
+}
+
+// ArtifactUploadPredicate is the predicate of an artifact upload attestation
+type ArtifactUploadPredicate struct {
+	JobID string `json:"job_id,omitempty"`
+}
+
+// DSSEEnvelope is a Dead Simple Signing Envelope, which an attestation is
+// wrapped in when it's signed
+type DSSEEnvelope struct {
+	PayloadType string          `json:"payloadType"`
+	Payload     []byte          `json:"payload"`
+	Signatures  []DSSESignature `json:"signatures"`
+}
+
+// DSSESignature is a signature in a DSSEEnvelope. KeyID is the hex encoded
+// SHA-256 of the DER encoded (PKIX) public key that verifies it.
+type DSSESignature struct {
+	KeyID string `json:"keyid"`
+	Sig   []byte `json:"sig"`
+}
+
+// NewInTotoStatement returns an in-toto Statement with a subject for each of
+// the artifacts
+func NewInTotoStatement(jobID string, artifacts []*api.Artifact) InTotoStatement {
+	statement := InTotoStatement{
+		Type:          InTotoStatementType,
+		Subject:       make([]InTotoSubject, 0, len(artifacts)),
+		PredicateType: ArtifactUploadPredicateType,
+		Predicate:     ArtifactUploadPredicate{JobID: jobID},
+	}
+	for _, artifact := range artifacts {
+		statement.Subject = append(statement.Subject, InTotoSubject{
+			Name:        artifact.Path,
+			Digest:      map[string]string{"sha256": artifact.Sha256Sum},
+			MediaType:   artifact.ContentType,
+			Annotations: map[string]any{"size": artifact.FileSize},
+		})
+	}
+	return statement
+}
+
+// WriteArtifactAttestation writes an in-toto Statement for the artifacts to w
+// as JSON. If key isn't nil, the Statement is signed with it, and a
+// DSSEEnvelope containing it is written instead.
+func WriteArtifactAttestation(w io.Writer, jobID string, artifacts []*api.Artifact, key crypto.Signer) error {
+	payload, err := json.Marshal(NewInTotoStatement(jobID, artifacts))
+	if err != nil {
+		return err
+	}
+
+	if key == nil {
+		_, err := w.Write(append(payload, '\n'))
+		return err
+	}
+
+	sig, err := signDSSE(key, InTotoPayloadType, payload)
+	if err != nil {
+		return fmt.Errorf("signing attestation: %w", err)
+	}
+
+	keyID, err := publicKeyID(key.Public())
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(DSSEEnvelope{
+		PayloadType: InTotoPayloadType,
+		Payload:     payload,
+		Signatures:  []DSSESignature{{KeyID: keyID, Sig: sig}},
+	})
+}
+
+// dssePAE returns the DSSE pre-authentication encoding of a payload, which is
+// what's actually signed
+func dssePAE(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload))
+}
+
+// signDSSE signs the pre-authentication encoding of the payload. Ed25519 keys
+// sign it directly, and other keys sign its SHA-256 digest.
+func signDSSE(key crypto.Signer, payloadType string, payload []byte) ([]byte, error) {
+	message := dssePAE(payloadType, payload)
+	if _, ok := key.(ed25519.PrivateKey); ok {
+		return key.Sign(rand.Reader, message, crypto.Hash(0))
+	}
+
+	digest := sha256.Sum256(message)
+	return key.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+// publicKeyID returns the hex encoded SHA-256 of the DER encoded public key
+func publicKeyID(pub crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", fmt.Errorf("encoding public key: %w", err)
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// ParseAttestationKey parses a PEM encoded Ed25519, ECDSA or RSA private key
+// to sign attestations with, in PKCS #8, or for ECDSA and RSA keys, SEC 1 or
+// PKCS #1 form respectively
+func ParseAttestationKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM encoded private key found")
+	}
+
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return key, nil
+	case "RSA PRIVATE KEY":
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return signer, nil
+}
+
+// attestationArtifact writes the attestation for the artifacts to a temporary
+// file, and builds an artifact to upload it as. The returned function removes
+// the file once it's been uploaded.
+func (a *ArtifactUploader) attestationArtifact(artifacts []*api.Artifact) (*api.Artifact, func(), error) {
+	name := a.conf.AttestationName
+	if name == "" {
+		name = DefaultArtifactAttestationName
+	}
+
+	return a.generatedArtifact("attestation", name, artifacts, func(w io.Writer) error {
+		return WriteArtifactAttestation(w, a.conf.JobID, artifacts, a.conf.AttestationKey)
+	})
+}
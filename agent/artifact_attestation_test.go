@@ -0,0 +1,138 @@
+package agent
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"testing"
+
+	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+var attestedArtifacts = []*api.Artifact{
+	{Path: "llamas.txt", FileSize: 7, Sha256Sum: "b36293fc54a3dc9e1582b8fa065aacd3b71e0622777b3a25be508671db5d47cd", ContentType: "text/plain"},
+}
+
+func TestWriteArtifactAttestationUnsigned(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteArtifactAttestation(&buf, "job-123", attestedArtifacts, nil); err != nil {
+		t.Fatalf("WriteArtifactAttestation() error = %v", err)
+	}
+
+	assert.JSONEq(t, `{
+		"_type": "https://in-toto.io/Statement/v1",
+		"subject": [{
+			"name": "llamas.txt",
+			"digest": {"sha256": "b36293fc54a3dc9e1582b8fa065aacd3b71e0622777b3a25be508671db5d47cd"},
+			"mediaType": "text/plain",
+			"annotations": {"size": 7}
+		}],
+		"predicateType": "https://buildkite.com/attestations/artifact-upload/v1",
+		"predicate": {"job_id": "job-123"}
+	}`, buf.String())
+}
+
+func TestWriteArtifactAttestationSigned(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey() error = %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteArtifactAttestation(&buf, "job-123", attestedArtifacts, priv); err != nil {
+		t.Fatalf("WriteArtifactAttestation() error = %v", err)
+	}
+
+	var envelope DSSEEnvelope
+	if err := json.Unmarshal(buf.Bytes(), &envelope); err != nil {
+		t.Fatalf("json.Unmarshal(envelope) error = %v", err)
+	}
+
+	assert.Equal(t, InTotoPayloadType, envelope.PayloadType)
+	if len(envelope.Signatures) != 1 {
+		t.Fatalf("len(envelope.Signatures) = %d, want 1", len(envelope.Signatures))
+	}
+
+	sig := envelope.Signatures[0]
+	if !ed25519.Verify(pub, dssePAE(envelope.PayloadType, envelope.Payload), sig.Sig) {
+		t.Errorf("ed25519.Verify(envelope) = false, want true")
+	}
+
+	keyID, err := publicKeyID(pub)
+	if err != nil {
+		t.Fatalf("publicKeyID() error = %v", err)
+	}
+	assert.Equal(t, keyID, sig.KeyID)
+
+	var statement InTotoStatement
+	if err := json.Unmarshal(envelope.Payload, &statement); err != nil {
+		t.Fatalf("json.Unmarshal(payload) error = %v", err)
+	}
+	assert.Equal(t, "llamas.txt", statement.Subject[0].Name)
+}
+
+func TestParseAttestationKeyECDSA(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey() error = %v", err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("x509.MarshalECPrivateKey() error = %v", err)
+	}
+
+	signer, err := ParseAttestationKey(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		t.Fatalf("ParseAttestationKey() error = %v", err)
+	}
+
+	payload := []byte(`{"llamas":true}`)
+	sig, err := signDSSE(signer, InTotoPayloadType, payload)
+	if err != nil {
+		t.Fatalf("signDSSE() error = %v", err)
+	}
+	digest := sha256.Sum256(dssePAE(InTotoPayloadType, payload))
+	if !ecdsa.VerifyASN1(&key.PublicKey, digest[:], sig) {
+		t.Errorf("ecdsa.VerifyASN1() = false, want true")
+	}
+
+	if _, err := ParseAttestationKey([]byte("not a key")); err == nil {
+		t.Errorf("ParseAttestationKey(not a key) error = nil, want an error")
+	}
+}
+
+func TestAttestationArtifact(t *testing.T) {
+	uploader := NewArtifactUploader(logger.Discard, nil, ArtifactUploaderConfig{
+		JobID:  "job-123",
+		Attest: true,
+	})
+
+	attestation, cleanup, err := uploader.attestationArtifact(attestedArtifacts)
+	if err != nil {
+		t.Fatalf("uploader.attestationArtifact() error = %v", err)
+	}
+	defer cleanup()
+
+	assert.Equal(t, DefaultArtifactAttestationName, attestation.Path)
+	assert.Equal(t, "application/json", attestation.ContentType)
+
+	b, err := os.ReadFile(attestation.AbsolutePath)
+	if err != nil {
+		t.Fatalf("os.ReadFile(attestation) error = %v", err)
+	}
+	var statement InTotoStatement
+	if err := json.Unmarshal(b, &statement); err != nil {
+		t.Fatalf("json.Unmarshal(attestation) error = %v", err)
+	}
+	assert.Equal(t, InTotoStatementType, statement.Type)
+	assert.Len(t, statement.Subject, 1)
+}
@@ -0,0 +1,158 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/agent/v3/logger"
+	"golang.org/x/time/rate"
+)
+
+// ArtifactBackend is a store that artifacts can be uploaded to and downloaded
+// from, selected by the scheme of the upload destination (e.g. s3://). The
+// built in S3, Google Cloud Storage and Artifactory backends implement it, and
+// others can be added with RegisterArtifactBackend.
+//
+// Before Upload is called, the artifact has its AbsolutePath, Path, FileSize,
+// ContentType, Sha1Sum and Sha256Sum populated from the file on disk, and its
+// URL set to whatever URL returned. Backends should store the content type
+// alongside the object, and may use the checksums to have the store verify
+// what it received. Backends that don't store the file byte-for-byte should
+// set StoredSize.
+//
+// Download writes the artifact's Path beneath the destination directory, and
+// should retry transient failures itself, as the built in backends do. Exists
+// reports whether the artifact has already been stored.
+type ArtifactBackend interface {
+	Uploader
+
+	// Download fetches the artifact into the destination directory
+	Download(ctx context.Context, artifact *api.Artifact, destination string) error
+
+	// Exists reports whether the artifact is already in the store
+	Exists(ctx context.Context, artifact *api.Artifact) (bool, error)
+}
+
+type ArtifactBackendConfig struct {
+	// The destination, including the scheme the backend was registered
+	// for, e.g. s3://my-bucket-name/foo/bar
+	Destination string
+
+	// Whether or not HTTP calls should be debugged
+	DebugHTTP bool
+
+	// Whether or not HTTP2 should be disabled for uploads
+	DisableHTTP2 bool
+
+	// The size of the buffer files are read into while they're being sent.
+	// Zero means the net/http default.
+	ReadBufferSize int
+
+	// If set, files are read no faster than this allows, shared with
+	// everything else using the same limiter
+	Bandwidth *rate.Limiter
+
+	// Whether uploads should always be a single request, for backends that
+	// support multipart uploads
+	DisableMultipart bool
+}
+
+// ArtifactBackendFactory creates an ArtifactBackend for a destination
+type ArtifactBackendFactory func(logger.Logger, ArtifactBackendConfig) (ArtifactBackend, error)
+
+var (
+	artifactBackendsMu sync.RWMutex
+	artifactBackends   = map[string]ArtifactBackendFactory{}
+)
+
+func init() {
+	RegisterArtifactBackend("s3", func(l logger.Logger, c ArtifactBackendConfig) (ArtifactBackend, error) {
+		return NewS3Uploader(l, S3UploaderConfig{
+			Destination:      c.Destination,
+			DebugHTTP:        c.DebugHTTP,
+			DisableHTTP2:     c.DisableHTTP2,
+			ReadBufferSize:   c.ReadBufferSize,
+			Bandwidth:        c.Bandwidth,
+			DisableMultipart: c.DisableMultipart,
+		})
+	})
+	RegisterArtifactBackend("gs", func(l logger.Logger, c ArtifactBackendConfig) (ArtifactBackend, error) {
+		return NewGSUploader(l, GSUploaderConfig{
+			Destination: c.Destination,
+			DebugHTTP:   c.DebugHTTP,
+		})
+	})
+	RegisterArtifactBackend("rt", func(l logger.Logger, c ArtifactBackendConfig) (ArtifactBackend, error) {
+		return NewArtifactoryUploader(l, ArtifactoryUploaderConfig{
+			Destination:    c.Destination,
+			DebugHTTP:      c.DebugHTTP,
+			DisableHTTP2:   c.DisableHTTP2,
+			ReadBufferSize: c.ReadBufferSize,
+			Bandwidth:      c.Bandwidth,
+		})
+	})
+}
+
+// RegisterArtifactBackend makes a backend available for destinations with the
+// given scheme (without the "://"). Registering a scheme again replaces the
+// previous backend, including the built in ones.
+func RegisterArtifactBackend(scheme string, f ArtifactBackendFactory) {
+	artifactBackendsMu.Lock()
+	defer artifactBackendsMu.Unlock()
+	artifactBackends[strings.ToLower(scheme)] = f
+}
+
+// NewArtifactBackend creates the backend registered for the scheme of
+// c.Destination
+func NewArtifactBackend(l logger.Logger, c ArtifactBackendConfig) (ArtifactBackend, error) {
+	f, ok := lookupArtifactBackend(c.Destination)
+	if !ok {
+		return nil, fmt.Errorf("no artifact backend for %q, expected one of %s", c.Destination, artifactBackendSchemes())
+	}
+	return f(l, c)
+}
+
+func lookupArtifactBackend(destination string) (ArtifactBackendFactory, bool) {
+	u, err := url.Parse(destination)
+	if err != nil || u.Scheme == "" {
+		return nil, false
+	}
+
+	artifactBackendsMu.RLock()
+	defer artifactBackendsMu.RUnlock()
+	f, ok := artifactBackends[strings.ToLower(u.Scheme)]
+	return f, ok
+}
+
+// artifactBackendSchemes returns the registered schemes, e.g. "gs://, rt:// or s3://"
+func artifactBackendSchemes() string {
+	artifactBackendsMu.RLock()
+	schemes := make([]string, 0, len(artifactBackends))
+	for scheme := range artifactBackends {
+		schemes = append(schemes, scheme+"://")
+	}
+	artifactBackendsMu.RUnlock()
+
+	sort.Strings(schemes)
+	if len(schemes) < 2 {
+		return strings.Join(schemes, "")
+	}
+	return strings.Join(schemes[:len(schemes)-1], ", ") + " or " + schemes[len(schemes)-1]
+}
+
+// artifactBackendDownloader adapts an ArtifactBackend to the Start method
+// used by the other downloaders
+type artifactBackendDownloader struct {
+	backend     ArtifactBackend
+	artifact    *api.Artifact
+	destination string
+}
+
+func (d artifactBackendDownloader) Start(ctx context.Context) error {
+	return d.backend.Download(ctx, d.artifact, d.destination)
+}
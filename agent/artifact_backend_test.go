@@ -0,0 +1,61 @@
+package agent
+
+import (
+	"context"
+	"testing"
+
+	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+type testArtifactBackend struct {
+	destination string
+}
+
+func (b *testArtifactBackend) setDestination(destination string) {
+	b.destination = destination
+}
+
+func (b *testArtifactBackend) URL(artifact *api.Artifact) string {
+	return b.destination + "/" + artifact.Path
+}
+
+func (b *testArtifactBackend) Upload(context.Context, *api.Artifact) error {
+	return nil
+}
+
+func (b *testArtifactBackend) Download(context.Context, *api.Artifact, string) error {
+	return nil
+}
+
+func (b *testArtifactBackend) Exists(context.Context, *api.Artifact) (bool, error) {
+	return false, nil
+}
+
+func TestRegisterArtifactBackend(t *testing.T) {
+	RegisterArtifactBackend("llama", func(l logger.Logger, c ArtifactBackendConfig) (ArtifactBackend, error) {
+		return &testArtifactBackend{destination: c.Destination}, nil
+	})
+	defer func() {
+		artifactBackendsMu.Lock()
+		delete(artifactBackends, "llama")
+		artifactBackendsMu.Unlock()
+	}()
+
+	backend, err := NewArtifactBackend(logger.Discard, ArtifactBackendConfig{Destination: "llama://herd/foo"})
+	if err != nil {
+		t.Fatalf("NewArtifactBackend() error = %v", err)
+	}
+	assert.Equal(t, "llama://herd/foo/bar.txt", backend.URL(&api.Artifact{Path: "bar.txt"}))
+
+	assert.Equal(t, "gs://, llama://, rt:// or s3://", artifactBackendSchemes())
+}
+
+func TestNewArtifactBackendWithUnknownScheme(t *testing.T) {
+	for _, destination := range []string{"alpaca://herd/foo", "herd/foo", ""} {
+		if _, err := NewArtifactBackend(logger.Discard, ArtifactBackendConfig{Destination: destination}); err == nil {
+			t.Errorf("NewArtifactBackend(%q) error = nil, want an error", destination)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/buildkite/agent/v3/pool"
+	"github.com/buildkite/roko"
+)
+
+// DefaultArtifactBackfillBatchSize is how many artifacts are backfilled
+// between progress reports by default
+const DefaultArtifactBackfillBatchSize = 100
+
+type ArtifactBackfillerConfig struct {
+	// The ID of the Build
+	BuildID string
+
+	// The query used to find the artifacts
+	Query string
+
+	// Which step should we look at for the jobs
+	Step string
+
+	// Whether to include artifacts from retried jobs in the search
+	IncludeRetriedJobs bool
+
+	// How many artifacts to backfill between progress reports. Zero means
+	// DefaultArtifactBackfillBatchSize.
+	BatchSize int
+
+	// Whether to show HTTP debugging
+	DebugHTTP bool
+}
+
+// ArtifactBackfillSummary counts the outcome of backfilling checksums
+type ArtifactBackfillSummary struct {
+	// Artifacts whose SHA-256 checksums were recorded
+	Updated int
+
+	// Artifacts that already had a SHA-256 checksum
+	Skipped int
+
+	// Artifacts that couldn't be backfilled, e.g. because they couldn't be
+	// downloaded, or didn't match their SHA-1 checksum
+	Failed int
+}
+
+// ArtifactBackfiller records SHA-256 checksums for artifacts that were
+// uploaded before they were recorded, by streaming each of them from storage
+// through the hash
+type ArtifactBackfiller struct {
+	// The config for backfilling
+	conf ArtifactBackfillerConfig
+
+	// The logger instance to use
+	logger logger.Logger
+
+	// The APIClient that will be used when searching for and updating
+	// artifacts
+	apiClient APIClient
+
+	// Used to stream artifacts from wherever they're stored
+	downloader ArtifactDownloader
+}
+
+func NewArtifactBackfiller(l logger.Logger, ac APIClient, c ArtifactBackfillerConfig) *ArtifactBackfiller {
+	return &ArtifactBackfiller{
+		conf:      c,
+		logger:    l,
+		apiClient: ac,
+		downloader: NewArtifactDownloader(l, ac, ArtifactDownloaderConfig{
+			DebugHTTP: c.DebugHTTP,
+		}),
+	}
+}
+
+// Backfill records the SHA-256 checksum of each matching artifact that
+// doesn't have one. Artifacts that have a SHA-1 checksum are only updated if
+// what's in storage still matches it. An error is returned if any artifact
+// couldn't be backfilled.
+func (b *ArtifactBackfiller) Backfill(ctx context.Context) (ArtifactBackfillSummary, error) {
+	var summary ArtifactBackfillSummary
+
+	artifacts, err := NewArtifactSearcher(b.logger, b.apiClient, b.conf.BuildID).
+		Search(ctx, b.conf.Query, b.conf.Step, b.conf.IncludeRetriedJobs, false)
+	if err != nil {
+		return summary, err
+	}
+
+	if len(artifacts) == 0 {
+		return summary, errors.New("No artifacts found for backfilling")
+	}
+
+	var missing []*api.Artifact
+	for _, artifact := range artifacts {
+		if artifact.Sha256Sum != "" {
+			summary.Skipped++
+			continue
+		}
+		missing = append(missing, artifact)
+	}
+
+	b.logger.Info("Found %d artifacts, %d of which need a SHA-256 checksum", len(artifacts), len(missing))
+
+	if len(missing) == 0 {
+		return summary, nil
+	}
+
+	s3Clients, err := b.downloader.generateS3Clients(missing)
+	if err != nil {
+		return summary, fmt.Errorf("failed to generate S3 clients for artifact backfill: %w", err)
+	}
+
+	batchSize := b.conf.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultArtifactBackfillBatchSize
+	}
+
+	var summaryMutex sync.Mutex
+
+	for start := 0; start < len(missing); start += batchSize {
+		end := start + batchSize
+		if end > len(missing) {
+			end = len(missing)
+		}
+
+		p := pool.New(pool.MaxConcurrencyLimit)
+
+		for _, artifact := range missing[start:end] {
+			// Create new instance of the artifact for the goroutine
+			// See: http://golang.org/doc/effective_go.html#channels
+			artifact := artifact
+
+			p.Spawn(func() {
+				err := b.backfill(ctx, artifact, s3Clients)
+
+				summaryMutex.Lock()
+				defer summaryMutex.Unlock()
+
+				if err != nil {
+					b.logger.Error("Failed to backfill artifact \"%s\": %s", artifact.Path, err)
+					summary.Failed++
+					return
+				}
+				summary.Updated++
+			})
+		}
+
+		p.Wait()
+
+		b.logger.Info("Backfilled %d of %d artifacts (%d failed)", end, len(missing), summary.Failed)
+	}
+
+	if summary.Failed > 0 {
+		return summary, fmt.Errorf("%d of %d artifacts couldn't be backfilled", summary.Failed, len(missing))
+	}
+
+	return summary, nil
+}
+
+// backfill hashes a single artifact and records its SHA-256 checksum
+func (b *ArtifactBackfiller) backfill(ctx context.Context, artifact *api.Artifact, s3Clients map[string]*s3.S3) error {
+	sha1sum, sha256sum, err := b.downloader.checksums(ctx, artifact, s3Clients)
+	if err != nil {
+		return err
+	}
+
+	// Don't record a checksum for something that's changed since it was
+	// uploaded
+	if artifact.Sha1Sum != "" && artifact.Sha1Sum != sha1sum {
+		return fmt.Errorf("sha1 is %s, expected %s", sha1sum, artifact.Sha1Sum)
+	}
+
+	// Bound the update, including its retries, by the client's MaxWait
+	ctx, cancel := b.apiClient.OperationContext(ctx)
+	defer cancel()
+
+	return roko.NewRetrier(
+		roko.WithMaxAttempts(10),
+		roko.WithStrategy(roko.Constant(5*time.Second)),
+	).DoWithContext(ctx, func(r *roko.Retrier) error {
+		_, resp, err := b.apiClient.UpdateArtifactChecksum(ctx, artifact.JobID, artifact.ID, sha256sum)
+		if resp != nil && (resp.StatusCode == 401 || resp.StatusCode == 404 || resp.StatusCode == 422) {
+			r.Break()
+		}
+		if err != nil {
+			b.logger.Warn("%s (%s)", err, r)
+			return err
+		}
+		b.logger.Debug("Recorded sha256 %s for artifact \"%s\"", sha256sum, artifact.Path)
+		return nil
+	})
+}
@@ -0,0 +1,85 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArtifactBackfiller(t *testing.T) {
+	var mu sync.Mutex
+	updated := map[string]string{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.Method + " " + req.URL.RequestURI() {
+		case "GET /builds/my-build/artifacts/search?state=finished":
+			fmt.Fprintf(rw, `[{
+				"id": "llamas-id",
+				"job_id": "my-job",
+				"path": "llamas.txt",
+				"sha1sum": "32be520dbc6978bc435c2a90cb5a6eaad7384ceb",
+				"url": "http://%[1]s/llamas"
+			}, {
+				"id": "alpacas-id",
+				"job_id": "my-job",
+				"path": "alpacas.txt",
+				"sha1sum": "059b3d94f65aadd625da62ffe11b69379184613f",
+				"sha256sum": "777c2d0b3f5819f8f119c50055568065b4a3253478c622061273c31b3b9e99a8",
+				"url": "http://%[1]s/alpacas"
+			}, {
+				"id": "vicunas-id",
+				"job_id": "my-job",
+				"path": "vicunas.txt",
+				"sha1sum": "32be520dbc6978bc435c2a90cb5a6eaad7384ceb",
+				"url": "http://%[1]s/vicunas"
+			}]`, req.Host)
+		case "GET /llamas":
+			fmt.Fprintln(rw, "llamas")
+		case "GET /alpacas":
+			fmt.Fprintln(rw, "alpacas")
+		case "GET /vicunas":
+			fmt.Fprintln(rw, "vicunas")
+		case "PATCH /jobs/my-job/artifacts/llamas-id", "PATCH /jobs/my-job/artifacts/vicunas-id":
+			var body api.ArtifactChecksumUpdateRequest
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				http.Error(rw, err.Error(), http.StatusBadRequest)
+				return
+			}
+			mu.Lock()
+			updated[req.URL.Path] = body.Sha256Sum
+			mu.Unlock()
+			fmt.Fprint(rw, `{}`)
+		default:
+			http.Error(rw, "Not found", http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	ac := api.NewClient(logger.Discard, api.Config{
+		Endpoint: server.URL,
+		Token:    "llamasforever",
+	})
+
+	b := NewArtifactBackfiller(logger.Discard, ac, ArtifactBackfillerConfig{
+		BuildID:   "my-build",
+		BatchSize: 1,
+	})
+
+	// vicunas.txt doesn't match its SHA-1, so it's left alone
+	summary, err := b.Backfill(context.Background())
+	if err == nil {
+		t.Errorf("b.Backfill() error = nil, want an error for the mismatched artifact")
+	}
+	assert.Equal(t, ArtifactBackfillSummary{Updated: 1, Skipped: 1, Failed: 1}, summary)
+	assert.Equal(t, map[string]string{
+		"/jobs/my-job/artifacts/llamas-id": "b36293fc54a3dc9e1582b8fa065aacd3b71e0622777b3a25be508671db5d47cd",
+	}, updated)
+}
@@ -74,6 +74,9 @@ func (a *ArtifactBatchCreator) Create(ctx context.Context) ([]*api.Artifact, err
 		var resp *api.Response
 		var err error
 
+		// Bound the operation, including its retries, by the client's MaxWait
+		opCtx, cancelOp := a.apiClient.OperationContext(ctx)
+
 		// Retry the batch upload a couple of times
 		err = roko.NewRetrier(
 			// TODO: e.g. roko.ExponentialSubsecond(500*time.Millisecond) WithMaxAttempts(10)
@@ -81,12 +84,12 @@ func (a *ArtifactBatchCreator) Create(ctx context.Context) ([]*api.Artifact, err
 			// Meanwhile, 8 roko.Exponential(2sec) attempts is 1,2,4,8,16,32,64 seconds delay (~2 mins)
 			roko.WithMaxAttempts(8),
 			roko.WithStrategy(roko.Exponential(2*time.Second, 0)),
-		).DoWithContext(ctx, func(r *roko.Retrier) error {
+		).DoWithContext(opCtx, func(r *roko.Retrier) error {
 
-			ctxTimeout := ctx
+			ctxTimeout := opCtx
 			if a.conf.CreateArtifactsTimeout != 0 {
 				var cancel func()
-				ctxTimeout, cancel = context.WithTimeout(ctx, a.conf.CreateArtifactsTimeout)
+				ctxTimeout, cancel = context.WithTimeout(opCtx, a.conf.CreateArtifactsTimeout)
 				defer cancel()
 			}
 
@@ -100,6 +103,7 @@ func (a *ArtifactBatchCreator) Create(ctx context.Context) ([]*api.Artifact, err
 
 			return err
 		})
+		cancelOp()
 
 		// Did the batch creation eventually fail?
 		if err != nil {
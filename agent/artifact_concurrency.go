@@ -0,0 +1,102 @@
+package agent
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultArtifactUploadMinConcurrency is the fewest artifacts that are
+// uploaded at once while backing off, unless
+// ArtifactUploaderConfig.MinConcurrency is set
+const DefaultArtifactUploadMinConcurrency = 1
+
+// concurrencyDecreaseCooldown is the least time between decreases in
+// concurrency, so that a burst of failures from uploads that were all in flight
+// at once only counts as a single signal to back off
+const concurrencyDecreaseCooldown = time.Second
+
+// concurrencyLimiter limits how many uploads are in flight at once, adjusting
+// the limit between min and max with additive increase, multiplicative
+// decrease (AIMD): the limit is halved when storage signals backpressure, and
+// grows by one each time a full limit's worth of uploads succeed.
+type concurrencyLimiter struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	min, max  int
+	limit     int
+	inFlight  int
+	successes int
+	clock     Clock
+	decreased time.Time
+}
+
+// newConcurrencyLimiter returns a limiter that starts at max, which is assumed
+// to be at least min
+func newConcurrencyLimiter(min, max int, clock Clock) *concurrencyLimiter {
+	l := &concurrencyLimiter{
+		min:   min,
+		max:   max,
+		limit: max,
+		clock: clock,
+	}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// acquire waits until another upload can start
+func (l *concurrencyLimiter) acquire() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for l.inFlight >= l.limit {
+		l.cond.Wait()
+	}
+	l.inFlight++
+}
+
+// release records that an upload has finished with err, adjusting the limit
+func (l *concurrencyLimiter) release(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inFlight--
+
+	switch {
+	case err == nil:
+		l.successes++
+		if l.successes >= l.limit && l.limit < l.max {
+			l.limit++
+			l.successes = 0
+		}
+
+	case isBackpressure(err):
+		l.successes = 0
+		now := l.clock.Now()
+		if l.limit > l.min && now.Sub(l.decreased) >= concurrencyDecreaseCooldown {
+			l.limit /= 2
+			if l.limit < l.min {
+				l.limit = l.min
+			}
+			l.decreased = now
+		}
+	}
+
+	l.cond.Broadcast()
+}
+
+// current returns the current limit
+func (l *concurrencyLimiter) current() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit
+}
+
+// isBackpressure reports whether err means storage wants us to slow down
+func isBackpressure(err error) bool {
+	switch uploadErrorStatus(err) {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	}
+	return false
+}
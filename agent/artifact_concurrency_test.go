@@ -0,0 +1,76 @@
+package agent
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConcurrencyLimiterAIMD(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l := newConcurrencyLimiter(2, 16, clock)
+	assert.Equal(t, 16, l.current())
+
+	backpressure := &uploadStatusError{StatusCode: http.StatusServiceUnavailable, message: "503 Service Unavailable"}
+
+	// Backpressure halves the limit
+	l.acquire()
+	l.release(backpressure)
+	assert.Equal(t, 8, l.current())
+
+	// ...but only once for a burst of failures
+	l.acquire()
+	l.release(backpressure)
+	assert.Equal(t, 8, l.current())
+
+	// ...and never below the minimum
+	for i := 0; i < 5; i++ {
+		clock.Sleep(time.Second)
+		l.acquire()
+		l.release(&uploadStatusError{StatusCode: http.StatusTooManyRequests, message: "429 Too Many Requests"})
+	}
+	assert.Equal(t, 2, l.current())
+
+	// Other failures don't count as backpressure
+	clock.Sleep(time.Second)
+	l.acquire()
+	l.release(errors.New("connection reset by peer"))
+	assert.Equal(t, 2, l.current())
+
+	// A limit's worth of successes increases it by one
+	for _, want := range []int{2, 3} {
+		assert.Equal(t, want, l.current())
+		for i := 0; i < want; i++ {
+			l.acquire()
+			l.release(nil)
+		}
+	}
+	assert.Equal(t, 4, l.current())
+}
+
+func TestConcurrencyLimiterBlocksAtLimit(t *testing.T) {
+	l := newConcurrencyLimiter(1, 1, realClock{})
+	l.acquire()
+
+	acquired := make(chan struct{})
+	go func() {
+		l.acquire()
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("acquire() returned while the limit was reached")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	l.release(nil)
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("acquire() didn't return after release()")
+	}
+}
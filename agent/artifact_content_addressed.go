@@ -0,0 +1,56 @@
+package agent
+
+import (
+	"errors"
+
+	"github.com/buildkite/agent/v3/api"
+)
+
+// ArtifactPathMetadataKey is the metadata key that content addressed
+// artifacts record the path they were uploaded as under, on the object in
+// the upload destination
+const ArtifactPathMetadataKey = "buildkite-artifact-path"
+
+// ContentAddressedPath returns the path an artifact with the SHA-256 checksum
+// is stored at when it's content addressed, e.g. sha256/ab/cdef0123... for
+// abcdef0123... The first byte is a directory of its own, so no directory in
+// the destination ends up with too many objects in it.
+func ContentAddressedPath(sha256sum string) string {
+	if len(sha256sum) <= 2 {
+		return "sha256/" + sha256sum
+	}
+	return "sha256/" + sha256sum[:2] + "/" + sha256sum[2:]
+}
+
+// checkContentAddressed returns an error if the artifacts can't be uploaded
+// content addressed. Buildkite's artifact storage decides where artifacts are
+// stored itself, so they need to be uploaded to a destination of our own.
+func (a *ArtifactUploader) checkContentAddressed() error {
+	destinations := a.conf.Destinations
+	if len(destinations) == 0 {
+		destinations = []string{a.conf.Destination}
+	}
+	for _, destination := range destinations {
+		if destination == "" {
+			return errors.New("content addressed artifacts can't be uploaded to Buildkite's artifact storage, set an upload destination")
+		}
+	}
+	return nil
+}
+
+// contentAddress stores each of the artifacts at the path of its SHA-256
+// checksum, rather than the path it's uploaded as
+func contentAddress(artifacts []*api.Artifact) {
+	for _, artifact := range artifacts {
+		artifact.StoragePath = ContentAddressedPath(artifact.Sha256Sum)
+	}
+}
+
+// storagePath returns the path the artifact is stored at in its upload
+// destination
+func storagePath(artifact *api.Artifact) string {
+	if artifact.StoragePath != "" {
+		return artifact.StoragePath
+	}
+	return artifact.Path
+}
@@ -0,0 +1,63 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContentAddressedPath(t *testing.T) {
+	t.Parallel()
+
+	sum := "b36293fc54a3dc9e1582b8fa065aacd3b71e0622777b3a25be508671db5d47cd"
+	assert.Equal(t, "sha256/b3/6293fc54a3dc9e1582b8fa065aacd3b71e0622777b3a25be508671db5d47cd", ContentAddressedPath(sum))
+}
+
+func TestContentAddressedStoragePath(t *testing.T) {
+	t.Parallel()
+
+	artifacts := []*api.Artifact{{
+		Path:      "logs/llamas.txt",
+		Sha256Sum: "b36293fc54a3dc9e1582b8fa065aacd3b71e0622777b3a25be508671db5d47cd",
+	}}
+	contentAddress(artifacts)
+
+	uploader := &S3Uploader{BucketPath: "cas"}
+	assert.Equal(t, "cas/sha256/b3/6293fc54a3dc9e1582b8fa065aacd3b71e0622777b3a25be508671db5d47cd", uploader.artifactPath(artifacts[0]))
+
+	// Downloads are written to the path it was uploaded as, but fetched from
+	// where it's stored
+	downloader := NewS3Downloader(logger.Discard, S3DownloaderConfig{
+		S3Path:      "s3://my-bucket-name/cas",
+		Path:        artifacts[0].Path,
+		StoragePath: artifacts[0].StoragePath,
+	})
+	assert.Equal(t, "cas/sha256/b3/6293fc54a3dc9e1582b8fa065aacd3b71e0622777b3a25be508671db5d47cd", downloader.BucketFileLocation())
+}
+
+func TestCheckContentAddressed(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name    string
+		conf    ArtifactUploaderConfig
+		wantErr bool
+	}{
+		{name: "own storage", conf: ArtifactUploaderConfig{Destination: "s3://my-bucket-name/cas"}},
+		{name: "buildkite storage", conf: ArtifactUploaderConfig{}, wantErr: true},
+		{name: "buildkite storage too", conf: ArtifactUploaderConfig{Destinations: []string{"s3://my-bucket-name/cas", ""}}, wantErr: true},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			tc.conf.ContentAddressed = true
+			err := NewArtifactUploader(logger.Discard, nil, tc.conf).checkContentAddressed()
+			if (err != nil) != tc.wantErr {
+				t.Errorf("checkContentAddressed() error = %v, wantErr %t", err, tc.wantErr)
+			}
+		})
+	}
+}
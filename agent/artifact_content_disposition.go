@@ -0,0 +1,27 @@
+package agent
+
+import (
+	"mime"
+	"path/filepath"
+
+	zglob "github.com/mattn/go-zglob"
+)
+
+// contentDisposition returns the Content-Disposition for the artifact with
+// the given path, from the first of the ContentDispositions whose pattern it
+// matches. An attachment includes the file's name, which is encoded as per RFC
+// 5987 if it isn't plain ASCII.
+func (a *ArtifactUploader) contentDisposition(path string) string {
+	for _, cd := range a.conf.ContentDispositions {
+		if ok, _ := zglob.Match(cd.Pattern, filepath.ToSlash(path)); !ok {
+			continue
+		}
+		if cd.Disposition != "attachment" {
+			return cd.Disposition
+		}
+		return mime.FormatMediaType("attachment", map[string]string{
+			"filename": filepath.Base(path),
+		})
+	}
+	return ""
+}
@@ -0,0 +1,39 @@
+package agent
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+
+	"github.com/buildkite/agent/v3/api"
+)
+
+// WriteArtifactsCSV writes the artifacts to w as RFC 4180 CSV, with a header
+// row followed by one row per artifact:
+//
+//	path,size,sha256,url
+//
+// Fields are quoted as needed, so paths containing commas or quotes are safe.
+func WriteArtifactsCSV(w io.Writer, artifacts []*api.Artifact) error {
+	cw := csv.NewWriter(w)
+	cw.UseCRLF = true
+
+	if err := cw.Write([]string{"path", "size", "sha256", "url"}); err != nil {
+		return err
+	}
+
+	for _, artifact := range artifacts {
+		err := cw.Write([]string{
+			artifact.Path,
+			strconv.FormatInt(artifact.FileSize, 10),
+			artifact.Sha256Sum,
+			artifact.URL,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,29 @@
+package agent
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/buildkite/agent/v3/api"
+)
+
+func TestWriteArtifactsCSV(t *testing.T) {
+	t.Parallel()
+
+	artifacts := []*api.Artifact{
+		{Path: "llamas.txt", FileSize: 42, Sha256Sum: "a3b4c5", URL: "https://example.com/llamas.txt"},
+		{Path: `alpacas, "fluffy".txt`, FileSize: 7, Sha256Sum: "d6e7f8"},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteArtifactsCSV(&buf, artifacts); err != nil {
+		t.Fatalf("WriteArtifactsCSV() error = %v", err)
+	}
+
+	want := "path,size,sha256,url\r\n" +
+		"llamas.txt,42,a3b4c5,https://example.com/llamas.txt\r\n" +
+		`"alpacas, ""fluffy"".txt",7,d6e7f8,` + "\r\n"
+	if got := buf.String(); got != want {
+		t.Errorf("WriteArtifactsCSV() wrote %q, want %q", got, want)
+	}
+}
@@ -4,10 +4,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/service/s3"
@@ -26,6 +28,10 @@ type ArtifactDownloaderConfig struct {
 	// Which step should we look at for the jobs
 	Step string
 
+	// If set, only artifacts with this SHA-256 checksum are downloaded, and
+	// it's an error if there aren't any
+	Sha256Sum string
+
 	// Whether to include artifacts from retried jobs in the search
 	IncludeRetriedJobs bool
 
@@ -34,6 +40,14 @@ type ArtifactDownloaderConfig struct {
 
 	// Whether to show HTTP debugging
 	DebugHTTP bool
+
+	// Whether to set the permissions of downloaded files to those they were
+	// uploaded with, for artifacts that recorded them
+	PreserveModes bool
+
+	// Whether to check that there's enough free space at the destination for
+	// all of the artifacts before downloading any of them
+	CheckDiskSpace bool
 }
 
 type ArtifactDownloader struct {
@@ -73,12 +87,25 @@ func (a *ArtifactDownloader) Download(ctx context.Context) error {
 		return err
 	}
 
+	if a.conf.Sha256Sum != "" {
+		artifacts = filterBySha256(artifacts, a.conf.Sha256Sum)
+		if len(artifacts) == 0 {
+			return fmt.Errorf("No artifacts found with sha256 %s", a.conf.Sha256Sum)
+		}
+	}
+
 	artifactCount := len(artifacts)
 
 	if artifactCount == 0 {
 		return errors.New("No artifacts found for downloading")
 	}
 
+	if a.conf.CheckDiskSpace {
+		if err := a.checkDiskSpace(downloadDestination, artifacts); err != nil {
+			return err
+		}
+	}
+
 	a.logger.Info("Found %d artifacts. Starting to download to: %s", artifactCount, downloadDestination)
 
 	p := pool.New(pool.MaxConcurrencyLimit)
@@ -94,58 +121,22 @@ func (a *ArtifactDownloader) Download(ctx context.Context) error {
 		artifact := artifact
 
 		p.Spawn(func() {
-			// Convert windows paths to slashes, otherwise we get a literal
-			// download of "dir/dir/file" vs sub-directories on non-windows agents
-			path := artifact.Path
-			if runtime.GOOS != "windows" {
-				path = strings.Replace(path, `\`, `/`, -1)
-			}
-
 			// Handle downloading from S3, GS, or RT
-			var dler interface {
-				Start(context.Context) error
+			dler, err := a.downloader(artifact, downloadPath(artifact), downloadTarget{
+				Destination: downloadDestination,
+				Retries:     5,
+			}, s3Clients)
+			if err == nil {
+				err = dler.Start(ctx)
 			}
-			switch {
-			case strings.HasPrefix(artifact.UploadDestination, "s3://"):
-				bucketName, _ := ParseS3Destination(artifact.UploadDestination)
-				dler = NewS3Downloader(a.logger, S3DownloaderConfig{
-					S3Client:    s3Clients[bucketName],
-					Path:        path,
-					S3Path:      artifact.UploadDestination,
-					Destination: downloadDestination,
-					Retries:     5,
-					DebugHTTP:   a.conf.DebugHTTP,
-				})
-			case strings.HasPrefix(artifact.UploadDestination, "gs://"):
-				dler = NewGSDownloader(a.logger, GSDownloaderConfig{
-					Path:        path,
-					Bucket:      artifact.UploadDestination,
-					Destination: downloadDestination,
-					Retries:     5,
-					DebugHTTP:   a.conf.DebugHTTP,
-				})
-			case strings.HasPrefix(artifact.UploadDestination, "rt://"):
-				dler = NewArtifactoryDownloader(a.logger, ArtifactoryDownloaderConfig{
-					Path:        path,
-					Repository:  artifact.UploadDestination,
-					Destination: downloadDestination,
-					Retries:     5,
-					DebugHTTP:   a.conf.DebugHTTP,
-				})
-			default:
-				dler = NewDownload(a.logger, http.DefaultClient, DownloadConfig{
-					URL:         artifact.URL,
-					Path:        path,
-					Destination: downloadDestination,
-					Retries:     5,
-					DebugHTTP:   a.conf.DebugHTTP,
-				})
+			if err == nil && a.conf.PreserveModes {
+				err = restoreMode(artifact, getTargetPath(downloadPath(artifact), downloadDestination))
 			}
 
 			// If the downloaded encountered an error, lock
 			// the pool, collect it, then unlock the pool
 			// again.
-			if err := dler.Start(ctx); err != nil {
+			if err != nil {
 				a.logger.Error("Failed to download artifact: %s", err)
 
 				p.Lock()
@@ -164,6 +155,163 @@ func (a *ArtifactDownloader) Download(ctx context.Context) error {
 	return nil
 }
 
+// checkDiskSpace returns an error if the artifacts' sizes add up to more than
+// the free space at destination. If the free space can't be found, it's only
+// logged, since the download may well succeed anyway.
+func (a *ArtifactDownloader) checkDiskSpace(destination string, artifacts []*api.Artifact) error {
+	var need uint64
+	for _, artifact := range artifacts {
+		if artifact.FileSize > 0 {
+			need += uint64(artifact.FileSize)
+		}
+	}
+
+	have, err := freeDiskSpace(destination)
+	if err != nil {
+		a.logger.Warn("Couldn't check the free disk space at %s: %v", destination, err)
+		return nil
+	}
+
+	if need > have {
+		return fmt.Errorf("Not enough disk space to download %d artifacts to %s: need %s, have %s",
+			len(artifacts), destination, formatMB(need), formatMB(have))
+	}
+
+	a.logger.Debug("Downloading %s of artifacts to %s, which has %s free", formatMB(need), destination, formatMB(have))
+	return nil
+}
+
+// formatMB formats a number of bytes in megabytes, like throughput
+func formatMB(bytes uint64) string {
+	return fmt.Sprintf("%.2f MB", float64(bytes)/(1024*1024))
+}
+
+// filterBySha256 returns the artifacts with the SHA-256 checksum sum. Each is
+// still verified against it once it's downloaded.
+func filterBySha256(artifacts []*api.Artifact, sum string) []*api.Artifact {
+	var matched []*api.Artifact
+	for _, artifact := range artifacts {
+		if strings.EqualFold(artifact.Sha256Sum, sum) {
+			matched = append(matched, artifact)
+		}
+	}
+	return matched
+}
+
+// downloadPath returns the path to download an artifact to, relative to the
+// destination
+func downloadPath(artifact *api.Artifact) string {
+	// Convert windows paths to slashes, otherwise we get a literal
+	// download of "dir/dir/file" vs sub-directories on non-windows agents
+	path := artifact.Path
+	if runtime.GOOS != "windows" {
+		path = strings.Replace(path, `\`, `/`, -1)
+	}
+	return path
+}
+
+// restoreMode sets the permissions of the downloaded file to those the
+// artifact was uploaded with, if it recorded them. On Windows, this can only
+// make the file read-only or writable.
+func restoreMode(artifact *api.Artifact, file string) error {
+	if artifact.FileMode == "" {
+		return nil
+	}
+
+	mode, err := strconv.ParseUint(artifact.FileMode, 8, 32)
+	if err != nil {
+		return fmt.Errorf("parsing file mode %q of %s: %w", artifact.FileMode, artifact.Path, err)
+	}
+
+	if err := os.Chmod(file, os.FileMode(mode).Perm()); err != nil {
+		return fmt.Errorf("restoring file mode of %s: %w", artifact.Path, err)
+	}
+	return nil
+}
+
+// downloadTarget is where a downloader writes an artifact to
+type downloadTarget struct {
+	// The root directory of the download
+	Destination string
+
+	// If set, the artifact is written here instead of beneath Destination
+	Writer io.Writer
+
+	// How many times to try the download before giving up
+	Retries int
+}
+
+// downloader returns a downloader for the storage the artifact was uploaded to
+func (a *ArtifactDownloader) downloader(artifact *api.Artifact, path string, target downloadTarget, s3Clients map[string]*s3.S3) (interface {
+	Start(context.Context) error
+}, error) {
+	switch {
+	case strings.HasPrefix(artifact.UploadDestination, "s3://"):
+		bucketName, _ := ParseS3Destination(artifact.UploadDestination)
+		return NewS3Downloader(a.logger, S3DownloaderConfig{
+			S3Client:    s3Clients[bucketName],
+			Path:        path,
+			StoragePath: artifact.StoragePath,
+			S3Path:      artifact.UploadDestination,
+			Destination: target.Destination,
+			Writer:      target.Writer,
+			Sha256Sum:   artifact.Sha256Sum,
+			Retries:     target.Retries,
+			DebugHTTP:   a.conf.DebugHTTP,
+		}), nil
+	case strings.HasPrefix(artifact.UploadDestination, "gs://"):
+		return NewGSDownloader(a.logger, GSDownloaderConfig{
+			Path:        path,
+			StoragePath: artifact.StoragePath,
+			Bucket:      artifact.UploadDestination,
+			Destination: target.Destination,
+			Writer:      target.Writer,
+			Sha256Sum:   artifact.Sha256Sum,
+			Retries:     target.Retries,
+			DebugHTTP:   a.conf.DebugHTTP,
+		}), nil
+	case strings.HasPrefix(artifact.UploadDestination, "rt://"):
+		return NewArtifactoryDownloader(a.logger, ArtifactoryDownloaderConfig{
+			Path:        path,
+			StoragePath: artifact.StoragePath,
+			Repository:  artifact.UploadDestination,
+			Destination: target.Destination,
+			Writer:      target.Writer,
+			Sha256Sum:   artifact.Sha256Sum,
+			Retries:     target.Retries,
+			DebugHTTP:   a.conf.DebugHTTP,
+		}), nil
+	case a.hasCustomBackend(artifact.UploadDestination):
+		if target.Writer != nil {
+			return nil, fmt.Errorf("streaming artifacts from %s isn't supported", artifact.UploadDestination)
+		}
+		backend, err := NewArtifactBackend(a.logger, ArtifactBackendConfig{
+			Destination: artifact.UploadDestination,
+			DebugHTTP:   a.conf.DebugHTTP,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("creating artifact backend: %w", err)
+		}
+		backendArtifact := *artifact
+		backendArtifact.Path = path
+		return artifactBackendDownloader{
+			backend:     backend,
+			artifact:    &backendArtifact,
+			destination: target.Destination,
+		}, nil
+	default:
+		return NewDownload(a.logger, http.DefaultClient, DownloadConfig{
+			URL:         artifact.URL,
+			Path:        path,
+			Destination: target.Destination,
+			Writer:      target.Writer,
+			Sha256Sum:   artifact.Sha256Sum,
+			Retries:     target.Retries,
+			DebugHTTP:   a.conf.DebugHTTP,
+		}), nil
+	}
+}
+
 // We want to have as few S3 clients as possible, as creating them is kind of an expensive operation
 // But it's also theoretically possible that we'll have multiple artifacts with different S3 buckets, and each
 // S3Client only applies to one bucket, so we need to store the S3 clients in a map, one for each bucket
@@ -188,3 +336,13 @@ func (a *ArtifactDownloader) generateS3Clients(artifacts []*api.Artifact) (map[s
 
 	return s3Clients, nil
 }
+
+// hasCustomBackend reports whether an artifact was uploaded to a destination
+// handled by a registered ArtifactBackend other than the built in ones
+func (a *ArtifactDownloader) hasCustomBackend(destination string) bool {
+	if destination == "" {
+		return false
+	}
+	_, ok := lookupArtifactBackend(destination)
+	return ok
+}
@@ -6,6 +6,9 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
 
 	"github.com/buildkite/agent/v3/api"
@@ -48,3 +51,139 @@ func TestArtifactDownloaderConnectsToEndpoint(t *testing.T) {
 		t.Errorf("d.Download() = %v", err)
 	}
 }
+
+func TestArtifactDownloaderSha256Sum(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.RequestURI() {
+		case "/builds/my-build/artifacts/search?state=finished":
+			fmt.Fprintf(rw, `[{
+				"id": "4600ac5c-5a13-4e92-bb83-f86f218f7b32",
+				"file_size": 3,
+				"path": "llamas.txt",
+				"sha256sum": "a12b7cb43c9d9134b5bb1b35e9096b66775d9e92e7611d1cc92b02edd6782a87",
+				"url": "http://%s/download"
+			}, {
+				"id": "0b7c4d6e-2f4a-4c3b-9a1e-6d5f8e7a9b0c",
+				"file_size": 3,
+				"path": "alpacas.txt",
+				"sha256sum": "0000000000000000000000000000000000000000000000000000000000000000",
+				"url": "http://%s/download"
+			}]`, req.Host, req.Host)
+		case "/download":
+			fmt.Fprintln(rw, "OK")
+		default:
+			http.Error(rw, "Not found", http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+
+	ac := api.NewClient(logger.Discard, api.Config{
+		Endpoint: server.URL,
+		Token:    "llamasforever",
+	})
+
+	dir := t.TempDir()
+	d := NewArtifactDownloader(logger.Discard, ac, ArtifactDownloaderConfig{
+		BuildID:     "my-build",
+		Destination: dir,
+		Sha256Sum:   "A12B7CB43C9D9134B5BB1B35E9096B66775D9E92E7611D1CC92B02EDD6782A87",
+	})
+	if err := d.Download(ctx); err != nil {
+		t.Fatalf("d.Download() = %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "llamas.txt")); err != nil {
+		t.Errorf("os.Stat(llamas.txt) error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "alpacas.txt")); !os.IsNotExist(err) {
+		t.Errorf("os.Stat(alpacas.txt) error = %v, want not exist", err)
+	}
+
+	d = NewArtifactDownloader(logger.Discard, ac, ArtifactDownloaderConfig{
+		BuildID:     "my-build",
+		Destination: dir,
+		Sha256Sum:   "1111111111111111111111111111111111111111111111111111111111111111",
+	})
+	if err := d.Download(ctx); err == nil {
+		t.Errorf("d.Download() with no matching sha256 = nil, want error")
+	}
+}
+
+func TestArtifactDownloaderCheckDiskSpace(t *testing.T) {
+	downloads := 0
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.RequestURI() {
+		case "/builds/my-build/artifacts/search?state=finished":
+			// No disk is this big
+			fmt.Fprintf(rw, `[{
+				"id": "4600ac5c-5a13-4e92-bb83-f86f218f7b32",
+				"file_size": 4611686018427387904,
+				"path": "llamas.txt",
+				"url": "http://%s/download"
+			}]`, req.Host)
+		case "/download":
+			downloads++
+			fmt.Fprintln(rw, "OK")
+		default:
+			http.Error(rw, "Not found", http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	ac := api.NewClient(logger.Discard, api.Config{
+		Endpoint: server.URL,
+		Token:    "llamasforever",
+	})
+
+	dir := t.TempDir()
+	d := NewArtifactDownloader(logger.Discard, ac, ArtifactDownloaderConfig{
+		BuildID:        "my-build",
+		Destination:    dir,
+		CheckDiskSpace: true,
+	})
+	err := d.Download(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "need 4398046511104.00 MB") {
+		t.Errorf("d.Download() error = %v, want a not enough disk space error", err)
+	}
+	if downloads != 0 {
+		t.Errorf("downloads = %d, want 0", downloads)
+	}
+
+	if err := d.checkDiskSpace(dir, []*api.Artifact{{Path: "llamas.txt", FileSize: 3}}); err != nil {
+		t.Errorf("d.checkDiskSpace(3 bytes) error = %v", err)
+	}
+}
+
+func TestRestoreMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows only has a read-only attribute")
+	}
+
+	file := filepath.Join(t.TempDir(), "script.sh")
+	if err := os.WriteFile(file, []byte("#!/bin/sh\n"), 0o644); err != nil {
+		t.Fatalf("os.WriteFile(script.sh) error = %v", err)
+	}
+
+	if err := restoreMode(&api.Artifact{Path: "script.sh", FileMode: "0755"}, file); err != nil {
+		t.Fatalf("restoreMode() error = %v", err)
+	}
+
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatalf("os.Stat(script.sh) error = %v", err)
+	}
+	if got, want := info.Mode().Perm(), os.FileMode(0o755); got != want {
+		t.Errorf("mode after restoreMode() = %v, want %v", got, want)
+	}
+
+	// Artifacts that didn't record a mode are left alone
+	if err := restoreMode(&api.Artifact{Path: "script.sh"}, file); err != nil {
+		t.Fatalf("restoreMode() error = %v", err)
+	}
+
+	if err := restoreMode(&api.Artifact{Path: "script.sh", FileMode: "rwxr-xr-x"}, file); err == nil {
+		t.Errorf("restoreMode() error = nil, want an error for an invalid mode")
+	}
+}
@@ -0,0 +1,85 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// ArtifactUploadEstimate is how much an upload would transfer, and roughly
+// how long it would take
+type ArtifactUploadEstimate struct {
+	Files int
+	Bytes int64
+
+	// Zero if there's no transfer rate to estimate it from
+	Duration time.Duration
+}
+
+func (e ArtifactUploadEstimate) String() string {
+	duration := "unknown"
+	if e.Duration > 0 {
+		duration = e.Duration.Round(time.Second).String()
+	}
+	return fmt.Sprintf("buildkite-agent: files=%d bytes=%d duration=%s", e.Files, e.Bytes, duration)
+}
+
+// Estimate finds the files that would be uploaded and adds up their sizes,
+// without reading or hashing them, or contacting the Agent API. The duration
+// is estimated from bytesPerSecond, or MaxBytesPerSecond if that's lower, and
+// left unknown if neither is set.
+func (a *ArtifactUploader) Estimate(bytesPerSecond int64) (ArtifactUploadEstimate, error) {
+	var estimate ArtifactUploadEstimate
+
+	base, err := a.baseDirectory()
+	if err != nil {
+		return estimate, err
+	}
+
+	matches, err := a.match(base)
+	if err != nil {
+		return estimate, err
+	}
+
+	for _, match := range matches {
+		// Empty directories are uploaded as a zero-byte marker
+		if match.emptyDir {
+			estimate.Files++
+			continue
+		}
+
+		info, err := os.Stat(match.readPath)
+		if errors.Is(err, fs.ErrNotExist) && a.conf.SkipVanished {
+			continue
+		}
+		if err != nil {
+			return estimate, fmt.Errorf("getting file info for %s: %w", match.readPath, err)
+		}
+		if info.Size() == 0 && a.conf.SkipEmpty {
+			continue
+		}
+		if info.ModTime().Before(a.conf.ModifiedSince) {
+			continue
+		}
+
+		estimate.Files++
+		estimate.Bytes += info.Size()
+	}
+
+	// Every file is uploaded to each of the destinations
+	if n := len(a.conf.Destinations); n > 1 {
+		estimate.Files *= n
+		estimate.Bytes *= int64(n)
+	}
+
+	if max := a.conf.MaxBytesPerSecond; max > 0 && (bytesPerSecond <= 0 || max < bytesPerSecond) {
+		bytesPerSecond = max
+	}
+	if bytesPerSecond > 0 {
+		estimate.Duration = time.Duration(float64(estimate.Bytes) / float64(bytesPerSecond) * float64(time.Second))
+	}
+
+	return estimate, nil
+}
@@ -0,0 +1,46 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEstimate(t *testing.T) {
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"llamas.txt":  "llamas are great",
+		"alpacas.txt": "",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o666); err != nil {
+			t.Fatalf("os.WriteFile(%s) error = %v", name, err)
+		}
+	}
+	os.Chdir(dir)
+
+	uploader := NewArtifactUploader(logger.Discard, nil, ArtifactUploaderConfig{
+		Paths:             "*.txt",
+		SkipEmpty:         true,
+		MaxBytesPerSecond: 8,
+	})
+
+	estimate, err := uploader.Estimate(0)
+	if err != nil {
+		t.Fatalf("uploader.Estimate(0) error = %v", err)
+	}
+
+	assert.Equal(t, ArtifactUploadEstimate{Files: 1, Bytes: 16, Duration: 2 * time.Second}, estimate)
+	assert.Equal(t, "buildkite-agent: files=1 bytes=16 duration=2s", estimate.String())
+}
+
+func TestEstimateWithoutRate(t *testing.T) {
+	estimate := ArtifactUploadEstimate{Files: 3, Bytes: 1024}
+	assert.Equal(t, "buildkite-agent: files=3 bytes=1024 duration=unknown", estimate.String())
+}
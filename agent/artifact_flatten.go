@@ -0,0 +1,65 @@
+package agent
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// The strategies for ArtifactUploaderConfig.FlattenStrategy. Given the files
+// a/report.xml, b/report.xml and b/summary.txt:
+//
+//   - ArtifactFlattenKeepPaths uploads them as a/report.xml, b/report.xml and
+//     b/summary.txt, i.e. doesn't flatten them at all
+//   - ArtifactFlattenErrorOnCollision would upload them as report.xml and
+//     summary.txt, but fails the upload because two of them are report.xml
+//   - ArtifactFlattenSuffix uploads them as report.xml, report-1.xml and
+//     summary.txt, numbering the files that collide in the order they're
+//     found
+const (
+	ArtifactFlattenKeepPaths        = "keep-paths"
+	ArtifactFlattenErrorOnCollision = "error-on-collision"
+	ArtifactFlattenSuffix           = "suffix"
+)
+
+// validateFlattenStrategy returns an error if strategy isn't one of the
+// ArtifactFlatten strategies. Empty is ArtifactFlattenKeepPaths.
+func validateFlattenStrategy(strategy string) error {
+	switch strategy {
+	case "", ArtifactFlattenKeepPaths, ArtifactFlattenErrorOnCollision, ArtifactFlattenSuffix:
+		return nil
+	}
+	return fmt.Errorf("invalid flatten strategy %q, it must be one of %s, %s or %s",
+		strategy, ArtifactFlattenKeepPaths, ArtifactFlattenErrorOnCollision, ArtifactFlattenSuffix)
+}
+
+// flattenPath returns the path a file should be uploaded as under the
+// FlattenStrategy, given the flattened paths already taken by other files,
+// and adds it to them. Collisions are only resolved for ArtifactFlattenSuffix;
+// for ArtifactFlattenErrorOnCollision they're left to be reported with the
+// other files that would be uploaded as the same path.
+func (a *ArtifactUploader) flattenPath(path string, taken map[string]bool) string {
+	switch a.conf.FlattenStrategy {
+	case "", ArtifactFlattenKeepPaths:
+		return path
+	case ArtifactFlattenErrorOnCollision:
+		return filepath.Base(path)
+	}
+
+	name := filepath.Base(path)
+	if taken[name] {
+		// Number the file before its extension, unless it's all extension
+		// (like .env), so it keeps opening with the same program
+		ext := filepath.Ext(name)
+		stem := strings.TrimSuffix(name, ext)
+		if stem == "" {
+			stem, ext = name, ""
+		}
+		for i := 1; taken[name]; i++ {
+			name = fmt.Sprintf("%s-%d%s", stem, i, ext)
+		}
+	}
+	taken[name] = true
+
+	return name
+}
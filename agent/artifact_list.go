@@ -0,0 +1,44 @@
+package agent
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// List finds the files that would be uploaded, and returns the paths they'd
+// be uploaded as, without reading or hashing them, or contacting the Agent
+// API. Everything that decides which files match and what they're called is
+// taken into account, but files that would be skipped once they're read or
+// stat'd (e.g. by SkipEmpty or ModifiedSince) are still listed, and files that
+// would be uploaded as the same path aren't an error.
+func (a *ArtifactUploader) List() ([]string, error) {
+	if a.conf.PathSeparator != "" && utf8.RuneCountInString(a.conf.PathSeparator) != 1 {
+		return nil, fmt.Errorf("invalid path separator %q, it must be a single character", a.conf.PathSeparator)
+	}
+
+	if err := validateFlattenStrategy(a.conf.FlattenStrategy); err != nil {
+		return nil, err
+	}
+
+	base, err := a.baseDirectory()
+	if err != nil {
+		return nil, err
+	}
+
+	matches, err := a.match(base)
+	if err != nil {
+		return nil, err
+	}
+
+	flattened := make(map[string]bool)
+	paths := make([]string, 0, len(matches))
+	for _, match := range matches {
+		path, err := a.uploadPath(base, match, flattened)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+
+	return paths, nil
+}
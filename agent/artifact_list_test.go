@@ -0,0 +1,64 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestList(t *testing.T) {
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "logs"), 0o777); err != nil {
+		t.Fatalf("os.MkdirAll(logs) error = %v", err)
+	}
+	for _, name := range []string{"logs/Build.log", "logs/empty.log", "llamas.txt"} {
+		content := "llamas"
+		if name == "logs/empty.log" {
+			content = ""
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o666); err != nil {
+			t.Fatalf("os.WriteFile(%s) error = %v", name, err)
+		}
+	}
+	os.Chdir(dir)
+
+	// Nothing is read, so empty files are still listed
+	uploader := NewArtifactUploader(logger.Discard, nil, ArtifactUploaderConfig{
+		Paths:          "logs/*.log",
+		LowercasePaths: true,
+		SkipEmpty:      true,
+	})
+
+	paths, err := uploader.List()
+	if err != nil {
+		t.Fatalf("uploader.List() error = %v", err)
+	}
+	for i := range paths {
+		paths[i] = filepath.ToSlash(paths[i])
+	}
+	sort.Strings(paths)
+
+	assert.Equal(t, []string{"logs/build.log", "logs/empty.log"}, paths)
+}
+
+func TestListNoMatches(t *testing.T) {
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+
+	os.Chdir(t.TempDir())
+
+	uploader := NewArtifactUploader(logger.Discard, nil, ArtifactUploaderConfig{Paths: "*.txt"})
+
+	paths, err := uploader.List()
+	if err != nil {
+		t.Fatalf("uploader.List() error = %v", err)
+	}
+	assert.Empty(t, paths)
+}
@@ -0,0 +1,102 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/buildkite/agent/v3/api"
+)
+
+// DefaultArtifactManifestName is the path the manifest is uploaded as when
+// UploadManifest is set without a ManifestName
+const DefaultArtifactManifestName = "manifest.json"
+
+// ArtifactManifest lists the artifacts in an upload, so that consumers can
+// find them all from a single artifact
+type ArtifactManifest struct {
+	Artifacts []ArtifactManifestEntry `json:"artifacts"`
+}
+
+// ArtifactManifestEntry describes one of the artifacts in an ArtifactManifest
+type ArtifactManifestEntry struct {
+	Path        string `json:"path"`
+	FileSize    int64  `json:"file_size"`
+	Sha256Sum   string `json:"sha256sum"`
+	ContentType string `json:"content_type"`
+}
+
+// WriteArtifactManifest writes an ArtifactManifest of the artifacts to w as
+// indented JSON
+func WriteArtifactManifest(w io.Writer, artifacts []*api.Artifact) error {
+	manifest := ArtifactManifest{Artifacts: make([]ArtifactManifestEntry, 0, len(artifacts))}
+	for _, artifact := range artifacts {
+		manifest.Artifacts = append(manifest.Artifacts, ArtifactManifestEntry{
+			Path:        artifact.Path,
+			FileSize:    artifact.FileSize,
+			Sha256Sum:   artifact.Sha256Sum,
+			ContentType: artifact.ContentType,
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(manifest)
+}
+
+// manifestArtifact writes the manifest of the artifacts to a temporary file,
+// and builds an artifact to upload it as. The returned function removes the
+// file once it's been uploaded.
+func (a *ArtifactUploader) manifestArtifact(artifacts []*api.Artifact) (*api.Artifact, func(), error) {
+	name := a.conf.ManifestName
+	if name == "" {
+		name = DefaultArtifactManifestName
+	}
+
+	return a.generatedArtifact("manifest", name, artifacts, func(w io.Writer) error {
+		return WriteArtifactManifest(w, artifacts)
+	})
+}
+
+// generatedArtifact writes a JSON file describing the other artifacts, like
+// the manifest, to a file in TempDir with write, and builds an artifact to upload
+// it as name. The returned function removes the file once it's been uploaded.
+func (a *ArtifactUploader) generatedArtifact(kind, name string, artifacts []*api.Artifact, write func(io.Writer) error) (*api.Artifact, func(), error) {
+	for _, artifact := range artifacts {
+		if artifact.Path == name {
+			return nil, nil, fmt.Errorf("file %s would be uploaded as %s, which is where the %s is uploaded", artifact.AbsolutePath, name, kind)
+		}
+	}
+
+	f, err := a.createTemp("buildkite-artifact-" + kind + "-*.json")
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating %s file: %w", kind, err)
+	}
+	cleanup := func() { os.Remove(f.Name()) }
+
+	if err := write(f); err != nil {
+		f.Close()
+		cleanup()
+		return nil, nil, fmt.Errorf("writing %s: %w", kind, err)
+	}
+	if err := f.Close(); err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("writing %s: %w", kind, err)
+	}
+
+	// Temporary files are only readable by their owner, which isn't a
+	// useful mode to record for a generated file
+	if err := os.Chmod(f.Name(), 0o644); err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("writing %s: %w", kind, err)
+	}
+
+	artifact, err := a.build(name, f.Name(), "")
+	if err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("building %s artifact: %w", kind, err)
+	}
+
+	return artifact, cleanup, nil
+}
@@ -0,0 +1,88 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteArtifactManifest(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteArtifactManifest(&buf, []*api.Artifact{
+		{Path: "llamas.txt", FileSize: 7, Sha256Sum: "b36293fc54a3dc9e1582b8fa065aacd3b71e0622777b3a25be508671db5d47cd", ContentType: "text/plain"},
+	})
+	if err != nil {
+		t.Fatalf("WriteArtifactManifest() error = %v", err)
+	}
+
+	assert.JSONEq(t, `{"artifacts": [{
+		"path": "llamas.txt",
+		"file_size": 7,
+		"sha256sum": "b36293fc54a3dc9e1582b8fa065aacd3b71e0622777b3a25be508671db5d47cd",
+		"content_type": "text/plain"
+	}]}`, buf.String())
+}
+
+func TestManifestArtifact(t *testing.T) {
+	uploader := NewArtifactUploader(logger.Discard, nil, ArtifactUploaderConfig{
+		UploadManifest: true,
+		ManifestName:   "camelids.json",
+	})
+
+	artifacts := []*api.Artifact{{Path: "llamas.txt", FileSize: 7}}
+
+	manifest, cleanup, err := uploader.manifestArtifact(artifacts)
+	if err != nil {
+		t.Fatalf("uploader.manifestArtifact() error = %v", err)
+	}
+	defer cleanup()
+
+	assert.Equal(t, "camelids.json", manifest.Path)
+	assert.Equal(t, "application/json", manifest.ContentType)
+	assert.Equal(t, "0644", manifest.FileMode)
+
+	b, err := os.ReadFile(manifest.AbsolutePath)
+	if err != nil {
+		t.Fatalf("os.ReadFile(manifest) error = %v", err)
+	}
+	var got ArtifactManifest
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("json.Unmarshal(manifest) error = %v", err)
+	}
+
+	// The manifest doesn't list itself
+	assert.Equal(t, []ArtifactManifestEntry{{Path: "llamas.txt", FileSize: 7}}, got.Artifacts)
+
+	cleanup()
+	if _, err := os.Stat(manifest.AbsolutePath); !os.IsNotExist(err) {
+		t.Errorf("os.Stat(manifest) error = %v, want it to have been removed", err)
+	}
+
+	// Files can't be uploaded where the manifest goes
+	artifacts = append(artifacts, &api.Artifact{Path: "camelids.json"})
+	if _, _, err := uploader.manifestArtifact(artifacts); err == nil {
+		t.Errorf("uploader.manifestArtifact() error = nil, want a collision error")
+	}
+}
+
+func TestManifestArtifactTempDir(t *testing.T) {
+	dir := t.TempDir()
+	uploader := NewArtifactUploader(logger.Discard, nil, ArtifactUploaderConfig{
+		UploadManifest: true,
+		TempDir:        dir,
+	})
+
+	manifest, cleanup, err := uploader.manifestArtifact([]*api.Artifact{{Path: "llamas.txt", FileSize: 7}})
+	if err != nil {
+		t.Fatalf("uploader.manifestArtifact() error = %v", err)
+	}
+	defer cleanup()
+
+	assert.Equal(t, dir, filepath.Dir(manifest.AbsolutePath))
+}
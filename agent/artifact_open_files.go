@@ -0,0 +1,27 @@
+package agent
+
+// DefaultArtifactMaxOpenFiles is the most files an upload has open at once,
+// when MaxOpenFiles isn't set and the process's file descriptor limit can't
+// be found
+const DefaultArtifactMaxOpenFiles = 256
+
+// fileDescriptorLimit returns the process's soft limit on open file
+// descriptors, and whether there is one. It's a variable so that tests can
+// simulate a low limit.
+var fileDescriptorLimit = openFileLimit
+
+// maxOpenFiles is the most files the uploader may have open at once. Unless
+// it's configured, it's a quarter of the file descriptor limit, leaving the
+// rest for network connections and everything else in the process.
+func (a *ArtifactUploader) maxOpenFiles() int {
+	if a.conf.MaxOpenFiles > 0 {
+		return a.conf.MaxOpenFiles
+	}
+	if limit, ok := fileDescriptorLimit(); ok {
+		if limit/4 < 1 {
+			return 1
+		}
+		return limit / 4
+	}
+	return DefaultArtifactMaxOpenFiles
+}
@@ -0,0 +1,50 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaxOpenFilesWithLowFileDescriptorLimit(t *testing.T) {
+	defer func(f func() (int, bool)) { fileDescriptorLimit = f }(fileDescriptorLimit)
+	fileDescriptorLimit = func() (int, bool) { return 16, true }
+
+	uploader := NewArtifactUploader(logger.Discard, nil, ArtifactUploaderConfig{
+		MinConcurrency: 8,
+		MaxConcurrency: 100,
+	})
+
+	assert.Equal(t, 4, uploader.maxOpenFiles())
+
+	min, max := uploader.concurrencyBounds()
+	assert.Equal(t, 4, min)
+	assert.Equal(t, 4, max)
+}
+
+func TestMaxOpenFiles(t *testing.T) {
+	defer func(f func() (int, bool)) { fileDescriptorLimit = f }(fileDescriptorLimit)
+
+	tests := []struct {
+		name         string
+		maxOpenFiles int
+		limit        int
+		hasLimit     bool
+		want         int
+	}{
+		{name: "configured", maxOpenFiles: 10, limit: 1024, hasLimit: true, want: 10},
+		{name: "from limit", limit: 1024, hasLimit: true, want: 256},
+		{name: "tiny limit", limit: 3, hasLimit: true, want: 1},
+		{name: "no limit", want: DefaultArtifactMaxOpenFiles},
+	}
+
+	for _, test := range tests {
+		fileDescriptorLimit = func() (int, bool) { return test.limit, test.hasLimit }
+
+		uploader := NewArtifactUploader(logger.Discard, nil, ArtifactUploaderConfig{
+			MaxOpenFiles: test.maxOpenFiles,
+		})
+		assert.Equal(t, test.want, uploader.maxOpenFiles(), test.name)
+	}
+}
@@ -0,0 +1,377 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/buildkite/agent/v3/logger"
+	zglob "github.com/mattn/go-zglob"
+)
+
+// GlobOptions configures how ExpandPaths matches files
+type GlobOptions struct {
+	// The directory relative patterns are resolved from. Empty means the
+	// current working directory.
+	BaseDir string
+
+	// The character that separates patterns. Empty means
+	// ArtifactPathDelimiter.
+	Separator string
+
+	// Whether to follow symbolic links to files and directories
+	FollowSymlinks bool
+
+	// Whether to skip symbolic links to files outside of the base directory,
+	// when following symbolic links
+	ConfineToBaseDir bool
+
+	// Whether to treat each pattern as a literal file name rather than a glob
+	NoGlob bool
+
+	// Whether globs should skip directories whose names start with a dot,
+	// like .git, unless the pattern names one itself
+	SkipHiddenDirs bool
+
+	// Whether to return an error if any of the patterns don't match a file,
+	// rather than ignoring them
+	Strict bool
+
+	// Whether to match directories that are empty, rather than skipping them
+	// like other directories
+	MatchEmptyDirs bool
+
+	// The logger to use for reporting skipped matches. If nil, nothing is
+	// logged.
+	Logger logger.Logger
+}
+
+// pathMatch is a file matched by one of the patterns given to expandPaths
+type pathMatch struct {
+	// The pattern the file matched, as it was given
+	globPath string
+
+	// The file as it was returned by the glob
+	file string
+
+	// The absolute path of the file
+	absolutePath string
+
+	// The path to read the file from, which is absolutePath with symlinks
+	// resolved if they're being followed
+	readPath string
+
+	// The directory the file is uploaded relative to, if it isn't the
+	// usual one
+	root string
+
+	// Whether the match is an empty directory rather than a file, see
+	// GlobOptions.MatchEmptyDirs
+	emptyDir bool
+}
+
+// ExpandPaths returns the absolute paths of the files matching patterns, which
+// are separated by opts.Separator, using the same rules as artifact
+// uploads: globs can include * and **, duplicates and directories are skipped
+// (except empty ones, if opts.MatchEmptyDirs is set), and patterns that don't
+// match anything are ignored, unless opts.Strict is set.
+func ExpandPaths(patterns string, opts GlobOptions) ([]string, error) {
+	matches, err := expandPaths(patterns, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(matches))
+	for _, match := range matches {
+		paths = append(paths, match.absolutePath)
+	}
+	return paths, nil
+}
+
+func expandPaths(patterns string, opts GlobOptions) ([]pathMatch, error) {
+	l := opts.Logger
+	if l == nil {
+		l = logger.Discard
+	}
+
+	// Symlinks are confined to the base directory as it is on disk, since
+	// it may be reached through symlinks itself
+	var confineTo string
+	if opts.FollowSymlinks && opts.ConfineToBaseDir {
+		base := opts.BaseDir
+		if base == "" {
+			wd, err := os.Getwd()
+			if err != nil {
+				return nil, fmt.Errorf("getting working directory: %w", err)
+			}
+			base = wd
+		}
+
+		var err error
+		confineTo, err = filepath.EvalSymlinks(base)
+		if err != nil {
+			return nil, fmt.Errorf("resolving symlinks for %s: %w", base, err)
+		}
+	}
+
+	// Resolve the globs (with * and ** in them), if it's a non-globbed path and doesn't exists
+	// then we will get the ErrNotExist that is handled below
+	globfunc := zglob.Glob
+	if opts.FollowSymlinks {
+		// Follow symbolic links for files & directories while expanding globs
+		globfunc = zglob.GlobFollowSymlinks
+	}
+	if opts.SkipHiddenDirs {
+		globfunc = func(pattern string) ([]string, error) {
+			return globSkippingHiddenDirs(pattern, opts.FollowSymlinks)
+		}
+	}
+	if opts.NoGlob {
+		globfunc = literalPath
+	}
+
+	separator := opts.Separator
+	if separator == "" {
+		separator = ArtifactPathDelimiter
+	}
+
+	// file paths are deduplicated after resolving globs etc
+	seenPaths := make(map[string]bool)
+
+	// patterns that didn't match any files, for reporting when strict
+	var unmatched []string
+
+	var matches []pathMatch
+	for _, globPath := range strings.Split(patterns, separator) {
+		globPath = strings.TrimSpace(globPath)
+		if globPath == "" {
+			continue
+		}
+
+		l.Debug("Searching for %s", globPath)
+
+		// Relative globs are resolved from the working directory, unless
+		// we've been asked to resolve them from somewhere else
+		pattern := globPath
+		if opts.BaseDir != "" && !filepath.IsAbs(globPath) {
+			pattern = filepath.Join(opts.BaseDir, globPath)
+		}
+
+		files, err := globfunc(pattern)
+		if errors.Is(err, os.ErrNotExist) {
+			l.Info("File not found: %s", globPath)
+			unmatched = append(unmatched, globPath)
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("resolving glob: %w", err)
+		}
+
+		// Files already matched by an earlier pattern still count as
+		// matches for this one
+		matched := false
+
+		for _, file := range files {
+			absolutePath, err := filepath.Abs(file)
+			if err != nil {
+				return nil, fmt.Errorf("resolving absolute path for file %s: %w", file, err)
+			}
+
+			// dedupe based on resolved absolutePath
+			if _, ok := seenPaths[absolutePath]; ok {
+				l.Debug("Skipping duplicate path %s", file)
+				matched = true
+				continue
+			}
+			seenPaths[absolutePath] = true
+
+			// Ignore directories, we only want files (unless they're
+			// empty, and we want those too)
+			if isDir(absolutePath) {
+				if opts.MatchEmptyDirs && isEmptyDir(absolutePath) {
+					matches = append(matches, pathMatch{
+						globPath:     globPath,
+						file:         file,
+						absolutePath: absolutePath,
+						readPath:     absolutePath,
+						emptyDir:     true,
+					})
+					matched = true
+					continue
+				}
+				l.Debug("Skipping directory %s", file)
+				continue
+			}
+
+			// When following symlinks, read symlinked files from their
+			// target, while still matching them at their own path
+			readPath := absolutePath
+			if opts.FollowSymlinks {
+				readPath, err = filepath.EvalSymlinks(absolutePath)
+				if err != nil {
+					return nil, fmt.Errorf("resolving symlinks for file %s: %w", file, err)
+				}
+				if confineTo != "" && !isWithin(confineTo, readPath) {
+					l.Warn("Skipping %s, which is a symlink to %s outside of %s", file, readPath, confineTo)
+					continue
+				}
+			}
+
+			matches = append(matches, pathMatch{
+				globPath:     globPath,
+				file:         file,
+				absolutePath: absolutePath,
+				readPath:     readPath,
+			})
+			matched = true
+		}
+
+		if !matched {
+			unmatched = append(unmatched, globPath)
+		}
+	}
+
+	if opts.Strict && len(unmatched) > 0 {
+		return nil, fmt.Errorf("no files matched %q", unmatched)
+	}
+
+	return matches, nil
+}
+
+// globSkippingHiddenDirs resolves pattern like zglob, except that it doesn't
+// descend into directories beneath the pattern's root whose names start with a
+// dot, unless the pattern itself names a dot directory
+func globSkippingHiddenDirs(pattern string, followSymlinks bool) ([]string, error) {
+	root, rest, ok := splitGlobRoot(pattern)
+	if !ok || strings.HasPrefix(rest, ".") || strings.Contains(rest, "/.") {
+		if followSymlinks {
+			return zglob.GlobFollowSymlinks(pattern)
+		}
+		return zglob.Glob(pattern)
+	}
+
+	// zglob can't be told to prune directories, and fastwalk can't walk
+	// through symlinks without it, so when following symlinks we have to
+	// walk everything and throw away what was found in hidden directories
+	if followSymlinks {
+		files, err := zglob.GlobFollowSymlinks(pattern)
+		if err != nil {
+			return nil, err
+		}
+		visible := files[:0]
+		for _, file := range files {
+			rel, err := filepath.Rel(root, file)
+			if err != nil || !inHiddenDir(rel) {
+				visible = append(visible, file)
+			}
+		}
+		return visible, nil
+	}
+
+	if _, err := os.Stat(root); err != nil {
+		return nil, err
+	}
+
+	var files []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != root && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ok, _ := zglob.Match(pattern, path); ok {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// splitGlobRoot splits pattern into the directory that globbing starts from,
+// and the rest of the pattern (with forward slashes), or returns false if
+// pattern isn't a glob
+func splitGlobRoot(pattern string) (root, rest string, ok bool) {
+	segments := strings.Split(filepath.ToSlash(pattern), "/")
+	for i, segment := range segments {
+		if !strings.ContainsAny(segment, "*{") {
+			continue
+		}
+		root = filepath.FromSlash(strings.Join(segments[:i], "/"))
+		switch {
+		case i == 0:
+			root = "."
+		case root == "":
+			root = string(filepath.Separator)
+		}
+		return root, strings.Join(segments[i:], "/"), true
+	}
+	return "", "", false
+}
+
+// globBase returns the fixed directory at the start of pattern, before any
+// glob characters, or the directory of a literal path, e.g. /var/log for
+// /var/log/**/*.log or /var/log/syslog
+func globBase(pattern string, noGlob bool) string {
+	if root, _, ok := splitGlobRoot(pattern); ok && !noGlob {
+		return root
+	}
+	return filepath.Dir(pattern)
+}
+
+// inHiddenDir reports whether the relative path is inside a directory whose
+// name starts with a dot
+func inHiddenDir(rel string) bool {
+	dirs := strings.Split(filepath.ToSlash(filepath.Dir(rel)), "/")
+	for _, dir := range dirs {
+		if strings.HasPrefix(dir, ".") && dir != "." && dir != ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// isWithin reports whether path is dir or inside it. Both should be absolute
+// and clean.
+func isWithin(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// literalPath returns path as the only match of a "glob" that isn't expanded,
+// or os.ErrNotExist if there's no such file, just like a glob would
+func literalPath(path string) ([]string, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	return []string{path}, nil
+}
+
+func isDir(path string) bool {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return fi.IsDir()
+}
+
+// isEmptyDir returns whether path is a directory with nothing in it
+func isEmptyDir(path string) bool {
+	dir, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer dir.Close()
+
+	_, err = dir.Readdirnames(1)
+	return errors.Is(err, io.EOF)
+}
@@ -0,0 +1,131 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandPaths(t *testing.T) {
+	wd, _ := os.Getwd()
+	root := filepath.Join(wd, "..")
+	os.Chdir(root)
+	defer os.Chdir(wd)
+
+	fixture := func(elem ...string) string {
+		return filepath.Join(append([]string{root, "test", "fixtures", "artifacts"}, elem...)...)
+	}
+
+	paths, err := ExpandPaths(strings.Join([]string{
+		filepath.Join("test", "fixtures", "artifacts", "**", "*.jpg"),
+		filepath.Join("test", "fixtures", "artifacts", "folder", "Commando.jpg"), // dupe
+		filepath.Join("test", "fixtures", "artifacts", "gifs"),                   // directory
+		filepath.Join("dontmatchanything", "*"),
+	}, ArtifactPathDelimiter), GlobOptions{})
+	if err != nil {
+		t.Fatalf("ExpandPaths() error = %v", err)
+	}
+
+	assert.ElementsMatch(t, []string{
+		fixture("Mr Freeze.jpg"),
+		fixture("folder", "Commando.jpg"),
+		fixture("this is a folder with a space", "The Terminator.jpg"),
+		fixture("links", "terminator", "terminator2.jpg"),
+	}, paths)
+}
+
+func TestExpandPathsFollowingSymlinks(t *testing.T) {
+	wd, _ := os.Getwd()
+	root := filepath.Join(wd, "..")
+	os.Chdir(root)
+	defer os.Chdir(wd)
+
+	paths, err := ExpandPaths(filepath.Join("test", "fixtures", "artifacts", "links", "**", "*.jpg"), GlobOptions{
+		FollowSymlinks: true,
+	})
+	if err != nil {
+		t.Fatalf("ExpandPaths() error = %v", err)
+	}
+
+	assert.ElementsMatch(t, []string{
+		filepath.Join(root, "test", "fixtures", "artifacts", "links", "terminator", "terminator2.jpg"),
+		filepath.Join(root, "test", "fixtures", "artifacts", "links", "folder-link", "terminator2.jpg"),
+	}, paths)
+}
+
+func TestExpandPathsWithBaseDir(t *testing.T) {
+	wd, _ := os.Getwd()
+	root := filepath.Join(wd, "..")
+
+	paths, err := ExpandPaths("*.png", GlobOptions{
+		BaseDir: filepath.Join(root, "test", "fixtures", "artifacts"),
+	})
+	if err != nil {
+		t.Fatalf("ExpandPaths() error = %v", err)
+	}
+
+	assert.Equal(t, []string{filepath.Join(root, "test", "fixtures", "artifacts", "Genisys.png")}, paths)
+}
+
+func TestExpandPathsWithSeparator(t *testing.T) {
+	wd, _ := os.Getwd()
+	root := filepath.Join(wd, "..")
+	os.Chdir(root)
+	defer os.Chdir(wd)
+
+	paths, err := ExpandPaths(strings.Join([]string{
+		filepath.Join("test", "fixtures", "artifacts", "Genisys.png"),
+		filepath.Join("test", "fixtures", "artifacts", "gifs", "Smile.gif"),
+	}, "\n"), GlobOptions{Separator: "\n"})
+	if err != nil {
+		t.Fatalf("ExpandPaths() error = %v", err)
+	}
+
+	assert.Equal(t, []string{
+		filepath.Join(root, "test", "fixtures", "artifacts", "Genisys.png"),
+		filepath.Join(root, "test", "fixtures", "artifacts", "gifs", "Smile.gif"),
+	}, paths)
+}
+
+func TestExpandPathsSkippingHiddenDirs(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		filepath.Join("a", "b.log"),
+		filepath.Join(".git", "c.log"),
+		filepath.Join("a", ".cache", "d.log"),
+		filepath.Join(".e.log"),
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
+			t.Fatalf("os.MkdirAll(%q) error = %v", filepath.Dir(path), err)
+		}
+		if err := os.WriteFile(path, []byte("llamas"), 0o666); err != nil {
+			t.Fatalf("os.WriteFile(%q) error = %v", path, err)
+		}
+	}
+
+	paths, err := ExpandPaths(filepath.Join("**", "*.log"), GlobOptions{
+		BaseDir:        dir,
+		SkipHiddenDirs: true,
+	})
+	if err != nil {
+		t.Fatalf("ExpandPaths() error = %v", err)
+	}
+	assert.ElementsMatch(t, []string{
+		filepath.Join(dir, "a", "b.log"),
+		filepath.Join(dir, ".e.log"),
+	}, paths)
+
+	// Globs that name a hidden directory still search it
+	paths, err = ExpandPaths(filepath.Join(".git", "*.log"), GlobOptions{
+		BaseDir:        dir,
+		SkipHiddenDirs: true,
+	})
+	if err != nil {
+		t.Fatalf("ExpandPaths() error = %v", err)
+	}
+	assert.Equal(t, []string{filepath.Join(dir, ".git", "c.log")}, paths)
+}
@@ -0,0 +1,160 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/buildkite/agent/v3/api"
+)
+
+// DefaultArtifactProgressInterval is the least time between progress ticks,
+// unless ArtifactUploaderConfig.ProgressInterval is set
+const DefaultArtifactProgressInterval = time.Second
+
+// ArtifactProgress is a tick of an artifact upload's progress. Bytes are
+// counted as they're read to be uploaded, and artifacts once they've finished,
+// whether or not they were uploaded successfully. Each destination counts
+// separately.
+type ArtifactProgress struct {
+	Artifacts      int     `json:"artifacts"`
+	TotalArtifacts int     `json:"total_artifacts"`
+	Bytes          int64   `json:"bytes"`
+	TotalBytes     int64   `json:"total_bytes"`
+	Percent        float64 `json:"percent"`
+}
+
+// artifactProgressReporter writes ArtifactProgress ticks as JSON lines, no more
+// often than an interval apart. The final tick is always written. A nil
+// reporter does nothing.
+type artifactProgressReporter struct {
+	mu       sync.Mutex
+	progress ArtifactProgress
+	interval time.Duration
+	lastTick time.Time
+	encoder  *json.Encoder
+
+	// How far into each artifact that's still uploading has been read
+	reading map[*api.Artifact]int64
+}
+
+func newArtifactProgressReporter(w io.Writer, interval time.Duration, totalArtifacts int, totalBytes int64) *artifactProgressReporter {
+	if interval <= 0 {
+		interval = DefaultArtifactProgressInterval
+	}
+	return &artifactProgressReporter{
+		progress: ArtifactProgress{
+			TotalArtifacts: totalArtifacts,
+			TotalBytes:     totalBytes,
+		},
+		interval: interval,
+		encoder:  json.NewEncoder(w),
+		reading:  make(map[*api.Artifact]int64),
+	}
+}
+
+// read records that an artifact has been read up to offset to upload it.
+// Reading the same bytes again, e.g. when retrying, doesn't count twice.
+func (r *artifactProgressReporter) read(artifact *api.Artifact, offset int64) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if offset > artifact.FileSize {
+		offset = artifact.FileSize
+	}
+	if offset <= r.reading[artifact] {
+		return
+	}
+	r.progress.Bytes += offset - r.reading[artifact]
+	r.reading[artifact] = offset
+
+	r.tick()
+}
+
+// finished records that an artifact has finished uploading
+func (r *artifactProgressReporter) finished(artifact *api.Artifact) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.progress.Artifacts++
+	r.progress.Bytes += artifact.FileSize - r.reading[artifact]
+	delete(r.reading, artifact)
+
+	r.tick()
+}
+
+// tick writes the progress, unless it was written less than the interval ago
+// and this isn't the final tick. It must be called with the lock held.
+func (r *artifactProgressReporter) tick() {
+	// Progress is by bytes, unless there aren't any, e.g. if all the
+	// artifacts are empty
+	if r.progress.TotalBytes > 0 {
+		r.progress.Percent = 100 * float64(r.progress.Bytes) / float64(r.progress.TotalBytes)
+	} else {
+		r.progress.Percent = 100 * float64(r.progress.Artifacts) / float64(r.progress.TotalArtifacts)
+	}
+
+	last := r.progress.Artifacts == r.progress.TotalArtifacts
+	if !last && time.Since(r.lastTick) < r.interval {
+		return
+	}
+	r.lastTick = time.Now()
+
+	// Progress is best effort, so there's nothing to do if it can't be written
+	_ = r.encoder.Encode(r.progress)
+}
+
+type artifactProgressKey struct{}
+
+// withArtifactProgress returns a context carrying r, so that the files the
+// uploaders open with openArtifact report how much of them has been read
+func withArtifactProgress(ctx context.Context, r *artifactProgressReporter) context.Context {
+	return context.WithValue(ctx, artifactProgressKey{}, r)
+}
+
+// artifactFile is an artifact's file opened for uploading, which reports how
+// far into it has been read
+type artifactFile struct {
+	*os.File
+
+	artifact *api.Artifact
+	progress *artifactProgressReporter
+}
+
+// openArtifact opens an artifact's file for uploading. Reads from it are
+// reported to the progress reporter in ctx, if there is one.
+func openArtifact(ctx context.Context, artifact *api.Artifact) (*artifactFile, error) {
+	f, err := os.Open(artifact.AbsolutePath)
+	if err != nil {
+		return nil, err
+	}
+	progress, _ := ctx.Value(artifactProgressKey{}).(*artifactProgressReporter)
+	return &artifactFile{File: f, artifact: artifact, progress: progress}, nil
+}
+
+func (f *artifactFile) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	if n > 0 && f.progress != nil {
+		if offset, err := f.File.Seek(0, io.SeekCurrent); err == nil {
+			f.progress.read(f.artifact, offset)
+		}
+	}
+	return n, err
+}
+
+func (f *artifactFile) ReadAt(p []byte, off int64) (int, error) {
+	n, err := f.File.ReadAt(p, off)
+	f.progress.read(f.artifact, off+int64(n))
+	return n, err
+}
@@ -0,0 +1,108 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent/v3/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArtifactProgressReporter(t *testing.T) {
+	var buf bytes.Buffer
+	r := newArtifactProgressReporter(&buf, time.Hour, 3, 100)
+
+	r.finished(&api.Artifact{FileSize: 50})
+	r.finished(&api.Artifact{FileSize: 25})
+	r.finished(&api.Artifact{FileSize: 25})
+
+	// The second tick is throttled, but the final one never is
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("len(lines) = %d, want 2: %q", len(lines), buf.String())
+	}
+
+	var first, last ArtifactProgress
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatalf("json.Unmarshal(%q) error = %v", lines[0], err)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &last); err != nil {
+		t.Fatalf("json.Unmarshal(%q) error = %v", lines[1], err)
+	}
+
+	assert.Equal(t, ArtifactProgress{Artifacts: 1, TotalArtifacts: 3, Bytes: 50, TotalBytes: 100, Percent: 50}, first)
+	assert.Equal(t, ArtifactProgress{Artifacts: 3, TotalArtifacts: 3, Bytes: 100, TotalBytes: 100, Percent: 100}, last)
+}
+
+func TestArtifactProgressReporterNil(t *testing.T) {
+	var r *artifactProgressReporter
+	r.read(&api.Artifact{FileSize: 42}, 21)
+	r.finished(&api.Artifact{FileSize: 42})
+}
+
+func TestArtifactProgressReporterBytesRead(t *testing.T) {
+	var buf bytes.Buffer
+	r := newArtifactProgressReporter(&buf, 0, 2, 100)
+	r.interval = -1 // Every tick
+
+	llamas := &api.Artifact{FileSize: 60}
+	alpacas := &api.Artifact{FileSize: 40}
+
+	r.read(llamas, 30)
+	r.read(alpacas, 10)
+	r.read(llamas, 20) // Re-reads, e.g. on retry, don't count again
+	r.read(llamas, 90) // Nor past the end
+	r.finished(llamas)
+	r.finished(alpacas)
+
+	var ticks []ArtifactProgress
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var tick ArtifactProgress
+		if err := json.Unmarshal([]byte(line), &tick); err != nil {
+			t.Fatalf("json.Unmarshal(%q) error = %v", line, err)
+		}
+		ticks = append(ticks, tick)
+	}
+
+	assert.Equal(t, []ArtifactProgress{
+		{Artifacts: 0, TotalArtifacts: 2, Bytes: 30, TotalBytes: 100, Percent: 30},
+		{Artifacts: 0, TotalArtifacts: 2, Bytes: 40, TotalBytes: 100, Percent: 40},
+		{Artifacts: 0, TotalArtifacts: 2, Bytes: 70, TotalBytes: 100, Percent: 70},
+		{Artifacts: 1, TotalArtifacts: 2, Bytes: 70, TotalBytes: 100, Percent: 70},
+		{Artifacts: 2, TotalArtifacts: 2, Bytes: 100, TotalBytes: 100, Percent: 100},
+	}, ticks)
+}
+
+func TestOpenArtifactReportsProgress(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "llamas.txt")
+	if err := os.WriteFile(path, []byte("llamas and alpacas"), 0o666); err != nil {
+		t.Fatalf("os.WriteFile(llamas.txt) error = %v", err)
+	}
+	artifact := &api.Artifact{AbsolutePath: path, FileSize: 18}
+
+	var buf bytes.Buffer
+	r := newArtifactProgressReporter(&buf, 0, 1, 18)
+	r.interval = -1 // Every tick
+
+	f, err := openArtifact(withArtifactProgress(context.Background(), r), artifact)
+	if err != nil {
+		t.Fatalf("openArtifact() error = %v", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Read(make([]byte, 6)); err != nil {
+		t.Fatalf("f.Read() error = %v", err)
+	}
+	assert.Equal(t, int64(6), r.progress.Bytes)
+
+	if _, err := f.ReadAt(make([]byte, 7), 11); err != nil {
+		t.Fatalf("f.ReadAt() error = %v", err)
+	}
+	assert.Equal(t, int64(18), r.progress.Bytes)
+}
@@ -0,0 +1,92 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/buildkite/roko"
+)
+
+type ArtifactRenamerConfig struct {
+	// The ID of the Build that the artifact belongs to
+	BuildID string
+
+	// The ID of the Job that uploaded the artifact
+	JobID string
+
+	// The ID of the artifact to rename
+	ArtifactID string
+
+	// The new path for the artifact
+	Path string
+}
+
+type ArtifactRenamer struct {
+	// The rename config
+	conf ArtifactRenamerConfig
+
+	// The logger instance to use
+	logger logger.Logger
+
+	// The APIClient that will be used when renaming the artifact
+	apiClient APIClient
+}
+
+func NewArtifactRenamer(l logger.Logger, ac APIClient, c ArtifactRenamerConfig) *ArtifactRenamer {
+	return &ArtifactRenamer{
+		logger:    l,
+		apiClient: ac,
+		conf:      c,
+	}
+}
+
+// Rename changes the stored path of an already uploaded artifact, without
+// re-uploading it. It's an error for the new path to already be used by a
+// different artifact in the same build.
+func (a *ArtifactRenamer) Rename(ctx context.Context) (*api.Artifact, error) {
+	if a.conf.Path == "" {
+		return nil, fmt.Errorf("a new path is required to rename artifact %s", a.conf.ArtifactID)
+	}
+
+	existing, err := NewArtifactSearcher(a.logger, a.apiClient, a.conf.BuildID).
+		Search(ctx, a.conf.Path, "", false, true)
+	if err != nil {
+		return nil, fmt.Errorf("searching for artifacts at %q: %w", a.conf.Path, err)
+	}
+
+	for _, artifact := range existing {
+		if artifact.Path == a.conf.Path && artifact.ID != a.conf.ArtifactID {
+			return nil, fmt.Errorf("artifact %s already exists at %q", artifact.ID, a.conf.Path)
+		}
+	}
+
+	a.logger.Info("Renaming artifact %s to %q", a.conf.ArtifactID, a.conf.Path)
+
+	// Bound the operation, including its retries, by the client's MaxWait
+	ctx, cancel := a.apiClient.OperationContext(ctx)
+	defer cancel()
+
+	var renamed *api.Artifact
+	err = roko.NewRetrier(
+		roko.WithMaxAttempts(10),
+		roko.WithStrategy(roko.Constant(5*time.Second)),
+	).DoWithContext(ctx, func(r *roko.Retrier) error {
+		var resp *api.Response
+		renamed, resp, err = a.apiClient.RenameArtifact(ctx, a.conf.JobID, a.conf.ArtifactID, a.conf.Path)
+		if resp != nil && (resp.StatusCode == 401 || resp.StatusCode == 404 || resp.StatusCode == 422) {
+			r.Break()
+		}
+		if err != nil {
+			a.logger.Warn("%s (%s)", err, r)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return renamed, nil
+}
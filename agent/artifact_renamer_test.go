@@ -0,0 +1,67 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/agent/v3/logger"
+)
+
+func TestArtifactRenamer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.Method + " " + req.URL.RequestURI() {
+		case "GET /builds/my-build/artifacts/search?include_duplicates=true&query=llamas.txt&state=finished":
+			fmt.Fprint(rw, `[]`)
+		case "GET /builds/my-build/artifacts/search?include_duplicates=true&query=alpacas.txt&state=finished":
+			fmt.Fprint(rw, `[{"id": "other-artifact", "path": "alpacas.txt"}]`)
+		case "PATCH /jobs/my-job/artifacts/my-artifact":
+			var body api.ArtifactRenameRequest
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				http.Error(rw, err.Error(), http.StatusBadRequest)
+				return
+			}
+			fmt.Fprintf(rw, `{"id": "my-artifact", "path": %q}`, body.Path)
+		default:
+			http.Error(rw, "Not found", http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+
+	ac := api.NewClient(logger.Discard, api.Config{
+		Endpoint: server.URL,
+		Token:    "llamasforever",
+	})
+
+	renamer := NewArtifactRenamer(logger.Discard, ac, ArtifactRenamerConfig{
+		BuildID:    "my-build",
+		JobID:      "my-job",
+		ArtifactID: "my-artifact",
+		Path:       "llamas.txt",
+	})
+
+	artifact, err := renamer.Rename(ctx)
+	if err != nil {
+		t.Fatalf("renamer.Rename() error = %v", err)
+	}
+	if got, want := artifact.Path, "llamas.txt"; got != want {
+		t.Errorf("artifact.Path = %q, want %q", got, want)
+	}
+
+	collider := NewArtifactRenamer(logger.Discard, ac, ArtifactRenamerConfig{
+		BuildID:    "my-build",
+		JobID:      "my-job",
+		ArtifactID: "my-artifact",
+		Path:       "alpacas.txt",
+	})
+
+	if _, err := collider.Rename(ctx); err == nil {
+		t.Errorf("collider.Rename() error = nil, want a collision error")
+	}
+}
@@ -37,6 +37,10 @@ func (a *ArtifactSearcher) Search(ctx context.Context, query, scope string, incl
 
 	var artifacts []*api.Artifact
 
+	// Bound the operation, including its retries, by the client's MaxWait
+	ctx, cancel := a.apiClient.OperationContext(ctx)
+	defer cancel()
+
 	// Retry on transport errors, a failed search will return 0 artifacts
 	err := roko.NewRetrier(
 		roko.WithMaxAttempts(10),
@@ -0,0 +1,31 @@
+package agent
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/buildkite/agent/v3/api"
+)
+
+// WriteArtifactsSHA256SUMS writes the artifacts' SHA-256 checksums to w in the
+// format of GNU and BSD sha256sum, one artifact per line:
+//
+//	<sha256>  <path>
+//
+// so that `sha256sum -c` can check the files, from the directory their paths
+// are relative to. Like sha256sum, paths containing backslashes or newlines
+// are escaped, and their lines start with a backslash.
+func WriteArtifactsSHA256SUMS(w io.Writer, artifacts []*api.Artifact) error {
+	for _, artifact := range artifacts {
+		prefix, path := "", artifact.Path
+		if strings.ContainsAny(path, "\\\n") {
+			prefix = "\\"
+			path = strings.NewReplacer("\\", "\\\\", "\n", "\\n").Replace(path)
+		}
+		if _, err := fmt.Fprintf(w, "%s%s  %s\n", prefix, artifact.Sha256Sum, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package agent
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/buildkite/agent/v3/api"
+)
+
+func TestWriteArtifactsSHA256SUMS(t *testing.T) {
+	t.Parallel()
+
+	artifacts := []*api.Artifact{
+		{Path: "llamas.txt", Sha256Sum: "a3b4c5"},
+		{Path: "alpacas/fluffy alpaca.txt", Sha256Sum: "d6e7f8"},
+		{Path: "back\\slash\nnewline.txt", Sha256Sum: "0a1b2c"},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteArtifactsSHA256SUMS(&buf, artifacts); err != nil {
+		t.Fatalf("WriteArtifactsSHA256SUMS() error = %v", err)
+	}
+
+	want := "a3b4c5  llamas.txt\n" +
+		"d6e7f8  alpacas/fluffy alpaca.txt\n" +
+		`\0a1b2c  back\\slash\nnewline.txt` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("WriteArtifactsSHA256SUMS() wrote %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,42 @@
+package agent
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// ArtifactChecksumSidecarExt is the extension of the files next to artifacts
+// that hold the SHA-256 checksum to record for them, when sidecars are trusted
+const ArtifactChecksumSidecarExt = ".sha256"
+
+// readChecksumSidecar reads the SHA-256 checksum from the sidecar file for
+// path, if there is one. Sidecars hold a hex encoded checksum, optionally
+// followed by whitespace and a file name, like a line of sha256sum output.
+func readChecksumSidecar(path string) (sum string, ok bool, err error) {
+	sidecar := path + ArtifactChecksumSidecarExt
+
+	b, err := os.ReadFile(sidecar)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("reading checksum sidecar %s: %w", sidecar, err)
+	}
+
+	fields := strings.Fields(string(b))
+	if len(fields) == 0 {
+		return "", false, fmt.Errorf("checksum sidecar %s is empty", sidecar)
+	}
+
+	// sha256sum starts lines for escaped file names with a backslash
+	sum = strings.ToLower(strings.TrimPrefix(fields[0], "\\"))
+	if decoded, err := hex.DecodeString(sum); err != nil || len(decoded) != 32 {
+		return "", false, fmt.Errorf("checksum sidecar %s doesn't start with a SHA-256 checksum: %q", sidecar, fields[0])
+	}
+
+	return sum, true, nil
+}
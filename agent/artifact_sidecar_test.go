@@ -0,0 +1,52 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadChecksumSidecar(t *testing.T) {
+	t.Parallel()
+
+	const sum = "fc5e8608c7772e4ae834fbc47eec3d902099eb3599f5191e40d9e3d9b3764b0e"
+
+	tests := []struct {
+		name    string
+		sidecar string
+		wantSum string
+		wantOK  bool
+		wantErr bool
+		noWrite bool
+	}{
+		{name: "no sidecar", noWrite: true},
+		{name: "bare checksum", sidecar: sum + "\n", wantSum: sum, wantOK: true},
+		{name: "sha256sum line", sidecar: sum + "  llamas.tar.gz\n", wantSum: sum, wantOK: true},
+		{name: "upper case", sidecar: "FC5E8608C7772E4AE834FBC47EEC3D902099EB3599F5191E40D9E3D9B3764B0E", wantSum: sum, wantOK: true},
+		{name: "empty", sidecar: "\n", wantErr: true},
+		{name: "too short", sidecar: sum[:40], wantErr: true},
+		{name: "not hex", sidecar: "llamas" + sum[6:], wantErr: true},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(t.TempDir(), "llamas.tar.gz")
+			if !test.noWrite {
+				if err := os.WriteFile(path+ArtifactChecksumSidecarExt, []byte(test.sidecar), 0o666); err != nil {
+					t.Fatalf("os.WriteFile() error = %v", err)
+				}
+			}
+
+			gotSum, gotOK, err := readChecksumSidecar(path)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("readChecksumSidecar() error = %v, want error %t", err, test.wantErr)
+			}
+			if gotSum != test.wantSum || gotOK != test.wantOK {
+				t.Errorf("readChecksumSidecar() = (%q, %t), want (%q, %t)", gotSum, gotOK, test.wantSum, test.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package agent
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"time"
+
+	"github.com/buildkite/agent/v3/api"
+)
+
+// streamArtifact reads Stream to its end and returns an artifact for it, to be
+// uploaded as StreamPath. Every artifact's size and checksums are sent to
+// Buildkite before it's uploaded, so the stream can't be passed straight
+// through to storage: it's spooled to a file in TempDir, and hashed as it's
+// written rather than read back afterwards. The returned function removes the
+// file, and should be called once the artifact has been uploaded.
+func (a *ArtifactUploader) streamArtifact() (*api.Artifact, func(), error) {
+	if a.conf.StreamPath == "" {
+		return nil, nil, errors.New("a path to upload the stream as is required")
+	}
+	if a.conf.ExpiresIn < 0 {
+		return nil, nil, fmt.Errorf("invalid expiry %s, it must be positive", a.conf.ExpiresIn)
+	}
+
+	f, err := a.createTemp("buildkite-artifact-stream-*")
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating spool file: %w", err)
+	}
+	cleanup := func() { os.Remove(f.Name()) }
+
+	hash1, hash256 := sha1.New(), sha256.New()
+	size, err := io.CopyBuffer(io.MultiWriter(f, hash1, hash256), a.conf.Stream, make([]byte, hashBufferSize))
+	if err != nil {
+		f.Close()
+		cleanup()
+		return nil, nil, fmt.Errorf("spooling stream: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("spooling stream: %w", err)
+	}
+
+	contentType := a.conf.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(path.Ext(a.conf.StreamPath))
+		if contentType == "" {
+			contentType = ArtifactFallbackMimeType
+		}
+	}
+
+	// Temporary files are only readable by their owner, which isn't a useful
+	// mode to record for something that never had one
+	artifact := &api.Artifact{
+		Path:         a.conf.StreamPath,
+		AbsolutePath: f.Name(),
+		FileSize:     size,
+		Sha1Sum:      hex.EncodeToString(hash1.Sum(nil)),
+		Sha256Sum:    hex.EncodeToString(hash256.Sum(nil)),
+		ContentType:  contentType,
+		FileMode:     "0644",
+	}
+
+	artifact.ContentDisposition = a.contentDisposition(artifact.Path)
+
+	if a.conf.ExpiresIn > 0 {
+		expiresAt := time.Now().UTC().Add(a.conf.ExpiresIn).Truncate(time.Second)
+		artifact.ExpiresAt = &expiresAt
+	}
+
+	return artifact, cleanup, nil
+}
@@ -0,0 +1,84 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamArtifact(t *testing.T) {
+	uploader := NewArtifactUploader(logger.Discard, nil, ArtifactUploaderConfig{
+		Stream:     strings.NewReader("{\"llamas\": true}\n"),
+		StreamPath: "reports/report.json",
+	})
+
+	artifact, cleanup, err := uploader.streamArtifact()
+	if err != nil {
+		t.Fatalf("uploader.streamArtifact() error = %v", err)
+	}
+
+	assert.Equal(t, "reports/report.json", artifact.Path)
+	assert.Equal(t, int64(17), artifact.FileSize)
+	assert.Equal(t, "ba44169a8cb75694cdf5a9364e51cbd29fdd25a8", artifact.Sha1Sum)
+	assert.Equal(t, "1ce009f2e1ef3d13a170571e7a8f4b6befbd105baaea92b30a0b547f7a00421c", artifact.Sha256Sum)
+	assert.Equal(t, "application/json", artifact.ContentType)
+	assert.Equal(t, "0644", artifact.FileMode)
+
+	b, err := os.ReadFile(artifact.AbsolutePath)
+	if err != nil {
+		t.Fatalf("os.ReadFile(spool) error = %v", err)
+	}
+	assert.Equal(t, "{\"llamas\": true}\n", string(b))
+
+	cleanup()
+	_, err = os.Stat(artifact.AbsolutePath)
+	assert.True(t, os.IsNotExist(err), "spool file should be removed by cleanup")
+}
+
+func TestStreamArtifactRequiresPath(t *testing.T) {
+	uploader := NewArtifactUploader(logger.Discard, nil, ArtifactUploaderConfig{
+		Stream: strings.NewReader("llamas"),
+	})
+
+	if _, _, err := uploader.streamArtifact(); err == nil {
+		t.Fatal("uploader.streamArtifact() error = nil, want an error without a StreamPath")
+	}
+}
+
+func TestStreamArtifactTempDir(t *testing.T) {
+	dir := t.TempDir()
+	uploader := NewArtifactUploader(logger.Discard, nil, ArtifactUploaderConfig{
+		Stream:     strings.NewReader("llamas"),
+		StreamPath: "llamas.txt",
+		TempDir:    dir,
+	})
+
+	artifact, cleanup, err := uploader.streamArtifact()
+	if err != nil {
+		t.Fatalf("uploader.streamArtifact() error = %v", err)
+	}
+	defer cleanup()
+
+	assert.Equal(t, dir, filepath.Dir(artifact.AbsolutePath))
+}
+
+func TestUploadUnwritableTempDir(t *testing.T) {
+	uploader := NewArtifactUploader(logger.Discard, nil, ArtifactUploaderConfig{
+		Stream:     strings.NewReader("llamas"),
+		StreamPath: "llamas.txt",
+		TempDir:    filepath.Join(t.TempDir(), "missing"),
+	})
+
+	// The uploader has no API client, so it would panic if it got as far as
+	// creating artifacts
+	err := uploader.Upload(context.Background())
+	if err == nil {
+		t.Fatal("uploader.Upload() error = nil, want an error for the temp dir")
+	}
+	assert.Contains(t, err.Error(), "isn't writable")
+}
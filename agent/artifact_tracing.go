@@ -0,0 +1,50 @@
+package agent
+
+import (
+	"context"
+	"net/url"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// artifactTracerName is the name of the tracer artifact upload spans are
+// created with
+const artifactTracerName = "github.com/buildkite/agent/v3/agent"
+
+// newArtifactTracer returns a tracer from tp, or one that does nothing if tp
+// is nil
+func newArtifactTracer(tp trace.TracerProvider) trace.Tracer {
+	if tp == nil {
+		tp = trace.NewNoopTracerProvider()
+	}
+	return tp.Tracer(artifactTracerName)
+}
+
+// endSpan records err on span, if there was one, and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// artifactBackendName is the name of the storage backend for destination,
+// for describing uploads in spans
+func artifactBackendName(destination string) string {
+	if destination == "" {
+		return "buildkite"
+	}
+	if u, err := url.Parse(destination); err == nil && u.Scheme != "" {
+		return strings.ToLower(u.Scheme)
+	}
+	return "unknown"
+}
+
+// startArtifactSpan starts a span for a phase of an artifact upload
+func (a *ArtifactUploader) startArtifactSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return a.tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
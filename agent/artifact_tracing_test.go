@@ -0,0 +1,61 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestCollectTracing(t *testing.T) {
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+
+	dir := t.TempDir()
+	for _, name := range []string{"llamas.txt", "alpacas.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0o666); err != nil {
+			t.Fatalf("os.WriteFile(%s) error = %v", name, err)
+		}
+	}
+	os.Chdir(dir)
+
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	uploader := NewArtifactUploader(logger.Discard, nil, ArtifactUploaderConfig{
+		Paths:          "*.txt",
+		TracerProvider: tp,
+	})
+	if _, err := uploader.Collect(); err != nil {
+		t.Fatalf("uploader.Collect() error = %v", err)
+	}
+
+	spans := map[string][]attribute.KeyValue{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span.Attributes()
+	}
+
+	assert.Equal(t, map[string][]attribute.KeyValue{
+		"artifact.discover": {attribute.Int("artifact.files", 2)},
+		"artifact.hash":     {attribute.Int("artifact.files", 2), attribute.Int64("artifact.bytes", 21)},
+	}, spans)
+}
+
+func TestArtifactBackendName(t *testing.T) {
+	t.Parallel()
+
+	for destination, want := range map[string]string{
+		"":                  "buildkite",
+		"s3://bucket/path":  "s3",
+		"GS://bucket":       "gs",
+		"rt://repo/path":    "rt",
+		"not a destination": "unknown",
+	} {
+		assert.Equal(t, want, artifactBackendName(destination), "artifactBackendName(%q)", destination)
+	}
+}
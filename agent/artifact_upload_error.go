@@ -0,0 +1,180 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/buildkite/agent/v3/api"
+	"google.golang.org/api/googleapi"
+)
+
+// The categories of ArtifactUploadError
+const (
+	ArtifactUploadErrorFile    = "file"
+	ArtifactUploadErrorHTTP    = "http"
+	ArtifactUploadErrorNetwork = "network"
+	ArtifactUploadErrorTimeout = "timeout"
+	ArtifactUploadErrorUnknown = "unknown"
+)
+
+// ArtifactUploadError describes why an artifact failed to upload, in a form
+// that can be logged as structured data
+type ArtifactUploadError struct {
+	// The path the artifact was being uploaded as
+	Path string
+
+	// What kind of failure it was, one of the ArtifactUploadError* categories
+	Category string
+
+	// The HTTP status the storage backend responded with, if it responded
+	StatusCode int
+
+	// Whether trying again might succeed
+	Retryable bool
+
+	// The underlying error
+	Err error
+}
+
+func newArtifactUploadError(artifact *api.Artifact, err error) *ArtifactUploadError {
+	e := &ArtifactUploadError{
+		Path:       artifact.Path,
+		Category:   ArtifactUploadErrorUnknown,
+		StatusCode: uploadErrorStatus(err),
+		Retryable:  true,
+		Err:        err,
+	}
+
+	var netErr net.Error
+	switch {
+	case e.StatusCode != 0:
+		e.Category = ArtifactUploadErrorHTTP
+		e.Retryable = e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+
+	case errors.Is(err, os.ErrNotExist), errors.Is(err, os.ErrPermission):
+		e.Category = ArtifactUploadErrorFile
+		e.Retryable = false
+
+	case errors.Is(err, context.DeadlineExceeded):
+		e.Category = ArtifactUploadErrorTimeout
+
+	case errors.As(err, &netErr):
+		e.Category = ArtifactUploadErrorNetwork
+		if netErr.Timeout() {
+			e.Category = ArtifactUploadErrorTimeout
+		}
+	}
+
+	return e
+}
+
+// newUploadError is like newArtifactUploadError, but errors that aren't
+// retryable by default are retryable if the RetryClassifier says so
+func (a *ArtifactUploader) newUploadError(artifact *api.Artifact, err error) *ArtifactUploadError {
+	e := newArtifactUploadError(artifact, err)
+	if !e.Retryable && a.conf.RetryClassifier != nil {
+		e.Retryable = a.conf.RetryClassifier(err)
+	}
+	return e
+}
+
+func (e *ArtifactUploadError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ArtifactUploadError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ArtifactUploadError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Path       string `json:"path"`
+		Category   string `json:"category"`
+		StatusCode int    `json:"http_status,omitempty"`
+		Retryable  bool   `json:"retryable"`
+		Message    string `json:"message"`
+	}{
+		Path:       e.Path,
+		Category:   e.Category,
+		StatusCode: e.StatusCode,
+		Retryable:  e.Retryable,
+		Message:    e.Err.Error(),
+	})
+}
+
+// ArtifactUploadRetry describes a failed attempt to upload an artifact, in a
+// form that can be logged as structured data. Durations are logged in seconds.
+type ArtifactUploadRetry struct {
+	// Which attempt failed, starting from 1
+	Attempt int
+
+	// Whether the upload is going to be tried again, and how long until it is
+	Retrying bool
+	Backoff  time.Duration
+
+	// How long it's been since the first attempt started
+	Elapsed time.Duration
+
+	// Why the attempt failed
+	Err *ArtifactUploadError
+}
+
+func (r *ArtifactUploadRetry) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Path           string  `json:"path"`
+		Attempt        int     `json:"attempt"`
+		Category       string  `json:"category"`
+		StatusCode     int     `json:"http_status,omitempty"`
+		Retrying       bool    `json:"retrying"`
+		BackoffSeconds float64 `json:"backoff_seconds"`
+		ElapsedSeconds float64 `json:"elapsed_seconds"`
+		Message        string  `json:"message"`
+	}{
+		Path:           r.Err.Path,
+		Attempt:        r.Attempt,
+		Category:       r.Err.Category,
+		StatusCode:     r.Err.StatusCode,
+		Retrying:       r.Retrying,
+		BackoffSeconds: r.Backoff.Seconds(),
+		ElapsedSeconds: r.Elapsed.Seconds(),
+		Message:        r.Err.Error(),
+	})
+}
+
+// uploadStatusError is returned by uploaders when storage responds with an
+// unsuccessful HTTP status
+type uploadStatusError struct {
+	StatusCode int
+	message    string
+}
+
+func (e *uploadStatusError) Error() string {
+	return e.message
+}
+
+// uploadErrorStatus returns the HTTP status of an error returned by one of the
+// uploaders, or 0 if there isn't one
+func uploadErrorStatus(err error) int {
+	var statusErr *uploadStatusError
+	var rtErr *errorResponse
+	var gsErr *googleapi.Error
+	var s3Err awserr.RequestFailure
+
+	switch {
+	case errors.As(err, &statusErr):
+		return statusErr.StatusCode
+	case errors.As(err, &rtErr):
+		return rtErr.Response.StatusCode
+	case errors.As(err, &gsErr):
+		return gsErr.Code
+	case errors.As(err, &s3Err):
+		return s3Err.StatusCode()
+	}
+	return 0
+}
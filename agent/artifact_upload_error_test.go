@@ -0,0 +1,127 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewArtifactUploadError(t *testing.T) {
+	artifact := &api.Artifact{Path: "llamas.txt"}
+
+	tests := []struct {
+		name      string
+		err       error
+		category  string
+		status    int
+		retryable bool
+	}{
+		{
+			name:      "server error",
+			err:       &uploadStatusError{StatusCode: 503, message: "Service Unavailable (503)"},
+			category:  ArtifactUploadErrorHTTP,
+			status:    503,
+			retryable: true,
+		},
+		{
+			name:      "forbidden",
+			err:       fmt.Errorf("uploading: %w", &uploadStatusError{StatusCode: 403, message: "Forbidden (403)"}),
+			category:  ArtifactUploadErrorHTTP,
+			status:    403,
+			retryable: false,
+		},
+		{
+			name:      "missing file",
+			err:       fmt.Errorf("failed to open file %q (%w)", "llamas.txt", os.ErrNotExist),
+			category:  ArtifactUploadErrorFile,
+			retryable: false,
+		},
+		{
+			name:      "timeout",
+			err:       context.DeadlineExceeded,
+			category:  ArtifactUploadErrorTimeout,
+			retryable: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			uploadErr := newArtifactUploadError(artifact, test.err)
+			assert.Equal(t, test.category, uploadErr.Category)
+			assert.Equal(t, test.status, uploadErr.StatusCode)
+			assert.Equal(t, test.retryable, uploadErr.Retryable)
+		})
+	}
+}
+
+func TestArtifactUploadErrorJSON(t *testing.T) {
+	uploadErr := newArtifactUploadError(&api.Artifact{Path: "llamas.txt"},
+		&uploadStatusError{StatusCode: 503, message: "Service Unavailable (503)"})
+
+	b, err := json.Marshal(uploadErr)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+
+	assert.JSONEq(t, `{
+		"path": "llamas.txt",
+		"category": "http",
+		"http_status": 503,
+		"retryable": true,
+		"message": "Service Unavailable (503)"
+	}`, string(b))
+}
+
+func TestArtifactUploadRetryJSON(t *testing.T) {
+	retry := &ArtifactUploadRetry{
+		Attempt:  2,
+		Retrying: true,
+		Backoff:  5 * time.Second,
+		Elapsed:  7500 * time.Millisecond,
+		Err: newArtifactUploadError(&api.Artifact{Path: "llamas.txt"},
+			&uploadStatusError{StatusCode: 503, message: "Service Unavailable (503)"}),
+	}
+
+	b, err := json.Marshal(retry)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+
+	assert.JSONEq(t, `{
+		"path": "llamas.txt",
+		"attempt": 2,
+		"category": "http",
+		"http_status": 503,
+		"retrying": true,
+		"backoff_seconds": 5,
+		"elapsed_seconds": 7.5,
+		"message": "Service Unavailable (503)"
+	}`, string(b))
+}
+
+func TestNewUploadErrorWithRetryClassifier(t *testing.T) {
+	// This storage is a teapot while it's resting
+	uploader := NewArtifactUploader(logger.Discard, nil, ArtifactUploaderConfig{
+		RetryClassifier: func(err error) bool {
+			return uploadErrorStatus(err) == http.StatusTeapot
+		},
+	})
+	artifact := &api.Artifact{Path: "llamas.txt"}
+
+	// Errors the classifier recognises become retryable
+	teapot := fmt.Errorf("uploading: %w", &uploadStatusError{StatusCode: http.StatusTeapot, message: "I'm a teapot (418)"})
+	assert.True(t, uploader.newUploadError(artifact, teapot).Retryable)
+
+	// Others are classified as usual
+	forbidden := &uploadStatusError{StatusCode: http.StatusForbidden, message: "Forbidden (403)"}
+	assert.False(t, uploader.newUploadError(artifact, forbidden).Retryable)
+	assert.True(t, uploader.newUploadError(artifact, context.DeadlineExceeded).Retryable)
+}
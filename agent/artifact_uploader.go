@@ -1,18 +1,24 @@
 package agent
 
 import (
+	"bufio"
 	"context"
+	"crypto"
 	"crypto/sha1"
 	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/buildkite/agent/v3/api"
 	"github.com/buildkite/agent/v3/experiments"
@@ -21,13 +27,55 @@ import (
 	"github.com/buildkite/agent/v3/pool"
 	"github.com/buildkite/roko"
 	zglob "github.com/mattn/go-zglob"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
 )
 
 const (
 	ArtifactPathDelimiter    = ";"
 	ArtifactFallbackMimeType = "binary/octet-stream"
+
+	// ArtifactOrderFile is the name of the file, in the directory artifact
+	// paths are relative to, that lists glob patterns in the order matching
+	// artifacts should be uploaded
+	ArtifactOrderFile = ".artifactorder"
+
+	// ArtifactRelativeToGitRoot can be used as ArtifactUploaderConfig.RelativeTo
+	// to resolve globs and artifact paths from the root of the enclosing git
+	// repository
+	ArtifactRelativeToGitRoot = "git-root"
+
+	// DefaultArtifactEmptyDirMarker is the name of the marker file uploaded
+	// in empty directories when PreserveEmptyDirs is set without an
+	// EmptyDirMarker
+	DefaultArtifactEmptyDirMarker = ".keep"
 )
 
+// DefaultArtifactSensitivePatterns are the file name patterns of artifacts
+// that are likely to contain secrets, such as private keys and dotenv files.
+var DefaultArtifactSensitivePatterns = []string{
+	"id_rsa", "id_dsa", "id_ecdsa", "id_ed25519",
+	"*.pem", "*.key", "*.p12", "*.pfx",
+	".env", ".env.*",
+}
+
+// ArtifactRoot is a set of paths that are resolved from, and uploaded
+// relative to, a directory other than the working directory. For example,
+// dist/**/*.js beneath the root componentA uploads componentA/dist/x.js as
+// dist/x.js. Relative directories are resolved from the working directory.
+type ArtifactRoot struct {
+	Dir   string
+	Paths string
+}
+
+// ArtifactContentDisposition sets the Content-Disposition of the artifacts
+// whose paths match a glob pattern
+type ArtifactContentDisposition struct {
+	Pattern     string
+	Disposition string
+}
+
 type ArtifactUploaderConfig struct {
 	// The ID of the Job
 	JobID string
@@ -35,17 +83,313 @@ type ArtifactUploaderConfig struct {
 	// The path of the uploads
 	Paths string
 
+	// The single character that separates the globs in Paths. Empty means
+	// ArtifactPathDelimiter.
+	PathSeparator string
+
+	// If set, Stream is read to its end and uploaded as a single artifact
+	// at StreamPath, instead of uploading the files matched by Paths. It's
+	// spooled to a temporary file while it's read, since Buildkite needs
+	// every artifact's size and checksums before it can be uploaded.
+	Stream     io.Reader
+	StreamPath string
+
 	// Where we'll be uploading artifacts
 	Destination string
 
+	// Where we'll be uploading artifacts, if there's more than one place.
+	// Overrides Destination. An empty string is Buildkite's artifact storage.
+	// Each file is only collected and hashed once, and is then uploaded to
+	// all the destinations at the same time.
+	Destinations []string
+
 	// A specific Content-Type to use for all artifacts
 	ContentType string
 
+	// The Content-Disposition of artifacts matching each pattern, either
+	// "inline" or "attachment", for backends that support it (only S3). The
+	// first matching pattern wins, and artifacts that don't match any are
+	// left to the backend's default. See ArtifactContentDisposition.
+	ContentDispositions []ArtifactContentDisposition
+
 	// Whether to show HTTP debugging
 	DebugHTTP bool
 
+	// Whether to disable HTTP2 when uploading to artifact storage
+	DisableHTTP2 bool
+
+	// The size of the buffer each artifact is read into while it's being
+	// streamed to storage. Zero means the net/http default of 4KB. Larger
+	// buffers mean fewer reads and writes per file, which can help throughput
+	// for large files on fast connections, at the cost of that much memory
+	// for every upload in progress at once. Doesn't apply to Google Cloud
+	// Storage, or to connections that use HTTP2.
+	ReadBufferSize int
+
+	// The most bytes per second to upload, across all the artifacts being
+	// uploaded at once (and all the destinations). Zero means no limit. This
+	// is a soft cap: it's measured over short windows, so uploads may briefly
+	// burst above it, and it doesn't include HTTP overhead. Doesn't apply to
+	// Google Cloud Storage.
+	MaxBytesPerSecond int64
+
+	// Whether to always upload with a single request, even for files large
+	// enough to use a multipart upload. Only applies to S3.
+	DisableMultipart bool
+
 	// Whether to follow symbolic links when resolving globs
 	FollowSymlinks bool
+
+	// Whether to upload files that are matched at several paths, because
+	// they're reached through symbolic links, only once. The path that isn't
+	// through a symbolic link wins, or if they all are, the first one matched.
+	DedupeByTarget bool
+
+	// Whether to refuse to follow symbolic links to files outside of the
+	// directory that paths are relative to, when following symbolic links
+	ConfineToWorkingDir bool
+
+	// Whether to treat each of the paths as a literal file name rather than
+	// a glob, for files with names containing glob characters like * or [
+	NoGlob bool
+
+	// What relative globs and artifact paths are relative to. Empty means the
+	// current working directory, and ArtifactRelativeToGitRoot means the root
+	// of the enclosing git repository.
+	RelativeTo string
+
+	// Whether to upload files matched by absolute globs relative to the
+	// fixed directory at the start of the glob, e.g. /var/log/app/x.log
+	// matched by /var/log/**/*.log as app/x.log, rather than at their whole
+	// absolute path
+	StripGlobBase bool
+
+	// Whether to record the checksum in a file's sha256sum style sidecar,
+	// e.g. llamas.tar.gz.sha256, as its SHA-256 checksum rather than the one
+	// calculated from its contents. Downloads check the recorded checksum, so
+	// it must match what's actually uploaded.
+	TrustChecksumSidecars bool
+
+	// Whether to skip files that are empty
+	SkipEmpty bool
+
+	// If set, files last modified before it are skipped, e.g. so outputs
+	// left unchanged in a cached build directory aren't uploaded again.
+	// Empty directory markers (see PreserveEmptyDirs) are always uploaded.
+	ModifiedSince time.Time
+
+	// Whether to upload a zero-byte marker file in each directory matched by
+	// the paths that's empty, named EmptyDirMarker (or
+	// DefaultArtifactEmptyDirMarker), so that the directory exists when the
+	// artifacts are downloaded. e.g. with the paths out/**/*, an empty
+	// out/cache directory is uploaded as out/cache/.keep. Markers are
+	// uploaded even if SkipEmpty is set.
+	PreserveEmptyDirs bool
+	EmptyDirMarker    string
+
+	// Whether to store each artifact in the upload destination at a path
+	// derived from its SHA-256 checksum (see ContentAddressedPath), rather
+	// than the path it's uploaded as, so identical files are only stored
+	// once. The path it's uploaded as is recorded in the object's metadata,
+	// and with the artifact, for downloads to find it by. Buildkite's
+	// artifact storage doesn't support it.
+	ContentAddressed bool
+
+	// Whether to skip, with a warning, files that are deleted between being
+	// matched and being read, like rotated logs, rather than failing. The
+	// artifact upload command enables it by default.
+	SkipVanished bool
+
+	// A directory that steps stage their outputs in, e.g.
+	// .buildkite-artifacts. If set, relative paths are resolved from it
+	// rather than the working directory (or git root, see RelativeTo), and
+	// the files are uploaded relative to it, without the staging directory in
+	// their paths. A relative StagingDir is itself resolved from the working
+	// directory (or git root). Absolute paths, and the paths of Roots, are
+	// unaffected.
+	StagingDir string
+
+	// The directory temporary files are written to, i.e. the spooled
+	// Stream and the generated manifest and attestation. Defaults to the
+	// system's temporary directory, which honours TMPDIR. It's checked to be
+	// writable before anything is uploaded.
+	TempDir string
+
+	// More paths to upload, each relative to their own root directory
+	// rather than the working directory, so files in sibling directories
+	// can be uploaded beneath the same path. See ArtifactRoot.
+	Roots []ArtifactRoot
+
+	// The most files the paths may match, as a guard against globs that
+	// match far more than intended. Checked before any files are read. Zero
+	// means no limit.
+	MaxFiles int
+
+	// If set, the checksums of files are cached in this file between
+	// uploads, and files whose size and modification time haven't changed
+	// aren't hashed again
+	ChecksumCache string
+
+	// Whether globs should skip directories whose names start with a dot,
+	// like .git, unless the glob names one itself. Skipped directories aren't
+	// walked at all, which can make globs like **/*.log much faster.
+	SkipHiddenDirs bool
+
+	// Whether to return an error listing the paths that don't match any files,
+	// even if other paths do, rather than ignoring them
+	StrictGlobs bool
+
+	// Whether to lowercase the paths artifacts are uploaded as. The files are
+	// still read from their original location on disk.
+	LowercasePaths bool
+
+	// Whether to upload files at just their file names, without the
+	// directories they're in, and what to do when two files then have the
+	// same name. One of ArtifactFlattenKeepPaths (the default, which doesn't
+	// flatten), ArtifactFlattenErrorOnCollision or ArtifactFlattenSuffix.
+	FlattenStrategy string
+
+	// Rewrites the paths artifacts are uploaded as, after they've been
+	// normalised, lowercased and flattened, e.g. to flatten directories. Returning an
+	// error stops the upload, as does rewriting two files to the same path.
+	// Nil leaves paths alone.
+	PathTransform func(path string) (string, error)
+
+	// File name patterns (matched against the base name of each file, ignoring
+	// case) of artifacts that look like they contain secrets. A warning is
+	// logged for any matching files. If nil,
+	// DefaultArtifactSensitivePatterns is used.
+	SensitivePatterns []string
+
+	// Whether to fail instead of uploading files that match
+	// SensitivePatterns
+	BlockSensitive bool
+
+	// If set, artifacts are marked as expiring this long after they're
+	// collected, as a hint for the storage backend or its lifecycle rules to
+	// delete them. Must be positive.
+	ExpiresIn time.Duration
+
+	// The bounds on how many artifacts are uploaded at once. Uploads start at
+	// MaxConcurrency, which is halved (down to MinConcurrency) whenever
+	// storage responds with 429 Too Many Requests or 503 Service
+	// Unavailable, and recovers gradually as uploads succeed. Zero means
+	// DefaultArtifactUploadMinConcurrency and ten per CPU respectively.
+	MinConcurrency int
+	MaxConcurrency int
+
+	// The most files that may be open at once. Files are hashed one at a time
+	// and each upload opens its file, so this also bounds MaxConcurrency.
+	// Zero means a quarter of the process's file descriptor limit, or
+	// DefaultArtifactMaxOpenFiles if there isn't one.
+	MaxOpenFiles int
+
+	// Whether to stop uploading after the first artifact fails to upload,
+	// cancelling any uploads in progress. By default, as many artifacts as
+	// possible are uploaded and any errors are returned together at the end.
+	FailFast bool
+
+	// The longest the whole upload may take, from finding the files to the
+	// last of them being uploaded. Once it's exceeded, no more uploads are
+	// started or retried, attempts already in progress are cancelled, and
+	// the upload fails saying how many artifacts were uploaded
+	// and how many remained. Their states are still reported to Buildkite.
+	// Zero means no limit.
+	Timeout time.Duration
+
+	// The total time that may be spent retrying uploads across all artifacts.
+	// Once exceeded, failing uploads are no longer retried. Zero means no
+	// limit.
+	MaxTotalRetryTime time.Duration
+
+	// Reports whether an error uploading an artifact is worth retrying, for
+	// errors that aren't retried by default, e.g. transient errors from a
+	// custom storage backend. By default, errors are retried unless storage
+	// responded with an HTTP status other than 5xx or 429, or the file
+	// couldn't be read.
+	RetryClassifier func(error) bool
+
+	// Whether to annotate the build with a table of the uploaded artifacts
+	// once they've all been uploaded successfully. The table is appended to
+	// the annotation with AnnotationContext, or
+	// DefaultArtifactAnnotationContext if that's empty.
+	Annotate          bool
+	AnnotationContext string
+
+	// If set, consulted before uploading each artifact, and artifacts with a
+	// checksum it already has are pointed at the stored copy rather than
+	// uploaded again. Successful uploads are added to it. Doesn't apply to
+	// Buildkite's artifact storage, which only gives out URLs on upload.
+	ChecksumStore ChecksumStore
+
+	// The seed for the random jitter added to the delays between retries.
+	// Zero means it's seeded from the current time, so the delays differ
+	// between runs; tests can set it to get the same delays every time.
+	RetryJitterSeed int64
+
+	// Whether to log where each artifact is uploaded to at info level,
+	// rather than debug
+	ShowDestinations bool
+
+	// If set, a single line ArtifactUploadSummary is written here once the
+	// upload has finished, whether or not it succeeded
+	SummaryWriter io.Writer
+
+	// If set, the uploaded artifacts are written here as CSV (see
+	// WriteArtifactsCSV) once they've all been uploaded successfully
+	CSVWriter io.Writer
+
+	// If set, the artifacts' SHA-256 checksums are written here in sha256sum
+	// format (see WriteArtifactsSHA256SUMS) once they've been found, before
+	// they're uploaded
+	ChecksumWriter io.Writer
+
+	// Whether to also upload a manifest listing the other artifacts (see
+	// ArtifactManifest), as ManifestName (or DefaultArtifactManifestName)
+	UploadManifest bool
+	ManifestName   string
+
+	// Whether to also upload an in-toto attestation of the other artifacts,
+	// and the manifest (see WriteArtifactAttestation), as AttestationName (or
+	// DefaultArtifactAttestationName). If AttestationKey is set, the
+	// attestation is signed with it, and wrapped in a DSSEEnvelope.
+	Attest          bool
+	AttestationName string
+	AttestationKey  crypto.Signer
+
+	// If set, ArtifactProgress ticks are written here as JSON lines while
+	// uploading, no more often than ProgressInterval (or
+	// DefaultArtifactProgressInterval) apart
+	ProgressWriter   io.Writer
+	ProgressInterval time.Duration
+
+	// If set, spans are created with it for the upload as a whole, finding
+	// the files, hashing them, and uploading each of them. Nil means no
+	// tracing.
+	TracerProvider trace.TracerProvider
+}
+
+// ArtifactUploadSummary counts the outcome of an artifact upload. Its String
+// method returns a single line in a stable format intended to be parsed by
+// other tools:
+//
+//	buildkite-agent: uploaded=42 skipped=3 failed=1 bytes=10485760 duration=4.2s
+//
+// All fields are always present, in that order. skipped counts artifacts that
+// weren't attempted, e.g. after an earlier failure when failing fast. bytes is
+// the total size of the successfully uploaded files, and duration is the
+// wall-clock time of the whole upload in Go's time.Duration format.
+type ArtifactUploadSummary struct {
+	Uploaded int
+	Skipped  int
+	Failed   int
+	Bytes    int64
+	Duration time.Duration
+}
+
+func (s ArtifactUploadSummary) String() string {
+	return fmt.Sprintf("buildkite-agent: uploaded=%d skipped=%d failed=%d bytes=%d duration=%s",
+		s.Uploaded, s.Skipped, s.Failed, s.Bytes, s.Duration.Round(time.Millisecond))
 }
 
 type ArtifactUploader struct {
@@ -57,130 +401,822 @@ type ArtifactUploader struct {
 
 	// The APIClient that will be used when uploading jobs
 	apiClient APIClient
+
+	// Reports progress while uploading, if there's a ProgressWriter
+	progress *artifactProgressReporter
+
+	// The time retries and backoff are measured against
+	clock Clock
+
+	// Checksums of files from earlier uploads, if there's a ChecksumCache
+	checksums *checksumCache
+
+	// Limits the rate of uploads, if there's a MaxBytesPerSecond
+	bandwidth *rate.Limiter
+
+	// The source of jitter for retries
+	random *rand.Rand
+
+	// Closed by Stop, once no more uploads should be started
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	// The context the uploader was constructed with. Uploads are cancelled
+	// when it's done, as well as when the context passed to Upload is.
+	// Experiments enabled in its scope (see experiments.WithScope) apply to
+	// the uploader as well as those enabled globally.
+	ctx context.Context
+
+	// Creates spans for the phases of uploads, from the TracerProvider
+	tracer trace.Tracer
 }
 
 func NewArtifactUploader(l logger.Logger, ac APIClient, c ArtifactUploaderConfig) *ArtifactUploader {
+	return NewArtifactUploaderWithContext(context.Background(), l, ac, c)
+}
+
+// NewArtifactUploaderWithContext is like NewArtifactUploader, but uploads are
+// also cancelled once ctx is done, however they're started.
+func NewArtifactUploaderWithContext(ctx context.Context, l logger.Logger, ac APIClient, c ArtifactUploaderConfig) *ArtifactUploader {
+	seed := c.RetryJitterSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
 	return &ArtifactUploader{
 		logger:    l,
 		apiClient: ac,
 		conf:      c,
+		clock:     realClock{},
+		random:    rand.New(rand.NewSource(seed)),
+		stop:      make(chan struct{}),
+		ctx:       ctx,
+		tracer:    newArtifactTracer(c.TracerProvider),
 	}
 }
 
-func (a *ArtifactUploader) Upload(ctx context.Context) error {
-	// Create artifact structs for all the files we need to upload
-	artifacts, err := a.Collect()
-	if err != nil {
-		return fmt.Errorf("collecting artifacts: %w", err)
+// withContext returns a context that's cancelled when either ctx or the
+// context the uploader was constructed with is done
+func (a *ArtifactUploader) withContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	// Cancel it straight away if the uploader's context is already done, so
+	// callers checking ctx.Err() right after this don't race the goroutine
+	if a.ctx.Err() != nil {
+		cancel()
+		return ctx, cancel
 	}
 
-	if len(artifacts) == 0 {
-		a.logger.Info("No files matched paths: %s", a.conf.Paths)
+	go func() {
+		select {
+		case <-a.ctx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// detachedContext carries the values of its parent, but is never cancelled
+// and has no deadline, so that the artifact states can still be sent to
+// Buildkite after the uploads themselves have been cancelled
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
+
+// createTemp creates a temporary file in TempDir, like os.CreateTemp
+func (a *ArtifactUploader) createTemp(pattern string) (*os.File, error) {
+	return os.CreateTemp(a.conf.TempDir, pattern)
+}
+
+// checkTempDir checks that TempDir, if one was given, is somewhere temporary
+// files can be written, so that a bad one fails before anything is uploaded
+func (a *ArtifactUploader) checkTempDir() error {
+	if a.conf.TempDir == "" {
 		return nil
 	}
 
-	a.logger.Info("Found %d files that match %q", len(artifacts), a.conf.Paths)
-	if err := a.upload(ctx, artifacts); err != nil {
-		return fmt.Errorf("uploading artifacts: %w", err)
+	f, err := a.createTemp("buildkite-artifact-check-*")
+	if err != nil {
+		return fmt.Errorf("temp dir %s isn't writable: %w", a.conf.TempDir, err)
 	}
+	f.Close()
+	return os.Remove(f.Name())
+}
 
-	return nil
+// Stop stops the uploader from starting any more uploads, e.g. when the agent
+// is shutting down. Uploads already in progress carry on, and their states are
+// still reported to Buildkite. Cancel the context passed to Upload to stop
+// those too.
+func (a *ArtifactUploader) Stop() {
+	a.stopOnce.Do(func() { close(a.stop) })
 }
 
-func isDir(path string) bool {
-	fi, err := os.Stat(path)
-	if err != nil {
+// stopping returns whether Stop has been called
+func (a *ArtifactUploader) stopping() bool {
+	select {
+	case <-a.stop:
+		return true
+	default:
 		return false
 	}
-	return fi.IsDir()
 }
 
-func (a *ArtifactUploader) Collect() (artifacts []*api.Artifact, err error) {
+func (a *ArtifactUploader) Upload(ctx context.Context) (err error) {
+	ctx, cancel := a.withContext(ctx)
+	defer cancel()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ctx, span := a.startArtifactSpan(ctx, "artifact.upload")
+	defer func() { endSpan(span, err) }()
+
+	if a.conf.Timeout < 0 {
+		return fmt.Errorf("invalid timeout %s, it must be positive", a.conf.Timeout)
+	}
+	// When no more uploads should be started or retried, if there's a
+	// Timeout. It's passed down rather than kept on the uploader, so that
+	// concurrent calls to Upload each have their own.
+	var deadline time.Time
+	if a.conf.Timeout > 0 {
+		deadline = time.Now().Add(a.conf.Timeout)
+	}
+
+	if a.conf.ContentAddressed {
+		if err := a.checkContentAddressed(); err != nil {
+			return err
+		}
+	}
+
+	if err := a.checkTempDir(); err != nil {
+		return err
+	}
+
+	summary := &ArtifactUploadSummary{}
+	if a.conf.SummaryWriter != nil {
+		start := a.clock.Now()
+		defer func() {
+			summary.Duration = a.clock.Now().Sub(start)
+			fmt.Fprintln(a.conf.SummaryWriter, summary)
+		}()
+	}
+
+	// Create artifact structs for all the files we need to upload, or for
+	// the stream we're uploading instead
+	var artifacts []*api.Artifact
+	if a.conf.Stream != nil {
+		artifact, cleanup, err := a.streamArtifact()
+		if err != nil {
+			return fmt.Errorf("reading stream: %w", err)
+		}
+		defer cleanup()
+		artifacts = []*api.Artifact{artifact}
+
+		if err := a.checkSensitive(artifacts); err != nil {
+			return err
+		}
+
+		a.logger.Info("Read %d bytes from stream to upload as %s", artifact.FileSize, artifact.Path)
+	} else {
+		artifacts, err = a.collect(ctx)
+		if err != nil {
+			return fmt.Errorf("collecting artifacts: %w", err)
+		}
+
+		if len(artifacts) == 0 {
+			a.logger.Info("No files matched paths: %s", a.conf.Paths)
+			return nil
+		}
+
+		a.logger.Info("Found %d files that match %q", len(artifacts), a.conf.Paths)
+	}
+
+	if a.conf.ChecksumWriter != nil {
+		if err := WriteArtifactsSHA256SUMS(a.conf.ChecksumWriter, artifacts); err != nil {
+			return fmt.Errorf("writing checksums: %w", err)
+		}
+	}
+
+	if a.conf.UploadManifest {
+		manifest, cleanup, err := a.manifestArtifact(artifacts)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		artifacts = append(artifacts, manifest)
+	}
+
+	// The attestation covers every other artifact, including the manifest
+	if a.conf.Attest {
+		attestation, cleanup, err := a.attestationArtifact(artifacts)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		artifacts = append(artifacts, attestation)
+	}
+
+	if a.conf.ContentAddressed {
+		contentAddress(artifacts)
+	}
+
+	a.bandwidth = newBandwidthLimiter(a.conf.MaxBytesPerSecond)
+
+	destinations := a.conf.Destinations
+	if len(destinations) == 0 {
+		destinations = []string{a.conf.Destination}
+	}
+
+	if a.conf.ProgressWriter != nil {
+		var totalBytes int64
+		for _, artifact := range artifacts {
+			totalBytes += artifact.FileSize
+		}
+		a.progress = newArtifactProgressReporter(a.conf.ProgressWriter, a.conf.ProgressInterval,
+			len(artifacts)*len(destinations), totalBytes*int64(len(destinations)))
+	}
+	if len(destinations) == 1 {
+		if err := a.upload(ctx, destinations[0], artifacts, summary, deadline); err != nil {
+			return fmt.Errorf("uploading artifacts: %w", err)
+		}
+		if err := a.writeCSV(artifacts); err != nil {
+			return err
+		}
+		return a.annotate(ctx, artifacts)
+	}
+
+	// Each destination gets its own copy of the artifacts, since uploading
+	// them fills in details specific to where they were uploaded
+	var wg sync.WaitGroup
+	errs := make([]error, len(destinations))
+	summaries := make([]ArtifactUploadSummary, len(destinations))
+	uploaded := make([][]*api.Artifact, len(destinations))
+	for i, destination := range destinations {
+		copies := make([]*api.Artifact, 0, len(artifacts))
+		for _, artifact := range artifacts {
+			artifact := *artifact
+			copies = append(copies, &artifact)
+		}
+		uploaded[i] = copies
+
+		wg.Add(1)
+		go func(i int, destination string) {
+			defer wg.Done()
+			errs[i] = a.upload(ctx, destination, copies, &summaries[i], deadline)
+		}(i, destination)
+	}
+	wg.Wait()
+
+	var failed []string
+	for i, destination := range destinations {
+		if destination == "" {
+			destination = "Buildkite artifact storage"
+		}
+		if errs[i] != nil {
+			a.logger.Error("Uploading to %s failed: %s", destination, errs[i])
+			failed = append(failed, destination)
+		} else {
+			a.logger.Info("Uploaded %d artifacts to %s", summaries[i].Uploaded, destination)
+		}
+
+		summary.Uploaded += summaries[i].Uploaded
+		summary.Skipped += summaries[i].Skipped
+		summary.Failed += summaries[i].Failed
+		summary.Bytes += summaries[i].Bytes
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("uploading artifacts to %d of %d destinations failed: %s",
+			len(failed), len(destinations), strings.Join(failed, ", "))
+	}
+
+	// Every destination gets its own rows, since their URLs differ
+	var all []*api.Artifact
+	for _, copies := range uploaded {
+		all = append(all, copies...)
+	}
+	if err := a.writeCSV(all); err != nil {
+		return err
+	}
+
+	return a.annotate(ctx, artifacts)
+}
+
+// findGitRoot returns the root of the git repository enclosing dir
+func findGitRoot(dir string) (string, error) {
+	for {
+		// .git is a directory in a regular checkout, and a file in worktrees
+		// and submodules
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return dir, nil
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", errors.New("not inside a git repository")
+		}
+		dir = parent
+	}
+}
+
+// baseDirectory returns the directory that relative globs and artifact paths
+// are resolved from
+func (a *ArtifactUploader) baseDirectory() (string, error) {
 	wd, err := os.Getwd()
 	if err != nil {
-		return nil, fmt.Errorf("getting working directory: %w", err)
+		return "", fmt.Errorf("getting working directory: %w", err)
 	}
 
-	// file paths are deduplicated after resolving globs etc
-	seenPaths := make(map[string]bool)
+	switch a.conf.RelativeTo {
+	case "":
+		return wd, nil
 
-	for _, globPath := range strings.Split(a.conf.Paths, ArtifactPathDelimiter) {
-		globPath = strings.TrimSpace(globPath)
-		if globPath == "" {
-			continue
+	case ArtifactRelativeToGitRoot:
+		root, err := findGitRoot(wd)
+		if err != nil {
+			a.logger.Warn("Couldn't find git repository root from %s (%v), paths will be relative to the working directory", wd, err)
+			return wd, nil
+		}
+		a.logger.Debug("Resolving artifact paths relative to git repository root %s", root)
+		return root, nil
+
+	default:
+		return "", fmt.Errorf("invalid relative-to value %q, expected %q", a.conf.RelativeTo, ArtifactRelativeToGitRoot)
+	}
+}
+
+// logDestination logs where the artifact will be uploaded to, once any path
+// transformations have been applied. It's logged at info level if
+// ShowDestinations is set, and at debug level otherwise.
+func (a *ArtifactUploader) logDestination(artifact *api.Artifact) {
+	log := a.logger.Debug
+	if a.conf.ShowDestinations {
+		log = a.logger.Info
+	}
+
+	// Buildkite's artifact storage only gives out URLs once it's uploaded
+	destination := artifact.URL
+	if destination == "" {
+		destination = "Buildkite artifact storage"
+	}
+	log("%s (from %s) -> %s", artifact.Path, artifact.AbsolutePath, destination)
+}
+
+// writeCSV writes the uploaded artifacts as CSV, if there's a CSVWriter
+func (a *ArtifactUploader) writeCSV(artifacts []*api.Artifact) error {
+	if a.conf.CSVWriter == nil {
+		return nil
+	}
+	if err := WriteArtifactsCSV(a.conf.CSVWriter, artifacts); err != nil {
+		return fmt.Errorf("writing artifacts CSV: %w", err)
+	}
+	return nil
+}
+
+// CountMatches returns how many files the upload paths match, without reading
+// them, so that callers can check an upload is sensible before doing any
+// expensive work. It fails if there are more than MaxFiles.
+func (a *ArtifactUploader) CountMatches() (int, error) {
+	base, err := a.baseDirectory()
+	if err != nil {
+		return 0, err
+	}
+
+	matches, err := a.match(base)
+	return len(matches), err
+}
+
+// match resolves the upload paths, relative to base if they need to be, and
+// checks there aren't too many matches
+func (a *ArtifactUploader) match(base string) ([]pathMatch, error) {
+	opts := GlobOptions{
+		Separator:        a.conf.PathSeparator,
+		FollowSymlinks:   a.conf.FollowSymlinks,
+		ConfineToBaseDir: a.conf.ConfineToWorkingDir,
+		NoGlob:           a.conf.NoGlob,
+		SkipHiddenDirs:   a.conf.SkipHiddenDirs,
+		Strict:           a.conf.StrictGlobs,
+		MatchEmptyDirs:   a.conf.PreserveEmptyDirs,
+		Logger:           a.logger,
+	}
+	if a.conf.RelativeTo != "" {
+		opts.BaseDir = base
+	}
+
+	// Relative paths are resolved from, and uploaded relative to, the
+	// staging directory if there is one
+	var staging string
+	if a.conf.StagingDir != "" {
+		staging = a.conf.StagingDir
+		if !filepath.IsAbs(staging) {
+			staging = filepath.Join(base, staging)
+		}
+		opts.BaseDir = staging
+	}
+
+	matches, err := expandPaths(a.conf.Paths, opts)
+	if err != nil {
+		return nil, err
+	}
+	if staging != "" {
+		for i := range matches {
+			if !filepath.IsAbs(matches[i].globPath) {
+				matches[i].root = staging
+			}
+		}
+	}
+
+	// Each root's paths are resolved from it, and files are only matched
+	// once, by whichever paths match them first
+	seen := make(map[string]bool)
+	for _, match := range matches {
+		seen[match.absolutePath] = true
+	}
+	for _, root := range a.conf.Roots {
+		dir := root.Dir
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(base, dir)
 		}
 
-		a.logger.Debug("Searching for %s", globPath)
+		rootOpts := opts
+		rootOpts.BaseDir = dir
+		rootMatches, err := expandPaths(root.Paths, rootOpts)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, match := range rootMatches {
+			if seen[match.absolutePath] {
+				continue
+			}
+			seen[match.absolutePath] = true
+			match.root = dir
+			matches = append(matches, match)
+		}
+	}
+
+	if a.conf.DedupeByTarget {
+		if matches, err = a.dedupeByTarget(matches); err != nil {
+			return nil, err
+		}
+	}
+
+	if a.conf.MaxFiles > 0 && len(matches) > a.conf.MaxFiles {
+		return matches, fmt.Errorf("paths %q match %d files, which is more than the limit of %d", a.conf.Paths, len(matches), a.conf.MaxFiles)
+	}
+
+	return matches, nil
+}
 
-		// Resolve the globs (with * and ** in them), if it's a non-globbed path and doesn't exists
-		// then we will get the ErrNotExist that is handled below
-		globfunc := zglob.Glob
-		if a.conf.FollowSymlinks {
-			// Follow symbolic links for files & directories while expanding globs
-			globfunc = zglob.GlobFollowSymlinks
+// dedupeByTarget drops matches for files that have already been matched at
+// another path, such as through a symlinked directory. Of the paths to the
+// same file, the one that isn't through a symlink is kept, and otherwise the
+// first one matched.
+func (a *ArtifactUploader) dedupeByTarget(matches []pathMatch) ([]pathMatch, error) {
+	deduped := make([]pathMatch, 0, len(matches))
+
+	// Resolved targets, mapped to the index of their match in deduped
+	targets := make(map[string]int)
+
+	for _, match := range matches {
+		target, err := filepath.EvalSymlinks(match.readPath)
+		if errors.Is(err, fs.ErrNotExist) {
+			// Leave files that have vanished for buildAll to deal with
+			target = match.readPath
+		} else if err != nil {
+			return nil, fmt.Errorf("resolving symlinks for file %s: %w", match.file, err)
 		}
-		files, err := globfunc(globPath)
-		if errors.Is(err, os.ErrNotExist) {
-			a.logger.Info("File not found: %s", globPath)
+
+		i, ok := targets[target]
+		if !ok {
+			targets[target] = len(deduped)
+			deduped = append(deduped, match)
 			continue
+		}
+
+		if match.absolutePath == target && deduped[i].absolutePath != target {
+			a.logger.Debug("Skipping %s, which is the same file as %s", deduped[i].file, match.file)
+			deduped[i] = match
+		} else {
+			a.logger.Debug("Skipping %s, which is the same file as %s", match.file, deduped[i].file)
+		}
+	}
+
+	return deduped, nil
+}
+
+func (a *ArtifactUploader) Collect() (artifacts []*api.Artifact, err error) {
+	return a.collect(a.ctx)
+}
+
+func (a *ArtifactUploader) collect(ctx context.Context) (artifacts []*api.Artifact, err error) {
+	if a.conf.ExpiresIn < 0 {
+		return nil, fmt.Errorf("invalid expiry %s, it must be positive", a.conf.ExpiresIn)
+	}
+
+	if a.conf.PathSeparator != "" && utf8.RuneCountInString(a.conf.PathSeparator) != 1 {
+		return nil, fmt.Errorf("invalid path separator %q, it must be a single character", a.conf.PathSeparator)
+	}
+
+	if err := validateFlattenStrategy(a.conf.FlattenStrategy); err != nil {
+		return nil, err
+	}
+
+	base, err := a.baseDirectory()
+	if err != nil {
+		return nil, err
+	}
+
+	_, span := a.startArtifactSpan(ctx, "artifact.discover")
+	matches, err := a.match(base)
+	span.SetAttributes(attribute.Int("artifact.files", len(matches)))
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.conf.ChecksumCache != "" {
+		a.checksums, err = loadChecksumCache(a.conf.ChecksumCache)
+		if a.checksums == nil {
+			return nil, fmt.Errorf("loading checksum cache: %w", err)
 		} else if err != nil {
-			return nil, fmt.Errorf("resolving glob: %w", err)
+			a.logger.Warn("Ignoring invalid checksum cache: %v", err)
 		}
+		defer func() {
+			if err := a.checksums.save(); err != nil {
+				a.logger.Warn("Failed to save checksum cache: %v", err)
+			}
+		}()
+	}
 
-		// Process each glob match into an api.Artifact
-		for _, file := range files {
-			absolutePath, err := filepath.Abs(file)
+	_, span = a.startArtifactSpan(ctx, "artifact.hash")
+	artifacts, err = a.buildAll(base, matches)
+	var totalBytes int64
+	for _, artifact := range artifacts {
+		totalBytes += artifact.FileSize
+	}
+	span.SetAttributes(attribute.Int("artifact.files", len(artifacts)), attribute.Int64("artifact.bytes", totalBytes))
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.checkSensitive(artifacts); err != nil {
+		return nil, err
+	}
+
+	order, err := readArtifactOrder(filepath.Join(base, ArtifactOrderFile))
+	if err != nil {
+		return nil, err
+	}
+	if len(order) > 0 {
+		a.logger.Debug("Ordering artifacts using %s", ArtifactOrderFile)
+		sortArtifacts(artifacts, order)
+	}
+
+	return artifacts, nil
+}
+
+// buildAll builds an api.Artifact for each of the matched files
+func (a *ArtifactUploader) buildAll(base string, matches []pathMatch) (artifacts []*api.Artifact, err error) {
+	// upload paths, mapped to the file they came from, so that we can detect
+	// files that would be uploaded as the same path, e.g. if they only differ
+	// by case, or are in the same place beneath different roots
+	uploadPaths := make(map[string]string)
+
+	// the paths files have been flattened to, see FlattenStrategy
+	flattened := make(map[string]bool)
+
+	skippedEmpty, skippedUnmodified := 0, 0
+
+	// Process each glob match into an api.Artifact
+	for _, match := range matches {
+		// Empty and unmodified files are skipped before anything else, so
+		// they can't collide with other files
+		if (a.conf.SkipEmpty || !a.conf.ModifiedSince.IsZero()) && !match.emptyDir {
+			info, err := os.Stat(match.readPath)
+			if errors.Is(err, fs.ErrNotExist) && a.conf.SkipVanished {
+				a.logger.Warn("Skipping %s, which was deleted after it was found", match.readPath)
+				continue
+			}
 			if err != nil {
-				return nil, fmt.Errorf("resolving absolute path for file %s: %w", file, err)
+				return nil, fmt.Errorf("getting file info for %s: %w", match.readPath, err)
 			}
-
-			// dedupe based on resolved absolutePath
-			if _, ok := seenPaths[absolutePath]; ok {
-				a.logger.Debug("Skipping duplicate path %s", file)
+			if a.conf.SkipEmpty && info.Size() == 0 {
+				skippedEmpty++
 				continue
 			}
-			seenPaths[absolutePath] = true
-
-			// Ignore directories, we only want files
-			if isDir(absolutePath) {
-				a.logger.Debug("Skipping directory %s", file)
+			if info.ModTime().Before(a.conf.ModifiedSince) {
+				skippedUnmodified++
 				continue
 			}
+		}
 
-			// If a glob is absolute, we need to make it relative to the root so that
-			// it can be combined with the download destination to make a valid path.
-			// This is possibly weird and crazy, this logic dates back to
-			// https://github.com/buildkite/agent/commit/8ae46d975aa60d1ae0e2cc0bff7a43d3bf960935
-			// from 2014, so I'm replicating it here to avoid breaking things
-			if filepath.IsAbs(globPath) {
-				if runtime.GOOS == "windows" {
-					wd = filepath.VolumeName(absolutePath) + "/"
-				} else {
-					wd = "/"
-				}
-			}
+		path, err := a.uploadPath(base, match, flattened)
+		if err != nil {
+			return nil, err
+		}
 
-			path, err := filepath.Rel(wd, absolutePath)
-			if err != nil {
-				return nil, fmt.Errorf("resolving relative path for file %s: %w", file, err)
-			}
+		// Empty directories are uploaded as a marker file inside them,
+		// which has nothing in it, like /dev/null
+		readPath := match.readPath
+		if match.emptyDir {
+			readPath = os.DevNull
+		}
 
-			if experiments.IsEnabled("normalised-upload-paths") {
-				// Convert any Windows paths to Unix/URI form
-				path = filepath.ToSlash(path)
-			}
+		if other, ok := uploadPaths[path]; ok {
+			return nil, fmt.Errorf("files %s and %s would both be uploaded as %s", other, match.readPath, path)
+		}
+		uploadPaths[path] = match.readPath
+
+		// Build an artifact object using the paths we have.
+		artifact, err := a.build(path, readPath, match.globPath)
+		if errors.Is(err, fs.ErrNotExist) && a.conf.SkipVanished {
+			a.logger.Warn("Skipping %s, which was deleted after it was found", match.readPath)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("building artifact: %w", err)
+		}
+
+		artifacts = append(artifacts, artifact)
+	}
 
-			// Build an artifact object using the paths we have.
-			artifact, err := a.build(path, absolutePath, globPath)
+	if skippedEmpty > 0 {
+		a.logger.Debug("Skipped %d empty files", skippedEmpty)
+	}
+	if skippedUnmodified > 0 {
+		a.logger.Info("Skipped %d files that weren't modified since %s", skippedUnmodified, a.conf.ModifiedSince.Format(time.RFC3339))
+	}
+
+	return artifacts, nil
+}
+
+// uploadPath returns the path a matched file is uploaded as, given the
+// paths earlier files have been flattened to (see flattenPath)
+func (a *ArtifactUploader) uploadPath(base string, match pathMatch, flattened map[string]bool) (string, error) {
+	// Files matched by relative globs are relative to the base directory.
+	// Files matched by absolute globs are relative to the root of the
+	// filesystem, so their paths are their whole absolute paths, unless
+	// StripGlobBase is set, in which case they're relative to the fixed
+	// directory at the start of the glob. Files matched beneath one of the
+	// roots are relative to it instead.
+	relativeTo := base
+	switch {
+	case match.root != "":
+		relativeTo = match.root
+	case filepath.IsAbs(match.globPath) && a.conf.StripGlobBase:
+		relativeTo = globBase(match.globPath, a.conf.NoGlob)
+	case filepath.IsAbs(match.globPath):
+		// This is possibly weird and crazy, this logic dates back to
+		// https://github.com/buildkite/agent/commit/8ae46d975aa60d1ae0e2cc0bff7a43d3bf960935
+		// from 2014, so I'm replicating it here to avoid breaking things
+		if runtime.GOOS == "windows" {
+			relativeTo = filepath.VolumeName(match.absolutePath) + "/"
+		} else {
+			relativeTo = "/"
+		}
+	}
+
+	path, err := filepath.Rel(relativeTo, match.absolutePath)
+	if err != nil {
+		return "", fmt.Errorf("resolving relative path for file %s: %w", match.file, err)
+	}
+
+	// Empty directories are uploaded as a marker file inside them
+	if match.emptyDir {
+		path = filepath.Join(path, a.emptyDirMarker())
+	}
+
+	if experiments.IsEnabledCtx(a.ctx, "normalised-upload-paths") {
+		// Convert any Windows paths to Unix/URI form
+		path = filepath.ToSlash(path)
+	}
+
+	if a.conf.LowercasePaths {
+		path = strings.ToLower(path)
+	}
+
+	path = a.flattenPath(path, flattened)
+
+	if a.conf.PathTransform != nil {
+		transformed, err := a.conf.PathTransform(path)
+		if err != nil {
+			return "", fmt.Errorf("transforming path %s: %w", path, err)
+		}
+		if transformed == "" {
+			return "", fmt.Errorf("transforming path %s: transformed path is empty", path)
+		}
+		path = transformed
+	}
+
+	return path, nil
+}
+
+// emptyDirMarker returns the name of the marker files uploaded for empty
+// directories
+func (a *ArtifactUploader) emptyDirMarker() string {
+	if a.conf.EmptyDirMarker != "" {
+		return a.conf.EmptyDirMarker
+	}
+	return DefaultArtifactEmptyDirMarker
+}
+
+// checkSensitive warns about artifacts whose file names look like they
+// contain secrets, and returns an error for them if they're being blocked
+func (a *ArtifactUploader) checkSensitive(artifacts []*api.Artifact) error {
+	patterns := a.conf.SensitivePatterns
+	if patterns == nil {
+		patterns = DefaultArtifactSensitivePatterns
+	}
+
+	var sensitive []string
+	for _, artifact := range artifacts {
+		name := strings.ToLower(filepath.Base(artifact.Path))
+		for _, pattern := range patterns {
+			matched, err := filepath.Match(strings.ToLower(pattern), name)
 			if err != nil {
-				return nil, fmt.Errorf("building artifact: %w", err)
+				return fmt.Errorf("invalid sensitive file pattern %q: %w", pattern, err)
+			}
+			if matched {
+				sensitive = append(sensitive, artifact.Path)
+				break
 			}
+		}
+	}
+
+	if len(sensitive) == 0 {
+		return nil
+	}
+
+	if a.conf.BlockSensitive {
+		return fmt.Errorf("refusing to upload %d files that look like they contain secrets: %s",
+			len(sensitive), strings.Join(sensitive, ", "))
+	}
+
+	a.logger.Warn("The following %d files look like they contain secrets, and will be uploaded anyway:", len(sensitive))
+	for _, path := range sensitive {
+		a.logger.Warn("  %s", path)
+	}
+	a.logger.Warn("Use --block-sensitive to refuse to upload them")
+	return nil
+}
+
+// readArtifactOrder returns the glob patterns listed in an artifact order
+// file, one per line, ignoring blank lines and comments starting with #. A
+// missing file has no patterns.
+func readArtifactOrder(filename string) ([]string, error) {
+	f, err := os.Open(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", ArtifactOrderFile, err)
+	}
+	defer f.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, err := zglob.Match(line, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q in %s: %w", line, ArtifactOrderFile, err)
+		}
+		patterns = append(patterns, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", ArtifactOrderFile, err)
+	}
+
+	return patterns, nil
+}
 
-			artifacts = append(artifacts, artifact)
+// sortArtifacts groups artifacts by the first of the patterns their path
+// matches, in the order of the patterns, followed by any that don't match. The
+// order within each group is unchanged.
+func sortArtifacts(artifacts []*api.Artifact, patterns []string) {
+	rank := make(map[*api.Artifact]int, len(artifacts))
+	for _, artifact := range artifacts {
+		rank[artifact] = len(patterns)
+		for i, pattern := range patterns {
+			if ok, _ := zglob.Match(pattern, filepath.ToSlash(artifact.Path)); ok {
+				rank[artifact] = i
+				break
+			}
 		}
 	}
 
-	return artifacts, nil
+	sort.SliceStable(artifacts, func(i, j int) bool {
+		return rank[artifacts[i]] < rank[artifacts[j]]
+	})
 }
 
 func (a *ArtifactUploader) build(path string, absolutePath string, globPath string) (*api.Artifact, error) {
@@ -197,11 +1233,34 @@ func (a *ArtifactUploader) build(path string, absolutePath string, globPath stri
 		return nil, fmt.Errorf("getting file info for %s: %w", absolutePath, err)
 	}
 
-	// Generate a SHA-1 and SHA-256 checksums for the file
-	hash1, hash256 := sha1.New(), sha256.New()
-	io.Copy(io.MultiWriter(hash1, hash256), file)
-	sha1sum := fmt.Sprintf("%040x", hash1.Sum(nil))
-	sha256sum := fmt.Sprintf("%064x", hash256.Sum(nil))
+	// Generate a SHA-1 and SHA-256 checksums for the file, unless we already
+	// know them from an earlier upload
+	sha1sum, sha256sum, cached := "", "", false
+	if a.checksums != nil {
+		sha1sum, sha256sum, cached = a.checksums.lookup(absolutePath, fileInfo)
+	}
+	if cached {
+		a.logger.Debug("Using cached checksums for %s", absolutePath)
+	} else {
+		sha1sum, sha256sum, err = hashFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("hashing %s: %w", absolutePath, err)
+		}
+		if a.checksums != nil {
+			a.checksums.store(absolutePath, fileInfo, sha1sum, sha256sum)
+		}
+	}
+
+	if a.conf.TrustChecksumSidecars {
+		sidecarSum, ok, err := readChecksumSidecar(absolutePath)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			a.logger.Debug("Using SHA-256 checksum %s for %s from its sidecar", sidecarSum, absolutePath)
+			sha256sum = sidecarSum
+		}
+	}
 
 	// Determine the Content-Type to send
 	contentType := a.conf.ContentType
@@ -224,40 +1283,140 @@ func (a *ArtifactUploader) build(path string, absolutePath string, globPath stri
 		Sha1Sum:      sha1sum,
 		Sha256Sum:    sha256sum,
 		ContentType:  contentType,
+		FileMode:     fmt.Sprintf("%04o", fileInfo.Mode().Perm()),
+	}
+
+	artifact.ContentDisposition = a.contentDisposition(path)
+
+	if a.conf.ExpiresIn > 0 {
+		expiresAt := time.Now().UTC().Add(a.conf.ExpiresIn).Truncate(time.Second)
+		artifact.ExpiresAt = &expiresAt
 	}
 
 	return artifact, nil
 }
 
-func (a *ArtifactUploader) upload(ctx context.Context, artifacts []*api.Artifact) error {
+// artifactUploadAttempts is how many times each artifact is tried before
+// giving up on it
+const artifactUploadAttempts = 10
+
+// hashBufferSize is the size of the buffer files are read through when hashing,
+// which bounds the memory used regardless of the size of the file
+const hashBufferSize = 64 * 1024
+
+// hashFile returns the hex encoded SHA-1 and SHA-256 checksums of r, reading it
+// in hashBufferSize chunks
+func hashFile(r io.Reader) (sha1sum, sha256sum string, err error) {
+	hash1, hash256 := sha1.New(), sha256.New()
+
+	// Hide any WriterTo implementation of r, so that io.CopyBuffer uses our
+	// buffer rather than r choosing its own
+	buf := make([]byte, hashBufferSize)
+	if _, err := io.CopyBuffer(io.MultiWriter(hash1, hash256), struct{ io.Reader }{r}, buf); err != nil {
+		return "", "", err
+	}
+
+	return fmt.Sprintf("%040x", hash1.Sum(nil)), fmt.Sprintf("%064x", hash256.Sum(nil)), nil
+}
+
+// throughput formats the rate of transferring size bytes in d, in megabytes
+// per second
+func throughput(size int64, d time.Duration) string {
+	if d <= 0 {
+		return "unknown MB/s"
+	}
+	return fmt.Sprintf("%.2f MB/s", float64(size)/d.Seconds()/(1024*1024))
+}
+
+// concurrencyBounds returns the fewest and most artifacts to upload at once
+func (a *ArtifactUploader) concurrencyBounds() (min, max int) {
+	min, max = a.conf.MinConcurrency, a.conf.MaxConcurrency
+	if max <= 0 {
+		// Completely arbitrary, see pool.New
+		max = runtime.NumCPU() * 10
+	}
+	if files := a.maxOpenFiles(); max > files {
+		a.logger.Debug("Uploading at most %d artifacts at once, to limit open files", files)
+		max = files
+	}
+	if min <= 0 {
+		min = DefaultArtifactUploadMinConcurrency
+	}
+	if min > max {
+		min = max
+	}
+	return min, max
+}
+
+// retryStats tracks the number of retries, and the time spent retrying, across
+// all the artifacts in an upload
+type retryStats struct {
+	mu      sync.Mutex
+	count   int
+	elapsed time.Duration
+}
+
+// add records a retry that took d, measured from the end of the failed attempt
+// it retried
+func (s *retryStats) add(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.count++
+	s.elapsed += d
+}
+
+// exceeds reports whether the time spent retrying has exceeded budget. A zero
+// budget is never exceeded.
+func (s *retryStats) exceeds(budget time.Duration) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return budget > 0 && s.elapsed > budget
+}
+
+func (s *retryStats) totals() (int, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.count, s.elapsed
+}
+
+func (a *ArtifactUploader) upload(ctx context.Context, destination string, artifacts []*api.Artifact, summary *ArtifactUploadSummary, deadline time.Time) (err error) {
+	var totalBytes int64
+	for _, artifact := range artifacts {
+		totalBytes += artifact.FileSize
+	}
+	ctx, span := a.startArtifactSpan(ctx, "artifact.upload_destination",
+		attribute.String("artifact.backend", artifactBackendName(destination)),
+		attribute.Int("artifact.files", len(artifacts)),
+		attribute.Int64("artifact.bytes", totalBytes),
+	)
+	defer func() { endSpan(span, err) }()
+
 	var uploader Uploader
-	var err error
 
 	// Determine what uploader to use
-	if a.conf.Destination != "" {
-		if strings.HasPrefix(a.conf.Destination, "s3://") {
-			uploader, err = NewS3Uploader(a.logger, S3UploaderConfig{
-				Destination: a.conf.Destination,
-				DebugHTTP:   a.conf.DebugHTTP,
-			})
-		} else if strings.HasPrefix(a.conf.Destination, "gs://") {
-			uploader, err = NewGSUploader(a.logger, GSUploaderConfig{
-				Destination: a.conf.Destination,
-				DebugHTTP:   a.conf.DebugHTTP,
-			})
-		} else if strings.HasPrefix(a.conf.Destination, "rt://") {
-			uploader, err = NewArtifactoryUploader(a.logger, ArtifactoryUploaderConfig{
-				Destination: a.conf.Destination,
-				DebugHTTP:   a.conf.DebugHTTP,
-			})
-		} else {
-			return fmt.Errorf("invalid upload destination: '%v'. Only s3://, gs:// or rt:// upload schemes are allowed. Did you forget to surround your artifact upload pattern in double quotes?", a.conf.Destination)
+	if destination != "" {
+		newBackend, ok := lookupArtifactBackend(destination)
+		if !ok {
+			return fmt.Errorf("invalid upload destination: '%v'. Only %s upload schemes are allowed. Did you forget to surround your artifact upload pattern in double quotes?", destination, artifactBackendSchemes())
 		}
 
-		a.logger.Info("Uploading to %q, using your agent configuration", a.conf.Destination)
+		uploader, err = newBackend(a.logger, ArtifactBackendConfig{
+			Destination:    destination,
+			DebugHTTP:      a.conf.DebugHTTP,
+			DisableHTTP2:   a.conf.DisableHTTP2,
+			ReadBufferSize: a.conf.ReadBufferSize,
+			Bandwidth:      a.bandwidth,
+
+			DisableMultipart: a.conf.DisableMultipart,
+		})
+
+		a.logger.Info("Uploading to %q, using your agent configuration", destination)
 	} else {
 		uploader = NewFormUploader(a.logger, FormUploaderConfig{
-			DebugHTTP: a.conf.DebugHTTP,
+			DebugHTTP:      a.conf.DebugHTTP,
+			DisableHTTP2:   a.conf.DisableHTTP2,
+			ReadBufferSize: a.conf.ReadBufferSize,
+			Bandwidth:      a.bandwidth,
 		})
 
 		a.logger.Info("Uploading to default Buildkite artifact storage")
@@ -268,16 +1427,35 @@ func (a *ArtifactUploader) upload(ctx context.Context, artifacts []*api.Artifact
 		return fmt.Errorf("creating uploader: %v", err)
 	}
 
+	checksumStore := a.conf.ChecksumStore
+	if checksumStore == nil {
+		checksumStore = noopChecksumStore{}
+	}
+
+	// Paths of the artifacts that are already stored somewhere, which don't
+	// need uploading again
+	stored := make(map[string]bool)
+
 	// Set the URLs of the artifacts based on the uploader
 	for _, artifact := range artifacts {
 		artifact.URL = uploader.URL(artifact)
+		if artifact.URL != "" && artifact.Sha256Sum != "" {
+			url, ok, err := checksumStore.Has(ctx, artifact.Sha256Sum)
+			if err != nil {
+				a.logger.Warn("Failed to check the checksum store for %q, uploading it anyway: %v", artifact.Path, err)
+			} else if ok {
+				artifact.URL = url
+				stored[artifact.Path] = true
+			}
+		}
+		a.logDestination(artifact)
 	}
 
 	// Create the artifacts on Buildkite
 	batchCreator := NewArtifactBatchCreator(a.logger, a.apiClient, ArtifactBatchCreatorConfig{
 		JobID:                  a.conf.JobID,
 		Artifacts:              artifacts,
-		UploadDestination:      a.conf.Destination,
+		UploadDestination:      destination,
 		CreateArtifactsTimeout: 10 * time.Second,
 	})
 
@@ -286,16 +1464,47 @@ func (a *ArtifactUploader) upload(ctx context.Context, artifacts []*api.Artifact
 		return err
 	}
 
-	// Prepare a concurrency pool to upload the artifacts
-	p := pool.New(pool.MaxConcurrencyLimit)
+	// Prepare a concurrency pool to upload the artifacts. The pool bounds
+	// the number of goroutines, and the limiter adjusts how many of them are
+	// actually uploading as storage signals backpressure.
+	minConcurrency, maxConcurrency := a.concurrencyBounds()
+	p := pool.New(maxConcurrency)
+	limiter := newConcurrencyLimiter(minConcurrency, maxConcurrency, a.clock)
 	errors := []error{}
 	var errorsMutex sync.Mutex
 
+	// Keep track of retries across all the artifacts
+	retries := &retryStats{}
+
+	// How many artifacts weren't uploaded because the uploader was stopped
+	stopped := 0
+
+	// Uploads are cancelled separately from the artifact state updates, so
+	// that a fail-fast, timed out or cancelled upload can stop early but
+	// still report its states. The state updates are still bounded by the
+	// client's MaxWait.
+	stateCtx := detachedContext{parent: ctx}
+	var uploadCtx context.Context
+	var cancelUploads context.CancelFunc
+	if !deadline.IsZero() {
+		uploadCtx, cancelUploads = context.WithDeadline(ctx, deadline)
+	} else {
+		uploadCtx, cancelUploads = context.WithCancel(ctx)
+	}
+	defer cancelUploads()
+	uploadCtx = withArtifactProgress(uploadCtx, a.progress)
+
 	// Create a wait group so we can make sure the uploader waits for all
 	// the artifact states to upload before finishing
 	var stateUploaderWaitGroup sync.WaitGroup
 	stateUploaderWaitGroup.Add(1)
 
+	// The artifacts by ID, to look up their stored sizes once finished
+	artifactsByID := make(map[string]*api.Artifact, len(artifacts))
+	for _, artifact := range artifacts {
+		artifactsByID[artifact.ID] = artifact
+	}
+
 	// A map to keep track of artifact states and how many we've uploaded
 	artifactStates := make(map[string]string)
 	artifactStatesUploaded := 0
@@ -305,7 +1514,7 @@ func (a *ArtifactUploader) upload(ctx context.Context, artifacts []*api.Artifact
 	// seconds in batches
 	go func() {
 		for artifactStatesUploaded < len(artifacts) {
-			statesToUpload := make(map[string]string)
+			var statesToUpload []*api.ArtifactBatchUpdateArtifact
 
 			// Grab all the states we need to upload, and remove
 			// them from the tracking map
@@ -315,26 +1524,34 @@ func (a *ArtifactUploader) upload(ctx context.Context, artifacts []*api.Artifact
 			// nothing else is changing it at the same time.
 			artifactStatesMutex.Lock()
 			for id, state := range artifactStates {
-				statesToUpload[id] = state
+				update := &api.ArtifactBatchUpdateArtifact{ID: id, State: state}
+				if state == "finished" {
+					update.StoredSize = artifactsByID[id].StoredSize
+				}
+				statesToUpload = append(statesToUpload, update)
 				delete(artifactStates, id)
 			}
 			artifactStatesMutex.Unlock()
 
 			if len(statesToUpload) > 0 {
 				artifactStatesUploaded += len(statesToUpload)
-				for id, state := range statesToUpload {
-					a.logger.Debug("Artifact `%s` has state `%s`", id, state)
+				for _, update := range statesToUpload {
+					a.logger.Debug("Artifact `%s` has state `%s`", update.ID, update.State)
 				}
 
-				// Update the states of the artifacts in bulk.
+				// Update the states of the artifacts in bulk, bounding
+				// the operation, including its retries, by the client's
+				// MaxWait
+				opCtx, cancelOp := a.apiClient.OperationContext(stateCtx)
 				err := roko.NewRetrier(
 					// TODO: e.g. roko.ExponentialSubsecond(500*time.Millisecond) WithMaxAttempts(10)
 					// see: https://github.com/buildkite/roko/pull/8
 					// Meanwhile, 8 roko.Exponential(2sec) attempts is 1,2,4,8,16,32,64 seconds delay (~2 mins)
 					roko.WithMaxAttempts(8),
 					roko.WithStrategy(roko.Exponential(2*time.Second, 0)),
-				).DoWithContext(ctx, func(r *roko.Retrier) error {
-					ctxShort, cancel := context.WithTimeout(ctx, 5*time.Second)
+					roko.WithSleepFunc(a.clock.Sleep),
+				).DoWithContext(opCtx, func(r *roko.Retrier) error {
+					ctxShort, cancel := context.WithTimeout(opCtx, 5*time.Second)
 					defer cancel()
 					if _, err := a.apiClient.UpdateArtifacts(ctxShort, a.conf.JobID, statesToUpload); err != nil {
 						a.logger.Warn("%s (%s)", err, r)
@@ -342,6 +1559,7 @@ func (a *ArtifactUploader) upload(ctx context.Context, artifacts []*api.Artifact
 					}
 					return nil
 				})
+				cancelOp()
 				if err != nil {
 					a.logger.Error("Error uploading artifact states: %s", err)
 
@@ -369,27 +1587,125 @@ func (a *ArtifactUploader) upload(ctx context.Context, artifacts []*api.Artifact
 		artifact := artifact
 
 		p.Spawn(func() {
+			// Don't start any more uploads once they've been cancelled,
+			// e.g. after an earlier failure when failing fast
+			if err := uploadCtx.Err(); err != nil {
+				switch {
+				case err == context.DeadlineExceeded:
+					a.logger.Warn("Skipping upload of artifact \"%s\" as the upload timed out", artifact.Path)
+				case ctx.Err() != nil:
+					a.logger.Warn("Skipping upload of artifact \"%s\" as the upload was cancelled", artifact.Path)
+				default:
+					a.logger.Warn("Skipping upload of artifact \"%s\" after an earlier failure", artifact.Path)
+				}
+
+				artifactStatesMutex.Lock()
+				artifactStates[artifact.ID] = "error"
+				summary.Skipped++
+				artifactStatesMutex.Unlock()
+
+				a.progress.finished(artifact)
+				return
+			}
+
+			if a.stopping() {
+				a.logger.Warn("Skipping upload of artifact \"%s\" as the upload is stopping", artifact.Path)
+
+				artifactStatesMutex.Lock()
+				artifactStates[artifact.ID] = "error"
+				summary.Skipped++
+				stopped++
+				artifactStatesMutex.Unlock()
+
+				a.progress.finished(artifact)
+				return
+			}
+
+			if stored[artifact.Path] {
+				a.logger.Info("Skipping upload of artifact \"%s\", an identical file is already stored at %s", artifact.Path, artifact.URL)
+
+				artifactStatesMutex.Lock()
+				artifactStates[artifact.ID] = "finished"
+				summary.Skipped++
+				artifactStatesMutex.Unlock()
+
+				a.progress.finished(artifact)
+				return
+			}
+
 			// Show a nice message that we're starting to upload the file
 			a.logger.Info("Uploading artifact %s %s (%d bytes)", artifact.ID, artifact.Path, artifact.FileSize)
 
 			var state string
+			var firstAttemptAt, failedAt time.Time
+			var transferTime time.Duration
+
+			_, span := a.startArtifactSpan(uploadCtx, "artifact.upload_file",
+				attribute.String("artifact.path", artifact.Path),
+				attribute.String("artifact.backend", artifactBackendName(destination)),
+				attribute.Int64("artifact.bytes", artifact.FileSize),
+			)
 
 			// Upload the artifact and then set the state depending
 			// on whether or not it passed. We'll retry the upload
 			// a couple of times before giving up.
 			err := roko.NewRetrier(
-				roko.WithMaxAttempts(10),
+				roko.WithMaxAttempts(artifactUploadAttempts),
 				roko.WithStrategy(roko.Constant(5*time.Second)),
-			).DoWithContext(ctx, func(r *roko.Retrier) error {
-				if err := uploader.Upload(artifact); err != nil {
-					a.logger.Warn("%s (%s)", err, r)
+				roko.WithSleepFunc(a.clock.Sleep),
+			).DoWithContext(uploadCtx, func(r *roko.Retrier) error {
+				limiter.acquire()
+				attemptStart := a.clock.Now()
+				if r.AttemptCount() == 0 {
+					firstAttemptAt = attemptStart
+				}
+				err := uploader.Upload(uploadCtx, artifact)
+				transferTime = a.clock.Now().Sub(attemptStart)
+				limiter.release(err)
+				if isBackpressure(err) {
+					a.logger.Debug("Storage is applying backpressure, uploading at most %d artifacts at once", limiter.current())
+				}
+				if r.AttemptCount() > 0 {
+					retries.add(a.clock.Now().Sub(failedAt))
+				}
+				if err != nil {
+					failedAt = a.clock.Now()
+
+					// Each failed attempt is included as structured
+					// data in JSON logs
+					retry := &ArtifactUploadRetry{
+						Attempt: r.AttemptCount() + 1,
+						Elapsed: failedAt.Sub(firstAttemptAt),
+						Err:     a.newUploadError(artifact, err),
+					}
+					l := a.logger.WithFields(logger.JSONField("retry", retry))
+
+					switch {
+					case retries.exceeds(a.conf.MaxTotalRetryTime):
+						l.Warn("%s (retry budget of %s exceeded, giving up)", err, a.conf.MaxTotalRetryTime)
+						r.Break()
+					case !retry.Err.Retryable:
+						l.Warn("%s (not retryable, giving up)", err)
+						r.Break()
+					default:
+						if retry.Attempt < artifactUploadAttempts {
+							retry.Retrying = true
+							retry.Backoff = r.NextInterval()
+						}
+						l.Warn("%s (%s)", err, r)
+					}
 					return err
 				}
 				return nil
 			})
+			endSpan(span, err)
+
 			// Did the upload eventually fail?
 			if err != nil {
-				a.logger.Error("Error uploading artifact \"%s\": %s", artifact.Path, err)
+				// The error is included as structured data in JSON logs
+				uploadErr := a.newUploadError(artifact, err)
+				a.logger.WithFields(logger.JSONField("error", uploadErr)).
+					Error("Error uploading artifact \"%s\": %s", artifact.Path, err)
 
 				// Track the error that was raised. We need to
 				// acquire a lock since we mutate the errors
@@ -398,10 +1714,28 @@ func (a *ArtifactUploader) upload(ctx context.Context, artifacts []*api.Artifact
 				errors = append(errors, err)
 				errorsMutex.Unlock()
 
+				if a.conf.FailFast {
+					cancelUploads()
+				}
+
 				state = "error"
 			} else {
 				a.logger.Info("Successfully uploaded artifact \"%s\"", artifact.Path)
+				a.logger.Debug("Uploaded artifact \"%s\" (%d bytes) in %s (%s)",
+					artifact.Path, artifact.FileSize, transferTime.Round(time.Millisecond), throughput(artifact.FileSize, transferTime))
 				state = "finished"
+
+				// The built in uploaders report what they stored, but
+				// for others, assume they stored the original file
+				if artifact.StoredSize == 0 {
+					artifact.StoredSize = artifact.FileSize
+				}
+
+				if artifact.URL != "" && artifact.Sha256Sum != "" {
+					if err := checksumStore.Put(ctx, artifact.Sha256Sum, artifact.URL); err != nil {
+						a.logger.Warn("Failed to add %q to the checksum store: %v", artifact.Path, err)
+					}
+				}
 			}
 
 			// Since we mutate the artifactStates variable in
@@ -409,7 +1743,15 @@ func (a *ArtifactUploader) upload(ctx context.Context, artifacts []*api.Artifact
 			// nothing else is changing it at the same time.
 			artifactStatesMutex.Lock()
 			artifactStates[artifact.ID] = state
+			if state == "finished" {
+				summary.Uploaded++
+				summary.Bytes += artifact.FileSize
+			} else {
+				summary.Failed++
+			}
 			artifactStatesMutex.Unlock()
+
+			a.progress.finished(artifact)
 		})
 	}
 
@@ -423,10 +1765,23 @@ func (a *ArtifactUploader) upload(ctx context.Context, artifacts []*api.Artifact
 	// Wait for the statuses to finish uploading
 	stateUploaderWaitGroup.Wait()
 
+	if count, elapsed := retries.totals(); count > 0 {
+		a.logger.Notice("Retried artifact uploads %d times, spending %s retrying", count, elapsed.Round(time.Millisecond))
+	}
+
+	if uploadCtx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("upload timed out after %s, with %d of %d artifacts uploaded and %d remaining",
+			a.conf.Timeout, summary.Uploaded, len(artifacts), len(artifacts)-summary.Uploaded)
+	}
+
 	if len(errors) > 0 {
 		return fmt.Errorf("errors uploading artifacts: %v", errors)
 	}
 
+	if stopped > 0 {
+		return fmt.Errorf("stopped before uploading %d of %d artifacts", stopped, len(artifacts))
+	}
+
 	a.logger.Info("Artifact uploads completed successfully")
 
 	return nil
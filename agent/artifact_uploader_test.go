@@ -1,11 +1,20 @@
 package agent
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/buildkite/agent/v3/api"
 	"github.com/buildkite/agent/v3/experiments"
@@ -100,15 +109,7 @@ func TestCollect(t *testing.T) {
 	// path.Join function instead (which uses Unix/URI-style path separators,
 	// regardless of platform)
 
-	experimentKey := "normalised-upload-paths"
-	experimentPrev := experiments.IsEnabled(experimentKey)
-	defer func() {
-		if experimentPrev {
-			experiments.Enable(experimentKey)
-		} else {
-			experiments.Disable(experimentKey)
-		}
-	}()
+	defer experiments.Snapshot()()
 	experiments.Disable("normalised-upload-paths")
 	artifactsWithoutExperimentEnabled, err := uploader.Collect()
 	if err != nil {
@@ -213,6 +214,35 @@ func TestCollectWithSomeGlobsThatDontMatchAnything(t *testing.T) {
 	}
 }
 
+func TestCollectWithSomeGlobsThatDontMatchAnythingStrict(t *testing.T) {
+	wd, _ := os.Getwd()
+	root := filepath.Join(wd, "..")
+	os.Chdir(root)
+	defer os.Chdir(wd)
+
+	uploader := NewArtifactUploader(logger.Discard, nil, ArtifactUploaderConfig{
+		Paths: strings.Join([]string{
+			filepath.Join("dontmatchanything", "*"),
+			filepath.Join("dontmatchanything.zip"),
+			filepath.Join("test", "fixtures", "artifacts", "**", "*.jpg"),
+		}, ";"),
+		StrictGlobs: true,
+	})
+
+	_, err := uploader.Collect()
+	if err == nil {
+		t.Fatalf("uploader.Collect() error = nil, want an error listing the unmatched paths")
+	}
+	for _, path := range []string{filepath.Join("dontmatchanything", "*"), "dontmatchanything.zip"} {
+		if !strings.Contains(err.Error(), path) {
+			t.Errorf("uploader.Collect() error = %q, want it to mention %q", err, path)
+		}
+	}
+	if strings.Contains(err.Error(), "*.jpg") {
+		t.Errorf("uploader.Collect() error = %q, want it not to mention the path that matched", err)
+	}
+}
+
 func TestCollectWithSomeGlobsThatDontMatchAnythingFollowingSymlinks(t *testing.T) {
 	wd, _ := os.Getwd()
 	root := filepath.Join(wd, "..")
@@ -308,3 +338,1531 @@ func TestCollectWithDuplicateMatchesFollowingSymlinks(t *testing.T) {
 		paths,
 	)
 }
+
+func TestCollectWithDuplicateMatchesDedupedByTarget(t *testing.T) {
+	wd, _ := os.Getwd()
+	root := filepath.Join(wd, "..")
+	os.Chdir(root)
+	defer os.Chdir(wd)
+
+	uploader := NewArtifactUploader(logger.Discard, nil, ArtifactUploaderConfig{
+		Paths: strings.Join([]string{
+			filepath.Join("test", "fixtures", "artifacts", "**", "*.jpg"),
+			filepath.Join("test", "fixtures", "artifacts", "folder", "Commando.jpg"), // dupe
+		}, ";"),
+		FollowSymlinks: true,
+		DedupeByTarget: true,
+	})
+
+	artifacts, err := uploader.Collect()
+	if err != nil {
+		t.Fatalf("uploader.Collect() error = %v", err)
+	}
+
+	paths := []string{}
+	for _, a := range artifacts {
+		paths = append(paths, a.Path)
+	}
+
+	// Both terminator2.jpg paths are symlinks to The Terminator.jpg, which
+	// wins because it's the only path that isn't through a symlink
+	assert.ElementsMatch(
+		t,
+		[]string{
+			filepath.Join("test", "fixtures", "artifacts", "Mr Freeze.jpg"),
+			filepath.Join("test", "fixtures", "artifacts", "folder", "Commando.jpg"),
+			filepath.Join("test", "fixtures", "artifacts", "this is a folder with a space", "The Terminator.jpg"),
+		},
+		paths,
+	)
+}
+
+func TestCollectAbsoluteGlobsOutsideWorkingDir(t *testing.T) {
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+
+	outside := t.TempDir()
+	for _, name := range []string{
+		filepath.Join("app", "llamas.log"),
+		"alpacas.log",
+		"literal.txt",
+	} {
+		path := filepath.Join(outside, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
+			t.Fatalf("os.MkdirAll(%q) error = %v", filepath.Dir(path), err)
+		}
+		if err := os.WriteFile(path, []byte("llamas"), 0o666); err != nil {
+			t.Fatalf("os.WriteFile(%q) error = %v", path, err)
+		}
+	}
+
+	inside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(inside, "local.txt"), []byte("llamas"), 0o666); err != nil {
+		t.Fatalf("os.WriteFile(local.txt) error = %v", err)
+	}
+	os.Chdir(inside)
+
+	// The relative glob comes after the absolute ones, to check that it's
+	// still relative to the working directory
+	paths := strings.Join([]string{
+		filepath.Join(outside, "**", "*.log"),
+		filepath.Join(outside, "literal.txt"),
+		"local.txt",
+	}, ";")
+
+	// By default, files matched by absolute globs are uploaded at their whole
+	// absolute path, without the leading separator
+	fromRoot := func(path string) string {
+		rel, err := filepath.Rel(filepath.VolumeName(path)+string(filepath.Separator), path)
+		if err != nil {
+			t.Fatalf("filepath.Rel(%q) error = %v", path, err)
+		}
+		return rel
+	}
+
+	tests := []struct {
+		name          string
+		stripGlobBase bool
+		want          []string
+	}{
+		{
+			name: "whole absolute path",
+			want: []string{
+				fromRoot(filepath.Join(outside, "app", "llamas.log")),
+				fromRoot(filepath.Join(outside, "alpacas.log")),
+				fromRoot(filepath.Join(outside, "literal.txt")),
+				"local.txt",
+			},
+		},
+		{
+			name:          "stripping the glob base",
+			stripGlobBase: true,
+			want: []string{
+				filepath.Join("app", "llamas.log"),
+				"alpacas.log",
+				"literal.txt",
+				"local.txt",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			uploader := NewArtifactUploader(logger.Discard, nil, ArtifactUploaderConfig{
+				Paths:         paths,
+				StripGlobBase: test.stripGlobBase,
+			})
+
+			artifacts, err := uploader.Collect()
+			if err != nil {
+				t.Fatalf("uploader.Collect() error = %v", err)
+			}
+
+			got := []string{}
+			for _, a := range artifacts {
+				got = append(got, a.Path)
+			}
+			assert.ElementsMatch(t, test.want, got)
+		})
+	}
+}
+
+func TestCollectRelativeToGitRoot(t *testing.T) {
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, ".git"), 0o777); err != nil {
+		t.Fatalf("os.Mkdir(.git) error = %v", err)
+	}
+	subdir := filepath.Join(root, "some", "subdir")
+	if err := os.MkdirAll(subdir, 0o777); err != nil {
+		t.Fatalf("os.MkdirAll(%q) error = %v", subdir, err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "llamas.txt"), []byte("llamas"), 0o666); err != nil {
+		t.Fatalf("os.WriteFile(llamas.txt) error = %v", err)
+	}
+	os.Chdir(subdir)
+
+	uploader := NewArtifactUploader(logger.Discard, nil, ArtifactUploaderConfig{
+		Paths:      "*.txt",
+		RelativeTo: ArtifactRelativeToGitRoot,
+	})
+
+	artifacts, err := uploader.Collect()
+	if err != nil {
+		t.Fatalf("uploader.Collect() error = %v", err)
+	}
+
+	if len(artifacts) != 1 {
+		t.Fatalf("len(artifacts) = %d, want 1", len(artifacts))
+	}
+	assert.Equal(t, "llamas.txt", artifacts[0].Path)
+}
+
+func TestCollectLowercasePaths(t *testing.T) {
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "Report.HTML"), []byte("llamas"), 0o666); err != nil {
+		t.Fatalf("os.WriteFile(Report.HTML) error = %v", err)
+	}
+	os.Chdir(dir)
+
+	uploader := NewArtifactUploader(logger.Discard, nil, ArtifactUploaderConfig{
+		Paths:          "*.HTML",
+		LowercasePaths: true,
+	})
+
+	artifacts, err := uploader.Collect()
+	if err != nil {
+		t.Fatalf("uploader.Collect() error = %v", err)
+	}
+
+	if len(artifacts) != 1 {
+		t.Fatalf("len(artifacts) = %d, want 1", len(artifacts))
+	}
+	assert.Equal(t, "report.html", artifacts[0].Path)
+	assert.Equal(t, filepath.Join(dir, "Report.HTML"), artifacts[0].AbsolutePath)
+}
+
+func TestCollectLowercasePathsCollision(t *testing.T) {
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+
+	dir := t.TempDir()
+	for _, name := range []string{"Report.html", "report.HTML"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("llamas"), 0o666); err != nil {
+			t.Fatalf("os.WriteFile(%s) error = %v", name, err)
+		}
+	}
+	os.Chdir(dir)
+
+	// On case-insensitive filesystems only one of the files exists
+	if entries, _ := os.ReadDir(dir); len(entries) < 2 {
+		t.Skip("filesystem is case-insensitive")
+	}
+
+	uploader := NewArtifactUploader(logger.Discard, nil, ArtifactUploaderConfig{
+		Paths:          "Report.html;report.HTML",
+		LowercasePaths: true,
+	})
+
+	if _, err := uploader.Collect(); err == nil {
+		t.Fatalf("uploader.Collect() error = nil, want a collision error")
+	}
+}
+
+func TestCollectPathTransform(t *testing.T) {
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "reports", "unit"), 0o777); err != nil {
+		t.Fatalf("os.MkdirAll() error = %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "reports", "unit", "llamas.xml"), []byte("llamas"), 0o666); err != nil {
+		t.Fatalf("os.WriteFile(llamas.xml) error = %v", err)
+	}
+	os.Chdir(dir)
+
+	uploader := NewArtifactUploader(logger.Discard, nil, ArtifactUploaderConfig{
+		Paths: "reports/**/*.xml",
+		PathTransform: func(path string) (string, error) {
+			return "2022-01-01/" + filepath.Base(path), nil
+		},
+	})
+
+	artifacts, err := uploader.Collect()
+	if err != nil {
+		t.Fatalf("uploader.Collect() error = %v", err)
+	}
+
+	if len(artifacts) != 1 {
+		t.Fatalf("len(artifacts) = %d, want 1", len(artifacts))
+	}
+	assert.Equal(t, "2022-01-01/llamas.xml", artifacts[0].Path)
+	assert.Equal(t, filepath.Join(dir, "reports", "unit", "llamas.xml"), artifacts[0].AbsolutePath)
+}
+
+func TestCollectPathTransformCollisionAndError(t *testing.T) {
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+
+	dir := t.TempDir()
+	for _, name := range []string{"alpacas.txt", "llamas.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("llamas"), 0o666); err != nil {
+			t.Fatalf("os.WriteFile(%s) error = %v", name, err)
+		}
+	}
+	os.Chdir(dir)
+
+	collide := NewArtifactUploader(logger.Discard, nil, ArtifactUploaderConfig{
+		Paths: "*.txt",
+		PathTransform: func(string) (string, error) {
+			return "camelids.txt", nil
+		},
+	})
+	if _, err := collide.Collect(); err == nil {
+		t.Errorf("collide.Collect() error = nil, want a collision error")
+	}
+
+	errTransform := errors.New("no camelids allowed")
+	fail := NewArtifactUploader(logger.Discard, nil, ArtifactUploaderConfig{
+		Paths: "*.txt",
+		PathTransform: func(string) (string, error) {
+			return "", errTransform
+		},
+	})
+	if _, err := fail.Collect(); !errors.Is(err, errTransform) {
+		t.Errorf("fail.Collect() error = %v, want %v", err, errTransform)
+	}
+}
+
+func TestCollectFlattenStrategy(t *testing.T) {
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+
+	dir := t.TempDir()
+	for _, name := range []string{"a/report.xml", "b/report.xml", "b/summary.txt"} {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0o777); err != nil {
+			t.Fatalf("os.MkdirAll() error = %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("llamas"), 0o666); err != nil {
+			t.Fatalf("os.WriteFile(%s) error = %v", name, err)
+		}
+	}
+	os.Chdir(dir)
+
+	paths := func(strategy string) ([]string, error) {
+		uploader := NewArtifactUploader(logger.Discard, nil, ArtifactUploaderConfig{
+			Paths:           "**/*",
+			FlattenStrategy: strategy,
+		})
+		artifacts, err := uploader.Collect()
+		var paths []string
+		for _, artifact := range artifacts {
+			paths = append(paths, filepath.ToSlash(artifact.Path))
+		}
+		sort.Strings(paths)
+		return paths, err
+	}
+
+	t.Run("keep-paths", func(t *testing.T) {
+		got, err := paths(ArtifactFlattenKeepPaths)
+		if err != nil {
+			t.Fatalf("uploader.Collect() error = %v", err)
+		}
+		assert.Equal(t, []string{"a/report.xml", "b/report.xml", "b/summary.txt"}, got)
+	})
+
+	t.Run("error-on-collision", func(t *testing.T) {
+		if _, err := paths(ArtifactFlattenErrorOnCollision); err == nil {
+			t.Fatalf("uploader.Collect() error = nil, want a collision error")
+		}
+	})
+
+	t.Run("suffix", func(t *testing.T) {
+		got, err := paths(ArtifactFlattenSuffix)
+		if err != nil {
+			t.Fatalf("uploader.Collect() error = %v", err)
+		}
+		assert.Equal(t, []string{"report-1.xml", "report.xml", "summary.txt"}, got)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		if _, err := paths("squash"); err == nil {
+			t.Fatalf("uploader.Collect() error = nil, want an invalid strategy error")
+		}
+	})
+}
+
+func TestFlattenPathSuffix(t *testing.T) {
+	uploader := NewArtifactUploader(logger.Discard, nil, ArtifactUploaderConfig{
+		FlattenStrategy: ArtifactFlattenSuffix,
+	})
+
+	taken := make(map[string]bool)
+	var got []string
+	for _, path := range []string{"a/report.xml", "b/report.xml", "c/report-1.xml", "a/.env", "b/.env"} {
+		got = append(got, uploader.flattenPath(path, taken))
+	}
+
+	assert.Equal(t, []string{"report.xml", "report-1.xml", "report-1-1.xml", ".env", ".env-1"}, got)
+}
+
+func TestCollectPreserveEmptyDirs(t *testing.T) {
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+
+	dir := t.TempDir()
+	for _, d := range []string{"out/cache", "out/nested/deeper"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0o777); err != nil {
+			t.Fatalf("os.MkdirAll(%s) error = %v", d, err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "out", "llamas.txt"), []byte("llamas"), 0o666); err != nil {
+		t.Fatalf("os.WriteFile(llamas.txt) error = %v", err)
+	}
+	os.Chdir(dir)
+
+	paths := func(conf ArtifactUploaderConfig) []string {
+		conf.Paths = "out/**/*"
+		artifacts, err := NewArtifactUploader(logger.Discard, nil, conf).Collect()
+		if err != nil {
+			t.Fatalf("uploader.Collect() error = %v", err)
+		}
+		var paths []string
+		for _, artifact := range artifacts {
+			paths = append(paths, filepath.ToSlash(artifact.Path))
+		}
+		sort.Strings(paths)
+		return paths
+	}
+
+	assert.Equal(t, []string{"out/llamas.txt"}, paths(ArtifactUploaderConfig{}))
+
+	// Directories with only directories in them aren't empty
+	assert.Equal(t, []string{
+		"out/cache/.keep",
+		"out/llamas.txt",
+		"out/nested/deeper/.keep",
+	}, paths(ArtifactUploaderConfig{PreserveEmptyDirs: true, SkipEmpty: true}))
+
+	assert.Equal(t, []string{
+		"out/cache/.gitkeep",
+		"out/llamas.txt",
+		"out/nested/deeper/.gitkeep",
+	}, paths(ArtifactUploaderConfig{PreserveEmptyDirs: true, EmptyDirMarker: ".gitkeep"}))
+}
+
+func TestCollectModifiedSince(t *testing.T) {
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+
+	dir := t.TempDir()
+	since := time.Now().Add(-10 * time.Minute)
+	for name, mtime := range map[string]time.Time{
+		"cached.txt": since.Add(-time.Hour),
+		"built.txt":  since.Add(time.Minute),
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("llamas"), 0o666); err != nil {
+			t.Fatalf("os.WriteFile(%s) error = %v", name, err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatalf("os.Chtimes(%s) error = %v", name, err)
+		}
+	}
+	os.Chdir(dir)
+
+	uploader := NewArtifactUploader(logger.Discard, nil, ArtifactUploaderConfig{
+		Paths:         "*.txt",
+		ModifiedSince: since,
+	})
+
+	artifacts, err := uploader.Collect()
+	if err != nil {
+		t.Fatalf("uploader.Collect() error = %v", err)
+	}
+	if len(artifacts) != 1 {
+		t.Fatalf("len(artifacts) = %d, want 1", len(artifacts))
+	}
+	assert.Equal(t, "built.txt", artifacts[0].Path)
+}
+
+func TestCollectBlockSensitive(t *testing.T) {
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+
+	dir := t.TempDir()
+	for _, name := range []string{"build.log", "deploy.PEM", ".env"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("llamas"), 0o666); err != nil {
+			t.Fatalf("os.WriteFile(%s) error = %v", name, err)
+		}
+	}
+	os.Chdir(dir)
+
+	uploader := NewArtifactUploader(logger.Discard, nil, ArtifactUploaderConfig{
+		Paths: "*",
+	})
+	artifacts, err := uploader.Collect()
+	if err != nil {
+		t.Fatalf("uploader.Collect() error = %v", err)
+	}
+	assert.Equal(t, 3, len(artifacts))
+
+	uploader = NewArtifactUploader(logger.Discard, nil, ArtifactUploaderConfig{
+		Paths:          "*",
+		BlockSensitive: true,
+	})
+	if _, err := uploader.Collect(); err == nil {
+		t.Fatalf("uploader.Collect() error = nil, want an error for the sensitive files")
+	}
+
+	uploader = NewArtifactUploader(logger.Discard, nil, ArtifactUploaderConfig{
+		Paths:             "*",
+		BlockSensitive:    true,
+		SensitivePatterns: []string{"*.key"},
+	})
+	if _, err := uploader.Collect(); err != nil {
+		t.Fatalf("uploader.Collect() error = %v", err)
+	}
+}
+
+func TestCollectNoGlob(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file names can't contain * on Windows")
+	}
+
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+
+	dir := t.TempDir()
+	for _, name := range []string{"report[1]*.txt", "report1.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("llamas"), 0o666); err != nil {
+			t.Fatalf("os.WriteFile(%s) error = %v", name, err)
+		}
+	}
+	os.Chdir(dir)
+
+	uploader := NewArtifactUploader(logger.Discard, nil, ArtifactUploaderConfig{
+		Paths:  "report[1]*.txt;missing*.txt",
+		NoGlob: true,
+	})
+
+	artifacts, err := uploader.Collect()
+	if err != nil {
+		t.Fatalf("uploader.Collect() error = %v", err)
+	}
+
+	if len(artifacts) != 1 {
+		t.Fatalf("len(artifacts) = %d, want 1", len(artifacts))
+	}
+	assert.Equal(t, "report[1]*.txt", artifacts[0].Path)
+}
+
+func TestCollectConfineToWorkingDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("creating symlinks needs extra privileges on Windows")
+	}
+
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+
+	dir := t.TempDir()
+	for _, name := range []string{filepath.Join("outside", "secret.txt"), filepath.Join("work", "inside.txt")} {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0o777); err != nil {
+			t.Fatalf("os.MkdirAll() error = %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("llamas"), 0o666); err != nil {
+			t.Fatalf("os.WriteFile(%s) error = %v", name, err)
+		}
+	}
+	links := map[string]string{
+		"escape.txt": filepath.Join("..", "outside", "secret.txt"),
+		"alias.txt":  "inside.txt",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(dir, "work", name)); err != nil {
+			t.Fatalf("os.Symlink(%s) error = %v", name, err)
+		}
+	}
+	os.Chdir(filepath.Join(dir, "work"))
+
+	uploader := NewArtifactUploader(logger.Discard, nil, ArtifactUploaderConfig{
+		Paths:               "*.txt",
+		FollowSymlinks:      true,
+		ConfineToWorkingDir: true,
+	})
+
+	artifacts, err := uploader.Collect()
+	if err != nil {
+		t.Fatalf("uploader.Collect() error = %v", err)
+	}
+
+	var paths []string
+	for _, artifact := range artifacts {
+		paths = append(paths, artifact.Path)
+	}
+	sort.Strings(paths)
+	assert.Equal(t, []string{"alias.txt", "inside.txt"}, paths)
+}
+
+func TestCollectExpiresIn(t *testing.T) {
+	wd, _ := os.Getwd()
+	root := filepath.Join(wd, "..")
+	os.Chdir(root)
+	defer os.Chdir(wd)
+
+	uploader := NewArtifactUploader(logger.Discard, nil, ArtifactUploaderConfig{
+		Paths:     filepath.Join("test", "fixtures", "artifacts", "Genisys.png"),
+		ExpiresIn: 72 * time.Hour,
+	})
+
+	before := time.Now()
+	artifacts, err := uploader.Collect()
+	if err != nil {
+		t.Fatalf("uploader.Collect() error = %v", err)
+	}
+
+	if len(artifacts) != 1 {
+		t.Fatalf("len(artifacts) = %d, want 1", len(artifacts))
+	}
+	if artifacts[0].ExpiresAt == nil {
+		t.Fatalf("artifacts[0].ExpiresAt = nil, want a time")
+	}
+	assert.WithinDuration(t, before.Add(72*time.Hour), *artifacts[0].ExpiresAt, time.Minute)
+
+	uploader = NewArtifactUploader(logger.Discard, nil, ArtifactUploaderConfig{
+		Paths:     filepath.Join("test", "fixtures", "artifacts", "Genisys.png"),
+		ExpiresIn: -time.Hour,
+	})
+	if _, err := uploader.Collect(); err == nil {
+		t.Fatalf("uploader.Collect() error = nil, want an error for the negative expiry")
+	}
+}
+
+func TestContentDisposition(t *testing.T) {
+	t.Parallel()
+
+	uploader := NewArtifactUploader(logger.Discard, nil, ArtifactUploaderConfig{
+		ContentDispositions: []ArtifactContentDisposition{
+			{Pattern: "reports/**/*.pdf", Disposition: "inline"},
+			{Pattern: "**/*.pdf", Disposition: "attachment"},
+			{Pattern: "**/*.csv", Disposition: "attachment"},
+		},
+	})
+
+	for _, test := range []struct {
+		path string
+		want string
+	}{
+		{path: "reports/2023/summary.pdf", want: "inline"},
+		{path: "docs/manual.pdf", want: `attachment; filename=manual.pdf`},
+		{path: "data/llamas and alpacas.csv", want: `attachment; filename="llamas and alpacas.csv"`},
+		{path: "data/résumé.csv", want: `attachment; filename*=utf-8''r%C3%A9sum%C3%A9.csv`},
+		{path: "Genisys.png", want: ""},
+	} {
+		if got := uploader.contentDisposition(test.path); got != test.want {
+			t.Errorf("uploader.contentDisposition(%q) = %q, want %q", test.path, got, test.want)
+		}
+	}
+}
+
+func TestCollectInvalidPathSeparator(t *testing.T) {
+	uploader := NewArtifactUploader(logger.Discard, nil, ArtifactUploaderConfig{
+		Paths:         "*.txt||*.log",
+		PathSeparator: "||",
+	})
+
+	if _, err := uploader.Collect(); err == nil {
+		t.Fatalf("uploader.Collect() error = nil, want an error for the separator")
+	}
+}
+
+func TestCollectSkipEmpty(t *testing.T) {
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+
+	dir := t.TempDir()
+	for name, content := range map[string]string{"full.txt": "llamas", "empty.txt": ""} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o666); err != nil {
+			t.Fatalf("os.WriteFile(%s) error = %v", name, err)
+		}
+	}
+	os.Chdir(dir)
+
+	uploader := NewArtifactUploader(logger.Discard, nil, ArtifactUploaderConfig{
+		Paths:     "*.txt",
+		SkipEmpty: true,
+	})
+
+	artifacts, err := uploader.Collect()
+	if err != nil {
+		t.Fatalf("uploader.Collect() error = %v", err)
+	}
+
+	if len(artifacts) != 1 {
+		t.Fatalf("len(artifacts) = %d, want 1", len(artifacts))
+	}
+	assert.Equal(t, "full.txt", artifacts[0].Path)
+}
+
+func TestCollectSkipVanished(t *testing.T) {
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+
+	dir := t.TempDir()
+	for _, name := range []string{"llamas.log", "alpacas.log"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0o666); err != nil {
+			t.Fatalf("os.WriteFile(%s) error = %v", name, err)
+		}
+	}
+	os.Chdir(dir)
+
+	for _, skipVanished := range []bool{true, false} {
+		uploader := NewArtifactUploader(logger.Discard, nil, ArtifactUploaderConfig{
+			Paths:        "*.log",
+			SkipVanished: skipVanished,
+		})
+
+		matches, err := uploader.match(dir)
+		if err != nil {
+			t.Fatalf("uploader.match(%q) error = %v", dir, err)
+		}
+		if len(matches) != 2 {
+			t.Fatalf("len(matches) = %d, want 2", len(matches))
+		}
+
+		// The log is rotated away after it's been found, but before it's read
+		if err := os.Remove(filepath.Join(dir, "alpacas.log")); err != nil && !os.IsNotExist(err) {
+			t.Fatalf("os.Remove(alpacas.log) error = %v", err)
+		}
+
+		artifacts, err := uploader.buildAll(dir, matches)
+		if !skipVanished {
+			if err == nil {
+				t.Errorf("uploader.buildAll() error = nil, want an error for the deleted file")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("uploader.buildAll() error = %v", err)
+		}
+		if len(artifacts) != 1 {
+			t.Fatalf("len(artifacts) = %d, want 1", len(artifacts))
+		}
+		assert.Equal(t, "llamas.log", artifacts[0].Path)
+
+		if err := os.WriteFile(filepath.Join(dir, "alpacas.log"), []byte("alpacas.log"), 0o666); err != nil {
+			t.Fatalf("os.WriteFile(alpacas.log) error = %v", err)
+		}
+	}
+}
+
+func TestCollectMaxFiles(t *testing.T) {
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+
+	dir := t.TempDir()
+	for _, name := range []string{"llamas.txt", "alpacas.txt", "camels.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0o666); err != nil {
+			t.Fatalf("os.WriteFile(%s) error = %v", name, err)
+		}
+	}
+	os.Chdir(dir)
+
+	uploader := NewArtifactUploader(logger.Discard, nil, ArtifactUploaderConfig{
+		Paths:    "*.txt",
+		MaxFiles: 3,
+	})
+	count, err := uploader.CountMatches()
+	if err != nil {
+		t.Fatalf("uploader.CountMatches() error = %v", err)
+	}
+	assert.Equal(t, 3, count)
+
+	uploader = NewArtifactUploader(logger.Discard, nil, ArtifactUploaderConfig{
+		Paths:    "*.txt",
+		MaxFiles: 2,
+	})
+	count, err = uploader.CountMatches()
+	assert.Equal(t, 3, count)
+	assert.ErrorContains(t, err, "match 3 files, which is more than the limit of 2")
+
+	_, err = uploader.Collect()
+	assert.ErrorContains(t, err, "match 3 files, which is more than the limit of 2")
+}
+
+func TestArtifactUploadSummaryString(t *testing.T) {
+	summary := ArtifactUploadSummary{
+		Uploaded: 42,
+		Skipped:  3,
+		Failed:   1,
+		Bytes:    10485760,
+		Duration: 4200 * time.Millisecond,
+	}
+
+	assert.Equal(t, "buildkite-agent: uploaded=42 skipped=3 failed=1 bytes=10485760 duration=4.2s", summary.String())
+}
+
+func TestCollectWithArtifactOrder(t *testing.T) {
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "important"), 0o777); err != nil {
+		t.Fatalf("os.Mkdir(important) error = %v", err)
+	}
+	files := map[string]string{
+		"a.txt":           "llamas",
+		"b.log":           "alpacas",
+		"important/c.txt": "camels",
+		ArtifactOrderFile: "# logs first\n*.log\n\nimportant/**\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o666); err != nil {
+			t.Fatalf("os.WriteFile(%s) error = %v", name, err)
+		}
+	}
+	os.Chdir(dir)
+
+	uploader := NewArtifactUploader(logger.Discard, nil, ArtifactUploaderConfig{
+		Paths: "*.txt;*.log;important/*.txt",
+	})
+
+	artifacts, err := uploader.Collect()
+	if err != nil {
+		t.Fatalf("uploader.Collect() error = %v", err)
+	}
+
+	var paths []string
+	for _, artifact := range artifacts {
+		paths = append(paths, filepath.ToSlash(artifact.Path))
+	}
+	assert.Equal(t, []string{"b.log", "important/c.txt", "a.txt"}, paths)
+}
+
+func TestHashFileHasBoundedMemory(t *testing.T) {
+	const size = 64 << 20 // 64MB
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "big.bin"))
+	if err != nil {
+		t.Fatalf("os.Create() error = %v", err)
+	}
+	defer f.Close()
+	if err := f.Truncate(size); err != nil {
+		t.Fatalf("f.Truncate(%d) error = %v", size, err)
+	}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	sha1sum, sha256sum, err := hashFile(f)
+	if err != nil {
+		t.Fatalf("hashFile() error = %v", err)
+	}
+
+	runtime.ReadMemStats(&after)
+
+	// The checksums of 64MB of zeros, so we know all of the file was read
+	assert.Equal(t, "44fac4bedde4df04b9572ac665d3ac2c5cd00c7d", sha1sum)
+	assert.Equal(t, "3b6a07d0d404fab4e23b6d34bc6696a6a312dd92821332385e5af7c01c421351", sha256sum)
+
+	// Allow plenty of room for the buffer and the hashes themselves, but far
+	// less than the size of the file
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+		t.Errorf("hashFile() allocated %d bytes hashing a %d byte file, want at most %d", allocated, size, 1<<20)
+	}
+}
+
+func TestCollectSymlinkedFileFollowingSymlinks(t *testing.T) {
+	wd, _ := os.Getwd()
+	root := filepath.Join(wd, "..")
+	os.Chdir(root)
+	defer os.Chdir(wd)
+
+	uploader := NewArtifactUploader(logger.Discard, nil, ArtifactUploaderConfig{
+		Paths:          filepath.Join("test", "fixtures", "symlinks", "latest.log"),
+		FollowSymlinks: true,
+	})
+
+	artifacts, err := uploader.Collect()
+	if err != nil {
+		t.Fatalf("uploader.Collect() error = %v", err)
+	}
+
+	if len(artifacts) != 1 {
+		t.Fatalf("len(artifacts) = %d, want 1", len(artifacts))
+	}
+
+	target, err := filepath.EvalSymlinks(filepath.Join(root, "test", "fixtures", "symlinks", "build-123.log"))
+	if err != nil {
+		t.Fatalf("filepath.EvalSymlinks() error = %v", err)
+	}
+	info, err := os.Stat(target)
+	if err != nil {
+		t.Fatalf("os.Stat(%q) error = %v", target, err)
+	}
+
+	// The symlink is uploaded at its own path, with the content of its target
+	assert.Equal(t, filepath.Join("test", "fixtures", "symlinks", "latest.log"), artifacts[0].Path)
+	assert.Equal(t, target, artifacts[0].AbsolutePath)
+	assert.Equal(t, info.Size(), artifacts[0].FileSize)
+}
+
+type recordingArtifactBackend struct {
+	testArtifactBackend
+
+	mu       *sync.Mutex
+	uploaded *[]string
+}
+
+func (b *recordingArtifactBackend) Upload(_ context.Context, artifact *api.Artifact) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	*b.uploaded = append(*b.uploaded, b.URL(artifact))
+	return nil
+}
+
+// testArtifactServer is a fake Agent API that creates artifacts, numbering
+// them artifact-1, artifact-2 and so on across batches, and records the
+// batches, artifact states and stored sizes it's sent
+type testArtifactServer struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	batches     []api.ArtifactBatch
+	states      map[string]string
+	storedSizes map[string]int64
+}
+
+func newTestArtifactServer(t *testing.T) *testArtifactServer {
+	t.Helper()
+
+	s := &testArtifactServer{
+		states:      make(map[string]string),
+		storedSizes: make(map[string]int64),
+	}
+	created := 0
+	s.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		switch req.Method {
+		case http.MethodPost:
+			var batch api.ArtifactBatch
+			if err := json.NewDecoder(req.Body).Decode(&batch); err != nil {
+				http.Error(rw, err.Error(), http.StatusBadRequest)
+				return
+			}
+			s.batches = append(s.batches, batch)
+
+			ids := make([]string, 0, len(batch.Artifacts))
+			for range batch.Artifacts {
+				created++
+				ids = append(ids, fmt.Sprintf("artifact-%d", created))
+			}
+			json.NewEncoder(rw).Encode(api.ArtifactBatchCreateResponse{ArtifactIDs: ids})
+		case http.MethodPut:
+			var update api.ArtifactBatchUpdateRequest
+			if err := json.NewDecoder(req.Body).Decode(&update); err != nil {
+				http.Error(rw, err.Error(), http.StatusBadRequest)
+				return
+			}
+			for _, artifact := range update.Artifacts {
+				s.states[artifact.ID] = artifact.State
+				if artifact.StoredSize != 0 {
+					s.storedSizes[artifact.ID] = artifact.StoredSize
+				}
+			}
+			fmt.Fprint(rw, "{}")
+		default:
+			http.Error(rw, "Not found", http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// apiClient returns an Agent API client that talks to the server
+func (s *testArtifactServer) apiClient() *api.Client {
+	return api.NewClient(logger.Discard, api.Config{
+		Endpoint: s.URL,
+		Token:    "llamasforever",
+	})
+}
+
+// artifactStates returns the latest state of each artifact it's been sent
+func (s *testArtifactServer) artifactStates() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	states := make(map[string]string, len(s.states))
+	for id, state := range s.states {
+		states[id] = state
+	}
+	return states
+}
+
+// artifactStoredSizes returns the stored size of each artifact it's been sent
+func (s *testArtifactServer) artifactStoredSizes() map[string]int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sizes := make(map[string]int64, len(s.storedSizes))
+	for id, size := range s.storedSizes {
+		sizes[id] = size
+	}
+	return sizes
+}
+
+// artifactBatches returns the batches of artifacts it's created
+func (s *testArtifactServer) artifactBatches() []api.ArtifactBatch {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]api.ArtifactBatch(nil), s.batches...)
+}
+
+// destinedArtifactBackend is an ArtifactBackend for tests that's told the
+// destination it was created for, like testArtifactBackend
+type destinedArtifactBackend interface {
+	ArtifactBackend
+	setDestination(string)
+}
+
+// registerTestArtifactBackend registers backend for the scheme until the test
+// finishes, setting its destination to whatever it's created for
+func registerTestArtifactBackend(t *testing.T, scheme string, backend destinedArtifactBackend) {
+	t.Helper()
+
+	RegisterArtifactBackend(scheme, func(l logger.Logger, c ArtifactBackendConfig) (ArtifactBackend, error) {
+		backend.setDestination(c.Destination)
+		return backend, nil
+	})
+	t.Cleanup(func() {
+		artifactBackendsMu.Lock()
+		delete(artifactBackends, scheme)
+		artifactBackendsMu.Unlock()
+	})
+}
+
+func TestUploadToMultipleDestinations(t *testing.T) {
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+
+	dir := t.TempDir()
+	for _, name := range []string{"llamas.txt", "alpacas.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0o666); err != nil {
+			t.Fatalf("os.WriteFile(%s) error = %v", name, err)
+		}
+	}
+	os.Chdir(dir)
+
+	var mu sync.Mutex
+	var uploaded []string
+	for _, scheme := range []string{"llama", "alpaca"} {
+		registerTestArtifactBackend(t, scheme, &recordingArtifactBackend{mu: &mu, uploaded: &uploaded})
+	}
+
+	server := newTestArtifactServer(t)
+
+	uploader := NewArtifactUploader(logger.Discard, server.apiClient(), ArtifactUploaderConfig{
+		JobID:        "my-job",
+		Paths:        "*.txt",
+		Destinations: []string{"llama://herd", "alpaca://herd"},
+	})
+	if err := uploader.Upload(context.Background()); err != nil {
+		t.Fatalf("uploader.Upload() error = %v", err)
+	}
+
+	sort.Strings(uploaded)
+	assert.Equal(t, []string{
+		"alpaca://herd/alpacas.txt",
+		"alpaca://herd/llamas.txt",
+		"llama://herd/alpacas.txt",
+		"llama://herd/llamas.txt",
+	}, uploaded)
+	assert.Len(t, server.artifactBatches(), 2)
+}
+
+// fakeClock is a Clock that only moves when something sleeps on it
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	sleeps []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	c.sleeps = append(c.sleeps, d)
+}
+
+type failingArtifactBackend struct {
+	testArtifactBackend
+
+	attempts int
+}
+
+func (b *failingArtifactBackend) Upload(context.Context, *api.Artifact) error {
+	b.attempts++
+	return errors.New("storage is having a bad day")
+}
+
+func TestUploadRetryBudgetWithFakeClock(t *testing.T) {
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "llamas.txt"), []byte("llamas"), 0o666); err != nil {
+		t.Fatalf("os.WriteFile(llamas.txt) error = %v", err)
+	}
+	os.Chdir(dir)
+
+	backend := &failingArtifactBackend{}
+	registerTestArtifactBackend(t, "llama", backend)
+
+	server := newTestArtifactServer(t)
+
+	clock := &fakeClock{now: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)}
+	uploader := NewArtifactUploader(logger.Discard, server.apiClient(), ArtifactUploaderConfig{
+		JobID:             "my-job",
+		Paths:             "llamas.txt",
+		Destination:       "llama://herd",
+		MaxTotalRetryTime: 12 * time.Second,
+	})
+	uploader.clock = clock
+
+	if err := uploader.Upload(context.Background()); err == nil {
+		t.Fatalf("uploader.Upload() error = %v, want an error", err)
+	}
+
+	// Each retry waits 5 seconds, so the third retry is the one that goes
+	// over the 12 second budget
+	assert.Equal(t, 4, backend.attempts)
+	assert.Equal(t, []time.Duration{5 * time.Second, 5 * time.Second, 5 * time.Second}, clock.sleeps)
+}
+
+func TestThroughput(t *testing.T) {
+	assert.Equal(t, "2.00 MB/s", throughput(4*1024*1024, 2*time.Second))
+	assert.Equal(t, "0.50 MB/s", throughput(512*1024, time.Second))
+	assert.Equal(t, "unknown MB/s", throughput(1024, 0))
+}
+
+func TestUploadAfterStop(t *testing.T) {
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "llamas.txt"), []byte("llamas"), 0o666); err != nil {
+		t.Fatalf("os.WriteFile(llamas.txt) error = %v", err)
+	}
+	os.Chdir(dir)
+
+	backend := &countingArtifactBackend{}
+	registerTestArtifactBackend(t, "llama", backend)
+
+	server := newTestArtifactServer(t)
+
+	uploader := NewArtifactUploader(logger.Discard, server.apiClient(), ArtifactUploaderConfig{
+		JobID:       "my-job",
+		Paths:       "llamas.txt",
+		Destination: "llama://herd",
+	})
+	uploader.Stop()
+
+	if err := uploader.Upload(context.Background()); err == nil {
+		t.Fatalf("uploader.Upload() error = %v, want an error", err)
+	}
+
+	assert.Empty(t, backend.uploaded)
+	assert.Equal(t, map[string]string{"artifact-1": "error"}, server.artifactStates())
+}
+
+func TestUploadTimeout(t *testing.T) {
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "llamas.txt"), []byte("llamas"), 0o666); err != nil {
+		t.Fatalf("os.WriteFile(llamas.txt) error = %v", err)
+	}
+	os.Chdir(dir)
+
+	backend := &countingArtifactBackend{}
+	registerTestArtifactBackend(t, "llama", backend)
+
+	server := newTestArtifactServer(t)
+
+	// The deadline has passed by the time the uploads start
+	uploader := NewArtifactUploader(logger.Discard, server.apiClient(), ArtifactUploaderConfig{
+		JobID:       "my-job",
+		Paths:       "llamas.txt",
+		Destination: "llama://herd",
+		Timeout:     time.Nanosecond,
+	})
+
+	err := uploader.Upload(context.Background())
+	if err == nil {
+		t.Fatalf("uploader.Upload() error = %v, want an error", err)
+	}
+	assert.Contains(t, err.Error(), "with 0 of 1 artifacts uploaded and 1 remaining")
+
+	// The states are still reported after the deadline
+	assert.Empty(t, backend.uploaded)
+	assert.Equal(t, map[string]string{"artifact-1": "error"}, server.artifactStates())
+}
+
+// blockingArtifactBackend blocks its uploads until their context is done
+type blockingArtifactBackend struct {
+	testArtifactBackend
+
+	started chan struct{}
+}
+
+func (b *blockingArtifactBackend) Upload(ctx context.Context, _ *api.Artifact) error {
+	close(b.started)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestUploadCancelledInFlightAtShutdown(t *testing.T) {
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "llamas.txt"), []byte("llamas"), 0o666); err != nil {
+		t.Fatalf("os.WriteFile(llamas.txt) error = %v", err)
+	}
+	os.Chdir(dir)
+
+	backend := &blockingArtifactBackend{started: make(chan struct{})}
+	registerTestArtifactBackend(t, "llama", backend)
+
+	server := newTestArtifactServer(t)
+
+	uploader := NewArtifactUploader(logger.Discard, server.apiClient(), ArtifactUploaderConfig{
+		JobID:       "my-job",
+		Paths:       "llamas.txt",
+		Destination: "llama://herd",
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errs := make(chan error, 1)
+	go func() { errs <- uploader.Upload(ctx) }()
+
+	// Shut down the same way the artifact upload command does, once the
+	// upload is in flight
+	select {
+	case <-backend.started:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the upload to start")
+	}
+	uploader.Stop()
+	cancel()
+
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Fatalf("uploader.Upload() error = %v, want an error", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the in-flight upload to be cancelled")
+	}
+
+	// The state is still reported after the uploads are cancelled
+	assert.Equal(t, map[string]string{"artifact-1": "error"}, server.artifactStates())
+}
+
+// failFastArtifactBackend fails to upload fails.txt once blocks.txt is in
+// flight, and blocks the other uploads until their context is done
+type failFastArtifactBackend struct {
+	testArtifactBackend
+
+	blocking chan struct{}
+
+	mu        sync.Mutex
+	started   []string
+	cancelled []string
+}
+
+func (b *failFastArtifactBackend) Upload(ctx context.Context, artifact *api.Artifact) error {
+	b.mu.Lock()
+	b.started = append(b.started, artifact.Path)
+	b.mu.Unlock()
+
+	if artifact.Path == "fails.txt" {
+		<-b.blocking
+		return os.ErrPermission
+	}
+
+	if artifact.Path == "blocks.txt" {
+		close(b.blocking)
+	}
+	<-ctx.Done()
+
+	b.mu.Lock()
+	b.cancelled = append(b.cancelled, artifact.Path)
+	b.mu.Unlock()
+	return ctx.Err()
+}
+
+func TestUploadFailFast(t *testing.T) {
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+
+	dir := t.TempDir()
+	for _, name := range []string{"fails.txt", "blocks.txt", "later.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0o666); err != nil {
+			t.Fatalf("os.WriteFile(%s) error = %v", name, err)
+		}
+	}
+	os.Chdir(dir)
+
+	backend := &failFastArtifactBackend{blocking: make(chan struct{})}
+	registerTestArtifactBackend(t, "llama", backend)
+
+	server := newTestArtifactServer(t)
+
+	// Only two uploads run at once, so later.txt waits for fails.txt
+	uploader := NewArtifactUploader(logger.Discard, server.apiClient(), ArtifactUploaderConfig{
+		JobID:          "my-job",
+		Paths:          "fails.txt;blocks.txt;later.txt",
+		Destination:    "llama://herd",
+		MinConcurrency: 2,
+		MaxConcurrency: 2,
+		FailFast:       true,
+	})
+
+	errs := make(chan error, 1)
+	go func() { errs <- uploader.Upload(context.Background()) }()
+
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Fatalf("uploader.Upload() error = %v, want an error", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the first failure to stop the upload")
+	}
+
+	// The in-flight upload is cancelled, and the remaining one never starts
+	assert.ElementsMatch(t, []string{"fails.txt", "blocks.txt"}, backend.started)
+	assert.Equal(t, []string{"blocks.txt"}, backend.cancelled)
+	assert.Equal(t, map[string]string{
+		"artifact-1": "error",
+		"artifact-2": "error",
+		"artifact-3": "error",
+	}, server.artifactStates())
+}
+
+// compressingArtifactBackend pretends to compress each artifact to half its
+// size
+type compressingArtifactBackend struct {
+	testArtifactBackend
+}
+
+func (b *compressingArtifactBackend) Upload(_ context.Context, artifact *api.Artifact) error {
+	artifact.StoredSize = artifact.FileSize / 2
+	return nil
+}
+
+func TestUploadReportsStoredSize(t *testing.T) {
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "llamas.txt"), []byte("llamas"), 0o666); err != nil {
+		t.Fatalf("os.WriteFile(llamas.txt) error = %v", err)
+	}
+	os.Chdir(dir)
+
+	registerTestArtifactBackend(t, "llama", &compressingArtifactBackend{})
+
+	server := newTestArtifactServer(t)
+
+	uploader := NewArtifactUploader(logger.Discard, server.apiClient(), ArtifactUploaderConfig{
+		JobID:       "my-job",
+		Paths:       "llamas.txt",
+		Destination: "llama://herd",
+	})
+
+	if err := uploader.Upload(context.Background()); err != nil {
+		t.Fatalf("uploader.Upload() error = %v", err)
+	}
+
+	assert.Equal(t, map[string]string{"artifact-1": "finished"}, server.artifactStates())
+	assert.Equal(t, map[string]int64{"artifact-1": 3}, server.artifactStoredSizes())
+}
+
+func TestLogDestination(t *testing.T) {
+	t.Parallel()
+
+	artifact := &api.Artifact{
+		Path:         "reports/llamas.txt",
+		AbsolutePath: "/build/out/reports/llamas.txt",
+		URL:          "s3://herd/reports/llamas.txt",
+	}
+	formArtifact := &api.Artifact{
+		Path:         "alpacas.txt",
+		AbsolutePath: "/build/alpacas.txt",
+	}
+
+	for _, test := range []struct {
+		name             string
+		showDestinations bool
+		want             []string
+	}{
+		{
+			name: "debug",
+			want: []string{
+				"[debug] reports/llamas.txt (from /build/out/reports/llamas.txt) -> s3://herd/reports/llamas.txt",
+				"[debug] alpacas.txt (from /build/alpacas.txt) -> Buildkite artifact storage",
+			},
+		},
+		{
+			name:             "show destinations",
+			showDestinations: true,
+			want: []string{
+				"[info] reports/llamas.txt (from /build/out/reports/llamas.txt) -> s3://herd/reports/llamas.txt",
+				"[info] alpacas.txt (from /build/alpacas.txt) -> Buildkite artifact storage",
+			},
+		},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			l := logger.NewBuffer()
+			uploader := NewArtifactUploader(l, nil, ArtifactUploaderConfig{ShowDestinations: test.showDestinations})
+
+			uploader.logDestination(artifact)
+			uploader.logDestination(formArtifact)
+
+			assert.Equal(t, test.want, l.Messages)
+		})
+	}
+}
+
+func TestUploadWithCancelledConstructionContext(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// The uploader has no API client, so it would panic if it got as far as
+	// creating artifacts
+	uploader := NewArtifactUploaderWithContext(ctx, logger.Discard, nil, ArtifactUploaderConfig{
+		JobID: "my-job",
+		Paths: "llamas.txt",
+	})
+
+	if err := uploader.Upload(context.Background()); !errors.Is(err, context.Canceled) {
+		t.Errorf("uploader.Upload() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestRetryJitterSeed(t *testing.T) {
+	t.Parallel()
+
+	a := NewArtifactUploader(logger.Discard, nil, ArtifactUploaderConfig{RetryJitterSeed: 42})
+	b := NewArtifactUploader(logger.Discard, nil, ArtifactUploaderConfig{RetryJitterSeed: 42})
+
+	for i := 0; i < 5; i++ {
+		if got, want := a.random.Float32(), b.random.Float32(); got != want {
+			t.Fatalf("jitter %d with the same seed = %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestCollectStagingDir(t *testing.T) {
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+
+	dir := t.TempDir()
+	for _, name := range []string{
+		filepath.Join(".buildkite-artifacts", "report.html"),
+		filepath.Join(".buildkite-artifacts", "logs", "build.log"),
+		"other.txt",
+	} {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0o777); err != nil {
+			t.Fatalf("os.MkdirAll(%s) error = %v", filepath.Dir(name), err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0o666); err != nil {
+			t.Fatalf("os.WriteFile(%s) error = %v", name, err)
+		}
+	}
+	os.Chdir(dir)
+
+	other := filepath.Join(dir, "other.txt")
+	uploader := NewArtifactUploader(logger.Discard, nil, ArtifactUploaderConfig{
+		Paths:      strings.Join([]string{"report.html", filepath.Join("logs", "*.log"), other}, ";"),
+		StagingDir: ".buildkite-artifacts",
+	})
+
+	artifacts, err := uploader.Collect()
+	if err != nil {
+		t.Fatalf("uploader.Collect() error = %v", err)
+	}
+
+	paths := make(map[string]string)
+	for _, artifact := range artifacts {
+		paths[artifact.AbsolutePath] = filepath.ToSlash(artifact.Path)
+	}
+
+	// Relative paths are resolved from the staging directory, and uploaded
+	// without it
+	assert.Equal(t, "report.html", paths[filepath.Join(dir, ".buildkite-artifacts", "report.html")])
+	assert.Equal(t, "logs/build.log", paths[filepath.Join(dir, ".buildkite-artifacts", "logs", "build.log")])
+
+	// Absolute paths are uploaded at their whole path, as usual
+	assert.True(t, strings.HasSuffix(filepath.ToSlash(other), paths[other]), "paths[other] = %q, want a suffix of %q", paths[other], other)
+	assert.NotEqual(t, "other.txt", paths[other])
+}
+
+func TestCollectRoots(t *testing.T) {
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+
+	dir := t.TempDir()
+	for _, name := range []string{
+		filepath.Join("componentA", "dist", "x.js"),
+		filepath.Join("componentB", "dist", "y.js"),
+		filepath.Join("componentB", "dist", "x.js"),
+	} {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0o777); err != nil {
+			t.Fatalf("os.MkdirAll(%s) error = %v", filepath.Dir(name), err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0o666); err != nil {
+			t.Fatalf("os.WriteFile(%s) error = %v", name, err)
+		}
+	}
+	os.Chdir(dir)
+
+	uploader := NewArtifactUploader(logger.Discard, nil, ArtifactUploaderConfig{
+		Roots: []ArtifactRoot{
+			{Dir: "componentA", Paths: "dist/*.js"},
+			{Dir: "componentB", Paths: "dist/y.js"},
+		},
+	})
+
+	artifacts, err := uploader.Collect()
+	if err != nil {
+		t.Fatalf("uploader.Collect() error = %v", err)
+	}
+
+	paths := make(map[string]string)
+	for _, artifact := range artifacts {
+		paths[filepath.ToSlash(artifact.Path)] = artifact.AbsolutePath
+	}
+	assert.Equal(t, map[string]string{
+		"dist/x.js": filepath.Join(dir, "componentA", "dist", "x.js"),
+		"dist/y.js": filepath.Join(dir, "componentB", "dist", "y.js"),
+	}, paths)
+
+	// Files in the same place beneath different roots collide
+	uploader = NewArtifactUploader(logger.Discard, nil, ArtifactUploaderConfig{
+		Roots: []ArtifactRoot{
+			{Dir: "componentA", Paths: "dist/*.js"},
+			{Dir: "componentB", Paths: "dist/*.js"},
+		},
+	})
+	if _, err := uploader.Collect(); err == nil {
+		t.Fatalf("uploader.Collect() error = nil, want a collision error")
+	}
+}
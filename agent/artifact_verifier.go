@@ -0,0 +1,181 @@
+package agent
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/buildkite/agent/v3/pool"
+	"github.com/buildkite/roko"
+)
+
+type ArtifactVerifierConfig struct {
+	// The ID of the Build
+	BuildID string
+
+	// The query used to find the artifacts
+	Query string
+
+	// Which step should we look at for the jobs
+	Step string
+
+	// Whether to include artifacts from retried jobs in the search
+	IncludeRetriedJobs bool
+
+	// Whether to show HTTP debugging
+	DebugHTTP bool
+}
+
+// ArtifactVerifySummary counts the outcome of verifying artifacts
+type ArtifactVerifySummary struct {
+	// Artifacts whose checksums matched
+	Verified int
+
+	// Artifacts whose checksums didn't match
+	Mismatched int
+
+	// Artifacts that couldn't be checked, e.g. because they couldn't be
+	// downloaded
+	Failed int
+}
+
+// ArtifactVerifier checks that artifacts in storage still match the checksums
+// recorded when they were uploaded. Artifacts are streamed through the hashes
+// rather than written to disk.
+type ArtifactVerifier struct {
+	// The config for verifying
+	conf ArtifactVerifierConfig
+
+	// The logger instance to use
+	logger logger.Logger
+
+	// The APIClient that will be used when searching for artifacts
+	apiClient APIClient
+
+	// Used to stream artifacts from wherever they're stored
+	downloader ArtifactDownloader
+}
+
+func NewArtifactVerifier(l logger.Logger, ac APIClient, c ArtifactVerifierConfig) *ArtifactVerifier {
+	return &ArtifactVerifier{
+		conf:      c,
+		logger:    l,
+		apiClient: ac,
+		downloader: NewArtifactDownloader(l, ac, ArtifactDownloaderConfig{
+			DebugHTTP: c.DebugHTTP,
+		}),
+	}
+}
+
+// Verify streams each matching artifact from storage and compares its
+// checksum to the one recorded when it was uploaded. An error is returned if
+// any artifact doesn't match or couldn't be checked.
+func (v *ArtifactVerifier) Verify(ctx context.Context) (ArtifactVerifySummary, error) {
+	var summary ArtifactVerifySummary
+
+	artifacts, err := NewArtifactSearcher(v.logger, v.apiClient, v.conf.BuildID).
+		Search(ctx, v.conf.Query, v.conf.Step, v.conf.IncludeRetriedJobs, false)
+	if err != nil {
+		return summary, err
+	}
+
+	if len(artifacts) == 0 {
+		return summary, errors.New("No artifacts found for verifying")
+	}
+
+	v.logger.Info("Found %d artifacts. Verifying checksums...", len(artifacts))
+
+	s3Clients, err := v.downloader.generateS3Clients(artifacts)
+	if err != nil {
+		return summary, fmt.Errorf("failed to generate S3 clients for artifact verification: %w", err)
+	}
+
+	p := pool.New(pool.MaxConcurrencyLimit)
+	var summaryMutex sync.Mutex
+
+	for _, artifact := range artifacts {
+		// Create new instance of the artifact for the goroutine
+		// See: http://golang.org/doc/effective_go.html#channels
+		artifact := artifact
+
+		p.Spawn(func() {
+			sha1sum, sha256sum, err := v.downloader.checksums(ctx, artifact, s3Clients)
+
+			summaryMutex.Lock()
+			defer summaryMutex.Unlock()
+
+			switch {
+			case err != nil:
+				v.logger.Error("Failed to verify artifact \"%s\": %s", artifact.Path, err)
+				summary.Failed++
+
+			case artifact.Sha256Sum != "" && artifact.Sha256Sum != sha256sum:
+				v.logger.Error("Artifact \"%s\" doesn't match: sha256 is %s, expected %s", artifact.Path, sha256sum, artifact.Sha256Sum)
+				summary.Mismatched++
+
+			// Artifacts uploaded by older agents only have a SHA-1
+			case artifact.Sha256Sum == "" && artifact.Sha1Sum != "" && artifact.Sha1Sum != sha1sum:
+				v.logger.Error("Artifact \"%s\" doesn't match: sha1 is %s, expected %s", artifact.Path, sha1sum, artifact.Sha1Sum)
+				summary.Mismatched++
+
+			case artifact.Sha256Sum == "" && artifact.Sha1Sum == "":
+				v.logger.Error("Artifact \"%s\" has no recorded checksum to verify", artifact.Path)
+				summary.Failed++
+
+			default:
+				v.logger.Debug("Artifact \"%s\" matches its checksum", artifact.Path)
+				summary.Verified++
+			}
+		})
+	}
+
+	p.Wait()
+
+	v.logger.Info("Verified %d artifacts: %d matched, %d mismatched, %d failed",
+		len(artifacts), summary.Verified, summary.Mismatched, summary.Failed)
+
+	if summary.Mismatched > 0 || summary.Failed > 0 {
+		return summary, fmt.Errorf("%d artifacts didn't match their checksums and %d couldn't be verified", summary.Mismatched, summary.Failed)
+	}
+
+	return summary, nil
+}
+
+// checksums streams artifact from storage through SHA-1 and SHA-256 hashes,
+// without writing it anywhere, and returns them hex encoded
+func (a *ArtifactDownloader) checksums(ctx context.Context, artifact *api.Artifact, s3Clients map[string]*s3.S3) (sha1sum, sha256sum string, err error) {
+	// Each attempt hashes the artifact from the start, so that a partial
+	// download can't affect the result
+	err = roko.NewRetrier(
+		roko.WithMaxAttempts(5),
+		roko.WithStrategy(roko.Constant(5*time.Second)),
+	).DoWithContext(ctx, func(r *roko.Retrier) error {
+		hash1, hash256 := sha1.New(), sha256.New()
+
+		dler, err := a.downloader(artifact, downloadPath(artifact), downloadTarget{
+			Writer:  io.MultiWriter(hash1, hash256),
+			Retries: 1,
+		}, s3Clients)
+		if err != nil {
+			r.Break()
+			return err
+		}
+		if err := dler.Start(ctx); err != nil {
+			a.logger.Warn("%s (%s)", err, r)
+			return err
+		}
+
+		sha1sum = fmt.Sprintf("%040x", hash1.Sum(nil))
+		sha256sum = fmt.Sprintf("%064x", hash256.Sum(nil))
+		return nil
+	})
+	return sha1sum, sha256sum, err
+}
@@ -0,0 +1,54 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArtifactVerifierFindsMismatches(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.RequestURI() {
+		case "/builds/my-build/artifacts/search?state=finished":
+			fmt.Fprintf(rw, `[{
+				"id": "4600ac5c-5a13-4e92-bb83-f86f218f7b32",
+				"path": "llamas.txt",
+				"sha256sum": "b36293fc54a3dc9e1582b8fa065aacd3b71e0622777b3a25be508671db5d47cd",
+				"url": "http://%s/llamas"
+			}, {
+				"id": "1b2c9e5a-07d4-4b3f-9a39-0e3e1f7c1a2b",
+				"path": "alpacas.txt",
+				"sha256sum": "b36293fc54a3dc9e1582b8fa065aacd3b71e0622777b3a25be508671db5d47cd",
+				"url": "http://%s/alpacas"
+			}]`, req.Host, req.Host)
+		case "/llamas":
+			fmt.Fprintln(rw, "llamas")
+		case "/alpacas":
+			fmt.Fprintln(rw, "alpacas")
+		default:
+			http.Error(rw, "Not found", http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	ac := api.NewClient(logger.Discard, api.Config{
+		Endpoint: server.URL,
+		Token:    "llamasforever",
+	})
+
+	v := NewArtifactVerifier(logger.Discard, ac, ArtifactVerifierConfig{
+		BuildID: "my-build",
+	})
+
+	summary, err := v.Verify(context.Background())
+	if err == nil {
+		t.Errorf("v.Verify() error = nil, want an error for the mismatched artifact")
+	}
+	assert.Equal(t, ArtifactVerifySummary{Verified: 1, Mismatched: 1}, summary)
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"path"
@@ -25,6 +26,17 @@ type ArtifactoryDownloaderConfig struct {
 	// also its location in the repo
 	Path string
 
+	// The location of the file in the repo, relative to the destination's
+	// path, if it isn't Path, e.g. when it was uploaded content addressed
+	StoragePath string
+
+	// If set, the file is written here instead of beneath Destination
+	Writer io.Writer
+
+	// The expected SHA-256 checksum of the file, if known. Downloads to
+	// Destination are checked against it, and discarded if they don't match.
+	Sha256Sum string
+
 	// How many times should it retry the download before giving up
 	Retries int
 
@@ -73,6 +85,8 @@ func (d ArtifactoryDownloader) Start(ctx context.Context) error {
 		URL:         fullURL,
 		Path:        d.conf.Path,
 		Destination: d.conf.Destination,
+		Writer:      d.conf.Writer,
+		Sha256Sum:   d.conf.Sha256Sum,
 		Retries:     d.conf.Retries,
 		Headers:     headers,
 		DebugHTTP:   d.conf.DebugHTTP,
@@ -81,10 +95,19 @@ func (d ArtifactoryDownloader) Start(ctx context.Context) error {
 
 func (d ArtifactoryDownloader) RepositoryFileLocation() string {
 	if d.RepositoryPath() != "" {
-		return path.Join(strings.TrimSuffix(d.RepositoryPath(), "/"), "/", strings.TrimPrefix(filepath.ToSlash(d.conf.Path), "/"))
+		return path.Join(strings.TrimSuffix(d.RepositoryPath(), "/"), "/", strings.TrimPrefix(filepath.ToSlash(d.storagePath()), "/"))
 	} else {
-		return d.conf.Path
+		return d.storagePath()
+	}
+}
+
+// storagePath returns the location of the file in the repo, relative to the
+// destination's path
+func (d ArtifactoryDownloader) storagePath() string {
+	if d.conf.StoragePath != "" {
+		return d.conf.StoragePath
 	}
+	return d.conf.Path
 }
 
 func (d ArtifactoryDownloader) RepositoryPath() string {
@@ -1,6 +1,7 @@
 package agent
 
 import (
+	"context"
 	"crypto/md5"
 	"crypto/sha1"
 	"crypto/sha256"
@@ -18,6 +19,7 @@ import (
 
 	"github.com/buildkite/agent/v3/api"
 	"github.com/buildkite/agent/v3/logger"
+	"golang.org/x/time/rate"
 )
 
 type ArtifactoryUploaderConfig struct {
@@ -27,6 +29,17 @@ type ArtifactoryUploaderConfig struct {
 
 	// Whether or not HTTP calls should be debugged
 	DebugHTTP bool
+
+	// Whether or not HTTP2 should be disabled for uploads
+	DisableHTTP2 bool
+
+	// The size of the buffer files are read into while they're being sent.
+	// Zero means the net/http default.
+	ReadBufferSize int
+
+	// If set, files are read no faster than this allows, shared with
+	// everything else using the same limiter
+	Bandwidth *rate.Limiter
 }
 
 type ArtifactoryUploader struct {
@@ -72,7 +85,7 @@ func NewArtifactoryUploader(l logger.Logger, c ArtifactoryUploaderConfig) (*Arti
 	return &ArtifactoryUploader{
 		logger:     l,
 		conf:       c,
-		client:     &http.Client{},
+		client:     newUploadHTTPClient(c.DisableHTTP2, c.ReadBufferSize, c.Bandwidth),
 		iURL:       parsedURL,
 		Path:       path,
 		Repository: repo,
@@ -98,18 +111,25 @@ func (u *ArtifactoryUploader) URL(artifact *api.Artifact) string {
 	return url.String()
 }
 
-func (u *ArtifactoryUploader) Upload(artifact *api.Artifact) error {
+func (u *ArtifactoryUploader) Upload(ctx context.Context, artifact *api.Artifact) error {
 	// Open file from filesystem
 	u.logger.Debug("Reading file \"%s\"", artifact.AbsolutePath)
-	f, err := os.Open(artifact.AbsolutePath)
+	f, err := openArtifact(ctx, artifact)
 	if err != nil {
-		return fmt.Errorf("failed to open file %q (%v)", artifact.AbsolutePath, err)
+		return fmt.Errorf("failed to open file %q (%w)", artifact.AbsolutePath, err)
 	}
 
 	// Upload the file to Artifactory.
 	u.logger.Debug("Uploading \"%s\" to `%s`", artifact.Path, u.URL(artifact))
 
-	req, err := http.NewRequest("PUT", u.URL(artifact), f)
+	// Content addressed files don't say what they are from their path, so
+	// it's recorded as a property, which are set with matrix parameters
+	uploadURL := u.URL(artifact)
+	if artifact.StoragePath != "" {
+		uploadURL += ";" + ArtifactPathMetadataKey + "=" + url.QueryEscape(artifact.Path)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "PUT", uploadURL, f)
 	req.SetBasicAuth(u.user, u.password)
 	if err != nil {
 		return err
@@ -141,9 +161,44 @@ func (u *ArtifactoryUploader) Upload(artifact *api.Artifact) error {
 		return err
 	}
 
+	// The file is uploaded as it is
+	artifact.StoredSize = artifact.FileSize
 	return nil
 }
 
+func (u *ArtifactoryUploader) Download(ctx context.Context, artifact *api.Artifact, destination string) error {
+	return NewArtifactoryDownloader(u.logger, ArtifactoryDownloaderConfig{
+		Repository:  u.conf.Destination,
+		Path:        artifact.Path,
+		StoragePath: artifact.StoragePath,
+		Destination: destination,
+		Retries:     5,
+		DebugHTTP:   u.conf.DebugHTTP,
+	}).Start(ctx)
+}
+
+func (u *ArtifactoryUploader) Exists(ctx context.Context, artifact *api.Artifact) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", u.URL(artifact), nil)
+	if err != nil {
+		return false, err
+	}
+	req.SetBasicAuth(u.user, u.password)
+
+	res, err := u.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if err := checkResponse(res); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 func checksumFile(hasher hash.Hash, path string) (string, error) {
 	f, err := os.Open(path)
 	if err != nil {
@@ -173,7 +228,7 @@ func sha1File(path string) ([]byte, error) {
 }
 
 func (u *ArtifactoryUploader) artifactPath(artifact *api.Artifact) string {
-	parts := []string{u.Repository, u.Path, artifact.Path}
+	parts := []string{u.Repository, u.Path, storagePath(artifact)}
 
 	return strings.Join(parts, "/")
 }
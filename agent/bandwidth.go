@@ -0,0 +1,84 @@
+package agent
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"golang.org/x/time/rate"
+)
+
+// newBandwidthLimiter returns a limiter for bytesPerSecond, or nil if it's
+// zero. Bytes can be sent in bursts of up to a tenth of a second's worth (or
+// 32KB, if that's more), so the cap is only accurate over short windows rather
+// than instant by instant.
+func newBandwidthLimiter(bytesPerSecond int64) *rate.Limiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+
+	burst := bytesPerSecond / 10
+	if burst < 32*1024 {
+		burst = 32 * 1024
+	}
+	return rate.NewLimiter(rate.Limit(bytesPerSecond), int(burst))
+}
+
+// throttledReader is a reader that waits for the limiter before returning
+// what it read
+type throttledReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rate.Limiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	// The limiter can't wait for more than its burst at once
+	if burst := t.limiter.Burst(); len(p) > burst {
+		p = p[:burst]
+	}
+
+	n, err := t.r.Read(p)
+	if n > 0 {
+		if waitErr := t.limiter.WaitN(t.ctx, n); waitErr != nil && err == nil {
+			err = waitErr
+		}
+	}
+	return n, err
+}
+
+// throttledTransport throttles the request bodies sent through it, which is
+// where artifacts are read from disk
+type throttledTransport struct {
+	next    http.RoundTripper
+	limiter *rate.Limiter
+}
+
+func (t *throttledTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return t.next.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	req.Body = t.throttle(req.Context(), req.Body)
+	if getBody := req.GetBody; getBody != nil {
+		req.GetBody = func() (io.ReadCloser, error) {
+			body, err := getBody()
+			if err != nil {
+				return nil, err
+			}
+			return t.throttle(req.Context(), body), nil
+		}
+	}
+	return t.next.RoundTrip(req)
+}
+
+func (t *throttledTransport) throttle(ctx context.Context, body io.ReadCloser) io.ReadCloser {
+	return struct {
+		io.Reader
+		io.Closer
+	}{
+		Reader: &throttledReader{ctx: ctx, r: body, limiter: t.limiter},
+		Closer: body,
+	}
+}
@@ -0,0 +1,38 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestNewBandwidthLimiterUnlimited(t *testing.T) {
+	if l := newBandwidthLimiter(0); l != nil {
+		t.Errorf("newBandwidthLimiter(0) = %v, want nil", l)
+	}
+}
+
+func TestThrottledReader(t *testing.T) {
+	const rate = 1024 * 1024
+	limiter := newBandwidthLimiter(rate)
+
+	// The first burst is free, the rest should take about 200ms
+	data := make([]byte, limiter.Burst()+rate/5)
+	r := &throttledReader{ctx: context.Background(), r: bytes.NewReader(data), limiter: limiter}
+
+	start := time.Now()
+	n, err := io.Copy(io.Discard, r)
+	if err != nil {
+		t.Fatalf("io.Copy() error = %v", err)
+	}
+	elapsed := time.Since(start)
+
+	if n != int64(len(data)) {
+		t.Errorf("io.Copy() = %d, want %d", n, len(data))
+	}
+	if elapsed < 150*time.Millisecond {
+		t.Errorf("reading %d bytes at %d bytes/s took %s, want at least 150ms", len(data), rate, elapsed)
+	}
+}
@@ -0,0 +1,131 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// checksumCacheMinAge is how long ago a file must have been modified for its
+// checksums to be cached. Writes to a file in the same instant it was hashed
+// might not change its modification time, so recently modified files are
+// always hashed again.
+const checksumCacheMinAge = 2 * time.Second
+
+// checksumCacheEntry is the checksums of a file, along with the size and
+// modification time it had when they were computed
+type checksumCacheEntry struct {
+	Size      int64     `json:"size"`
+	ModTime   time.Time `json:"mtime"`
+	Sha1Sum   string    `json:"sha1sum"`
+	Sha256Sum string    `json:"sha256sum"`
+}
+
+// checksumCache remembers the checksums of files between uploads, keyed by
+// their absolute path. Cached checksums are only used if the file's size and
+// modification time haven't changed at all.
+type checksumCache struct {
+	path string
+
+	mu      sync.Mutex
+	entries map[string]checksumCacheEntry
+	dirty   bool
+}
+
+// loadChecksumCache reads the cache stored at path. A cache that doesn't exist
+// yet is empty.
+func loadChecksumCache(path string) (*checksumCache, error) {
+	c := &checksumCache{
+		path:    path,
+		entries: make(map[string]checksumCacheEntry),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &c.entries); err != nil {
+		return c, fmt.Errorf("parsing checksum cache %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// lookup returns the cached checksums of the file at path, if it hasn't changed
+// since they were cached
+func (c *checksumCache) lookup(path string, info os.FileInfo) (sha1sum, sha256sum string, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[path]
+	if !ok || entry.Size != info.Size() || !entry.ModTime.Equal(info.ModTime()) {
+		return "", "", false
+	}
+	return entry.Sha1Sum, entry.Sha256Sum, true
+}
+
+// store caches the checksums of the file at path
+func (c *checksumCache) store(path string, info os.FileInfo, sha1sum, sha256sum string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(info.ModTime()) < checksumCacheMinAge {
+		delete(c.entries, path)
+		c.dirty = true
+		return
+	}
+
+	c.entries[path] = checksumCacheEntry{
+		Size:      info.Size(),
+		ModTime:   info.ModTime(),
+		Sha1Sum:   sha1sum,
+		Sha256Sum: sha256sum,
+	}
+	c.dirty = true
+}
+
+// save writes the cache back to where it was loaded from, if it has changed
+func (c *checksumCache) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.dirty {
+		return nil
+	}
+
+	data, err := json.Marshal(c.entries)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, so that concurrent uploads never see
+	// a partially written cache
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o777); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), c.path); err != nil {
+		return err
+	}
+
+	c.dirty = false
+	return nil
+}
@@ -0,0 +1,92 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChecksumCache(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "llamas.txt")
+	if err := os.WriteFile(file, []byte("llamas"), 0o666); err != nil {
+		t.Fatalf("os.WriteFile(%q) error = %v", file, err)
+	}
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(file, mtime, mtime); err != nil {
+		t.Fatalf("os.Chtimes(%q) error = %v", file, err)
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatalf("os.Stat(%q) error = %v", file, err)
+	}
+
+	cachePath := filepath.Join(dir, "cache", "checksums.json")
+	cache, err := loadChecksumCache(cachePath)
+	if err != nil {
+		t.Fatalf("loadChecksumCache() error = %v", err)
+	}
+	cache.store(file, info, "sha1", "sha256")
+	if err := cache.save(); err != nil {
+		t.Fatalf("cache.save() error = %v", err)
+	}
+
+	// The checksums survive being saved and loaded again
+	cache, err = loadChecksumCache(cachePath)
+	if err != nil {
+		t.Fatalf("loadChecksumCache() error = %v", err)
+	}
+	sha1sum, sha256sum, ok := cache.lookup(file, info)
+	assert.True(t, ok)
+	assert.Equal(t, "sha1", sha1sum)
+	assert.Equal(t, "sha256", sha256sum)
+
+	// Any change to the size or modification time means hashing again
+	if err := os.WriteFile(file, []byte("alpacas"), 0o666); err != nil {
+		t.Fatalf("os.WriteFile(%q) error = %v", file, err)
+	}
+	if err := os.Chtimes(file, mtime, mtime); err != nil {
+		t.Fatalf("os.Chtimes(%q) error = %v", file, err)
+	}
+	changed, err := os.Stat(file)
+	if err != nil {
+		t.Fatalf("os.Stat(%q) error = %v", file, err)
+	}
+	_, _, ok = cache.lookup(file, changed)
+	assert.False(t, ok)
+
+	touched := time.Now().Add(-time.Minute)
+	if err := os.Chtimes(file, touched, touched); err != nil {
+		t.Fatalf("os.Chtimes(%q) error = %v", file, err)
+	}
+	changed, err = os.Stat(file)
+	if err != nil {
+		t.Fatalf("os.Stat(%q) error = %v", file, err)
+	}
+	_, _, ok = cache.lookup(file, changed)
+	assert.False(t, ok)
+}
+
+func TestChecksumCacheSkipsRecentlyModifiedFiles(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "llamas.txt")
+	if err := os.WriteFile(file, []byte("llamas"), 0o666); err != nil {
+		t.Fatalf("os.WriteFile(%q) error = %v", file, err)
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatalf("os.Stat(%q) error = %v", file, err)
+	}
+
+	cache, err := loadChecksumCache(filepath.Join(dir, "checksums.json"))
+	if err != nil {
+		t.Fatalf("loadChecksumCache() error = %v", err)
+	}
+	cache.store(file, info, "sha1", "sha256")
+
+	_, _, ok := cache.lookup(file, info)
+	assert.False(t, ok)
+}
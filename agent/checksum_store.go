@@ -0,0 +1,28 @@
+package agent
+
+import "context"
+
+// ChecksumStore is an index of artifacts that have already been uploaded,
+// keyed by their SHA-256 checksums. It can be shared between many uploads,
+// e.g. across a whole fleet of agents, so that files already in storage
+// anywhere aren't uploaded again.
+type ChecksumStore interface {
+	// Has returns the URL of a stored file with the checksum, if there is
+	// one
+	Has(ctx context.Context, sha256 string) (url string, ok bool, err error)
+
+	// Put records that a file with the checksum has been stored at the URL
+	Put(ctx context.Context, sha256, url string) error
+}
+
+// noopChecksumStore is the ChecksumStore used when none is configured, which
+// never has anything
+type noopChecksumStore struct{}
+
+func (noopChecksumStore) Has(context.Context, string) (string, bool, error) {
+	return "", false, nil
+}
+
+func (noopChecksumStore) Put(context.Context, string, string) error {
+	return nil
+}
@@ -0,0 +1,95 @@
+package agent
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeChecksumStore struct {
+	mu   sync.Mutex
+	urls map[string]string
+}
+
+func (s *fakeChecksumStore) Has(_ context.Context, sha256 string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	url, ok := s.urls[sha256]
+	return url, ok, nil
+}
+
+func (s *fakeChecksumStore) Put(_ context.Context, sha256, url string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.urls[sha256] = url
+	return nil
+}
+
+type countingArtifactBackend struct {
+	testArtifactBackend
+
+	mu       sync.Mutex
+	uploaded []string
+}
+
+func (b *countingArtifactBackend) Upload(_ context.Context, artifact *api.Artifact) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.uploaded = append(b.uploaded, artifact.Path)
+	return nil
+}
+
+func TestUploadSkipsArtifactsInChecksumStore(t *testing.T) {
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+
+	dir := t.TempDir()
+	for _, name := range []string{"llamas.txt", "alpacas.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0o666); err != nil {
+			t.Fatalf("os.WriteFile(%s) error = %v", name, err)
+		}
+	}
+	os.Chdir(dir)
+
+	backend := &countingArtifactBackend{}
+	registerTestArtifactBackend(t, "llama", backend)
+
+	server := newTestArtifactServer(t)
+
+	llamasSum := fmt.Sprintf("%x", sha256.Sum256([]byte("llamas.txt")))
+	alpacasSum := fmt.Sprintf("%x", sha256.Sum256([]byte("alpacas.txt")))
+	store := &fakeChecksumStore{urls: map[string]string{
+		llamasSum: "llama://elsewhere/llamas.txt",
+	}}
+
+	uploader := NewArtifactUploader(logger.Discard, server.apiClient(), ArtifactUploaderConfig{
+		JobID:         "my-job",
+		Paths:         "*.txt",
+		Destination:   "llama://herd",
+		ChecksumStore: store,
+	})
+
+	if err := uploader.Upload(context.Background()); err != nil {
+		t.Fatalf("uploader.Upload() error = %v", err)
+	}
+
+	// Only the file the store didn't have was uploaded, and the other one
+	// points at the existing copy
+	assert.Equal(t, []string{"alpacas.txt"}, backend.uploaded)
+	for _, artifact := range server.artifactBatches()[0].Artifacts {
+		if artifact.Path == "llamas.txt" {
+			assert.Equal(t, "llama://elsewhere/llamas.txt", artifact.URL)
+		}
+	}
+
+	// The new upload was added to the store
+	assert.Equal(t, "llama://herd/alpacas.txt", store.urls[alpacasSum])
+}
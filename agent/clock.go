@@ -0,0 +1,21 @@
+package agent
+
+import "time"
+
+// Clock is the source of time for retries and backoff, so that tests can
+// control it instead of waiting on the real clock
+type Clock interface {
+	Now() time.Time
+	Sleep(time.Duration)
+}
+
+// realClock is the Clock everything uses outside of tests
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
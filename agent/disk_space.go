@@ -0,0 +1,16 @@
+//go:build !windows
+// +build !windows
+
+package agent
+
+import "golang.org/x/sys/unix"
+
+// freeDiskSpace returns how many bytes are available to this process on the
+// filesystem containing dir
+func freeDiskSpace(dir string) (uint64, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,20 @@
+//go:build windows
+// +build windows
+
+package agent
+
+import "golang.org/x/sys/windows"
+
+// freeDiskSpace returns how many bytes are available to this process on the
+// volume containing dir
+func freeDiskSpace(dir string) (uint64, error) {
+	path, err := windows.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, err
+	}
+	var available, total, free uint64
+	if err := windows.GetDiskFreeSpaceEx(path, &available, &total, &free); err != nil {
+		return 0, err
+	}
+	return available, nil
+}
@@ -28,6 +28,13 @@ type DownloadConfig struct {
 	// The relative path that should be preserved in the download folder
 	Path string
 
+	// If set, the file is written here instead of beneath Destination
+	Writer io.Writer
+
+	// The expected SHA-256 checksum of the file, if known. Downloads to
+	// Destination are checked against it, and discarded if they don't match.
+	Sha256Sum string
+
 	// How many times should it retry the download before giving up
 	Retries int
 
@@ -102,7 +109,11 @@ func (d Download) try(ctx context.Context) error {
 	targetDirectory, _ := filepath.Split(targetFile)
 
 	// Show a nice message that we're starting to download the file
-	d.logger.Debug("Downloading %s to %s", d.conf.URL, targetFile)
+	if d.conf.Writer != nil {
+		d.logger.Debug("Streaming %s", d.conf.URL)
+	} else {
+		d.logger.Debug("Downloading %s to %s", d.conf.URL, targetFile)
+	}
 
 	request, err := http.NewRequestWithContext(ctx, "GET", d.conf.URL, nil)
 	if err != nil {
@@ -112,6 +123,18 @@ func (d Download) try(ctx context.Context) error {
 		request.Header.Add(k, v)
 	}
 
+	// Files are downloaded next to where they're going, and only moved into
+	// place once they're complete. If an earlier attempt was interrupted,
+	// ask for just the rest of the file.
+	partialFile := targetFile + partialDownloadSuffix
+	var offset int64
+	if d.conf.Writer == nil {
+		if info, err := os.Stat(partialFile); err == nil && info.Size() > 0 {
+			offset = info.Size()
+			request.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		}
+	}
+
 	// Start by downloading the file
 	response, err := d.client.Do(request)
 	if err != nil {
@@ -119,6 +142,13 @@ func (d Download) try(ctx context.Context) error {
 	}
 	defer response.Body.Close()
 
+	// If what we have can't be resumed, e.g. because the file has changed
+	// since, start again from scratch next time
+	if offset > 0 && response.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		os.Remove(partialFile)
+		return &downloadError{response.Status}
+	}
+
 	// Double check the status
 	if response.StatusCode/100 != 2 && response.StatusCode/100 != 3 {
 		if d.conf.DebugHTTP {
@@ -133,16 +163,36 @@ func (d Download) try(ctx context.Context) error {
 		return &downloadError{response.Status}
 	}
 
+	// Stream the data to the writer rather than a file, if we've been given one
+	if d.conf.Writer != nil {
+		bytes, err := io.Copy(d.conf.Writer, response.Body)
+		if err != nil {
+			return fmt.Errorf("Error when copying data %s (%T: %v)", d.conf.URL, err, err)
+		}
+
+		d.logger.Debug("Successfully streamed \"%s\" %d bytes", d.conf.Path, bytes)
+		return nil
+	}
+
 	// Now make the folder for our file
 	// Actual file permissions will be reduced by umask, and won't be 0777 unless the user has manually changed the umask to 000
 	if err := os.MkdirAll(targetDirectory, 0777); err != nil {
 		return fmt.Errorf("Failed to create folder for %s (%T: %v)", targetFile, err, err)
 	}
 
-	// Create a file to handle the file
-	fileBuffer, err := os.Create(targetFile)
+	// Append to the partial file if the server sent the rest of it, otherwise
+	// (e.g. if it doesn't support ranges) start again from the beginning
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if offset > 0 && resumesAt(response, offset) {
+		d.logger.Info("Resuming download of \"%s\" from %d bytes", d.conf.Path, offset)
+		flags = os.O_WRONLY | os.O_APPEND
+	} else {
+		offset = 0
+	}
+
+	fileBuffer, err := os.OpenFile(partialFile, flags, 0666)
 	if err != nil {
-		return fmt.Errorf("Failed to create file %s (%T: %v)", targetFile, err, err)
+		return fmt.Errorf("Failed to create file %s (%T: %v)", partialFile, err, err)
 	}
 	defer fileBuffer.Close()
 
@@ -152,7 +202,28 @@ func (d Download) try(ctx context.Context) error {
 		return fmt.Errorf("Error when copying data %s (%T: %v)", d.conf.URL, err, err)
 	}
 
-	d.logger.Info("Successfully downloaded \"%s\" %d bytes", d.conf.Path, bytes)
+	if err := fileBuffer.Close(); err != nil {
+		return fmt.Errorf("Failed to write file %s (%T: %v)", partialFile, err, err)
+	}
+
+	// Resumed downloads in particular could have been stitched together
+	// wrongly, so check the whole file is what was uploaded
+	if d.conf.Sha256Sum != "" {
+		sum, err := fileSha256Sum(partialFile)
+		if err != nil {
+			return fmt.Errorf("Failed to checksum file %s (%T: %v)", partialFile, err, err)
+		}
+		if sum != d.conf.Sha256Sum {
+			os.Remove(partialFile)
+			return fmt.Errorf("Downloaded file %s has sha256 %s, expected %s", d.conf.Path, sum, d.conf.Sha256Sum)
+		}
+	}
+
+	if err := os.Rename(partialFile, targetFile); err != nil {
+		return fmt.Errorf("Failed to move %s to %s (%T: %v)", partialFile, targetFile, err, err)
+	}
+
+	d.logger.Info("Successfully downloaded \"%s\" %d bytes", d.conf.Path, offset+bytes)
 
 	return nil
 }
@@ -164,3 +235,27 @@ type downloadError struct {
 func (e *downloadError) Error() string {
 	return e.s
 }
+
+// partialDownloadSuffix is added to the names of files while they're being
+// downloaded
+const partialDownloadSuffix = ".partial"
+
+// resumesAt reports whether response is the content of a file from offset
+// onwards, in response to a Range request
+func resumesAt(response *http.Response, offset int64) bool {
+	if response.StatusCode != http.StatusPartialContent {
+		return false
+	}
+	return strings.HasPrefix(response.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset))
+}
+
+func fileSha256Sum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	_, sha256sum, err := hashFile(f)
+	return sha256sum, err
+}
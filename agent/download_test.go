@@ -4,8 +4,20 @@
 package agent
 
 import (
-	"github.com/stretchr/testify/assert"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestGetTargetPath(t *testing.T) {
@@ -51,3 +63,72 @@ func TestGetTargetPath(t *testing.T) {
 	assert.Equal(t, "foo/app/logs/a.log", getTargetPath("app/logs/a.log", "foo/app"))
 	assert.Equal(t, "app/logs/a.log", getTargetPath("app/logs/a.log", "."))
 }
+
+func TestDownloadResumesPartialFile(t *testing.T) {
+	content := []byte(strings.Repeat("llamas ", 1000))
+	sum := sha256.Sum256(content)
+
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		ranges = append(ranges, req.Header.Get("Range"))
+		http.ServeContent(rw, req, "llamas.txt", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	// Pretend an earlier attempt got half way through
+	dir := t.TempDir()
+	partial := filepath.Join(dir, "llamas.txt"+partialDownloadSuffix)
+	if err := os.WriteFile(partial, content[:3000], 0o666); err != nil {
+		t.Fatalf("os.WriteFile(%q) error = %v", partial, err)
+	}
+
+	err := NewDownload(logger.Discard, http.DefaultClient, DownloadConfig{
+		URL:         server.URL,
+		Path:        "llamas.txt",
+		Destination: dir,
+		Retries:     1,
+		Sha256Sum:   hex.EncodeToString(sum[:]),
+	}).Start(context.Background())
+	if err != nil {
+		t.Fatalf("Download.Start() error = %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, "llamas.txt"))
+	if err != nil {
+		t.Fatalf("os.ReadFile() error = %v", err)
+	}
+	assert.Equal(t, content, got)
+	assert.Equal(t, []string{"bytes=3000-"}, ranges)
+	assert.NoFileExists(t, partial)
+}
+
+func TestDownloadRestartsWithoutRangeSupport(t *testing.T) {
+	content := []byte(strings.Repeat("alpacas ", 1000))
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write(content)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	partial := filepath.Join(dir, "alpacas.txt"+partialDownloadSuffix)
+	if err := os.WriteFile(partial, []byte("something else entirely"), 0o666); err != nil {
+		t.Fatalf("os.WriteFile(%q) error = %v", partial, err)
+	}
+
+	err := NewDownload(logger.Discard, http.DefaultClient, DownloadConfig{
+		URL:         server.URL,
+		Path:        "alpacas.txt",
+		Destination: dir,
+		Retries:     1,
+	}).Start(context.Background())
+	if err != nil {
+		t.Fatalf("Download.Start() error = %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, "alpacas.txt"))
+	if err != nil {
+		t.Fatalf("os.ReadFile() error = %v", err)
+	}
+	assert.Equal(t, content, got)
+}
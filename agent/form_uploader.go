@@ -2,6 +2,7 @@ package agent
 
 import (
 	"bytes"
+	"context"
 	_ "crypto/sha512" // import sha512 to make sha512 ssl certs work
 	"fmt"
 	"io"
@@ -15,10 +16,10 @@ import (
 	// "net/http/httputil"
 	"errors"
 	"net/url"
-	"os"
 
 	"github.com/buildkite/agent/v3/api"
 	"github.com/buildkite/agent/v3/logger"
+	"golang.org/x/time/rate"
 )
 
 var ArtifactPathVariableRegex = regexp.MustCompile("\\$\\{artifact\\:path\\}")
@@ -29,6 +30,17 @@ var maxFormUploadedArtifactSize = int64(5368709120)
 type FormUploaderConfig struct {
 	// Whether or not HTTP calls should be debugged
 	DebugHTTP bool
+
+	// Whether or not HTTP2 should be disabled for uploads
+	DisableHTTP2 bool
+
+	// The size of the buffer files are read into while they're being sent.
+	// Zero means the net/http default.
+	ReadBufferSize int
+
+	// If set, files are read no faster than this allows, shared with
+	// everything else using the same limiter
+	Bandwidth *rate.Limiter
 }
 
 type FormUploader struct {
@@ -52,13 +64,13 @@ func (u *FormUploader) URL(artifact *api.Artifact) string {
 	return ""
 }
 
-func (u *FormUploader) Upload(artifact *api.Artifact) error {
+func (u *FormUploader) Upload(ctx context.Context, artifact *api.Artifact) error {
 	if artifact.FileSize > maxFormUploadedArtifactSize {
 		return errArtifactTooLarge{Size: artifact.FileSize}
 	}
 
 	// Create a HTTP request for uploading the file
-	request, err := createUploadRequest(u.logger, artifact)
+	request, err := createUploadRequest(ctx, u.logger, artifact)
 	if err != nil {
 		return err
 	}
@@ -93,7 +105,7 @@ func (u *FormUploader) Upload(artifact *api.Artifact) error {
 	}
 
 	// Create the client
-	client := &http.Client{}
+	client := newUploadHTTPClient(u.conf.DisableHTTP2, u.conf.ReadBufferSize, u.conf.Bandwidth)
 
 	// Perform the request
 	u.logger.Debug("%s %s", request.Method, request.URL)
@@ -124,16 +136,20 @@ func (u *FormUploader) Upload(artifact *api.Artifact) error {
 			}
 
 			// Return a custom error with the response body from the page
-			message := fmt.Sprintf("%s (%d)", body, response.StatusCode)
-			return errors.New(message)
+			return &uploadStatusError{
+				StatusCode: response.StatusCode,
+				message:    fmt.Sprintf("%s (%d)", body, response.StatusCode),
+			}
 		}
 	}
 
+	// The file is uploaded as it is
+	artifact.StoredSize = artifact.FileSize
 	return nil
 }
 
 // Creates a new file upload http request with optional extra params
-func createUploadRequest(l logger.Logger, artifact *api.Artifact) (*http.Request, error) {
+func createUploadRequest(ctx context.Context, l logger.Logger, artifact *api.Artifact) (*http.Request, error) {
 	streamer := newMultipartStreamer()
 
 	// Set the post data for the request
@@ -148,7 +164,7 @@ func createUploadRequest(l logger.Logger, artifact *api.Artifact) (*http.Request
 		}
 	}
 
-	fh, err := os.Open(artifact.AbsolutePath)
+	fh, err := openArtifact(ctx, artifact)
 	if err != nil {
 		return nil, err
 	}
@@ -171,7 +187,7 @@ func createUploadRequest(l logger.Logger, artifact *api.Artifact) (*http.Request
 	uri.Path = artifact.UploadInstructions.Action.Path
 
 	// Create the request
-	req, err := http.NewRequest(artifact.UploadInstructions.Action.Method, uri.String(), streamer.Reader())
+	req, err := http.NewRequestWithContext(ctx, artifact.UploadInstructions.Action.Method, uri.String(), streamer.Reader())
 	if err != nil {
 		fh.Close()
 		return nil, err
@@ -2,6 +2,7 @@ package agent
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -89,6 +90,7 @@ func TestFormUploading(t *testing.T) {
 			AbsolutePath: abspath,
 			GlobPath:     "llamas.txt",
 			ContentType:  "text/plain",
+			FileSize:     6,
 			UploadInstructions: &api.ArtifactUploadInstructions{
 				Data: map[string]string{
 					"path": "${artifact:path}",
@@ -106,8 +108,11 @@ func TestFormUploading(t *testing.T) {
 				}},
 		}
 
-		if err := uploader.Upload(artifact); err != nil {
-			t.Errorf("uploader.Upload(artifact) = %v", err)
+		if err := uploader.Upload(context.Background(), artifact); err != nil {
+			t.Errorf("uploader.Upload(context.Background(), artifact) = %v", err)
+		}
+		if got, want := artifact.StoredSize, int64(6); got != want {
+			t.Errorf("artifact.StoredSize = %d, want %d", got, want)
 		}
 	}
 
@@ -154,8 +159,8 @@ func TestFormUploadFileMissing(t *testing.T) {
 			}},
 	}
 
-	if err := uploader.Upload(artifact); !os.IsNotExist(err) {
-		t.Errorf("uploader.Upload(artifact) = %v, want os.ErrNotExist", err)
+	if err := uploader.Upload(context.Background(), artifact); !os.IsNotExist(err) {
+		t.Errorf("uploader.Upload(context.Background(), artifact) = %v, want os.ErrNotExist", err)
 	}
 }
 
@@ -172,7 +177,54 @@ func TestFormUploadTooBig(t *testing.T) {
 		UploadInstructions: &api.ArtifactUploadInstructions{},
 	}
 
-	if err := uploader.Upload(artifact); !errors.Is(err, errArtifactTooLarge{Size: size}) {
-		t.Errorf("uploader.Upload(artifact) = %v, want errArtifactTooLarge", err)
+	if err := uploader.Upload(context.Background(), artifact); !errors.Is(err, errArtifactTooLarge{Size: size}) {
+		t.Errorf("uploader.Upload(context.Background(), artifact) = %v, want errArtifactTooLarge", err)
+	}
+}
+
+func BenchmarkFormUploadReadBufferSize(b *testing.B) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		io.Copy(io.Discard, req.Body)
+	}))
+	defer server.Close()
+
+	const size = 64 * 1024 * 1024
+	abspath := filepath.Join(b.TempDir(), "llamas.bin")
+	if err := os.WriteFile(abspath, bytes.Repeat([]byte("llamas!\n"), size/8), 0600); err != nil {
+		b.Fatalf("os.WriteFile(%q) error = %v", abspath, err)
+	}
+
+	for _, bufSize := range []int{0, 32 * 1024, 256 * 1024, 1024 * 1024} {
+		b.Run(fmt.Sprintf("%dKB", bufSize/1024), func(b *testing.B) {
+			uploader := NewFormUploader(logger.Discard, FormUploaderConfig{
+				ReadBufferSize: bufSize,
+			})
+			b.SetBytes(size)
+			for i := 0; i < b.N; i++ {
+				artifact := &api.Artifact{
+					ID:           "xxxxx-xxxx-xxxx-xxxx-xxxxxxxxxx",
+					Path:         "llamas.bin",
+					AbsolutePath: abspath,
+					GlobPath:     "llamas.bin",
+					FileSize:     size,
+					ContentType:  "application/octet-stream",
+					UploadInstructions: &api.ArtifactUploadInstructions{
+						Action: struct {
+							URL       string "json:\"url,omitempty\""
+							Method    string "json:\"method\""
+							Path      string "json:\"path\""
+							FileInput string "json:\"file_input\""
+						}{
+							URL:       server.URL,
+							Method:    "POST",
+							Path:      "buildkiteartifacts.com",
+							FileInput: "file",
+						}},
+				}
+				if err := uploader.Upload(context.Background(), artifact); err != nil {
+					b.Fatalf("uploader.Upload(context.Background(), artifact) = %v", err)
+				}
+			}
+		})
 	}
 }
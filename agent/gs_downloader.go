@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"strings"
 
 	"github.com/buildkite/agent/v3/logger"
@@ -21,6 +22,17 @@ type GSDownloaderConfig struct {
 	// also its location in the bucket
 	Path string
 
+	// The location of the file in the bucket, relative to the destination's
+	// path, if it isn't Path, e.g. when it was uploaded content addressed
+	StoragePath string
+
+	// If set, the file is written here instead of beneath Destination
+	Writer io.Writer
+
+	// The expected SHA-256 checksum of the file, if known. Downloads to
+	// Destination are checked against it, and discarded if they don't match.
+	Sha256Sum string
+
 	// How many times should it retry the download before giving up
 	Retries int
 
@@ -56,6 +68,8 @@ func (d GSDownloader) Start(ctx context.Context) error {
 		URL:         url,
 		Path:        d.conf.Path,
 		Destination: d.conf.Destination,
+		Writer:      d.conf.Writer,
+		Sha256Sum:   d.conf.Sha256Sum,
 		Retries:     d.conf.Retries,
 		DebugHTTP:   d.conf.DebugHTTP,
 	}).Start(ctx)
@@ -63,10 +77,19 @@ func (d GSDownloader) Start(ctx context.Context) error {
 
 func (d GSDownloader) BucketFileLocation() string {
 	if d.BucketPath() != "" {
-		return strings.TrimSuffix(d.BucketPath(), "/") + "/" + strings.TrimPrefix(d.conf.Path, "/")
+		return strings.TrimSuffix(d.BucketPath(), "/") + "/" + strings.TrimPrefix(d.storagePath(), "/")
 	} else {
-		return d.conf.Path
+		return d.storagePath()
+	}
+}
+
+// storagePath returns the location of the file in the bucket, relative to the
+// destination's path
+func (d GSDownloader) storagePath() string {
+	if d.conf.StoragePath != "" {
+		return d.conf.StoragePath
 	}
+	return d.conf.Path
 }
 
 func (d GSDownloader) BucketPath() string {
@@ -119,7 +119,7 @@ func (u *GSUploader) URL(artifact *api.Artifact) string {
 	return artifactURL.String()
 }
 
-func (u *GSUploader) Upload(artifact *api.Artifact) error {
+func (u *GSUploader) Upload(ctx context.Context, artifact *api.Artifact) error {
 	permission := os.Getenv("BUILDKITE_GS_ACL")
 
 	// The dirtiest validation method ever...
@@ -144,25 +144,53 @@ func (u *GSUploader) Upload(artifact *api.Artifact) error {
 		ContentType:        artifact.ContentType,
 		ContentDisposition: u.contentDisposition(artifact),
 	}
-	file, err := os.Open(artifact.AbsolutePath)
+	// Content addressed objects don't say what they are from their name
+	if artifact.StoragePath != "" {
+		object.Metadata = map[string]string{ArtifactPathMetadataKey: artifact.Path}
+	}
+	file, err := openArtifact(ctx, artifact)
 	if err != nil {
-		return errors.New(fmt.Sprintf("Failed to open file \"%q\" (%v)", artifact.AbsolutePath, err))
+		return fmt.Errorf("Failed to open file \"%q\" (%w)", artifact.AbsolutePath, err)
 	}
 	call := u.service.Objects.Insert(u.BucketName, object)
 	if permission != "" {
 		call = call.PredefinedAcl(permission)
 	}
-	if res, err := call.Media(file, googleapi.ContentType("")).Do(); err == nil {
+	if res, err := call.Media(file, googleapi.ContentType("")).Context(ctx).Do(); err == nil {
 		u.logger.Debug("Created object %v at location %v\n\n", res.Name, res.SelfLink)
+		artifact.StoredSize = int64(res.Size)
 	} else {
-		return errors.New(fmt.Sprintf("Failed to PUT file \"%s\" (%v)", u.artifactPath(artifact), err))
+		return fmt.Errorf("Failed to PUT file \"%s\" (%w)", u.artifactPath(artifact), err)
 	}
 
 	return nil
 }
 
+func (u *GSUploader) Download(ctx context.Context, artifact *api.Artifact, destination string) error {
+	return NewGSDownloader(u.logger, GSDownloaderConfig{
+		Bucket:      u.conf.Destination,
+		Path:        artifact.Path,
+		StoragePath: artifact.StoragePath,
+		Destination: destination,
+		Retries:     5,
+		DebugHTTP:   u.conf.DebugHTTP,
+	}).Start(ctx)
+}
+
+func (u *GSUploader) Exists(ctx context.Context, artifact *api.Artifact) (bool, error) {
+	_, err := u.service.Objects.Get(u.BucketName, u.artifactPath(artifact)).Context(ctx).Do()
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 func (u *GSUploader) artifactPath(artifact *api.Artifact) string {
-	parts := []string{u.BucketPath, artifact.Path}
+	parts := []string{u.BucketPath, storagePath(artifact)}
 
 	return strings.Join(parts, "/")
 }
@@ -644,6 +644,8 @@ func (r *JobRunner) createEnvironment() ([]string, error) {
 	env["BUILDKITE_SHELL"] = r.conf.AgentConfiguration.Shell
 	env["BUILDKITE_AGENT_EXPERIMENT"] = strings.Join(experiments.Enabled(), ",")
 	env["BUILDKITE_REDACTED_VARS"] = strings.Join(r.conf.AgentConfiguration.RedactedVars, ",")
+	env["BUILDKITE_REDACTED_REPLACEMENT"] = r.conf.AgentConfiguration.RedactedReplacement
+	env["BUILDKITE_FAIL_ON_REDACTION"] = fmt.Sprintf("%t", r.conf.AgentConfiguration.FailOnRedaction)
 
 	// propagate CancelSignal to bootstrap, unless it's the default SIGTERM
 	if r.conf.CancelSignal != process.SIGTERM {
@@ -0,0 +1,23 @@
+//go:build !windows
+// +build !windows
+
+package agent
+
+import (
+	"math"
+
+	"golang.org/x/sys/unix"
+)
+
+// openFileLimit returns the soft RLIMIT_NOFILE of the process
+func openFileLimit() (int, bool) {
+	var rlimit unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_NOFILE, &rlimit); err != nil {
+		return 0, false
+	}
+	if rlimit.Cur > math.MaxInt32 {
+		// Effectively unlimited
+		return math.MaxInt32, true
+	}
+	return int(rlimit.Cur), true
+}
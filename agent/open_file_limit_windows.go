@@ -0,0 +1,10 @@
+//go:build windows
+// +build windows
+
+package agent
+
+// openFileLimit reports that there's no limit on open files on Windows, which
+// doesn't limit handles per process the way Unix limits file descriptors
+func openFileLimit() (int, bool) {
+	return 0, false
+}
@@ -112,6 +112,11 @@ func (u *PipelineUploader) pipelineUploadAsyncWithRetry(
 	l logger.Logger,
 ) (*pipelineUploadAsyncResult, error) {
 	result := &pipelineUploadAsyncResult{}
+
+	// Bound the operation, including its retries, by the client's MaxWait
+	ctx, cancel := u.Client.OperationContext(ctx)
+	defer cancel()
+
 	// Retry the pipeline upload a few times before giving up
 	if err := roko.NewRetrier(
 		roko.WithMaxAttempts(defaultAttempts),
@@ -175,6 +180,10 @@ func (u *PipelineUploader) pipelineUploadAsyncWithRetry(
 }
 
 func (u *PipelineUploader) pollForPiplineUploadStatus(ctx context.Context, l logger.Logger) error {
+	// Bound the operation, including its retries, by the client's MaxWait
+	ctx, cancel := u.Client.OperationContext(ctx)
+	defer cancel()
+
 	return roko.NewRetrier(
 		roko.WithMaxAttempts(defaultAttempts),
 		roko.WithStrategy(roko.Constant(defaultSleepDuration)),
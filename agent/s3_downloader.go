@@ -3,6 +3,7 @@ package agent
 import (
 	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"strings"
 	"time"
@@ -26,6 +27,17 @@ type S3DownloaderConfig struct {
 	// also its location in the bucket
 	Path string
 
+	// The location of the file in the bucket, relative to the destination's
+	// path, if it isn't Path, e.g. when it was uploaded content addressed
+	StoragePath string
+
+	// If set, the file is written here instead of beneath Destination
+	Writer io.Writer
+
+	// The expected SHA-256 checksum of the file, if known. Downloads to
+	// Destination are checked against it, and discarded if they don't match.
+	Sha256Sum string
+
 	// How many times should it retry the download before giving up
 	Retries int
 
@@ -68,6 +80,8 @@ func (d S3Downloader) Start(ctx context.Context) error {
 		URL:         signedURL,
 		Path:        d.conf.Path,
 		Destination: d.conf.Destination,
+		Writer:      d.conf.Writer,
+		Sha256Sum:   d.conf.Sha256Sum,
 		Retries:     d.conf.Retries,
 		DebugHTTP:   d.conf.DebugHTTP,
 	}).Start(ctx)
@@ -75,10 +89,19 @@ func (d S3Downloader) Start(ctx context.Context) error {
 
 func (d S3Downloader) BucketFileLocation() string {
 	if d.BucketPath() != "" {
-		return strings.TrimSuffix(d.BucketPath(), "/") + "/" + strings.TrimPrefix(d.conf.Path, "/")
+		return strings.TrimSuffix(d.BucketPath(), "/") + "/" + strings.TrimPrefix(d.storagePath(), "/")
 	} else {
-		return d.conf.Path
+		return d.storagePath()
+	}
+}
+
+// storagePath returns the location of the file in the bucket, relative to the
+// destination's path
+func (d S3Downloader) storagePath() string {
+	if d.conf.StoragePath != "" {
+		return d.conf.StoragePath
 	}
+	return d.conf.Path
 }
 
 func (d S3Downloader) BucketPath() string {
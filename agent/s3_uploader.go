@@ -1,18 +1,27 @@
 package agent
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 	"net/url"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/buildkite/agent/v3/api"
 	"github.com/buildkite/agent/v3/logger"
+	"golang.org/x/time/rate"
 )
 
+// S3ExpiresAtTag is the object tag that holds when an artifact expires, as an
+// RFC 3339 timestamp, for artifacts uploaded with an expiry
+const S3ExpiresAtTag = "buildkite-expires-at"
+
 type S3UploaderConfig struct {
 	// The destination which includes the S3 bucket name and the path.
 	// For example, s3://my-bucket-name/foo/bar
@@ -20,6 +29,22 @@ type S3UploaderConfig struct {
 
 	// Whether or not HTTP calls should be debugged
 	DebugHTTP bool
+
+	// Whether or not HTTP2 should be disabled for uploads
+	DisableHTTP2 bool
+
+	// The size of the buffer files are read into while they're being sent.
+	// Zero means the net/http default.
+	ReadBufferSize int
+
+	// If set, files are read no faster than this allows, shared with
+	// everything else using the same limiter
+	Bandwidth *rate.Limiter
+
+	// Whether to always upload with a single PUT, for S3 compatible storage
+	// that doesn't support multipart uploads. Files larger than 5GB can't
+	// be uploaded this way.
+	DisableMultipart bool
 }
 
 type S3Uploader struct {
@@ -48,6 +73,10 @@ func NewS3Uploader(l logger.Logger, c S3UploaderConfig) (*S3Uploader, error) {
 		return nil, err
 	}
 
+	if c.DisableHTTP2 || c.ReadBufferSize > 0 || c.Bandwidth != nil {
+		s3Client.Config.HTTPClient = newUploadHTTPClient(c.DisableHTTP2, c.ReadBufferSize, c.Bandwidth)
+	}
+
 	return &S3Uploader{
 		logger:     l,
 		conf:       c,
@@ -80,7 +109,7 @@ func (u *S3Uploader) URL(artifact *api.Artifact) string {
 	return url.String()
 }
 
-func (u *S3Uploader) Upload(artifact *api.Artifact) error {
+func (u *S3Uploader) Upload(ctx context.Context, artifact *api.Artifact) error {
 
 	permission, err := u.resolvePermission()
 	if err != nil {
@@ -92,9 +121,9 @@ func (u *S3Uploader) Upload(artifact *api.Artifact) error {
 
 	// Open file from filesystem
 	u.logger.Debug("Reading file \"%s\"", artifact.AbsolutePath)
-	f, err := os.Open(artifact.AbsolutePath)
+	f, err := openArtifact(ctx, artifact)
 	if err != nil {
-		return fmt.Errorf("failed to open file %q (%v)", artifact.AbsolutePath, err)
+		return fmt.Errorf("failed to open file %q (%w)", artifact.AbsolutePath, err)
 	}
 
 	// Upload the file to S3.
@@ -107,18 +136,85 @@ func (u *S3Uploader) Upload(artifact *api.Artifact) error {
 		ACL:         aws.String(permission),
 		Body:        f,
 	}
+	if artifact.ContentDisposition != "" {
+		params.ContentDisposition = aws.String(artifact.ContentDisposition)
+	}
+	// Content addressed objects don't say what they are from their key
+	if artifact.StoragePath != "" {
+		params.Metadata = map[string]*string{ArtifactPathMetadataKey: aws.String(artifact.Path)}
+	}
 	// if enabled we assign the sse configuration
 	if u.serverSideEncryptionEnabled() {
 		params.ServerSideEncryption = aws.String("AES256")
 	}
 
-	_, err = uploader.Upload(params)
+	// Tag artifacts that expire, so that bucket lifecycle rules can use it
+	if artifact.ExpiresAt != nil {
+		tags := url.Values{}
+		tags.Set(S3ExpiresAtTag, artifact.ExpiresAt.UTC().Format(time.RFC3339))
+		params.Tagging = aws.String(tags.Encode())
+	}
+
+	if u.conf.DisableMultipart {
+		if artifact.FileSize > uploader.PartSize {
+			u.logger.Debug("Skipping multipart upload for \"%s\" as multipart uploads are disabled", artifact.Path)
+		}
+
+		_, err = u.client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+			Bucket:               params.Bucket,
+			Key:                  params.Key,
+			ContentType:          params.ContentType,
+			ContentDisposition:   params.ContentDisposition,
+			ACL:                  params.ACL,
+			ServerSideEncryption: params.ServerSideEncryption,
+			Tagging:              params.Tagging,
+			Metadata:             params.Metadata,
+			Body:                 f,
+		})
+		if err != nil {
+			return err
+		}
+		artifact.StoredSize = artifact.FileSize
+		return nil
+	}
+
+	if _, err := uploader.UploadWithContext(ctx, params); err != nil {
+		return err
+	}
+
+	// The file is uploaded as it is
+	artifact.StoredSize = artifact.FileSize
+	return nil
+}
 
-	return err
+func (u *S3Uploader) Download(ctx context.Context, artifact *api.Artifact, destination string) error {
+	return NewS3Downloader(u.logger, S3DownloaderConfig{
+		S3Client:    u.client,
+		S3Path:      u.conf.Destination,
+		Path:        artifact.Path,
+		StoragePath: artifact.StoragePath,
+		Destination: destination,
+		Retries:     5,
+		DebugHTTP:   u.conf.DebugHTTP,
+	}).Start(ctx)
+}
+
+func (u *S3Uploader) Exists(ctx context.Context, artifact *api.Artifact) (bool, error) {
+	_, err := u.client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(u.BucketName),
+		Key:    aws.String(u.artifactPath(artifact)),
+	})
+	if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() == http.StatusNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
 func (u *S3Uploader) artifactPath(artifact *api.Artifact) string {
-	parts := []string{u.BucketPath, artifact.Path}
+	parts := []string{u.BucketPath, storagePath(artifact)}
 
 	return strings.Join(parts, "/")
 }
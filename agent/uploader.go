@@ -1,7 +1,12 @@
 package agent
 
 import (
+	"context"
+	"crypto/tls"
+	"net/http"
+
 	"github.com/buildkite/agent/v3/api"
+	"golang.org/x/time/rate"
 )
 
 type Uploader interface {
@@ -9,6 +14,35 @@ type Uploader interface {
 	// from this method prior to uploading.
 	URL(*api.Artifact) string
 
-	// The actual uploading of the file
-	Upload(*api.Artifact) error
+	// The actual uploading of the file, which should stop, returning an
+	// error, once ctx is done. Uploaders that write a different number of
+	// bytes than the artifact's FileSize (e.g. by compressing) should set
+	// the artifact's StoredSize.
+	Upload(context.Context, *api.Artifact) error
+}
+
+// newUploadHTTPClient returns an HTTP client for talking to artifact storage.
+// HTTP2 can be disabled independently of the Agent API client, for proxies that
+// only misbehave with large uploads. If readBufferSize is positive, request
+// bodies are read from disk in chunks of that size, rather than net/http's
+// default of 4KB. If bandwidth isn't nil, request bodies are read no faster
+// than it allows, shared with every other client using it.
+func newUploadHTTPClient(disableHTTP2 bool, readBufferSize int, bandwidth *rate.Limiter) *http.Client {
+	if !disableHTTP2 && readBufferSize <= 0 && bandwidth == nil {
+		return &http.Client{}
+	}
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if disableHTTP2 {
+		t.ForceAttemptHTTP2 = false
+		t.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+	if readBufferSize > 0 {
+		t.WriteBufferSize = readBufferSize
+	}
+	if bandwidth != nil {
+		return &http.Client{Transport: &throttledTransport{next: t, limiter: bandwidth}}
+	}
+
+	return &http.Client{Transport: t}
 }
@@ -2,10 +2,15 @@ package api
 
 import (
 	"context"
+	"crypto/sha256"
 	"fmt"
 	"time"
 )
 
+// ArtifactIdempotencyKeyHeader is the header CreateArtifacts sends the batch's
+// idempotency key in
+const ArtifactIdempotencyKeyHeader = "Idempotency-Key"
+
 // Artifact represents an artifact on the Buildkite Agent API
 type Artifact struct {
 	// The ID of the artifact. The ID is assigned to it after a successful
@@ -24,6 +29,16 @@ type Artifact struct {
 	// The size of the file in bytes
 	FileSize int64 `json:"file_size"`
 
+	// The number of bytes actually written to the storage backend, which can
+	// differ from FileSize if the upload was compressed. Populated after
+	// upload.
+	StoredSize int64 `json:"stored_size,omitempty"`
+
+	// The permission bits of the file in octal, like "0755", so that they
+	// can be restored when it's downloaded. Windows only has a read-only
+	// attribute, so files uploaded from it are either "0444" or "0666".
+	FileMode string `json:"file_mode,omitempty"`
+
 	// A SHA-1 hash of the uploaded file
 	Sha1Sum string `json:"sha1sum"`
 
@@ -36,6 +51,10 @@ type Artifact struct {
 	// UTC timestamp this artifact was considered created
 	CreatedAt time.Time `json:"created_at"`
 
+	// A hint for when the artifact is no longer needed and can be deleted,
+	// if it's only needed for a while
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+
 	// The HTTP url to this artifact once it's been uploaded
 	URL string `json:"url,omitempty"`
 
@@ -43,11 +62,18 @@ type Artifact struct {
 	// uploaded
 	UploadDestination string `json:"upload_destination,omitempty"`
 
+	// The path the artifact is stored at in the upload destination, if it
+	// isn't stored at Path, e.g. when it's content addressed
+	StoragePath string `json:"storage_path,omitempty"`
+
 	// Information on how to upload this artifact.
 	UploadInstructions *ArtifactUploadInstructions `json:"-"`
 
 	// A specific Content-Type to use on upload
 	ContentType string `json:"-"`
+
+	// A specific Content-Disposition to use on upload, if any
+	ContentDisposition string `json:"-"`
 }
 
 type ArtifactBatch struct {
@@ -85,17 +111,37 @@ type ArtifactSearchOptions struct {
 type ArtifactBatchUpdateArtifact struct {
 	ID    string `json:"id"`
 	State string `json:"state"`
+
+	// The number of bytes written to the storage backend, sent once the
+	// artifact is finished
+	StoredSize int64 `json:"stored_size,omitempty"`
 }
 
 type ArtifactBatchUpdateRequest struct {
 	Artifacts []*ArtifactBatchUpdateArtifact `json:"artifacts"`
 }
 
+// IdempotencyKey returns a key identifying the artifacts in the batch, so that
+// a retried create request can be recognised. It's the hex encoded SHA-256 of
+// each artifact's Sha256Sum and Path, in order, each followed by a newline. It
+// only depends on the artifacts, so the same files at the same paths always
+// have the same key.
+func (b *ArtifactBatch) IdempotencyKey() string {
+	h := sha256.New()
+	for _, a := range b.Artifacts {
+		fmt.Fprintf(h, "%s\n%s\n", a.Sha256Sum, a.Path)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
 // CreateArtifacts takes a slice of artifacts, and creates them on Buildkite as a batch.
 func (c *Client) CreateArtifacts(ctx context.Context, jobId string, batch *ArtifactBatch) (*ArtifactBatchCreateResponse, *Response, error) {
 	u := fmt.Sprintf("jobs/%s/artifacts", jobId)
 
-	req, err := c.newRequest(ctx, "POST", u, batch)
+	req, err := c.newRequest(ctx, "POST", u, batch, Header{
+		Name:  ArtifactIdempotencyKeyHeader,
+		Value: batch.IdempotencyKey(),
+	})
 	if err != nil {
 		return nil, nil, err
 	}
@@ -109,14 +155,10 @@ func (c *Client) CreateArtifacts(ctx context.Context, jobId string, batch *Artif
 	return createResponse, resp, err
 }
 
-// Updates a particular artifact
-func (c *Client) UpdateArtifacts(ctx context.Context, jobId string, artifactStates map[string]string) (*Response, error) {
+// Updates the states of a batch of artifacts
+func (c *Client) UpdateArtifacts(ctx context.Context, jobId string, artifacts []*ArtifactBatchUpdateArtifact) (*Response, error) {
 	u := fmt.Sprintf("jobs/%s/artifacts", jobId)
-	payload := ArtifactBatchUpdateRequest{}
-
-	for id, state := range artifactStates {
-		payload.Artifacts = append(payload.Artifacts, &ArtifactBatchUpdateArtifact{id, state})
-	}
+	payload := ArtifactBatchUpdateRequest{Artifacts: artifacts}
 
 	req, err := c.newRequest(ctx, "PUT", u, payload)
 	if err != nil {
@@ -152,3 +194,48 @@ func (c *Client) SearchArtifacts(ctx context.Context, buildId string, opt *Artif
 
 	return a, resp, err
 }
+
+type ArtifactRenameRequest struct {
+	Path string `json:"path"`
+}
+
+// RenameArtifact changes the path of an artifact that has already been uploaded
+func (c *Client) RenameArtifact(ctx context.Context, jobId string, artifactId string, path string) (*Artifact, *Response, error) {
+	u := fmt.Sprintf("jobs/%s/artifacts/%s", jobId, artifactId)
+
+	req, err := c.newRequest(ctx, "PATCH", u, &ArtifactRenameRequest{Path: path})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	a := new(Artifact)
+	resp, err := c.doRequest(req, a)
+	if err != nil {
+		return nil, resp, err
+	}
+
+	return a, resp, err
+}
+
+type ArtifactChecksumUpdateRequest struct {
+	Sha256Sum string `json:"sha256sum"`
+}
+
+// UpdateArtifactChecksum records the SHA-256 checksum of an artifact that has
+// already been uploaded, for artifacts uploaded before it was recorded
+func (c *Client) UpdateArtifactChecksum(ctx context.Context, jobId string, artifactId string, sha256sum string) (*Artifact, *Response, error) {
+	u := fmt.Sprintf("jobs/%s/artifacts/%s", jobId, artifactId)
+
+	req, err := c.newRequest(ctx, "PATCH", u, &ArtifactChecksumUpdateRequest{Sha256Sum: sha256sum})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	a := new(Artifact)
+	resp, err := c.doRequest(req, a)
+	if err != nil {
+		return nil, resp, err
+	}
+
+	return a, resp, err
+}
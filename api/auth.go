@@ -2,7 +2,9 @@ package api
 
 import (
 	"fmt"
+	"io"
 	"net/http"
+	"sync"
 )
 
 type canceler interface {
@@ -15,19 +17,84 @@ type authenticatedTransport struct {
 	// organizations registration token, or the agents access token.
 	Token string
 
+	// TokenSource, if set, is called for a fresh token when a request is
+	// rejected with a 401. The request is retried once with the new token.
+	TokenSource func() (string, error)
+
 	// Delegate is the underlying HTTP transport
 	Delegate http.RoundTripper
+
+	// Guards Token, which changes when it's refreshed
+	mu sync.Mutex
 }
 
 // RoundTrip invoked each time a request is made
-func (t authenticatedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
-	if t.Token == "" {
+func (t *authenticatedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token := t.token()
+	if token == "" {
 		return nil, fmt.Errorf("Invalid token, empty string supplied")
 	}
 
-	req.Header.Set("Authorization", fmt.Sprintf("Token %s", t.Token))
+	req.Header.Set("Authorization", fmt.Sprintf("Token %s", token))
+
+	resp, err := t.Delegate.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || t.TokenSource == nil {
+		return resp, err
+	}
+
+	// Without a way to rewind the body the request can't be sent again, so
+	// the 401 is all we've got
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return resp, nil
+	}
+
+	fresh, err := t.refresh(token)
+	if err != nil {
+		return resp, nil
+	}
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+		retry.Body = body
+	}
+	retry.Header.Set("Authorization", fmt.Sprintf("Token %s", fresh))
+
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	return t.Delegate.RoundTrip(retry)
+}
+
+func (t *authenticatedTransport) token() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.Token
+}
+
+// refresh returns a new token to replace stale. If another request has already
+// refreshed it, that token is used rather than asking the TokenSource again.
+func (t *authenticatedTransport) refresh(stale string) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.Token != stale {
+		return t.Token, nil
+	}
+
+	token, err := t.TokenSource()
+	if err != nil {
+		return "", err
+	}
+	if token == "" {
+		return "", fmt.Errorf("Invalid token, empty string supplied")
+	}
 
-	return t.Delegate.RoundTrip(req)
+	t.Token = token
+	return token, nil
 }
 
 // CancelRequest cancels an in-flight request by closing its connection.
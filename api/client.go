@@ -4,6 +4,7 @@ package api
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"crypto/tls"
 	"encoding/json"
@@ -25,17 +26,49 @@ import (
 const (
 	defaultEndpoint  = "https://agent.buildkite.com/"
 	defaultUserAgent = "buildkite-agent/api"
+
+	// The public Agent API, including its version, which endpoint tokens
+	// resolve to. It matches the CLI's default --endpoint.
+	publicEndpoint = "https://agent.buildkite.com/v3"
+
+	// Request bodies smaller than this aren't worth compressing
+	compressMinSize = 1024
+)
+
+// Endpoint tokens that are resolved to a concrete endpoint when a Client is
+// constructed, e.g. "auto" or "region:eu".
+const (
+	EndpointAuto         = "auto"
+	EndpointRegionPrefix = "region:"
 )
 
+// RegionalEndpoints maps the region names that can be used in endpoint tokens
+// to concrete Agent API endpoints. The public Agent API is hosted in the US and
+// served from a global edge network that routes requests to the nearest
+// location, so "auto" and "global" use it too. Regions can be added here, e.g.
+// for dedicated Buildkite installations.
+var RegionalEndpoints = map[string]string{
+	EndpointAuto: publicEndpoint,
+	"global":     publicEndpoint,
+	"us":         publicEndpoint,
+}
+
 // Config is configuration for the API Client
 type Config struct {
 	// Endpoint for API requests. Defaults to the public Buildkite Agent API.
-	// The URL should always be specified with a trailing slash.
+	// The URL should always be specified with a trailing slash. A region
+	// token ("auto" or "region:<name>") may be given instead of a URL, which
+	// is resolved using RegionalEndpoints.
 	Endpoint string
 
 	// The authentication token to use, either a registration or access token
 	Token string
 
+	// If set, called to get a fresh token when a request fails with a 401,
+	// e.g. because a long-running operation outlived the access token. The
+	// request is retried once with the new token.
+	TokenSource func() (string, error)
+
 	// User agent used when communicating with the Buildkite Agent API.
 	UserAgent string
 
@@ -45,6 +78,23 @@ type Config struct {
 	// If true, requests and responses will be dumped and set to the logger
 	DebugHTTP bool
 
+	// If true, request bodies larger than a small threshold are gzipped and
+	// sent with a Content-Encoding: gzip header. Responses are always
+	// requested with Accept-Encoding: gzip and transparently decompressed by
+	// the default transport.
+	Compress bool
+
+	// The longest a single operation, including any retries, may take
+	// before giving up. Only applies to operations run with a context from
+	// OperationContext. Zero means no limit.
+	MaxWait time.Duration
+
+	// The most idle connections to keep open to the endpoint, and the most
+	// connections to it at once. Zero means the net/http defaults: 2 idle
+	// connections, and no limit. Ignored if HTTPClient is set.
+	MaxIdleConnsPerHost int
+	MaxConnsPerHost     int
+
 	// The http client used, leave nil for the default
 	HTTPClient *http.Client
 }
@@ -67,6 +117,8 @@ func NewClient(l logger.Logger, conf Config) *Client {
 		conf.Endpoint = defaultEndpoint
 	}
 
+	conf.Endpoint = resolveEndpoint(l, conf.Endpoint)
+
 	if conf.UserAgent == "" {
 		conf.UserAgent = defaultUserAgent
 	}
@@ -82,6 +134,8 @@ func NewClient(l logger.Logger, conf Config) *Client {
 				KeepAlive: 30 * time.Second,
 			}).DialContext,
 			MaxIdleConns:        100,
+			MaxIdleConnsPerHost: conf.MaxIdleConnsPerHost,
+			MaxConnsPerHost:     conf.MaxConnsPerHost,
 			IdleConnTimeout:     90 * time.Second,
 			TLSHandshakeTimeout: 30 * time.Second,
 		}
@@ -93,8 +147,9 @@ func NewClient(l logger.Logger, conf Config) *Client {
 		httpClient = &http.Client{
 			Timeout: 60 * time.Second,
 			Transport: &authenticatedTransport{
-				Token:    conf.Token,
-				Delegate: t,
+				Token:       conf.Token,
+				TokenSource: conf.TokenSource,
+				Delegate:    t,
 			},
 		}
 	}
@@ -106,11 +161,45 @@ func NewClient(l logger.Logger, conf Config) *Client {
 	}
 }
 
+// resolveEndpoint expands an endpoint region token into a concrete endpoint,
+// falling back to the public Agent API for unknown regions. Any other value is
+// returned unchanged.
+func resolveEndpoint(l logger.Logger, endpoint string) string {
+	var region string
+	switch {
+	case endpoint == EndpointAuto:
+		region = EndpointAuto
+	case strings.HasPrefix(endpoint, EndpointRegionPrefix):
+		region = strings.TrimPrefix(endpoint, EndpointRegionPrefix)
+	default:
+		return endpoint
+	}
+
+	resolved, ok := RegionalEndpoints[region]
+	if !ok {
+		l.Warn("Unknown endpoint region %q, falling back to %s", region, publicEndpoint)
+		resolved = publicEndpoint
+	}
+
+	l.Debug("Resolved endpoint %q to %s", endpoint, resolved)
+	return resolved
+}
+
 // Config returns the internal configuration for the Client
 func (c *Client) Config() Config {
 	return c.conf
 }
 
+// OperationContext returns a context for a single API operation, including any
+// retries of it. If MaxWait is set, the context has a deadline that far from
+// now, so requests and retries made with it give up once it has passed.
+func (c *Client) OperationContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.conf.MaxWait <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.conf.MaxWait)
+}
+
 // FromAgentRegisterResponse returns a new instance using the access token and endpoint
 // from the registration response
 func (c *Client) FromAgentRegisterResponse(resp *AgentRegisterResponse) *Client {
@@ -165,6 +254,20 @@ func (c *Client) newRequest(
 		}
 	}
 
+	compressed := false
+	if c.conf.Compress && buf.Len() >= compressMinSize {
+		gzipped := new(bytes.Buffer)
+		zw := gzip.NewWriter(gzipped)
+		if _, err := buf.WriteTo(zw); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		buf = gzipped
+		compressed = true
+	}
+
 	req, err := http.NewRequestWithContext(ctx, method, u, buf)
 	if err != nil {
 		return nil, err
@@ -180,6 +283,10 @@ func (c *Client) newRequest(
 		req.Header.Add("Content-Type", "application/json")
 	}
 
+	if compressed {
+		req.Header.Add("Content-Encoding", "gzip")
+	}
+
 	return req, nil
 }
 
@@ -228,7 +335,8 @@ func (c *Client) doRequest(req *http.Request, v any) (*Response, error) {
 		// file contents into the debug log (especially if it's been
 		// gzipped)
 		var requestDump []byte
-		if strings.Contains(req.Header.Get("Content-Type"), "multipart/form-data") {
+		if strings.Contains(req.Header.Get("Content-Type"), "multipart/form-data") ||
+			req.Header.Get("Content-Encoding") == "gzip" {
 			requestDump, err = httputil.DumpRequestOut(req, false)
 		} else {
 			requestDump, err = httputil.DumpRequestOut(req, true)
@@ -1,12 +1,17 @@
 package api_test
 
 import (
+	"compress/gzip"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/buildkite/agent/v3/api"
 	"github.com/buildkite/agent/v3/logger"
@@ -68,6 +73,262 @@ func TestRegisteringAndConnectingClient(t *testing.T) {
 	}
 }
 
+func TestCompressedRequestBodies(t *testing.T) {
+	body := strings.Repeat("llamas ", 1000)
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if got, want := req.Header.Get("Content-Encoding"), "gzip"; got != want {
+			http.Error(rw, fmt.Sprintf("Content-Encoding = %q, want %q", got, want), http.StatusBadRequest)
+			return
+		}
+
+		zr, err := gzip.NewReader(req.Body)
+		if err != nil {
+			http.Error(rw, fmt.Sprintf("gzip.NewReader(req.Body) error = %v", err), http.StatusBadRequest)
+			return
+		}
+
+		var annotation api.Annotation
+		if err := json.NewDecoder(zr).Decode(&annotation); err != nil {
+			http.Error(rw, fmt.Sprintf("decoding body error = %v", err), http.StatusBadRequest)
+			return
+		}
+
+		if annotation.Body != body {
+			http.Error(rw, "annotation body didn't round trip", http.StatusBadRequest)
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	c := api.NewClient(logger.Discard, api.Config{
+		Endpoint: server.URL,
+		Token:    "llamas",
+		Compress: true,
+	})
+
+	if _, err := c.Annotate(context.Background(), "my-job", &api.Annotation{Body: body}); err != nil {
+		t.Errorf("c.Annotate() error = %v", err)
+	}
+}
+
+func TestEndpointRegionTokens(t *testing.T) {
+	// A region that's been added, e.g. for a dedicated installation
+	api.RegionalEndpoints["llamaland"] = "https://agent.llamaland.example.com/v3"
+	defer delete(api.RegionalEndpoints, "llamaland")
+
+	tests := []struct {
+		name, endpoint, want string
+	}{
+		{name: "explicit URL", endpoint: "https://agent.example.com/v3", want: "https://agent.example.com/v3"},
+		{name: "empty", endpoint: "", want: "https://agent.buildkite.com/"},
+		{name: "auto", endpoint: "auto", want: "https://agent.buildkite.com/v3"},
+		{name: "auto region", endpoint: "region:auto", want: "https://agent.buildkite.com/v3"},
+		{name: "known region", endpoint: "region:us", want: "https://agent.buildkite.com/v3"},
+		{name: "added region", endpoint: "region:llamaland", want: "https://agent.llamaland.example.com/v3"},
+		{name: "unknown region", endpoint: "region:atlantis", want: "https://agent.buildkite.com/v3"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := api.NewClient(logger.Discard, api.Config{Endpoint: test.endpoint, Token: "llamas"})
+			if got := c.Config().Endpoint; got != test.want {
+				t.Errorf("api.NewClient(Endpoint: %q).Config().Endpoint = %q, want %q", test.endpoint, got, test.want)
+			}
+		})
+	}
+}
+
+func TestEndpointRegionTokenRequestPath(t *testing.T) {
+	var gotURL string
+	httpClient := &http.Client{
+		Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			gotURL = req.URL.String()
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": []string{"application/json"}},
+				Body:       io.NopCloser(strings.NewReader(`{}`)),
+				Request:    req,
+			}, nil
+		}),
+	}
+
+	for _, endpoint := range []string{"auto", "region:us", "region:atlantis"} {
+		c := api.NewClient(logger.Discard, api.Config{Endpoint: endpoint, Token: "llamas", HTTPClient: httpClient})
+		if _, err := c.Connect(context.Background()); err != nil {
+			t.Fatalf("c.Connect() error = %v", err)
+		}
+		if want := "https://agent.buildkite.com/v3/connect"; gotURL != want {
+			t.Errorf("api.NewClient(Endpoint: %q) requested %q, want %q", endpoint, gotURL, want)
+		}
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
 func authToken(req *http.Request) string {
 	return strings.TrimPrefix(req.Header.Get("Authorization"), "Token ")
 }
+
+func TestCreateArtifactsIdempotencyKey(t *testing.T) {
+	batch := &api.ArtifactBatch{
+		ID: "batch-1",
+		Artifacts: []*api.Artifact{
+			{Path: "llamas.txt", Sha256Sum: "a3b4c5"},
+			{Path: "alpacas.txt", Sha256Sum: "d6e7f8"},
+		},
+	}
+
+	var keys []string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		keys = append(keys, req.Header.Get(api.ArtifactIdempotencyKeyHeader))
+		rw.WriteHeader(http.StatusOK)
+		fmt.Fprint(rw, `{"id":"batch-1","artifact_ids":["1","2"]}`)
+	}))
+	defer server.Close()
+
+	c := api.NewClient(logger.Discard, api.Config{
+		Endpoint: server.URL,
+		Token:    "llamas",
+	})
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := c.CreateArtifacts(context.Background(), "my-job", batch); err != nil {
+			t.Fatalf("c.CreateArtifacts() error = %v", err)
+		}
+	}
+
+	if len(keys) != 2 || keys[0] == "" || keys[0] != keys[1] {
+		t.Errorf("idempotency keys = %q, want two identical non-empty keys", keys)
+	}
+	if got, want := keys[0], batch.IdempotencyKey(); got != want {
+		t.Errorf("idempotency key = %q, want %q", got, want)
+	}
+
+	batch.Artifacts[0].Path = "llamas-2.txt"
+	if got := batch.IdempotencyKey(); got == keys[0] {
+		t.Errorf("batch.IdempotencyKey() = %q after changing a path, want it to change", got)
+	}
+}
+
+func TestOperationContextMaxWait(t *testing.T) {
+	ctx := context.Background()
+
+	c := api.NewClient(logger.Discard, api.Config{Token: "llamas"})
+	opCtx, cancel := c.OperationContext(ctx)
+	if _, ok := opCtx.Deadline(); ok {
+		t.Errorf("OperationContext() has a deadline without MaxWait")
+	}
+	cancel()
+
+	c = api.NewClient(logger.Discard, api.Config{Token: "llamas", MaxWait: time.Minute})
+	opCtx, cancel = c.OperationContext(ctx)
+	defer cancel()
+	deadline, ok := opCtx.Deadline()
+	if !ok {
+		t.Fatalf("OperationContext() has no deadline with MaxWait")
+	}
+	if remaining := time.Until(deadline); remaining <= 0 || remaining > time.Minute {
+		t.Errorf("OperationContext() deadline is %s away, want at most %s", remaining, time.Minute)
+	}
+}
+
+func TestMaxConnsPerHost(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+
+		// Long enough for the other requests to pile up
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+
+		fmt.Fprint(rw, `{}`)
+	}))
+	defer server.Close()
+
+	c := api.NewClient(logger.Discard, api.Config{
+		Endpoint:        server.URL,
+		Token:           "llamas",
+		MaxConnsPerHost: 1,
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := c.Ping(context.Background()); err != nil {
+				t.Errorf("c.Ping() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if maxInFlight != 1 {
+		t.Errorf("most requests in flight at once = %d, want 1", maxInFlight)
+	}
+}
+
+func TestTokenSourceRefreshesOnUnauthorized(t *testing.T) {
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		bodies = append(bodies, string(body))
+
+		if got, want := authToken(req), "alpacas"; got != want {
+			http.Error(rw, fmt.Sprintf("authToken(req) = %q, want %q", got, want), http.StatusUnauthorized)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		fmt.Fprint(rw, `{"id":"batch-1","artifact_ids":["1"]}`)
+	}))
+	defer server.Close()
+
+	refreshes := 0
+	c := api.NewClient(logger.Discard, api.Config{
+		Endpoint: server.URL,
+		Token:    "llamas",
+		TokenSource: func() (string, error) {
+			refreshes++
+			return "alpacas", nil
+		},
+	})
+
+	batch := &api.ArtifactBatch{
+		ID:        "batch-1",
+		Artifacts: []*api.Artifact{{Path: "llamas.txt"}},
+	}
+	if _, _, err := c.CreateArtifacts(context.Background(), "my-job", batch); err != nil {
+		t.Fatalf("c.CreateArtifacts() error = %v", err)
+	}
+
+	if got, want := refreshes, 1; got != want {
+		t.Errorf("TokenSource called %d times, want %d", got, want)
+	}
+	if len(bodies) != 2 || bodies[0] == "" || bodies[0] != bodies[1] {
+		t.Errorf("request bodies = %q, want the same body sent twice", bodies)
+	}
+
+	// The refreshed token is used from then on
+	if _, _, err := c.CreateArtifacts(context.Background(), "my-job", batch); err != nil {
+		t.Fatalf("c.CreateArtifacts() error = %v", err)
+	}
+	if got, want := refreshes, 1; got != want {
+		t.Errorf("TokenSource called %d times, want %d", got, want)
+	}
+}
@@ -55,6 +55,14 @@ type Bootstrap struct {
 
 	// A channel to track cancellation
 	cancelCh chan struct{}
+
+	// Redacts high entropy tokens from the shell output, when the
+	// redact-high-entropy experiment is enabled
+	entropyRedactor *redaction.EntropyRedactor
+
+	// Every Redactor set up by setupRedactors, for counting redactions at the
+	// end of the job
+	redactors redaction.RedactorMux
 }
 
 // New returns a new Bootstrap instance
@@ -121,6 +129,14 @@ func (b *Bootstrap) Run(ctx context.Context) (exitCode int) {
 
 	defer cleanup()
 
+	// Report on redactions once everything else, including the pre-exit
+	// hook, has finished writing output
+	defer func() {
+		if code := b.reportRedactions(); code != 0 && exitCode == 0 {
+			exitCode = code
+		}
+	}()
+
 	// Tear down the environment (and fire pre-exit hook) before we exit
 	defer func() {
 		if err = b.tearDown(ctx); err != nil {
@@ -294,7 +310,7 @@ func (b *Bootstrap) executeHook(ctx context.Context, hookCfg HookConfig) error {
 	b.shell.Headerf("Running %s hook", hookName)
 
 	redactors := b.setupRedactors()
-	defer redactors.Flush()
+	defer b.flushRedactors(redactors)
 
 	// We need a script to wrap the hook script so that we can snaffle the changed
 	// environment variables
@@ -381,7 +397,7 @@ func (b *Bootstrap) applyEnvironmentChanges(changes hook.HookScriptChanges, reda
 
 	// reset output redactors based on new environment variable values
 	redactors.Flush()
-	redactors.Reset(redaction.GetValuesToRedact(b.shell, b.Config.RedactedVars, b.shell.Env.Dump()))
+	redactors.ResetNamed(redaction.GetKeyValuesToRedact(b.shell, b.Config.RedactedVars, b.shell.Env.Dump()))
 
 	// First, let see any of the environment variables are supposed
 	// to change the bootstrap configuration at run time.
@@ -1773,7 +1789,7 @@ func (b *Bootstrap) defaultCommandPhase(ctx context.Context) error {
 	}
 
 	redactors := b.setupRedactors()
-	defer redactors.Flush()
+	defer b.flushRedactors(redactors)
 
 	var cmd []string
 	cmd = append(cmd, shell...)
@@ -1961,7 +1977,16 @@ func (b *Bootstrap) ignoredEnv() []string {
 // matching environment vars.
 // redaction.RedactorMux (possibly empty) is returned so the caller can `defer redactor.Flush()`
 func (b *Bootstrap) setupRedactors() redaction.RedactorMux {
-	valuesToRedact := redaction.GetValuesToRedact(b.shell, b.Config.RedactedVars, b.shell.Env.Dump())
+	// The entropy redactor is set up first, so that it sits beneath any
+	// Redactor that wraps the shell Writer
+	if experiments.IsEnabled("redact-high-entropy") && b.entropyRedactor == nil {
+		// There's no variable name for values found this way
+		replacement := redaction.Replacement(b.Config.RedactedReplacement, "")
+		b.entropyRedactor = redaction.NewEntropyRedactor(b.shell.Writer, replacement, redaction.DefaultEntropyConfig)
+		b.shell.Writer = b.entropyRedactor
+	}
+
+	valuesToRedact := redaction.GetKeyValuesToRedact(b.shell, b.Config.RedactedVars, b.shell.Env.Dump())
 	if len(valuesToRedact) == 0 {
 		return nil
 	}
@@ -1974,13 +1999,14 @@ func (b *Bootstrap) setupRedactors() redaction.RedactorMux {
 
 	// If the shell Writer is already a Redactor, reset the values to redact.
 	if redactor, ok := b.shell.Writer.(*redaction.Redactor); ok {
-		redactor.Reset(valuesToRedact)
+		redactor.ResetNamed(valuesToRedact)
 		mux = append(mux, redactor)
 	} else if len(valuesToRedact) == 0 {
 		// skip
 	} else {
-		redactor := redaction.NewRedactor(b.shell.Writer, "[REDACTED]", valuesToRedact)
+		redactor := redaction.NewNamedRedactor(b.shell.Writer, b.Config.RedactedReplacement, valuesToRedact)
 		b.shell.Writer = redactor
+		b.redactors = append(b.redactors, redactor)
 		mux = append(mux, redactor)
 	}
 
@@ -1996,19 +2022,56 @@ func (b *Bootstrap) setupRedactors() redaction.RedactorMux {
 		}
 	}
 	if redactor := shellLoggerRedactor; redactor != nil {
-		redactor.Reset(valuesToRedact)
+		redactor.ResetNamed(valuesToRedact)
 		mux = append(mux, redactor)
 	} else if len(valuesToRedact) == 0 {
 		// skip
 	} else if shellWriterLogger != nil {
-		redactor := redaction.NewRedactor(b.shell.Writer, "[REDACTED]", valuesToRedact)
+		redactor := redaction.NewNamedRedactor(b.shell.Writer, b.Config.RedactedReplacement, valuesToRedact)
 		shellWriterLogger.Writer = redactor
+		b.redactors = append(b.redactors, redactor)
 		mux = append(mux, redactor)
 	}
 
 	return mux
 }
 
+// flushRedactors flushes the redactors returned by setupRedactors, and then
+// the entropy redactor they write through, if there is one
+func (b *Bootstrap) flushRedactors(mux redaction.RedactorMux) error {
+	err := mux.Flush()
+	if b.entropyRedactor != nil {
+		if entropyErr := b.entropyRedactor.Flush(); err == nil {
+			err = entropyErr
+		}
+	}
+	return err
+}
+
+// reportRedactions reports whether any values were redacted from the job
+// output. If FailOnRedaction is set and something was, it returns the exit
+// code the job should fail with, otherwise 0.
+func (b *Bootstrap) reportRedactions() int {
+	redactions := b.redactors.Redactions()
+	if b.entropyRedactor != nil {
+		redactions += b.entropyRedactor.Redactions()
+	}
+	if redactions == 0 {
+		if b.FailOnRedaction {
+			b.shell.Commentf("No values were redacted from the job output")
+		}
+		return 0
+	}
+
+	if !b.FailOnRedaction {
+		b.shell.Warningf("%d value(s) were redacted from the job output", redactions)
+		return 0
+	}
+
+	b.shell.Errorf("%d value(s) were redacted from the job output, failing the job because --fail-on-redaction is set", redactions)
+	return 1
+}
+
 type pluginCheckout struct {
 	*plugin.Plugin
 	*plugin.Definition
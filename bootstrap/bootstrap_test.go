@@ -79,6 +79,34 @@ func TestGetValuesToRedactEmpty(t *testing.T) {
 	assert.Equal(t, 0, len(valuesToRedact))
 }
 
+func TestGetValuesToRedactShortAndCommonValues(t *testing.T) {
+	t.Parallel()
+
+	redactConfig := []string{
+		"*_TOKEN",
+		"*_ENABLED",
+	}
+	environment := map[string]string{
+		// Only variables matching the patterns are considered, however
+		// long or common their values are
+		"CI":                   "true",
+		"BUILDKITE_BRANCH":     "production",
+		"BUILDKITE_REPO_OWNER": "AzureDiamond",
+
+		// Values shorter than RedactLengthMin aren't redacted, even when
+		// the variable matches
+		"FEATURE_ENABLED": "true",
+		"DISABLED_TOKEN":  "none",
+		"EMPTY_TOKEN":     "",
+
+		"GITHUB_TOKEN": "ghp_llamas",
+	}
+
+	valuesToRedact := redaction.GetValuesToRedact(shell.DiscardLogger, redactConfig, environment)
+
+	assert.Equal(t, []string{"ghp_llamas"}, valuesToRedact)
+}
+
 func TestStartTracing_NoTracingBackend(t *testing.T) {
 	var err error
 
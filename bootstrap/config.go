@@ -152,6 +152,12 @@ type Config struct {
 	// List of environment variable globs to redact from job output
 	RedactedVars []string
 
+	// What to replace redacted values with, see redaction.Replacement
+	RedactedReplacement string
+
+	// Whether to fail the job if any values were redacted from its output
+	FailOnRedaction bool
+
 	// Backend to use for tracing. If an empty string, no tracing will occur.
 	TracingBackend string
 
@@ -28,13 +28,9 @@ var commitPattern = bintest.MatchPattern(`(?ms)\Acommit [0-9a-f]+\n.*^Author:`)
 // Enable an experiment, returning a function to restore the previous state.
 // Usage: defer experimentWithUndo("foo")()
 func experimentWithUndo(name string) func() {
-	prev := experiments.IsEnabled(name)
+	restore := experiments.Snapshot()
 	experiments.Enable(name)
-	return func() {
-		if !prev {
-			experiments.Disable(name)
-		}
-	}
+	return restore
 }
 
 func TestCheckingOutGitHubPullRequestsWithGitMirrorsExperiment(t *testing.T) {
@@ -10,6 +10,7 @@ import (
 	"os/signal"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -113,15 +114,29 @@ type AgentStartConfig struct {
 	Spawn                       int      `cli:"spawn"`
 	SpawnWithPriority           bool     `cli:"spawn-with-priority"`
 	LogFormat                   string   `cli:"log-format"`
+	LogJSONRename               []string `cli:"log-json-rename" normalize:"list"`
+	LogJSONDrop                 []string `cli:"log-json-drop" normalize:"list"`
+	LogJSONStaticFields         []string `cli:"log-json-static-fields" normalize:"list"`
+	LogJSONFlatten              bool     `cli:"log-json-flatten"`
+	LogJSONFlattenDelimiter     string   `cli:"log-json-flatten-delimiter"`
+	LogStderrLevel              string   `cli:"log-stderr-level"`
+	LogFile                     string   `cli:"log-file" normalize:"filepath"`
+	LogFileFormat               string   `cli:"log-file-format"`
+	LogFileLevel                string   `cli:"log-file-level"`
 	CancelSignal                string   `cli:"cancel-signal"`
 	RedactedVars                []string `cli:"redacted-vars" normalize:"list"`
+	RedactedVarsFile            string   `cli:"redacted-vars-file" normalize:"filepath"`
+	RedactedReplacement         string   `cli:"redacted-replacement"`
+	FailOnRedaction             bool     `cli:"fail-on-redaction"`
 
 	// Global flags
-	Debug       bool     `cli:"debug"`
-	LogLevel    string   `cli:"log-level"`
-	NoColor     bool     `cli:"no-color"`
-	Experiments []string `cli:"experiment" normalize:"list"`
-	Profile     string   `cli:"profile"`
+	Debug        bool     `cli:"debug"`
+	Quiet        bool     `cli:"quiet"`
+	LogLevel     string   `cli:"log-level"`
+	PrefixFields []string `cli:"log-prefix-fields" normalize:"list"`
+	NoColor      bool     `cli:"no-color"`
+	Experiments  []string `cli:"experiment" normalize:"list"`
+	Profile      string   `cli:"profile"`
 
 	// API config
 	DebugHTTP bool   `cli:"debug-http"`
@@ -524,6 +539,57 @@ var AgentStartCommand = cli.Command{
 			EnvVar: "BUILDKITE_LOG_FORMAT",
 			Value:  "text",
 		},
+		cli.StringSliceFlag{
+			Name:   "log-json-rename",
+			Value:  &cli.StringSlice{},
+			Usage:  "With ′--log-format json′, rename the standard ts, level or msg fields, e.g. ′msg=message′",
+			EnvVar: "BUILDKITE_LOG_JSON_RENAME",
+		},
+		cli.StringSliceFlag{
+			Name:   "log-json-drop",
+			Value:  &cli.StringSlice{},
+			Usage:  "With ′--log-format json′, leave out the standard ts, level or msg fields",
+			EnvVar: "BUILDKITE_LOG_JSON_DROP",
+		},
+		cli.StringSliceFlag{
+			Name:   "log-json-static-fields",
+			Value:  &cli.StringSlice{},
+			Usage:  "With ′--log-format json′, add fields to every line, e.g. ′source=buildkite-agent′",
+			EnvVar: "BUILDKITE_LOG_JSON_STATIC_FIELDS",
+		},
+		cli.BoolFlag{
+			Name:   "log-json-flatten",
+			Usage:  "With ′--log-format json′, write structured fields as flat top-level keys, e.g. ′error.path′, rather than as nested objects",
+			EnvVar: "BUILDKITE_LOG_JSON_FLATTEN",
+		},
+		cli.StringFlag{
+			Name:   "log-json-flatten-delimiter",
+			Value:  ".",
+			Usage:  "With ′--log-json-flatten′, what to join the keys of nested fields with",
+			EnvVar: "BUILDKITE_LOG_JSON_FLATTEN_DELIMITER",
+		},
+		cli.StringFlag{
+			Name:   "log-stderr-level",
+			Usage:  "Send log entries at or above this level, e.g. ′warn′, to stderr, and the rest to stdout. By default text logs all go to stderr, and JSON logs to stdout",
+			EnvVar: "BUILDKITE_LOG_STDERR_LEVEL",
+		},
+		cli.StringFlag{
+			Name:   "log-file",
+			Usage:  "Also write the agent's logs to this file, at ′--log-file-level′ regardless of ′--log-level′",
+			EnvVar: "BUILDKITE_LOG_FILE",
+		},
+		cli.StringFlag{
+			Name:   "log-file-format",
+			Value:  "json",
+			Usage:  "The format of the ′--log-file′, either text or json",
+			EnvVar: "BUILDKITE_LOG_FILE_FORMAT",
+		},
+		cli.StringFlag{
+			Name:   "log-file-level",
+			Value:  "debug",
+			Usage:  "The lowest level of entries written to the ′--log-file′. ′--log-level′, ′--quiet′ and ′--debug′ only apply to the console",
+			EnvVar: "BUILDKITE_LOG_FILE_LEVEL",
+		},
 		cli.IntFlag{
 			Name:   "spawn",
 			Usage:  "The number of agents to spawn in parallel",
@@ -563,10 +629,15 @@ var AgentStartCommand = cli.Command{
 		// Global flags
 		NoColorFlag,
 		DebugFlag,
+		QuietFlag,
 		LogLevelFlag,
+		LogPrefixFieldsFlag,
 		ExperimentsFlag,
 		ProfileFlag,
 		RedactedVars,
+		RedactedVarsFile,
+		RedactedReplacement,
+		FailOnRedaction,
 
 		// Deprecated flags which will be removed in v4
 		cli.StringSliceFlag{
@@ -655,6 +726,11 @@ var AgentStartCommand = cli.Command{
 			os.Exit(1)
 		}
 
+		cfg.RedactedVars, err = mergeRedactedVarsFile(cfg.RedactedVars, cfg.RedactedVarsFile)
+		if err != nil {
+			l.Fatal("Failed to load redacted vars file: %v", err)
+		}
+
 		// Check if git-mirrors are enabled
 		if experiments.IsEnabled("git-mirrors") {
 			if cfg.GitMirrorsPath == "" {
@@ -792,6 +868,8 @@ var AgentStartCommand = cli.Command{
 			LogFormat:                  cfg.LogFormat,
 			Shell:                      cfg.Shell,
 			RedactedVars:               cfg.RedactedVars,
+			RedactedReplacement:        cfg.RedactedReplacement,
+			FailOnRedaction:            cfg.FailOnRedaction,
 			AcquireJob:                 cfg.AcquireJob,
 			TracingBackend:             cfg.TracingBackend,
 			TracingServiceName:         cfg.TracingServiceName,
@@ -827,6 +905,8 @@ var AgentStartCommand = cli.Command{
 		l.Notice("The agent source code can be found here: https://github.com/buildkite/agent")
 		l.Notice("For questions and support, email us at: hello@buildkite.com")
 
+		logEnabledExperiments(l)
+
 		if agentConf.ConfigPath != "" {
 			l.WithFields(logger.StringField(`path`, agentConf.ConfigPath)).Info("Configuration loaded")
 		}
@@ -876,7 +956,11 @@ var AgentStartCommand = cli.Command{
 		}
 
 		// Create the API client
-		client := api.NewClient(l, loadAPIClientConfig(cfg, "Token"))
+		apiConf, err := loadAPIClientConfig(cfg, "Token")
+		if err != nil {
+			l.Fatal("%s", err)
+		}
+		client := api.NewClient(l, apiConf)
 
 		// The registration request for all agents
 		registerReq := api.AgentRegisterRequest{
@@ -1006,6 +1090,19 @@ var AgentStartCommand = cli.Command{
 	},
 }
 
+// logEnabledExperiments logs the experiments that are enabled, if there are
+// any. JSON logs get them as a proper array, so they can be queried without
+// having to parse the message.
+func logEnabledExperiments(l logger.Logger) {
+	enabled := experiments.Enabled()
+	if len(enabled) == 0 {
+		return
+	}
+	sort.Strings(enabled)
+	l.WithFields(logger.JSONField("experiments", enabled)).
+		Notice("Enabled experiments: %s", strings.Join(enabled, ", "))
+}
+
 func handlePoolSignals(ctx context.Context, l logger.Logger, pool *agent.AgentPool) chan os.Signal {
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, os.Interrupt,
@@ -1,11 +1,14 @@
 package clicommand
 
 import (
+	"bytes"
+	"encoding/json"
 	"os"
 	"path/filepath"
 	"runtime"
 	"testing"
 
+	"github.com/buildkite/agent/v3/experiments"
 	"github.com/buildkite/agent/v3/logger"
 	"github.com/stretchr/testify/assert"
 )
@@ -116,3 +119,31 @@ func TestAgentShutdownHook(t *testing.T) {
 		assert.Equal(t, []string{}, log.Messages)
 	})
 }
+
+func TestLogEnabledExperiments(t *testing.T) {
+	defer experiments.Snapshot()()
+	for _, experiment := range experiments.Enabled() {
+		experiments.Disable(experiment)
+	}
+
+	var buf bytes.Buffer
+	l := logger.NewConsoleLogger(logger.NewJSONPrinter(&buf), func(int) {})
+
+	// Nothing is logged without any experiments
+	logEnabledExperiments(l)
+	assert.Empty(t, buf.String())
+
+	experiments.Enable("llamas")
+	experiments.Enable("alpacas")
+	logEnabledExperiments(l)
+
+	var line struct {
+		Msg         string   `json:"msg"`
+		Experiments []string `json:"experiments"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("json.Unmarshal(%q) error = %v", buf.String(), err)
+	}
+	assert.Equal(t, "Enabled experiments: alpacas, llamas", line.Msg)
+	assert.Equal(t, []string{"alpacas", "llamas"}, line.Experiments)
+}
@@ -62,17 +62,20 @@ type AnnotateConfig struct {
 	Job     string `cli:"job" validate:"required"`
 
 	// Global flags
-	Debug       bool     `cli:"debug"`
-	LogLevel    string   `cli:"log-level"`
-	NoColor     bool     `cli:"no-color"`
-	Experiments []string `cli:"experiment" normalize:"list"`
-	Profile     string   `cli:"profile"`
+	Debug        bool     `cli:"debug"`
+	Quiet        bool     `cli:"quiet"`
+	LogLevel     string   `cli:"log-level"`
+	PrefixFields []string `cli:"log-prefix-fields" normalize:"list"`
+	NoColor      bool     `cli:"no-color"`
+	Experiments  []string `cli:"experiment" normalize:"list"`
+	Profile      string   `cli:"profile"`
 
 	// API config
 	DebugHTTP        bool   `cli:"debug-http"`
 	AgentAccessToken string `cli:"agent-access-token" validate:"required"`
 	Endpoint         string `cli:"endpoint" validate:"required"`
 	NoHTTP2          bool   `cli:"no-http2"`
+	MaxAPIWait       string `cli:"max-api-wait"`
 }
 
 var AnnotateCommand = cli.Command{
@@ -106,12 +109,15 @@ var AnnotateCommand = cli.Command{
 		AgentAccessTokenFlag,
 		EndpointFlag,
 		NoHTTP2Flag,
+		MaxAPIWaitFlag,
 		DebugHTTPFlag,
 
 		// Global flags
 		NoColorFlag,
 		DebugFlag,
+		QuietFlag,
 		LogLevelFlag,
+		LogPrefixFieldsFlag,
 		ExperimentsFlag,
 		ProfileFlag,
 	},
@@ -167,7 +173,11 @@ func annotate(ctx context.Context, cfg AnnotateConfig, l logger.Logger) error {
 	}
 
 	// Create the API client
-	client := api.NewClient(l, loadAPIClientConfig(cfg, "AgentAccessToken"))
+	apiConf, err := loadAPIClientConfig(cfg, "AgentAccessToken")
+	if err != nil {
+		return err
+	}
+	client := api.NewClient(l, apiConf)
 
 	// Create the annotation we'll send to the Buildkite API
 	annotation := &api.Annotation{
@@ -177,8 +187,12 @@ func annotate(ctx context.Context, cfg AnnotateConfig, l logger.Logger) error {
 		Append:  cfg.Append,
 	}
 
+	// Bound the whole operation, including retries, by --max-api-wait
+	ctx, cancel := client.OperationContext(ctx)
+	defer cancel()
+
 	// Retry the annotation a few times before giving up
-	err := roko.NewRetrier(
+	err = roko.NewRetrier(
 		roko.WithMaxAttempts(5),
 		roko.WithStrategy(roko.Constant(1*time.Second)),
 		roko.WithJitter(),
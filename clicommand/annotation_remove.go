@@ -33,17 +33,20 @@ type AnnotationRemoveConfig struct {
 	Job     string `cli:"job" validate:"required"`
 
 	// Global flags
-	Debug       bool     `cli:"debug"`
-	LogLevel    string   `cli:"log-level"`
-	NoColor     bool     `cli:"no-color"`
-	Experiments []string `cli:"experiment" normalize:"list"`
-	Profile     string   `cli:"profile"`
+	Debug        bool     `cli:"debug"`
+	Quiet        bool     `cli:"quiet"`
+	LogLevel     string   `cli:"log-level"`
+	PrefixFields []string `cli:"log-prefix-fields" normalize:"list"`
+	NoColor      bool     `cli:"no-color"`
+	Experiments  []string `cli:"experiment" normalize:"list"`
+	Profile      string   `cli:"profile"`
 
 	// API config
 	DebugHTTP        bool   `cli:"debug-http"`
 	AgentAccessToken string `cli:"agent-access-token" validate:"required"`
 	Endpoint         string `cli:"endpoint" validate:"required"`
 	NoHTTP2          bool   `cli:"no-http2"`
+	MaxAPIWait       string `cli:"max-api-wait"`
 }
 
 var AnnotationRemoveCommand = cli.Command{
@@ -68,12 +71,15 @@ var AnnotationRemoveCommand = cli.Command{
 		AgentAccessTokenFlag,
 		EndpointFlag,
 		NoHTTP2Flag,
+		MaxAPIWaitFlag,
 		DebugHTTPFlag,
 
 		// Global flags
 		NoColorFlag,
 		DebugFlag,
+		QuietFlag,
 		LogLevelFlag,
+		LogPrefixFieldsFlag,
 		ExperimentsFlag,
 		ProfileFlag,
 	},
@@ -102,7 +108,15 @@ var AnnotationRemoveCommand = cli.Command{
 		defer done()
 
 		// Create the API client
-		client := api.NewClient(l, loadAPIClientConfig(cfg, "AgentAccessToken"))
+		apiConf, err := loadAPIClientConfig(cfg, "AgentAccessToken")
+		if err != nil {
+			l.Fatal("%s", err)
+		}
+		client := api.NewClient(l, apiConf)
+
+		// Bound the whole operation, including retries, by --max-api-wait
+		ctx, cancel := client.OperationContext(ctx)
+		defer cancel()
 
 		// Retry the removal a few times before giving up
 		err = roko.NewRetrier(
@@ -0,0 +1,160 @@
+package clicommand
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/buildkite/agent/v3/agent"
+	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/agent/v3/cliconfig"
+	"github.com/urfave/cli"
+)
+
+const backfillHelpDescription = `Usage:
+
+   buildkite-agent artifact backfill [options] [query]
+
+Description:
+
+   Records SHA-256 checksums for artifacts in a build that were uploaded before
+   they were recorded, so that they can be checked when they're downloaded or
+   verified. Each artifact without one is streamed from where it's stored and
+   hashed as it's read, without being saved anywhere, and its checksum is
+   recorded. Artifacts that already have a SHA-256 checksum are skipped.
+
+   Artifacts that have a SHA-1 checksum are only updated if what's stored
+   still matches it. Progress is logged after each batch of artifacts, and the
+   command exits non-zero if any artifact couldn't be backfilled.
+
+Example:
+
+   $ buildkite-agent artifact backfill --build xxx
+
+   You can scope the backfill to a particular job or step, and to artifacts
+   matching a query:
+
+   $ buildkite-agent artifact backfill "pkg/*.tar.gz" --step "tests" --build xxx`
+
+type ArtifactBackfillConfig struct {
+	Query              string `cli:"arg:0" label:"artifact search query"`
+	Step               string `cli:"step"`
+	Build              string `cli:"build" validate:"required"`
+	IncludeRetriedJobs bool   `cli:"include-retried-jobs"`
+	BatchSize          int    `cli:"batch-size"`
+
+	// Global flags
+	Debug        bool     `cli:"debug"`
+	Quiet        bool     `cli:"quiet"`
+	LogLevel     string   `cli:"log-level"`
+	PrefixFields []string `cli:"log-prefix-fields" normalize:"list"`
+	NoColor      bool     `cli:"no-color"`
+	Experiments  []string `cli:"experiment" normalize:"list"`
+	Profile      string   `cli:"profile"`
+
+	// API config
+	DebugHTTP        bool   `cli:"debug-http"`
+	AgentAccessToken string `cli:"agent-access-token" validate:"required"`
+	Endpoint         string `cli:"endpoint" validate:"required"`
+	NoHTTP2          bool   `cli:"no-http2"`
+	MaxAPIWait       string `cli:"max-api-wait"`
+}
+
+var ArtifactBackfillCommand = cli.Command{
+	Name:        "backfill",
+	Usage:       "Records SHA-256 checksums for artifacts uploaded without them",
+	Description: backfillHelpDescription,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "step",
+			Value: "",
+			Usage: "Scope the search to a particular step by using either its name or job ID",
+		},
+		cli.StringFlag{
+			Name:   "build",
+			Value:  "",
+			EnvVar: "BUILDKITE_BUILD_ID",
+			Usage:  "The build that the artifacts were uploaded to",
+		},
+		cli.BoolFlag{
+			Name:   "include-retried-jobs",
+			EnvVar: "BUILDKITE_AGENT_INCLUDE_RETRIED_JOBS",
+			Usage:  "Include artifacts from retried jobs in the search",
+		},
+		cli.IntFlag{
+			Name:  "batch-size",
+			Value: agent.DefaultArtifactBackfillBatchSize,
+			Usage: "How many artifacts to backfill between progress reports",
+		},
+
+		// API Flags
+		AgentAccessTokenFlag,
+		EndpointFlag,
+		NoHTTP2Flag,
+		MaxAPIWaitFlag,
+		DebugHTTPFlag,
+
+		// Global flags
+		NoColorFlag,
+		DebugFlag,
+		QuietFlag,
+		LogLevelFlag,
+		LogPrefixFieldsFlag,
+		ExperimentsFlag,
+		ProfileFlag,
+	},
+	Action: func(c *cli.Context) {
+		ctx := context.Background()
+
+		// The configuration will be loaded into this struct
+		cfg := ArtifactBackfillConfig{}
+
+		loader := cliconfig.Loader{CLI: c, Config: &cfg}
+		warnings, err := loader.Load()
+		if err != nil {
+			fmt.Printf("%s", err)
+			os.Exit(1)
+		}
+
+		l := CreateLogger(&cfg)
+
+		// Now that we have a logger, log out the warnings that loading config generated
+		for _, warning := range warnings {
+			l.Warn("%s", warning)
+		}
+
+		// Setup any global configuration options
+		done := HandleGlobalFlags(l, cfg)
+		defer done()
+
+		query := cfg.Query
+		if query == "" {
+			query = "*"
+		}
+
+		// Create the API client
+		apiConf, err := loadAPIClientConfig(cfg, "AgentAccessToken")
+		if err != nil {
+			l.Fatal("%s", err)
+		}
+		client := api.NewClient(l, apiConf)
+
+		// Setup the backfiller
+		backfiller := agent.NewArtifactBackfiller(l, client, agent.ArtifactBackfillerConfig{
+			Query:              query,
+			BuildID:            cfg.Build,
+			Step:               cfg.Step,
+			IncludeRetriedJobs: cfg.IncludeRetriedJobs,
+			BatchSize:          cfg.BatchSize,
+			DebugHTTP:          cfg.DebugHTTP,
+		})
+
+		// Backfill the checksums
+		summary, err := backfiller.Backfill(ctx)
+		if err != nil {
+			l.Fatal("Failed to backfill artifact checksums: %s", err)
+		}
+
+		l.Info("Recorded %d checksums, skipped %d artifacts that already had one", summary.Updated, summary.Skipped)
+	},
+}
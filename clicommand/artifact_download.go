@@ -46,27 +46,41 @@ Example:
 
    $ buildkite-agent artifact download "pkg/*.tar.gz" . --step "tests" --build xxx
 
-   You can also use the step's jobs id (provided by the environment variable $BUILDKITE_JOB_ID)`
+   You can also use the step's jobs id (provided by the environment variable $BUILDKITE_JOB_ID)
+
+   To fetch exactly the bytes you expect, regardless of what they're named,
+   you can select artifacts by their SHA-256 checksum:
+
+   $ buildkite-agent artifact download "*" . --sha256 xxx --build xxx`
 
 type ArtifactDownloadConfig struct {
 	Query              string `cli:"arg:0" label:"artifact search query" validate:"required"`
 	Destination        string `cli:"arg:1" label:"artifact download path" validate:"required"`
 	Step               string `cli:"step"`
 	Build              string `cli:"build" validate:"required"`
+	Sha256Sum          string `cli:"sha256"`
 	IncludeRetriedJobs bool   `cli:"include-retried-jobs"`
+	PreserveModes      bool   `cli:"preserve-modes"`
+	CheckDiskSpace     bool   `cli:"check-disk-space"`
 
 	// Global flags
-	Debug       bool     `cli:"debug"`
-	LogLevel    string   `cli:"log-level"`
-	NoColor     bool     `cli:"no-color"`
-	Experiments []string `cli:"experiment" normalize:"list"`
-	Profile     string   `cli:"profile"`
+	Debug        bool     `cli:"debug"`
+	Quiet        bool     `cli:"quiet"`
+	LogLevel     string   `cli:"log-level"`
+	PrefixFields []string `cli:"log-prefix-fields" normalize:"list"`
+	NoColor      bool     `cli:"no-color"`
+	Experiments  []string `cli:"experiment" normalize:"list"`
+	Profile      string   `cli:"profile"`
 
 	// API config
 	DebugHTTP        bool   `cli:"debug-http"`
 	AgentAccessToken string `cli:"agent-access-token" validate:"required"`
 	Endpoint         string `cli:"endpoint" validate:"required"`
 	NoHTTP2          bool   `cli:"no-http2"`
+	MaxAPIWait       string `cli:"max-api-wait"`
+
+	APIMaxIdleConnsPerHost int `cli:"api-max-idle-conns-per-host"`
+	APIMaxConnsPerHost     int `cli:"api-max-conns-per-host"`
 }
 
 var ArtifactDownloadCommand = cli.Command{
@@ -85,22 +99,42 @@ var ArtifactDownloadCommand = cli.Command{
 			EnvVar: "BUILDKITE_BUILD_ID",
 			Usage:  "The build that the artifacts were uploaded to",
 		},
+		cli.StringFlag{
+			Name:  "sha256",
+			Value: "",
+			Usage: "Only download artifacts with this SHA-256 checksum, failing if there aren't any. Downloaded files are verified against it",
+		},
 		cli.BoolFlag{
 			Name:   "include-retried-jobs",
 			EnvVar: "BUILDKITE_AGENT_INCLUDE_RETRIED_JOBS",
 			Usage:  "Include artifacts from retried jobs in the search",
 		},
+		cli.BoolFlag{
+			Name:   "preserve-modes",
+			EnvVar: "BUILDKITE_ARTIFACT_PRESERVE_MODES",
+			Usage:  "Set the permissions of downloaded files to those they were uploaded with, such as the executable bit. On Windows, only read-only files are preserved",
+		},
+		cli.BoolTFlag{
+			Name:   "check-disk-space",
+			EnvVar: "BUILDKITE_ARTIFACT_CHECK_DISK_SPACE",
+			Usage:  "Check that there's enough free space at the destination for all of the artifacts before downloading any of them. Use ′--check-disk-space=false′ to skip the check",
+		},
 
 		// API Flags
 		AgentAccessTokenFlag,
 		EndpointFlag,
 		NoHTTP2Flag,
+		MaxAPIWaitFlag,
+		APIMaxIdleConnsPerHostFlag,
+		APIMaxConnsPerHostFlag,
 		DebugHTTPFlag,
 
 		// Global flags
 		NoColorFlag,
 		DebugFlag,
+		QuietFlag,
 		LogLevelFlag,
+		LogPrefixFieldsFlag,
 		ExperimentsFlag,
 		ProfileFlag,
 	},
@@ -129,7 +163,11 @@ var ArtifactDownloadCommand = cli.Command{
 		defer done()
 
 		// Create the API client
-		client := api.NewClient(l, loadAPIClientConfig(cfg, "AgentAccessToken"))
+		apiConf, err := loadAPIClientConfig(cfg, "AgentAccessToken")
+		if err != nil {
+			l.Fatal("%s", err)
+		}
+		client := api.NewClient(l, apiConf)
 
 		// Setup the downloader
 		downloader := agent.NewArtifactDownloader(l, client, agent.ArtifactDownloaderConfig{
@@ -137,8 +175,11 @@ var ArtifactDownloadCommand = cli.Command{
 			Destination:        cfg.Destination,
 			BuildID:            cfg.Build,
 			Step:               cfg.Step,
+			Sha256Sum:          cfg.Sha256Sum,
 			IncludeRetriedJobs: cfg.IncludeRetriedJobs,
 			DebugHTTP:          cfg.DebugHTTP,
+			PreserveModes:      cfg.PreserveModes,
+			CheckDiskSpace:     cfg.CheckDiskSpace,
 		})
 
 		// Download the artifacts
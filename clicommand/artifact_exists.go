@@ -0,0 +1,147 @@
+package clicommand
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/buildkite/agent/v3/agent"
+	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/agent/v3/cliconfig"
+	"github.com/urfave/cli"
+)
+
+const existsHelpDescription = `Usage:
+
+   buildkite-agent artifact exists [options] <query>
+
+Description:
+
+   Checks whether any artifacts matching <query> have been uploaded to a build.
+   The paths of any matching artifacts are printed, one per line, and the
+   command exits with a status of 0. If nothing matches, it exits with a
+   status of 100.
+
+   Note: You need to ensure that your search query is surrounded by quotes if
+   using a wild card as the built-in shell path globbing will provide files,
+   which will break the search.
+
+Example:
+
+   $ buildkite-agent artifact exists "pkg/*.tar.gz" --build xxx
+
+   This can be used to skip regenerating artifacts that an earlier job has
+   already uploaded:
+
+   $ buildkite-agent artifact exists "pkg/*.tar.gz" --step "build" || make package`
+
+type ArtifactExistsConfig struct {
+	Query              string `cli:"arg:0" label:"artifact search query" validate:"required"`
+	Step               string `cli:"step"`
+	Build              string `cli:"build" validate:"required"`
+	IncludeRetriedJobs bool   `cli:"include-retried-jobs"`
+
+	// Global flags
+	Debug        bool     `cli:"debug"`
+	Quiet        bool     `cli:"quiet"`
+	LogLevel     string   `cli:"log-level"`
+	PrefixFields []string `cli:"log-prefix-fields" normalize:"list"`
+	NoColor      bool     `cli:"no-color"`
+	Experiments  []string `cli:"experiment" normalize:"list"`
+	Profile      string   `cli:"profile"`
+
+	// API config
+	DebugHTTP        bool   `cli:"debug-http"`
+	AgentAccessToken string `cli:"agent-access-token" validate:"required"`
+	Endpoint         string `cli:"endpoint" validate:"required"`
+	NoHTTP2          bool   `cli:"no-http2"`
+	MaxAPIWait       string `cli:"max-api-wait"`
+}
+
+var ArtifactExistsCommand = cli.Command{
+	Name:        "exists",
+	Usage:       "Checks whether artifacts matching a query have been uploaded",
+	Description: existsHelpDescription,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "step",
+			Value: "",
+			Usage: "Scope the search to a particular step by using either its name or job ID",
+		},
+		cli.StringFlag{
+			Name:   "build",
+			Value:  "",
+			EnvVar: "BUILDKITE_BUILD_ID",
+			Usage:  "The build that the artifacts were uploaded to",
+		},
+		cli.BoolFlag{
+			Name:   "include-retried-jobs",
+			EnvVar: "BUILDKITE_AGENT_INCLUDE_RETRIED_JOBS",
+			Usage:  "Include artifacts from retried jobs in the search",
+		},
+
+		// API Flags
+		AgentAccessTokenFlag,
+		EndpointFlag,
+		NoHTTP2Flag,
+		MaxAPIWaitFlag,
+		DebugHTTPFlag,
+
+		// Global flags
+		NoColorFlag,
+		DebugFlag,
+		QuietFlag,
+		LogLevelFlag,
+		LogPrefixFieldsFlag,
+		ExperimentsFlag,
+		ProfileFlag,
+	},
+	Action: func(c *cli.Context) {
+		ctx := context.Background()
+
+		// The configuration will be loaded into this struct
+		cfg := ArtifactExistsConfig{}
+
+		loader := cliconfig.Loader{CLI: c, Config: &cfg}
+		warnings, err := loader.Load()
+		if err != nil {
+			fmt.Printf("%s", err)
+			os.Exit(1)
+		}
+
+		l := CreateLogger(&cfg)
+
+		// Now that we have a logger, log out the warnings that loading config generated
+		for _, warning := range warnings {
+			l.Warn("%s", warning)
+		}
+
+		// Setup any global configuration options
+		done := HandleGlobalFlags(l, cfg)
+		defer done()
+
+		// Create the API client
+		apiConf, err := loadAPIClientConfig(cfg, "AgentAccessToken")
+		if err != nil {
+			l.Fatal("%s", err)
+		}
+		client := api.NewClient(l, apiConf)
+
+		// Only finished artifacts count, and each path only needs printing once
+		searcher := agent.NewArtifactSearcher(l, client, cfg.Build)
+		artifacts, err := searcher.Search(ctx, cfg.Query, cfg.Step, cfg.IncludeRetriedJobs, false)
+		if err != nil {
+			l.Fatal("Failed to search for artifacts: %s", err)
+		}
+
+		// If nothing matched, exit with an error
+		if len(artifacts) == 0 {
+			l.Info("No matches found for %q", cfg.Query)
+			os.Exit(100)
+		}
+
+		for _, artifact := range artifacts {
+			fmt.Println(artifact.Path)
+		}
+	},
+}
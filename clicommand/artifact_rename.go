@@ -0,0 +1,134 @@
+package clicommand
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/buildkite/agent/v3/agent"
+	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/agent/v3/cliconfig"
+	"github.com/urfave/cli"
+)
+
+const renameHelpDescription = `Usage:
+
+   buildkite-agent artifact rename [options] <artifact-id> <path>
+
+Description:
+
+   Changes the path of an artifact that has already been uploaded, without
+   re-uploading the file. Only the stored path is changed.
+
+   The rename fails if another artifact in the build already has the new path.
+
+Example:
+
+   $ buildkite-agent artifact rename 0183c9e0-2e37-4cf4-9f4b-3e1f3d1d6b2e "reports/coverage.html"
+
+   The artifact ID can be found using 'buildkite-agent artifact search' with
+   the %i format specifier.`
+
+type ArtifactRenameConfig struct {
+	ArtifactID string `cli:"arg:0" label:"artifact id" validate:"required"`
+	Path       string `cli:"arg:1" label:"new artifact path" validate:"required"`
+	Job        string `cli:"job" validate:"required"`
+	Build      string `cli:"build" validate:"required"`
+
+	// Global flags
+	Debug        bool     `cli:"debug"`
+	Quiet        bool     `cli:"quiet"`
+	LogLevel     string   `cli:"log-level"`
+	PrefixFields []string `cli:"log-prefix-fields" normalize:"list"`
+	NoColor      bool     `cli:"no-color"`
+	Experiments  []string `cli:"experiment" normalize:"list"`
+	Profile      string   `cli:"profile"`
+
+	// API config
+	DebugHTTP        bool   `cli:"debug-http"`
+	AgentAccessToken string `cli:"agent-access-token" validate:"required"`
+	Endpoint         string `cli:"endpoint" validate:"required"`
+	NoHTTP2          bool   `cli:"no-http2"`
+	MaxAPIWait       string `cli:"max-api-wait"`
+}
+
+var ArtifactRenameCommand = cli.Command{
+	Name:        "rename",
+	Usage:       "Renames an artifact that has already been uploaded",
+	Description: renameHelpDescription,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:   "job",
+			Value:  "",
+			Usage:  "The job that uploaded the artifact",
+			EnvVar: "BUILDKITE_JOB_ID",
+		},
+		cli.StringFlag{
+			Name:   "build",
+			Value:  "",
+			EnvVar: "BUILDKITE_BUILD_ID",
+			Usage:  "The build that the artifact was uploaded to",
+		},
+
+		// API Flags
+		AgentAccessTokenFlag,
+		EndpointFlag,
+		NoHTTP2Flag,
+		MaxAPIWaitFlag,
+		DebugHTTPFlag,
+
+		// Global flags
+		NoColorFlag,
+		DebugFlag,
+		QuietFlag,
+		LogLevelFlag,
+		LogPrefixFieldsFlag,
+		ExperimentsFlag,
+		ProfileFlag,
+	},
+	Action: func(c *cli.Context) {
+		ctx := context.Background()
+
+		// The configuration will be loaded into this struct
+		cfg := ArtifactRenameConfig{}
+
+		loader := cliconfig.Loader{CLI: c, Config: &cfg}
+		warnings, err := loader.Load()
+		if err != nil {
+			fmt.Printf("%s", err)
+			os.Exit(1)
+		}
+
+		l := CreateLogger(&cfg)
+
+		// Now that we have a logger, log out the warnings that loading config generated
+		for _, warning := range warnings {
+			l.Warn("%s", warning)
+		}
+
+		// Setup any global configuration options
+		done := HandleGlobalFlags(l, cfg)
+		defer done()
+
+		// Create the API client
+		apiConf, err := loadAPIClientConfig(cfg, "AgentAccessToken")
+		if err != nil {
+			l.Fatal("%s", err)
+		}
+		client := api.NewClient(l, apiConf)
+
+		renamer := agent.NewArtifactRenamer(l, client, agent.ArtifactRenamerConfig{
+			BuildID:    cfg.Build,
+			JobID:      cfg.Job,
+			ArtifactID: cfg.ArtifactID,
+			Path:       cfg.Path,
+		})
+
+		artifact, err := renamer.Rename(ctx)
+		if err != nil {
+			l.Fatal("Failed to rename artifact: %s", err)
+		}
+
+		l.Info("Renamed artifact %s to %q", cfg.ArtifactID, artifact.Path)
+	},
+}
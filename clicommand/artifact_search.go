@@ -44,7 +44,11 @@ Example:
 
    $ buildkite-agent artifact search "*" -format "%p\n"
 
-   The above will return a list of filenames separated by newline.`
+   The above will return a list of filenames separated by newline.
+
+   To list the artifacts as CSV, with a header row, use -format csv:
+
+   $ buildkite-agent artifact search "*" -format csv`
 
 type ArtifactSearchConfig struct {
 	Query              string `cli:"arg:0" label:"artifact search query" validate:"required"`
@@ -55,17 +59,20 @@ type ArtifactSearchConfig struct {
 	PrintFormat        string `cli:"format"`
 
 	// Global flags
-	Debug       bool     `cli:"debug"`
-	LogLevel    string   `cli:"log-level"`
-	NoColor     bool     `cli:"no-color"`
-	Experiments []string `cli:"experiment" normalize:"list"`
-	Profile     string   `cli:"profile"`
+	Debug        bool     `cli:"debug"`
+	Quiet        bool     `cli:"quiet"`
+	LogLevel     string   `cli:"log-level"`
+	PrefixFields []string `cli:"log-prefix-fields" normalize:"list"`
+	NoColor      bool     `cli:"no-color"`
+	Experiments  []string `cli:"experiment" normalize:"list"`
+	Profile      string   `cli:"profile"`
 
 	// API config
 	DebugHTTP        bool   `cli:"debug-http"`
 	AgentAccessToken string `cli:"agent-access-token" validate:"required"`
 	Endpoint         string `cli:"endpoint" validate:"required"`
 	NoHTTP2          bool   `cli:"no-http2"`
+	MaxAPIWait       string `cli:"max-api-wait"`
 }
 
 var ArtifactSearchCommand = cli.Command{
@@ -119,19 +126,22 @@ Format specifiers:
 		cli.StringFlag{
 			Name:  "format",
 			Value: "%j %p %c\n",
-			Usage: "Output formatting of results. See below for listing of available format specifiers.",
+			Usage: "Output formatting of results. See below for listing of available format specifiers, or use ′csv′ for CSV with path, size, sha256 and url columns.",
 		},
 
 		// API Flags
 		AgentAccessTokenFlag,
 		EndpointFlag,
 		NoHTTP2Flag,
+		MaxAPIWaitFlag,
 		DebugHTTPFlag,
 
 		// Global flags
 		NoColorFlag,
 		DebugFlag,
+		QuietFlag,
 		LogLevelFlag,
+		LogPrefixFieldsFlag,
 		ExperimentsFlag,
 		ProfileFlag,
 	},
@@ -160,7 +170,11 @@ Format specifiers:
 		defer done()
 
 		// Create the API client
-		client := api.NewClient(l, loadAPIClientConfig(cfg, "AgentAccessToken"))
+		apiConf, err := loadAPIClientConfig(cfg, "AgentAccessToken")
+		if err != nil {
+			return err
+		}
+		client := api.NewClient(l, apiConf)
 
 		// Setup the searcher and try get the artifacts
 		searcher := agent.NewArtifactSearcher(l, client, cfg.Build)
@@ -177,6 +191,10 @@ Format specifiers:
 			}
 		}
 
+		if cfg.PrintFormat == "csv" {
+			return agent.WriteArtifactsCSV(os.Stdout, artifacts)
+		}
+
 		for _, artifact := range artifacts {
 			r := strings.NewReplacer(
 				"%p", artifact.Path,
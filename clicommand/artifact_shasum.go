@@ -58,17 +58,20 @@ type ArtifactShasumConfig struct {
 	IncludeRetriedJobs bool   `cli:"include-retried-jobs"`
 
 	// Global flags
-	Debug       bool     `cli:"debug"`
-	LogLevel    string   `cli:"log-level"`
-	NoColor     bool     `cli:"no-color"`
-	Experiments []string `cli:"experiment" normalize:"list"`
-	Profile     string   `cli:"profile"`
+	Debug        bool     `cli:"debug"`
+	Quiet        bool     `cli:"quiet"`
+	LogLevel     string   `cli:"log-level"`
+	PrefixFields []string `cli:"log-prefix-fields" normalize:"list"`
+	NoColor      bool     `cli:"no-color"`
+	Experiments  []string `cli:"experiment" normalize:"list"`
+	Profile      string   `cli:"profile"`
 
 	// API config
 	DebugHTTP        bool   `cli:"debug-http"`
 	AgentAccessToken string `cli:"agent-access-token" validate:"required"`
 	Endpoint         string `cli:"endpoint" validate:"required"`
 	NoHTTP2          bool   `cli:"no-http2"`
+	MaxAPIWait       string `cli:"max-api-wait"`
 }
 
 var ArtifactShasumCommand = cli.Command{
@@ -101,12 +104,15 @@ var ArtifactShasumCommand = cli.Command{
 		AgentAccessTokenFlag,
 		EndpointFlag,
 		NoHTTP2Flag,
+		MaxAPIWaitFlag,
 		DebugHTTPFlag,
 
 		// Global flags
 		NoColorFlag,
 		DebugFlag,
+		QuietFlag,
 		LogLevelFlag,
+		LogPrefixFieldsFlag,
 		ExperimentsFlag,
 		ProfileFlag,
 	},
@@ -142,7 +148,11 @@ var ArtifactShasumCommand = cli.Command{
 
 func searchAndPrintShaSum(ctx context.Context, cfg ArtifactShasumConfig, l logger.Logger, stdout io.Writer) error {
 	// Create the API client
-	client := api.NewClient(l, loadAPIClientConfig(cfg, "AgentAccessToken"))
+	apiConf, err := loadAPIClientConfig(cfg, "AgentAccessToken")
+	if err != nil {
+		return err
+	}
+	client := api.NewClient(l, apiConf)
 
 	// Find the artifact we want to show the SHASUM for
 	searcher := agent.NewArtifactSearcher(l, client, cfg.Build)
@@ -2,8 +2,14 @@ package clicommand
 
 import (
 	"context"
+	"crypto"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/buildkite/agent/v3/agent"
 	"github.com/buildkite/agent/v3/api"
@@ -23,6 +29,24 @@ Description:
    built-in shell path globbing will provide the files, which is currently not
    supported.
 
+   Files matched by relative paths are uploaded at their path relative to the
+   working directory. Files matched by absolute paths are uploaded at their
+   whole absolute path, without the leading slash, unless --strip-glob-base is
+   set, in which case they're relative to the directory the glob starts from
+   (or the file's directory, for paths that aren't globs).
+
+   With --staging-dir, relative paths are resolved from the staging directory
+   instead of the working directory, and files are uploaded relative to it.
+   Absolute paths, and the paths given with --root, aren't affected.
+
+   With --flatten-strategy, files are uploaded at just their file names,
+   without their directories, for tools that expect a flat directory of
+   artifacts. Given a/report.xml, b/report.xml and b/summary.txt:
+
+   keep-paths          a/report.xml, b/report.xml, b/summary.txt (the default)
+   error-on-collision  fails, since there would be two report.xml files
+   suffix              report.xml, report-1.xml, summary.txt
+
    You can specify an alternate destination on Amazon S3, Google Cloud Storage
    or Artifactory as per the examples below. This may be specified in the
    'destination' argument, or in the 'BUILDKITE_ARTIFACT_UPLOAD_DESTINATION'
@@ -55,7 +79,115 @@ Example:
    $ export BUILDKITE_ARTIFACTORY_URL=http://my-artifactory-instance.com/artifactory
    $ export BUILDKITE_ARTIFACTORY_USER=carol-danvers
    $ export BUILDKITE_ARTIFACTORY_PASSWORD=xxx
-   $ buildkite-agent artifact upload "log/**/*.log" rt://name-of-your-artifactory-repo/$BUILDKITE_JOB_ID`
+   $ buildkite-agent artifact upload "log/**/*.log" rt://name-of-your-artifactory-repo/$BUILDKITE_JOB_ID
+
+   To upload to more than one destination at once, separate them with a
+   semicolon. Use 'buildkite' for the Buildkite-managed storage:
+
+   $ buildkite-agent artifact upload "log/**/*.log" "s3://name-of-your-s3-bucket/$BUILDKITE_JOB_ID;buildkite"
+
+   With --content-addressed, files uploaded to your own storage are stored
+   at the path of their SHA-256 checksum instead, so identical files are only
+   stored once, and 'buildkite-agent artifact download' finds them by the
+   path they were uploaded as:
+
+   $ buildkite-agent artifact upload --content-addressed "log/**/*.log" s3://name-of-your-s3-bucket/cas
+
+Summary:
+
+   Once finished, a single summary line is always printed to stderr, whatever
+   the log format. Its format is stable, so it's safe to parse:
+
+   buildkite-agent: uploaded=42 skipped=3 failed=1 bytes=10485760 duration=4.2s
+
+Progress:
+
+   With --progress-json, the progress of the upload is written to stdout (or
+   --progress-file) as it goes, as one JSON object per line:
+
+   {"artifacts":12,"total_artifacts":42,"bytes":5242880,"total_bytes":10485760,"percent":50}
+
+Verifying:
+
+   With --verify-only, the matched files are read and hashed, and printed to
+   stdout as their SHA-256, size and path, without uploading anything. The
+   Agent API isn't contacted, so no job or agent access token is needed:
+
+   $ buildkite-agent artifact upload --verify-only "log/**/*.log"
+
+   With --checksum-file, the checksums are also written to a file in the
+   format of sha256sum, whether or not the files are uploaded:
+
+   $ buildkite-agent artifact upload --verify-only --checksum-file SHA256SUMS "log/**/*.log"
+   $ sha256sum -c SHA256SUMS
+
+Listing matches:
+
+   With --list-only, the paths the matched files would be uploaded as are
+   printed to stdout, one per line, without reading, hashing or uploading
+   them, or contacting the Agent API. It only fails if the paths can't be
+   resolved, not if nothing matches:
+
+   $ buildkite-agent artifact upload --list-only "log/**/*.log"
+
+Estimating:
+
+   With --estimate, the matched files are found and their sizes added up,
+   without reading, hashing or uploading them, or contacting the Agent API. A
+   rough duration is worked out from --estimate-bandwidth or
+   --max-bytes-per-second, whichever is lower:
+
+   $ buildkite-agent artifact upload --estimate --estimate-bandwidth 10485760 "log/**/*.log"
+   buildkite-agent: files=42 bytes=104857600 duration=10s
+
+Listing:
+
+   With --format csv, the uploaded artifacts are printed to stdout as CSV once
+   they've all been uploaded, with a header row, for use in spreadsheets:
+
+   path,size,sha256,url
+
+Attesting:
+
+   With --attest, an in-toto Statement listing the SHA-256 checksum and size
+   of every other artifact is also uploaded, as attestation.intoto.json (or
+   --attestation-name). With --attestation-key, it's signed with the key and
+   uploaded in a DSSE envelope instead:
+
+   $ buildkite-agent artifact upload --attestation-key signing-key.pem "dist/*"
+
+Streaming:
+
+   With --stream, stdin is uploaded as a single artifact at the given path,
+   instead of uploading files matched by paths, so a command's output can be
+   uploaded without writing it into the working directory first:
+
+   $ mycmd | buildkite-agent artifact upload --stream report.json
+
+   Buildkite needs each artifact's size and checksums before it's uploaded, so
+   the stream is spooled to a temporary file as it's read, which is removed
+   once the upload has finished. The destination can still be given with
+   'BUILDKITE_ARTIFACT_UPLOAD_DESTINATION'.
+
+Config file:
+
+   Options can also be kept in a YAML or JSON file, given with
+   --artifact-config, using the same names as the flags. Flags and environment
+   variables override values from the file. Lists can be given as YAML lists,
+   and the paths and destination arguments as 'paths' and 'destination':
+
+   paths: "log/**/*.log;tmp/*.html"
+   follow-symlinks: true
+   content-disposition:
+     - "*.html=inline"
+     - "*.log=attachment"`
+
+var ArtifactConfigFlag = cli.StringFlag{
+	Name:   "artifact-config",
+	Value:  "",
+	Usage:  "Path to a YAML or JSON file of upload options, keyed by flag name. Flags and environment variables override values from the file",
+	EnvVar: "BUILDKITE_ARTIFACT_CONFIG",
+}
 
 var FollowSymlinksFlag = cli.BoolFlag{
 	Name:   "follow-symlinks",
@@ -63,27 +195,418 @@ var FollowSymlinksFlag = cli.BoolFlag{
 	EnvVar: "BUILDKITE_AGENT_ARTIFACT_SYMLINKS",
 }
 
+var ArtifactNoHTTP2Flag = cli.BoolFlag{
+	Name:   "artifact-no-http2",
+	Usage:  "Disable HTTP2 when uploading to artifact storage, independently of ′--no-http2′. Not supported for Google Cloud Storage",
+	EnvVar: "BUILDKITE_ARTIFACT_NO_HTTP2",
+}
+
+var ArtifactNoMultipartFlag = cli.BoolFlag{
+	Name:   "no-multipart",
+	Usage:  "Always upload artifacts to Amazon S3 with a single PUT, even when they're large enough for a multipart upload, for storage that doesn't support multipart uploads",
+	EnvVar: "BUILDKITE_ARTIFACT_NO_MULTIPART",
+}
+
+var ArtifactStripGlobBaseFlag = cli.BoolFlag{
+	Name:   "strip-glob-base",
+	Usage:  "Upload files matched by absolute globs relative to the directory the glob starts from, e.g. ′/var/log/**/*.log′ uploads /var/log/app/x.log as app/x.log, rather than at their whole absolute path",
+	EnvVar: "BUILDKITE_ARTIFACT_STRIP_GLOB_BASE",
+}
+
+var ArtifactTrustChecksumSidecarsFlag = cli.BoolFlag{
+	Name:   "trust-checksum-sidecars",
+	Usage:  "Record the checksum in a file's sha256sum style sidecar, e.g. llamas.tar.gz.sha256, as its SHA-256 checksum instead of calculating it",
+	EnvVar: "BUILDKITE_ARTIFACT_TRUST_CHECKSUM_SIDECARS",
+}
+
+var ArtifactDedupeByTargetFlag = cli.BoolFlag{
+	Name:   "dedupe-by-target",
+	Usage:  "Upload files that are matched at several paths through symbolic links only once, at the path that isn't through a symbolic link, or else the first one matched",
+	EnvVar: "BUILDKITE_ARTIFACT_DEDUPE_BY_TARGET",
+}
+
+var ArtifactConfineToWorkingDirFlag = cli.BoolFlag{
+	Name:   "confine-to-working-dir",
+	Usage:  "With ′--follow-symlinks′, refuse to upload files through symbolic links that point outside of the working directory",
+	EnvVar: "BUILDKITE_ARTIFACT_CONFINE_TO_WORKING_DIR",
+}
+
+var ArtifactLiteralPathsFlag = cli.BoolFlag{
+	Name:   "literal-paths",
+	Usage:  "Treat each upload path as a literal file name rather than a glob, for files with *, ? or [ in their names",
+	EnvVar: "BUILDKITE_ARTIFACT_LITERAL_PATHS",
+}
+
+var ArtifactProgressJSONFlag = cli.BoolFlag{
+	Name:   "progress-json",
+	Usage:  "Write the progress of the upload to stdout as JSON lines, at most once a second, separately from the logs",
+	EnvVar: "BUILDKITE_ARTIFACT_PROGRESS_JSON",
+}
+
+var ArtifactProgressFileFlag = cli.StringFlag{
+	Name:   "progress-file",
+	Usage:  "Write ′--progress-json′ progress to this file instead of stdout",
+	EnvVar: "BUILDKITE_ARTIFACT_PROGRESS_FILE",
+}
+
+var ArtifactChecksumFileFlag = cli.StringFlag{
+	Name:   "checksum-file",
+	Usage:  "Write the SHA-256 checksums of the matched files to this file in sha256sum format, e.g. SHA256SUMS, so ′sha256sum -c′ can check them. Works with ′--verify-only′ to write it without uploading",
+	EnvVar: "BUILDKITE_ARTIFACT_CHECKSUM_FILE",
+}
+
+var ArtifactExpiresInFlag = cli.DurationFlag{
+	Name:   "expires-in",
+	Usage:  "Mark the artifacts as expiring after this long, e.g. ′72h′, as a hint that they can be deleted. Artifacts uploaded to Amazon S3 are tagged with when they expire, for use in lifecycle rules",
+	EnvVar: "BUILDKITE_ARTIFACT_EXPIRES_IN",
+}
+
+var ArtifactPathSeparatorFlag = cli.StringFlag{
+	Name:   "path-separator",
+	Value:  agent.ArtifactPathDelimiter,
+	Usage:  "The single character that separates upload paths, for paths that contain ′;′. Use ′\\n′ for a newline",
+	EnvVar: "BUILDKITE_ARTIFACT_PATH_SEPARATOR",
+}
+
+var ArtifactSkipEmptyFlag = cli.BoolFlag{
+	Name:   "skip-empty",
+	Usage:  "Don't upload files that are empty",
+	EnvVar: "BUILDKITE_ARTIFACT_SKIP_EMPTY",
+}
+
+var ArtifactPreserveEmptyDirsFlag = cli.BoolFlag{
+	Name:   "preserve-empty-dirs",
+	Usage:  "Upload a zero-byte marker file (see ′--empty-dir-marker′) in each empty directory the paths match, e.g. out/cache/.keep for an empty out/cache, so the directory exists when the artifacts are downloaded",
+	EnvVar: "BUILDKITE_ARTIFACT_PRESERVE_EMPTY_DIRS",
+}
+
+var ArtifactEmptyDirMarkerFlag = cli.StringFlag{
+	Name:   "empty-dir-marker",
+	Value:  agent.DefaultArtifactEmptyDirMarker,
+	Usage:  "The name of the marker file uploaded in empty directories, with ′--preserve-empty-dirs′",
+	EnvVar: "BUILDKITE_ARTIFACT_EMPTY_DIR_MARKER",
+}
+
+var ArtifactSkipVanishedFlag = cli.BoolTFlag{
+	Name:   "skip-vanished",
+	Usage:  "Skip files that are deleted between being found and being read, like rotated logs, rather than failing the upload. Use ′--skip-vanished=false′ to fail instead",
+	EnvVar: "BUILDKITE_ARTIFACT_SKIP_VANISHED",
+}
+
+var ArtifactReadBufferSizeFlag = cli.IntFlag{
+	Name:   "read-buffer-size",
+	Value:  0,
+	Usage:  "The size in bytes of the buffer files are read into while uploading. Larger buffers can speed up uploads of large files, at the cost of memory for each file being uploaded at once. Defaults to 4KB. Not supported for Google Cloud Storage",
+	EnvVar: "BUILDKITE_ARTIFACT_READ_BUFFER_SIZE",
+}
+
+var ArtifactFailOnUnmatchedGlobFlag = cli.BoolFlag{
+	Name:   "fail-on-unmatched-glob",
+	Usage:  "Fail the upload, listing every path that doesn't match any files, even if other paths do",
+	EnvVar: "BUILDKITE_ARTIFACT_FAIL_ON_UNMATCHED_GLOB",
+}
+
+var ArtifactFailFastFlag = cli.BoolFlag{
+	Name:   "fail-fast",
+	Usage:  "Stop uploading after the first artifact fails to upload, cancelling any uploads in progress, rather than uploading as many artifacts as possible",
+	EnvVar: "BUILDKITE_ARTIFACT_FAIL_FAST",
+}
+
+var ArtifactSkipHiddenDirsFlag = cli.BoolFlag{
+	Name:   "skip-hidden-dirs",
+	Usage:  "Don't search directories whose names start with a dot, like ′.git′, while resolving globs, unless the glob names one",
+	EnvVar: "BUILDKITE_ARTIFACT_SKIP_HIDDEN_DIRS",
+}
+
+var ArtifactAnnotateFlag = cli.BoolFlag{
+	Name:   "annotate",
+	Usage:  "Once the artifacts have been uploaded, annotate the build with a table linking to them",
+	EnvVar: "BUILDKITE_ARTIFACT_ANNOTATE",
+}
+
+var ArtifactAnnotationContextFlag = cli.StringFlag{
+	Name:   "annotation-context",
+	Value:  agent.DefaultArtifactAnnotationContext,
+	Usage:  "The context of the annotation that ′--annotate′ appends to, so that different steps can list their artifacts separately",
+	EnvVar: "BUILDKITE_ARTIFACT_ANNOTATION_CONTEXT",
+}
+
+var ArtifactChecksumCacheFlag = cli.StringFlag{
+	Name:   "checksum-cache",
+	Usage:  "A file to cache the checksums of uploaded files in, so that files that haven't changed since an earlier upload (by size and modification time) aren't hashed again",
+	EnvVar: "BUILDKITE_ARTIFACT_CHECKSUM_CACHE",
+}
+
+var ArtifactMinConcurrencyFlag = cli.IntFlag{
+	Name:   "min-concurrency",
+	Value:  agent.DefaultArtifactUploadMinConcurrency,
+	Usage:  "The fewest artifacts to upload at once when storage is rate limiting uploads",
+	EnvVar: "BUILDKITE_ARTIFACT_UPLOAD_MIN_CONCURRENCY",
+}
+
+var ArtifactMaxConcurrencyFlag = cli.IntFlag{
+	Name:   "max-concurrency",
+	Value:  0,
+	Usage:  "The most artifacts to upload at once. Uploads slow down towards ′--min-concurrency′ when storage responds with 429 or 503, and speed up again as they succeed. Defaults to 10 per CPU",
+	EnvVar: "BUILDKITE_ARTIFACT_UPLOAD_MAX_CONCURRENCY",
+}
+
+var ArtifactUploadManifestFlag = cli.BoolFlag{
+	Name:   "upload-manifest",
+	Usage:  "Also upload a JSON manifest listing the path, size, SHA-256 checksum and content type of each of the other artifacts",
+	EnvVar: "BUILDKITE_ARTIFACT_UPLOAD_MANIFEST",
+}
+
+var ArtifactManifestNameFlag = cli.StringFlag{
+	Name:   "manifest-name",
+	Value:  agent.DefaultArtifactManifestName,
+	Usage:  "The path to upload the manifest as, with ′--upload-manifest′",
+	EnvVar: "BUILDKITE_ARTIFACT_MANIFEST_NAME",
+}
+
+var ArtifactAttestFlag = cli.BoolFlag{
+	Name:   "attest",
+	Usage:  "Also upload an in-toto attestation listing the SHA-256 checksum and size of each of the other artifacts",
+	EnvVar: "BUILDKITE_ARTIFACT_ATTEST",
+}
+
+var ArtifactAttestationNameFlag = cli.StringFlag{
+	Name:   "attestation-name",
+	Value:  agent.DefaultArtifactAttestationName,
+	Usage:  "The path to upload the attestation as, with ′--attest′",
+	EnvVar: "BUILDKITE_ARTIFACT_ATTESTATION_NAME",
+}
+
+var ArtifactAttestationKeyFlag = cli.StringFlag{
+	Name:   "attestation-key",
+	Value:  "",
+	Usage:  "Sign the attestation with this PEM encoded Ed25519, ECDSA or RSA private key, wrapping it in a DSSE envelope. Implies ′--attest′",
+	EnvVar: "BUILDKITE_ARTIFACT_ATTESTATION_KEY",
+}
+
+var ArtifactMaxOpenFilesFlag = cli.IntFlag{
+	Name:   "max-open-files",
+	Value:  0,
+	Usage:  "The most files to have open at once while hashing and uploading, which also limits ′--max-concurrency′. Defaults to a quarter of the open file limit",
+	EnvVar: "BUILDKITE_ARTIFACT_UPLOAD_MAX_OPEN_FILES",
+}
+
+var ArtifactMaxBytesPerSecondFlag = cli.IntFlag{
+	Name:   "max-bytes-per-second",
+	Value:  0,
+	Usage:  "Limit the total upload rate across all the artifacts being uploaded at once, as a soft cap measured over short windows. Zero means no limit. Not supported for Google Cloud Storage",
+	EnvVar: "BUILDKITE_ARTIFACT_MAX_BYTES_PER_SECOND",
+}
+
+var ArtifactMaxFilesFlag = cli.IntFlag{
+	Name:   "max-files",
+	Value:  0,
+	Usage:  "Fail without uploading anything if the paths match more than this many files. Zero means no limit",
+	EnvVar: "BUILDKITE_ARTIFACT_MAX_FILES",
+}
+
+var ArtifactFormatFlag = cli.StringFlag{
+	Name:   "format",
+	Usage:  "Once the upload has finished, print the uploaded artifacts to stdout in this format. Only ′csv′ is supported, which prints path, size, sha256 and url columns",
+	EnvVar: "BUILDKITE_ARTIFACT_FORMAT",
+}
+
+var ArtifactBlockSensitiveFlag = cli.BoolFlag{
+	Name:   "block-sensitive",
+	Usage:  "Fail instead of uploading files whose names look like they contain secrets, see ′--sensitive-patterns′",
+	EnvVar: "BUILDKITE_ARTIFACT_BLOCK_SENSITIVE",
+}
+
+var ArtifactSensitivePatternsFlag = cli.StringSliceFlag{
+	Name:   "sensitive-patterns",
+	Value:  &cli.StringSlice{},
+	Usage:  "File name patterns of artifacts that look like they contain secrets, which are warned about (or blocked with ′--block-sensitive′). Defaults to private keys, certificates and .env files",
+	EnvVar: "BUILDKITE_ARTIFACT_SENSITIVE_PATTERNS",
+}
+
+var ArtifactContentDispositionFlag = cli.StringSliceFlag{
+	Name:   "content-disposition",
+	Value:  &cli.StringSlice{},
+	Usage:  "Set the Content-Disposition of artifacts matching a glob, as ′pattern=inline′ or ′pattern=attachment′, e.g. ′**/*.pdf=attachment′. The first matching pattern wins. Only applies to Amazon S3",
+	EnvVar: "BUILDKITE_ARTIFACT_CONTENT_DISPOSITION",
+}
+
+var ArtifactShowDestinationsFlag = cli.BoolFlag{
+	Name:   "show-destinations",
+	Usage:  "Log the full destination each artifact is uploaded to, after any path changes have been applied",
+	EnvVar: "BUILDKITE_ARTIFACT_SHOW_DESTINATIONS",
+}
+
+var ArtifactVerifyOnlyFlag = cli.BoolFlag{
+	Name:  "verify-only",
+	Usage: "Check that every matched file can be read and hashed, and print them, without uploading anything or contacting the Agent API. Doesn't need a job or an agent access token",
+}
+
+var ArtifactEstimateFlag = cli.BoolFlag{
+	Name:  "estimate",
+	Usage: "Print how many files and bytes would be uploaded, and roughly how long it would take, without uploading anything or contacting the Agent API",
+}
+
+var ArtifactListOnlyFlag = cli.BoolFlag{
+	Name:  "list-only",
+	Usage: "Print the paths the matched files would be uploaded as, one per line, without reading or uploading them, or contacting the Agent API",
+}
+
+var ArtifactEstimateBandwidthFlag = cli.IntFlag{
+	Name:   "estimate-bandwidth",
+	Value:  0,
+	Usage:  "With ′--estimate′, the upload speed in bytes per second to estimate how long the upload would take from",
+	EnvVar: "BUILDKITE_ARTIFACT_ESTIMATE_BANDWIDTH",
+}
+
+var ArtifactShutdownGraceFlag = cli.DurationFlag{
+	Name:   "shutdown-grace",
+	Value:  10 * time.Second,
+	Usage:  "When terminated (e.g. with SIGTERM), how long to let uploads already in progress finish before cancelling them. No new uploads are started once terminated",
+	EnvVar: "BUILDKITE_ARTIFACT_SHUTDOWN_GRACE",
+}
+
+var ArtifactUploadTimeoutFlag = cli.DurationFlag{
+	Name:   "artifact-upload-timeout",
+	Usage:  "The longest the whole upload may take, e.g. ′30m′. Once exceeded, no more uploads are started or retried, and the upload fails with how many artifacts were uploaded and how many remained. Zero means no limit",
+	EnvVar: "BUILDKITE_ARTIFACT_UPLOAD_TIMEOUT",
+}
+
+var ArtifactSinceFlag = cli.StringFlag{
+	Name:   "since",
+	Value:  "",
+	Usage:  "Only upload files modified since this long ago, e.g. ′10m′, or since an RFC3339 timestamp, e.g. ′2006-01-02T15:04:05Z′, so unchanged files in a cached build directory aren't uploaded again",
+	EnvVar: "BUILDKITE_ARTIFACT_SINCE",
+}
+
+var ArtifactRetryJitterSeedFlag = cli.IntFlag{
+	Name:   "retry-jitter-seed",
+	Usage:  "Seed the random jitter added to retries, so that tests get the same delays every time. Zero means a different seed every run",
+	EnvVar: "BUILDKITE_ARTIFACT_RETRY_JITTER_SEED",
+	Hidden: true,
+}
+
+var ArtifactTempDirFlag = cli.StringFlag{
+	Name:   "temp-dir",
+	Value:  "",
+	Usage:  "The directory to write temporary files to, such as the spooled ′--stream′ and the generated manifest and attestation. Defaults to the system's temporary directory, e.g. ′$TMPDIR′",
+	EnvVar: "BUILDKITE_ARTIFACT_TEMP_DIR,BUILDKITE_TMPDIR",
+}
+
+var ArtifactStagingDirFlag = cli.StringFlag{
+	Name:   "staging-dir",
+	Value:  "",
+	Usage:  "Resolve relative paths from this directory, e.g. ′.buildkite-artifacts′, and upload the files relative to it, so ′.buildkite-artifacts/report.html′ is uploaded as report.html",
+	EnvVar: "BUILDKITE_ARTIFACT_STAGING_DIR",
+}
+
+var ArtifactFlattenStrategyFlag = cli.StringFlag{
+	Name:   "flatten-strategy",
+	Value:  "",
+	Usage:  "Upload files at just their file names: ′error-on-collision′ fails if two files have the same name, ′suffix′ numbers them (e.g. report-1.xml). Defaults to ′keep-paths′, which doesn't flatten",
+	EnvVar: "BUILDKITE_ARTIFACT_FLATTEN_STRATEGY",
+}
+
+var ArtifactContentAddressedFlag = cli.BoolFlag{
+	Name:   "content-addressed",
+	Usage:  "Store each file in the upload destination at a path made from its SHA-256 checksum, e.g. sha256/ab/cdef..., so identical files are only stored once. The path it's uploaded as is recorded in the object's metadata. Not supported by Buildkite's artifact storage",
+	EnvVar: "BUILDKITE_ARTIFACT_CONTENT_ADDRESSED",
+}
+
+var ArtifactStreamFlag = cli.StringFlag{
+	Name:   "stream",
+	Value:  "",
+	Usage:  "Upload stdin as a single artifact at this path, e.g. ′report.json′, instead of files matching paths",
+	EnvVar: "BUILDKITE_ARTIFACT_STREAM",
+}
+
+var ArtifactRootFlag = cli.StringSliceFlag{
+	Name:   "root",
+	Value:  &cli.StringSlice{},
+	Usage:  "Also upload paths relative to another directory, as ′dir=paths′, e.g. ′componentA=dist/**/*.js′ uploads componentA/dist/x.js as dist/x.js. Can be given more than once",
+	EnvVar: "BUILDKITE_ARTIFACT_ROOTS",
+}
+
 type ArtifactUploadConfig struct {
-	UploadPaths string `cli:"arg:0" label:"upload paths" validate:"required"`
-	Destination string `cli:"arg:1" label:"destination" env:"BUILDKITE_ARTIFACT_UPLOAD_DESTINATION"`
-	Job         string `cli:"job" validate:"required"`
+	UploadPaths string `cli:"arg:0" label:"upload paths" config:"paths"`
+	Destination string `cli:"arg:1" label:"destination" env:"BUILDKITE_ARTIFACT_UPLOAD_DESTINATION" config:"destination"`
+	Job         string `cli:"job"`
 	ContentType string `cli:"content-type"`
 
 	// Global flags
-	Debug       bool     `cli:"debug"`
-	LogLevel    string   `cli:"log-level"`
-	NoColor     bool     `cli:"no-color"`
-	Experiments []string `cli:"experiment" normalize:"list"`
-	Profile     string   `cli:"profile"`
+	Debug        bool     `cli:"debug"`
+	Quiet        bool     `cli:"quiet"`
+	LogLevel     string   `cli:"log-level"`
+	PrefixFields []string `cli:"log-prefix-fields" normalize:"list"`
+	NoColor      bool     `cli:"no-color"`
+	Experiments  []string `cli:"experiment" normalize:"list"`
+	Profile      string   `cli:"profile"`
 
 	// API config
 	DebugHTTP        bool   `cli:"debug-http"`
-	AgentAccessToken string `cli:"agent-access-token" validate:"required"`
+	AgentAccessToken string `cli:"agent-access-token"`
 	Endpoint         string `cli:"endpoint" validate:"required"`
 	NoHTTP2          bool   `cli:"no-http2"`
+	MaxAPIWait       string `cli:"max-api-wait"`
+
+	APIMaxIdleConnsPerHost int `cli:"api-max-idle-conns-per-host"`
+	APIMaxConnsPerHost     int `cli:"api-max-conns-per-host"`
 
 	// Uploader flags
-	FollowSymlinks bool `cli:"follow-symlinks"`
+	FollowSymlinks        bool     `cli:"follow-symlinks"`
+	DedupeByTarget        bool     `cli:"dedupe-by-target"`
+	StripGlobBase         bool     `cli:"strip-glob-base"`
+	TrustChecksumSidecars bool     `cli:"trust-checksum-sidecars"`
+	ArtifactNoHTTP2       bool     `cli:"artifact-no-http2"`
+	NoMultipart           bool     `cli:"no-multipart"`
+	BlockSensitive        bool     `cli:"block-sensitive"`
+	LiteralPaths          bool     `cli:"literal-paths"`
+	ProgressJSON          bool     `cli:"progress-json"`
+	ProgressFile          string   `cli:"progress-file" normalize:"filepath"`
+	ChecksumFile          string   `cli:"checksum-file" normalize:"filepath"`
+	ConfineToWorkDir      bool     `cli:"confine-to-working-dir"`
+	MaxTotalRetryTime     string   `cli:"max-total-retry-time"`
+	ExpiresIn             string   `cli:"expires-in"`
+	PathSeparator         string   `cli:"path-separator"`
+	SkipEmpty             bool     `cli:"skip-empty"`
+	PreserveEmptyDirs     bool     `cli:"preserve-empty-dirs"`
+	EmptyDirMarker        string   `cli:"empty-dir-marker"`
+	SkipVanished          bool     `cli:"skip-vanished"`
+	SkipHiddenDirs        bool     `cli:"skip-hidden-dirs"`
+	FailOnUnmatchedGlob   bool     `cli:"fail-on-unmatched-glob"`
+	FailFast              bool     `cli:"fail-fast"`
+	Annotate              bool     `cli:"annotate"`
+	AnnotationContext     string   `cli:"annotation-context"`
+	ChecksumCache         string   `cli:"checksum-cache" normalize:"filepath"`
+	MinConcurrency        int      `cli:"min-concurrency"`
+	MaxConcurrency        int      `cli:"max-concurrency"`
+	MaxOpenFiles          int      `cli:"max-open-files"`
+	UploadManifest        bool     `cli:"upload-manifest"`
+	ManifestName          string   `cli:"manifest-name"`
+	Attest                bool     `cli:"attest"`
+	AttestationName       string   `cli:"attestation-name"`
+	AttestationKey        string   `cli:"attestation-key" normalize:"filepath"`
+	MaxBytesPerSecond     int      `cli:"max-bytes-per-second"`
+	MaxFiles              int      `cli:"max-files"`
+	Format                string   `cli:"format"`
+	Dispositions          []string `cli:"content-disposition" normalize:"list"`
+	ShowDestinations      bool     `cli:"show-destinations"`
+	VerifyOnly            bool     `cli:"verify-only"`
+	Estimate              bool     `cli:"estimate"`
+	ListOnly              bool     `cli:"list-only"`
+	EstimateBandwidth     int      `cli:"estimate-bandwidth"`
+	ShutdownGrace         string   `cli:"shutdown-grace"`
+	UploadTimeout         string   `cli:"artifact-upload-timeout"`
+	Since                 string   `cli:"since"`
+	RetryJitterSeed       int      `cli:"retry-jitter-seed"`
+	StagingDir            string   `cli:"staging-dir" normalize:"filepath"`
+	TempDir               string   `cli:"temp-dir" normalize:"filepath"`
+	Stream                string   `cli:"stream"`
+	FlattenStrategy       string   `cli:"flatten-strategy"`
+	ContentAddressed      bool     `cli:"content-addressed"`
+	Roots                 []string `cli:"root" normalize:"list"`
+	ReadBufferSize        int      `cli:"read-buffer-size"`
+	SensitivePatterns     []string `cli:"sensitive-patterns" normalize:"list"`
 }
 
 var ArtifactUploadCommand = cli.Command{
@@ -103,34 +626,129 @@ var ArtifactUploadCommand = cli.Command{
 			Usage:  "A specific Content-Type to set for the artifacts (otherwise detected)",
 			EnvVar: "BUILDKITE_ARTIFACT_CONTENT_TYPE",
 		},
+		cli.DurationFlag{
+			Name:   "max-total-retry-time",
+			Usage:  "The total time that may be spent retrying uploads across all artifacts, after which failing uploads aren't retried. Zero means no limit",
+			EnvVar: "BUILDKITE_ARTIFACT_MAX_TOTAL_RETRY_TIME",
+		},
 
 		// API Flags
 		AgentAccessTokenFlag,
 		EndpointFlag,
 		NoHTTP2Flag,
+		MaxAPIWaitFlag,
+		APIMaxIdleConnsPerHostFlag,
+		APIMaxConnsPerHostFlag,
 		DebugHTTPFlag,
 
 		// Global flags
 		NoColorFlag,
 		DebugFlag,
+		QuietFlag,
 		LogLevelFlag,
+		LogPrefixFieldsFlag,
 		ExperimentsFlag,
 		ProfileFlag,
 		FollowSymlinksFlag,
+		ArtifactDedupeByTargetFlag,
+		ArtifactStripGlobBaseFlag,
+		ArtifactTrustChecksumSidecarsFlag,
+		ArtifactNoHTTP2Flag,
+		ArtifactNoMultipartFlag,
+		ArtifactBlockSensitiveFlag,
+		ArtifactSensitivePatternsFlag,
+		ArtifactLiteralPathsFlag,
+		ArtifactConfineToWorkingDirFlag,
+		ArtifactProgressJSONFlag,
+		ArtifactProgressFileFlag,
+		ArtifactChecksumFileFlag,
+		ArtifactExpiresInFlag,
+		ArtifactPathSeparatorFlag,
+		ArtifactSkipEmptyFlag,
+		ArtifactPreserveEmptyDirsFlag,
+		ArtifactEmptyDirMarkerFlag,
+		ArtifactSkipVanishedFlag,
+		ArtifactSkipHiddenDirsFlag,
+		ArtifactFailOnUnmatchedGlobFlag,
+		ArtifactFailFastFlag,
+		ArtifactAnnotateFlag,
+		ArtifactAnnotationContextFlag,
+		ArtifactChecksumCacheFlag,
+		ArtifactMinConcurrencyFlag,
+		ArtifactMaxConcurrencyFlag,
+		ArtifactMaxOpenFilesFlag,
+		ArtifactUploadManifestFlag,
+		ArtifactManifestNameFlag,
+		ArtifactAttestFlag,
+		ArtifactAttestationNameFlag,
+		ArtifactAttestationKeyFlag,
+		ArtifactMaxBytesPerSecondFlag,
+		ArtifactMaxFilesFlag,
+		ArtifactFormatFlag,
+		ArtifactContentDispositionFlag,
+		ArtifactShowDestinationsFlag,
+		ArtifactVerifyOnlyFlag,
+		ArtifactEstimateFlag,
+		ArtifactListOnlyFlag,
+		ArtifactEstimateBandwidthFlag,
+		ArtifactShutdownGraceFlag,
+		ArtifactUploadTimeoutFlag,
+		ArtifactSinceFlag,
+		ArtifactRetryJitterSeedFlag,
+		ArtifactStagingDirFlag,
+		ArtifactTempDirFlag,
+		ArtifactStreamFlag,
+		ArtifactFlattenStrategyFlag,
+		ArtifactContentAddressedFlag,
+		ArtifactRootFlag,
+		ArtifactReadBufferSizeFlag,
+		ArtifactConfigFlag,
 	},
 	Action: func(c *cli.Context) {
-		ctx := context.Background()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
 
 		// The configuration will be loaded into this struct
 		cfg := ArtifactUploadConfig{}
 
 		loader := cliconfig.Loader{CLI: c, Config: &cfg}
+		if path := c.String("artifact-config"); path != "" {
+			loader.File = &cliconfig.File{Path: path}
+		}
 		warnings, err := loader.Load()
 		if err != nil {
 			fmt.Printf("%s", err)
 			os.Exit(1)
 		}
 
+		if cfg.Stream != "" {
+			// A stream replaces the paths, and can only be read once
+			if cfg.UploadPaths != "" || len(cfg.Roots) > 0 {
+				fmt.Printf("%s", loader.Errorf("Upload paths can't be given with --stream."))
+				os.Exit(1)
+			}
+			if cfg.VerifyOnly || cfg.Estimate || cfg.ListOnly {
+				fmt.Printf("%s", loader.Errorf("--stream can't be used with --verify-only, --estimate or --list-only."))
+				os.Exit(1)
+			}
+		} else if cfg.UploadPaths == "" && len(cfg.Roots) == 0 {
+			// Paths can be given entirely as roots
+			fmt.Printf("%s", loader.Errorf("Missing upload paths."))
+			os.Exit(1)
+		}
+
+		// These are only required when actually uploading
+		if cfg.usesAPI() {
+			if cfg.Job == "" {
+				fmt.Printf("%s", loader.Errorf("Missing job."))
+				os.Exit(1)
+			}
+			if cfg.AgentAccessToken == "" {
+				fmt.Printf("%s", loader.Errorf("Missing agent-access-token."))
+				os.Exit(1)
+			}
+		}
+
 		l := CreateLogger(&cfg)
 
 		// Now that we have a logger, log out the warnings that loading config generated
@@ -142,22 +760,339 @@ var ArtifactUploadCommand = cli.Command{
 		done := HandleGlobalFlags(l, cfg)
 		defer done()
 
-		// Create the API client
-		client := api.NewClient(l, loadAPIClientConfig(cfg, "AgentAccessToken"))
+		var maxTotalRetryTime time.Duration
+		if t := cfg.MaxTotalRetryTime; t != "" {
+			maxTotalRetryTime, err = time.ParseDuration(t)
+			if err != nil {
+				l.Fatal("Failed to parse max total retry time: %v", err)
+			}
+		}
+
+		// Newlines are hard to pass as flags, so they can be escaped
+		pathSeparator := cfg.PathSeparator
+		if pathSeparator == `\n` {
+			pathSeparator = "\n"
+		}
+
+		var shutdownGrace time.Duration
+		if cfg.ShutdownGrace != "" {
+			shutdownGrace, err = time.ParseDuration(cfg.ShutdownGrace)
+			if err != nil {
+				l.Fatal("Failed to parse shutdown grace: %v", err)
+			}
+		}
+
+		var uploadTimeout time.Duration
+		if cfg.UploadTimeout != "" {
+			uploadTimeout, err = time.ParseDuration(cfg.UploadTimeout)
+			if err != nil {
+				l.Fatal("Failed to parse upload timeout: %v", err)
+			}
+		}
+
+		var modifiedSince time.Time
+		if cfg.Since != "" {
+			modifiedSince, err = parseSince(cfg.Since, time.Now())
+			if err != nil {
+				l.Fatal("Failed to parse since: %v", err)
+			}
+		}
+
+		var expiresIn time.Duration
+		if cfg.ExpiresIn != "" {
+			expiresIn, err = time.ParseDuration(cfg.ExpiresIn)
+			if err != nil {
+				l.Fatal("Failed to parse expiry: %v", err)
+			}
+		}
+
+		if cfg.ReadBufferSize < 0 {
+			l.Fatal("Read buffer size must not be negative")
+		}
+
+		if cfg.MaxBytesPerSecond < 0 {
+			l.Fatal("Max bytes per second must not be negative")
+		}
+
+		// Only override the default sensitive file patterns if some were given
+		var sensitivePatterns []string
+		if len(cfg.SensitivePatterns) > 0 {
+			sensitivePatterns = cfg.SensitivePatterns
+		}
+
+		var roots []agent.ArtifactRoot
+		for _, root := range cfg.Roots {
+			dir, paths, ok := strings.Cut(root, "=")
+			if !ok || dir == "" || paths == "" {
+				l.Fatal("Invalid root %q, expected ′dir=paths′", root)
+			}
+			roots = append(roots, agent.ArtifactRoot{Dir: dir, Paths: paths})
+		}
+
+		var contentDispositions []agent.ArtifactContentDisposition
+		for _, cd := range cfg.Dispositions {
+			pattern, disposition, ok := strings.Cut(cd, "=")
+			if !ok || pattern == "" {
+				l.Fatal("Invalid content disposition %q, expected ′pattern=inline′ or ′pattern=attachment′", cd)
+			}
+			disposition = strings.ToLower(strings.TrimSpace(disposition))
+			if disposition != "inline" && disposition != "attachment" {
+				l.Fatal("Invalid content disposition %q for %q. Only 'inline' or 'attachment' are allowed.", disposition, pattern)
+			}
+			contentDispositions = append(contentDispositions, agent.ArtifactContentDisposition{
+				Pattern:     strings.TrimSpace(pattern),
+				Disposition: disposition,
+			})
+		}
+
+		// Several destinations can be given, separated like the paths are
+		var destinations []string
+		if strings.Contains(cfg.Destination, agent.ArtifactPathDelimiter) {
+			for _, destination := range strings.Split(cfg.Destination, agent.ArtifactPathDelimiter) {
+				destination = strings.TrimSpace(destination)
+				if destination == "buildkite" {
+					destination = ""
+				}
+				destinations = append(destinations, destination)
+			}
+		}
+
+		var progressWriter io.Writer
+		if cfg.ProgressJSON {
+			progressWriter = os.Stdout
+			if cfg.ProgressFile != "" {
+				f, err := os.Create(cfg.ProgressFile)
+				if err != nil {
+					l.Fatal("Failed to create progress file: %v", err)
+				}
+				defer f.Close()
+				progressWriter = f
+			}
+		}
+
+		var checksumWriter io.Writer
+		if cfg.ChecksumFile != "" {
+			f, err := os.Create(cfg.ChecksumFile)
+			if err != nil {
+				l.Fatal("Failed to create checksum file: %v", err)
+			}
+			defer f.Close()
+			checksumWriter = f
+		}
+
+		var csvWriter io.Writer
+		switch cfg.Format {
+		case "":
+		case "csv":
+			if progressWriter == os.Stdout {
+				l.Fatal("′--format′ and ′--progress-json′ can't both write to stdout, use ′--progress-file′")
+			}
+			csvWriter = os.Stdout
+		default:
+			l.Fatal("Invalid format %q. Only 'csv' is supported.", cfg.Format)
+		}
+
+		// Every file has to be hashed to verify it, so cached checksums
+		// can't be used
+		if cfg.VerifyOnly && cfg.ChecksumCache != "" {
+			l.Warn("Ignoring ′--checksum-cache′, since ′--verify-only′ hashes every file")
+			cfg.ChecksumCache = ""
+		}
+
+		var attestationKey crypto.Signer
+		if cfg.AttestationKey != "" {
+			data, err := os.ReadFile(cfg.AttestationKey)
+			if err != nil {
+				l.Fatal("Failed to read attestation key: %v", err)
+			}
+			attestationKey, err = agent.ParseAttestationKey(data)
+			if err != nil {
+				l.Fatal("Failed to parse attestation key: %v", err)
+			}
+		}
+
+		// Create the API client, unless we're only verifying or listing files,
+		// or estimating the upload
+		var client agent.APIClient
+		if cfg.usesAPI() {
+			apiConf, err := loadAPIClientConfig(cfg, "AgentAccessToken")
+			if err != nil {
+				l.Fatal("%s", err)
+			}
+			client = api.NewClient(l, apiConf)
+		}
+
+		var stream io.Reader
+		if cfg.Stream != "" {
+			stream = os.Stdin
+		}
 
 		// Setup the uploader
-		uploader := agent.NewArtifactUploader(l, client, agent.ArtifactUploaderConfig{
+		uploader := agent.NewArtifactUploaderWithContext(ctx, l, client, agent.ArtifactUploaderConfig{
 			JobID:          cfg.Job,
 			Paths:          cfg.UploadPaths,
+			StagingDir:     cfg.StagingDir,
+			TempDir:        cfg.TempDir,
+			Stream:         stream,
+			StreamPath:     cfg.Stream,
+			Roots:          roots,
+			PathSeparator:  pathSeparator,
 			Destination:    cfg.Destination,
+			Destinations:   destinations,
 			ContentType:    cfg.ContentType,
 			DebugHTTP:      cfg.DebugHTTP,
+			DisableHTTP2:   cfg.ArtifactNoHTTP2,
 			FollowSymlinks: cfg.FollowSymlinks,
+			NoGlob:         cfg.LiteralPaths,
+			SkipEmpty:      cfg.SkipEmpty,
+			SkipVanished:   cfg.SkipVanished,
+			SkipHiddenDirs: cfg.SkipHiddenDirs,
+			StrictGlobs:    cfg.FailOnUnmatchedGlob,
+			ReadBufferSize: cfg.ReadBufferSize,
+
+			ConfineToWorkingDir:   cfg.ConfineToWorkDir,
+			DedupeByTarget:        cfg.DedupeByTarget,
+			StripGlobBase:         cfg.StripGlobBase,
+			TrustChecksumSidecars: cfg.TrustChecksumSidecars,
+			FlattenStrategy:       cfg.FlattenStrategy,
+			ContentAddressed:      cfg.ContentAddressed,
+			ModifiedSince:         modifiedSince,
+			PreserveEmptyDirs:     cfg.PreserveEmptyDirs,
+			EmptyDirMarker:        cfg.EmptyDirMarker,
+
+			DisableMultipart:  cfg.NoMultipart,
+			FailFast:          cfg.FailFast,
+			MaxTotalRetryTime: maxTotalRetryTime,
+			Timeout:           uploadTimeout,
+			SummaryWriter:     os.Stderr,
+			ProgressWriter:    progressWriter,
+			ExpiresIn:         expiresIn,
+			SensitivePatterns: sensitivePatterns,
+			BlockSensitive:    cfg.BlockSensitive,
+			Annotate:          cfg.Annotate,
+			AnnotationContext: cfg.AnnotationContext,
+			ChecksumCache:     cfg.ChecksumCache,
+			MinConcurrency:    cfg.MinConcurrency,
+			MaxConcurrency:    cfg.MaxConcurrency,
+			MaxOpenFiles:      cfg.MaxOpenFiles,
+			UploadManifest:    cfg.UploadManifest,
+			ManifestName:      cfg.ManifestName,
+			Attest:            cfg.Attest || attestationKey != nil,
+			AttestationName:   cfg.AttestationName,
+			AttestationKey:    attestationKey,
+			MaxBytesPerSecond: int64(cfg.MaxBytesPerSecond),
+			MaxFiles:          cfg.MaxFiles,
+			CSVWriter:         csvWriter,
+			ChecksumWriter:    checksumWriter,
+			ShowDestinations:  cfg.ShowDestinations,
+			RetryJitterSeed:   int64(cfg.RetryJitterSeed),
+
+			ContentDispositions: contentDispositions,
 		})
 
+		if cfg.ListOnly {
+			paths, err := uploader.List()
+			if err != nil {
+				l.Fatal("Failed to list artifacts: %s", err)
+			}
+			for _, path := range paths {
+				fmt.Println(path)
+			}
+			return
+		}
+
+		if cfg.Estimate {
+			estimate, err := uploader.Estimate(int64(cfg.EstimateBandwidth))
+			if err != nil {
+				l.Fatal("Failed to estimate upload: %s", err)
+			}
+			fmt.Println(estimate)
+			return
+		}
+
+		if cfg.VerifyOnly {
+			artifacts, err := uploader.Collect()
+			if err != nil {
+				l.Fatal("Failed to verify artifacts: %s", err)
+			}
+
+			if checksumWriter != nil {
+				if err := agent.WriteArtifactsSHA256SUMS(checksumWriter, artifacts); err != nil {
+					l.Fatal("Failed to write checksum file: %s", err)
+				}
+			}
+
+			if csvWriter != nil {
+				if err := agent.WriteArtifactsCSV(csvWriter, artifacts); err != nil {
+					l.Fatal("Failed to write artifacts CSV: %s", err)
+				}
+			} else {
+				writeVerifiedArtifacts(os.Stdout, artifacts)
+			}
+
+			l.Info("Verified %d files that match %q", len(artifacts), cfg.UploadPaths)
+			return
+		}
+
+		// When terminated, stop starting new uploads, and give the ones in
+		// progress a while to finish before cancelling them
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(signals)
+
+		go func() {
+			select {
+			case sig := <-signals:
+				l.Warn("Received %v, finishing uploads in progress for up to %s", sig, shutdownGrace)
+				uploader.Stop()
+			case <-ctx.Done():
+				return
+			}
+
+			select {
+			case <-time.After(shutdownGrace):
+				l.Warn("Uploads didn't finish within %s, cancelling them", shutdownGrace)
+				cancel()
+			case <-signals:
+				l.Warn("Received another signal, cancelling uploads")
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
 		// Upload the artifacts
 		if err := uploader.Upload(ctx); err != nil {
 			l.Fatal("Failed to upload artifacts: %s", err)
 		}
 	},
 }
+
+// usesAPI returns whether the upload talks to the Agent API. Verifying,
+// listing or estimating the upload doesn't.
+func (cfg ArtifactUploadConfig) usesAPI() bool {
+	return !cfg.VerifyOnly && !cfg.Estimate && !cfg.ListOnly
+}
+
+// writeVerifiedArtifacts writes the SHA-256, size and path of each artifact
+// that's been verified with --verify-only, one per line
+func writeVerifiedArtifacts(w io.Writer, artifacts []*api.Artifact) {
+	for _, artifact := range artifacts {
+		fmt.Fprintf(w, "%s %d %s\n", artifact.Sha256Sum, artifact.FileSize, artifact.Path)
+	}
+}
+
+// parseSince parses the value of --since, which is either a duration before
+// now, e.g. 10m, or an RFC3339 timestamp
+func parseSince(since string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(since); err == nil {
+		if d < 0 {
+			return time.Time{}, fmt.Errorf("invalid duration %s, it must be positive", since)
+		}
+		return now.Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, since)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither a duration, like 10m, nor an RFC3339 timestamp", since)
+	}
+	return t, nil
+}
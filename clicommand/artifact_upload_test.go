@@ -0,0 +1,76 @@
+package clicommand
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent/v3/agent"
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSince(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		since   string
+		want    time.Time
+		wantErr bool
+	}{
+		{since: "10m", want: now.Add(-10 * time.Minute)},
+		{since: "2022-06-01T11:30:00Z", want: time.Date(2022, 6, 1, 11, 30, 0, 0, time.UTC)},
+		{since: "-10m", wantErr: true},
+		{since: "yesterday", wantErr: true},
+	} {
+		got, err := parseSince(tc.since, now)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("parseSince(%q) error = %v, wantErr %t", tc.since, err, tc.wantErr)
+			continue
+		}
+		if !got.Equal(tc.want) {
+			t.Errorf("parseSince(%q) = %v, want %v", tc.since, got, tc.want)
+		}
+	}
+}
+
+func TestArtifactUploadConfigUsesAPI(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, ArtifactUploadConfig{}.usesAPI())
+	assert.False(t, ArtifactUploadConfig{VerifyOnly: true}.usesAPI())
+	assert.False(t, ArtifactUploadConfig{Estimate: true}.usesAPI())
+	assert.False(t, ArtifactUploadConfig{ListOnly: true}.usesAPI())
+}
+
+func TestVerifyOnly(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "llamas.txt"), []byte("llamas"), 0o666); err != nil {
+		t.Fatalf("os.WriteFile(llamas.txt) error = %v", err)
+	}
+
+	// Verifying needs no API client
+	uploader := agent.NewArtifactUploader(logger.Discard, nil, agent.ArtifactUploaderConfig{
+		Paths: filepath.Join(dir, "*.txt"),
+	})
+	artifacts, err := uploader.Collect()
+	if err != nil {
+		t.Fatalf("uploader.Collect() error = %v", err)
+	}
+
+	if len(artifacts) != 1 {
+		t.Fatalf("len(uploader.Collect()) = %d, want 1", len(artifacts))
+	}
+	assert.Equal(t, "llamas.txt", filepath.Base(artifacts[0].Path))
+
+	var buf bytes.Buffer
+	writeVerifiedArtifacts(&buf, artifacts)
+
+	want := "66f0d436b0469c570b3b8d7e11a681881d9a7bcd8b12d5c2db426015d3ddfd1c 6 " + artifacts[0].Path + "\n"
+	assert.Equal(t, want, buf.String())
+}
@@ -0,0 +1,148 @@
+package clicommand
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/buildkite/agent/v3/agent"
+	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/agent/v3/cliconfig"
+	"github.com/urfave/cli"
+)
+
+const verifyHelpDescription = `Usage:
+
+   buildkite-agent artifact verify [options] [query]
+
+Description:
+
+   Checks that artifacts already uploaded to a build still match the checksums
+   recorded when they were uploaded. Each artifact is streamed from where it's
+   stored and hashed as it's read, without being saved anywhere.
+
+   All of the build's artifacts are checked, unless a search query is given.
+   Any mismatches are logged, followed by a summary, and the command exits
+   non-zero if any artifact didn't match or couldn't be checked.
+
+Example:
+
+   $ buildkite-agent artifact verify --build xxx
+
+   You can scope the check to a particular job or step, and to artifacts
+   matching a query:
+
+   $ buildkite-agent artifact verify "pkg/*.tar.gz" --step "tests" --build xxx`
+
+type ArtifactVerifyConfig struct {
+	Query              string `cli:"arg:0" label:"artifact search query"`
+	Step               string `cli:"step"`
+	Build              string `cli:"build" validate:"required"`
+	IncludeRetriedJobs bool   `cli:"include-retried-jobs"`
+
+	// Global flags
+	Debug        bool     `cli:"debug"`
+	Quiet        bool     `cli:"quiet"`
+	LogLevel     string   `cli:"log-level"`
+	PrefixFields []string `cli:"log-prefix-fields" normalize:"list"`
+	NoColor      bool     `cli:"no-color"`
+	Experiments  []string `cli:"experiment" normalize:"list"`
+	Profile      string   `cli:"profile"`
+
+	// API config
+	DebugHTTP        bool   `cli:"debug-http"`
+	AgentAccessToken string `cli:"agent-access-token" validate:"required"`
+	Endpoint         string `cli:"endpoint" validate:"required"`
+	NoHTTP2          bool   `cli:"no-http2"`
+	MaxAPIWait       string `cli:"max-api-wait"`
+}
+
+var ArtifactVerifyCommand = cli.Command{
+	Name:        "verify",
+	Usage:       "Verifies the checksums of artifacts that have already been uploaded",
+	Description: verifyHelpDescription,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "step",
+			Value: "",
+			Usage: "Scope the search to a particular step by using either its name or job ID",
+		},
+		cli.StringFlag{
+			Name:   "build",
+			Value:  "",
+			EnvVar: "BUILDKITE_BUILD_ID",
+			Usage:  "The build that the artifacts were uploaded to",
+		},
+		cli.BoolFlag{
+			Name:   "include-retried-jobs",
+			EnvVar: "BUILDKITE_AGENT_INCLUDE_RETRIED_JOBS",
+			Usage:  "Include artifacts from retried jobs in the search",
+		},
+
+		// API Flags
+		AgentAccessTokenFlag,
+		EndpointFlag,
+		NoHTTP2Flag,
+		MaxAPIWaitFlag,
+		DebugHTTPFlag,
+
+		// Global flags
+		NoColorFlag,
+		DebugFlag,
+		QuietFlag,
+		LogLevelFlag,
+		LogPrefixFieldsFlag,
+		ExperimentsFlag,
+		ProfileFlag,
+	},
+	Action: func(c *cli.Context) {
+		ctx := context.Background()
+
+		// The configuration will be loaded into this struct
+		cfg := ArtifactVerifyConfig{}
+
+		loader := cliconfig.Loader{CLI: c, Config: &cfg}
+		warnings, err := loader.Load()
+		if err != nil {
+			fmt.Printf("%s", err)
+			os.Exit(1)
+		}
+
+		l := CreateLogger(&cfg)
+
+		// Now that we have a logger, log out the warnings that loading config generated
+		for _, warning := range warnings {
+			l.Warn("%s", warning)
+		}
+
+		// Setup any global configuration options
+		done := HandleGlobalFlags(l, cfg)
+		defer done()
+
+		query := cfg.Query
+		if query == "" {
+			query = "*"
+		}
+
+		// Create the API client
+		apiConf, err := loadAPIClientConfig(cfg, "AgentAccessToken")
+		if err != nil {
+			l.Fatal("%s", err)
+		}
+		client := api.NewClient(l, apiConf)
+
+		// Setup the verifier
+		verifier := agent.NewArtifactVerifier(l, client, agent.ArtifactVerifierConfig{
+			Query:              query,
+			BuildID:            cfg.Build,
+			Step:               cfg.Step,
+			IncludeRetriedJobs: cfg.IncludeRetriedJobs,
+			DebugHTTP:          cfg.DebugHTTP,
+		})
+
+		// Verify the artifacts
+		if _, err := verifier.Verify(ctx); err != nil {
+			l.Fatal("Failed to verify artifacts: %s", err)
+		}
+	},
+}
@@ -83,13 +83,18 @@ type BootstrapConfig struct {
 	LocalHooksEnabled            bool     `cli:"local-hooks-enabled"`
 	PTY                          bool     `cli:"pty"`
 	LogLevel                     string   `cli:"log-level"`
+	PrefixFields                 []string `cli:"log-prefix-fields" normalize:"list"`
 	Debug                        bool     `cli:"debug"`
+	Quiet                        bool     `cli:"quiet"`
 	Shell                        string   `cli:"shell"`
 	Experiments                  []string `cli:"experiment" normalize:"list"`
 	Phases                       []string `cli:"phases" normalize:"list"`
 	Profile                      string   `cli:"profile"`
 	CancelSignal                 string   `cli:"cancel-signal"`
 	RedactedVars                 []string `cli:"redacted-vars" normalize:"list"`
+	RedactedVarsFile             string   `cli:"redacted-vars-file" normalize:"filepath"`
+	RedactedReplacement          string   `cli:"redacted-replacement"`
+	FailOnRedaction              bool     `cli:"fail-on-redaction"`
 	TracingBackend               string   `cli:"tracing-backend"`
 	TracingServiceName           string   `cli:"tracing-service-name"`
 }
@@ -342,9 +347,12 @@ var BootstrapCommand = cli.Command{
 		},
 		cli.StringSliceFlag{
 			Name:   "redacted-vars",
-			Usage:  "Pattern of environment variable names containing sensitive values",
+			Usage:  "Pattern of environment variable names containing sensitive values. ′*′ matches any run of characters in any position, e.g. ′AWS_*_KEY′ or ′*TOKEN*′, ′?′ matches any single character, and ′[AB]′ matches any one of the characters in the brackets",
 			EnvVar: "BUILDKITE_REDACTED_VARS",
 		},
+		RedactedVarsFile,
+		RedactedReplacement,
+		FailOnRedaction,
 		cli.StringFlag{
 			Name:   "tracing-backend",
 			Usage:  "The name of the tracing backend to use.",
@@ -358,7 +366,9 @@ var BootstrapCommand = cli.Command{
 			Value:  "buildkite-agent",
 		},
 		DebugFlag,
+		QuietFlag,
 		LogLevelFlag,
+		LogPrefixFieldsFlag,
 		ExperimentsFlag,
 		ProfileFlag,
 	},
@@ -408,6 +418,11 @@ var BootstrapCommand = cli.Command{
 			}
 		}
 
+		redactedVars, err := mergeRedactedVarsFile(cfg.RedactedVars, cfg.RedactedVarsFile)
+		if err != nil {
+			l.Fatal("Failed to load redacted vars file: %v", err)
+		}
+
 		cancelSig, err := process.ParseSignal(cfg.CancelSignal)
 		if err != nil {
 			l.Fatal("Failed to parse cancel-signal: %v", err)
@@ -452,7 +467,9 @@ var BootstrapCommand = cli.Command{
 			PluginsPath:                  cfg.PluginsPath,
 			PullRequest:                  cfg.PullRequest,
 			Queue:                        cfg.Queue,
-			RedactedVars:                 cfg.RedactedVars,
+			RedactedVars:                 redactedVars,
+			RedactedReplacement:          cfg.RedactedReplacement,
+			FailOnRedaction:              cfg.FailOnRedaction,
 			RefSpec:                      cfg.RefSpec,
 			Repository:                   cfg.Repository,
 			RunInPty:                     runInPty,
@@ -3,13 +3,19 @@ package clicommand
 import (
 	"errors"
 	"fmt"
+	"io"
+	"net/url"
 	"os"
 	"reflect"
 	"strings"
+	"time"
 
 	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/agent/v3/bootstrap/shell"
+	"github.com/buildkite/agent/v3/env"
 	"github.com/buildkite/agent/v3/experiments"
 	"github.com/buildkite/agent/v3/logger"
+	"github.com/buildkite/agent/v3/redaction"
 	"github.com/buildkite/agent/v3/version"
 	"github.com/oleiade/reflections"
 	"github.com/urfave/cli"
@@ -36,7 +42,7 @@ var AgentRegisterTokenFlag = cli.StringFlag{
 var EndpointFlag = cli.StringFlag{
 	Name:   "endpoint",
 	Value:  DefaultEndpoint,
-	Usage:  "The Agent API endpoint",
+	Usage:  "The Agent API endpoint, or a region token such as ′auto′ or ′region:<name>′ (e.g. ′region:us′)",
 	EnvVar: "BUILDKITE_AGENT_ENDPOINT",
 }
 
@@ -46,12 +52,38 @@ var NoHTTP2Flag = cli.BoolFlag{
 	EnvVar: "BUILDKITE_NO_HTTP2",
 }
 
+var MaxAPIWaitFlag = cli.DurationFlag{
+	Name:   "max-api-wait",
+	Usage:  "The longest to spend on any single Agent API operation, including retries, before giving up with a timeout error. Zero means no limit",
+	EnvVar: "BUILDKITE_AGENT_MAX_API_WAIT",
+}
+
+var APIMaxIdleConnsPerHostFlag = cli.IntFlag{
+	Name:   "api-max-idle-conns-per-host",
+	Value:  0,
+	Usage:  "The most idle connections to keep open to the Agent API, for reuse by later requests. Zero means the Go default of 2",
+	EnvVar: "BUILDKITE_AGENT_API_MAX_IDLE_CONNS_PER_HOST",
+}
+
+var APIMaxConnsPerHostFlag = cli.IntFlag{
+	Name:   "api-max-conns-per-host",
+	Value:  0,
+	Usage:  "The most connections to open to the Agent API at once, including those in use. Requests wait for a connection once it's reached. Zero means no limit",
+	EnvVar: "BUILDKITE_AGENT_API_MAX_CONNS_PER_HOST",
+}
+
 var DebugFlag = cli.BoolFlag{
 	Name:   "debug",
 	Usage:  "Enable debug mode. Synonym for ′--log-level debug′. Takes precedence over ′--log-level′",
 	EnvVar: "BUILDKITE_AGENT_DEBUG",
 }
 
+var QuietFlag = cli.BoolFlag{
+	Name:   "quiet, q",
+	Usage:  "Only log warnings and errors. Synonym for ′--log-level warn′. Takes precedence over ′--log-level′, but not ′--debug′",
+	EnvVar: "BUILDKITE_AGENT_QUIET",
+}
+
 var LogLevelFlag = cli.StringFlag{
 	Name:   "log-level",
 	Value:  "notice",
@@ -59,6 +91,13 @@ var LogLevelFlag = cli.StringFlag{
 	EnvVar: "BUILDKITE_AGENT_LOG_LEVEL",
 }
 
+var LogPrefixFieldsFlag = cli.StringSliceFlag{
+	Name:   "log-prefix-fields",
+	Value:  &cli.StringSlice{},
+	Usage:  "The keys of the log fields to show as a prefix in text logs, instead of ′agent′ and ′hook′",
+	EnvVar: "BUILDKITE_AGENT_LOG_PREFIX_FIELDS",
+}
+
 var ProfileFlag = cli.StringFlag{
 	Name:   "profile",
 	Usage:  "Enable a profiling mode, either cpu, memory, mutex or block",
@@ -86,15 +125,192 @@ var ExperimentsFlag = cli.StringSliceFlag{
 
 var RedactedVars = cli.StringSliceFlag{
 	Name:   "redacted-vars",
-	Usage:  "Pattern of environment variable names containing sensitive values",
+	Usage:  "Pattern of environment variable names containing sensitive values. ′*′ matches any run of characters in any position, e.g. ′AWS_*_KEY′ or ′*TOKEN*′, ′?′ matches any single character, and ′[AB]′ matches any one of the characters in the brackets",
 	EnvVar: "BUILDKITE_REDACTED_VARS",
 	Value:  &cli.StringSlice{"*_PASSWORD", "*_SECRET", "*_TOKEN", "*_ACCESS_KEY", "*_SECRET_KEY"},
 }
 
+// logRedactor returns a function that redacts the values of the environment
+// variables matching the config's RedactedVars option, merged with the
+// patterns in its RedactedVarsFile if it has one, in the same way as job
+// output, or nil if there aren't any
+func logRedactor(cfg any) func(string) string {
+	patternsCfg, err := reflections.GetField(cfg, "RedactedVars")
+	if err != nil {
+		return nil
+	}
+	patterns, ok := patternsCfg.([]string)
+	if !ok {
+		return nil
+	}
+
+	// The logger is created before commands read the file themselves, and
+	// they report any error reading it, so here it's only merged if it can
+	// be read
+	if filenameCfg, err := reflections.GetField(cfg, "RedactedVarsFile"); err == nil {
+		if filename, ok := filenameCfg.(string); ok {
+			if merged, err := mergeRedactedVarsFile(append([]string{}, patterns...), filename); err == nil {
+				patterns = merged
+			}
+		}
+	}
+	if len(patterns) == 0 {
+		return nil
+	}
+
+	values := redaction.GetValuesToRedact(shell.DiscardLogger, patterns, env.FromSlice(os.Environ()).Dump())
+	if len(values) == 0 {
+		return nil
+	}
+
+	replacement := redaction.DefaultReplacement
+	if replacementCfg, err := reflections.GetField(cfg, "RedactedReplacement"); err == nil {
+		if template, ok := replacementCfg.(string); ok {
+			replacement = redaction.Replacement(template, "")
+		}
+	}
+
+	return redaction.NewStringRedactor(replacement, values).Redact
+}
+
+// DefaultLogPrefixFields are the keys of the fields that are shown as a prefix
+// in text logs, unless the config has a PrefixFields option that overrides them.
+var DefaultLogPrefixFields = []string{"agent", "hook"}
+
+var RedactedReplacement = cli.StringFlag{
+	Name:   "redacted-replacement",
+	Usage:  "What to replace redacted values with in job output. ′%name′ is replaced with the name of the environment variable the value came from, e.g. ′[REDACTED:%name]′",
+	EnvVar: "BUILDKITE_REDACTED_REPLACEMENT",
+	Value:  redaction.DefaultReplacement,
+}
+
+var FailOnRedaction = cli.BoolFlag{
+	Name:   "fail-on-redaction",
+	Usage:  "Fail the job if any values were redacted from its output, since that means something printed a secret it shouldn't have",
+	EnvVar: "BUILDKITE_FAIL_ON_REDACTION",
+}
+
+// LogCorrelationEnv maps the fields that every log line can be tagged with,
+// to correlate lines from many jobs once they're aggregated, to the
+// environment variables their values are read from.
+var LogCorrelationEnv = map[string]string{
+	"job_id":   "BUILDKITE_JOB_ID",
+	"build_id": "BUILDKITE_BUILD_ID",
+	"step_id":  "BUILDKITE_STEP_ID",
+}
+
+// LogCorrelationFieldEnvVar names the field, one of the keys of
+// LogCorrelationEnv, that every log line is tagged with, unless the config has
+// a LogCorrelationField option that overrides it. It's shown as a prefix in
+// text logs.
+const LogCorrelationFieldEnvVar = "BUILDKITE_AGENT_LOG_CORRELATION_FIELD"
+
+var RedactedVarsFile = cli.StringFlag{
+	Name:   "redacted-vars-file",
+	Usage:  "Path to a file of environment variable name patterns containing sensitive values, one per line. Merged with ′--redacted-vars′",
+	EnvVar: "BUILDKITE_REDACTED_VARS_FILE",
+}
+
+// mergeRedactedVarsFile returns patterns combined with the patterns read from
+// filename, if a filename was given
+func mergeRedactedVarsFile(patterns []string, filename string) ([]string, error) {
+	if filename == "" {
+		return patterns, nil
+	}
+
+	filePatterns, err := redaction.ReadPatternsFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(patterns, filePatterns...), nil
+}
+
+// logCorrelationField returns the field that every log line should be tagged
+// with, from the config's LogCorrelationField option if it has one, or the
+// environment. The field is nil if it isn't configured or has no value.
+func logCorrelationField(cfg any) (logger.Field, error) {
+	key := os.Getenv(LogCorrelationFieldEnvVar)
+	if keyCfg, err := reflections.GetField(cfg, "LogCorrelationField"); err == nil {
+		if keyString, ok := keyCfg.(string); ok && keyString != "" {
+			key = keyString
+		}
+	}
+	if key == "" {
+		return nil, nil
+	}
+
+	envVar, ok := LogCorrelationEnv[key]
+	if !ok {
+		return nil, fmt.Errorf("unknown log correlation field %q, try job_id, build_id or step_id", key)
+	}
+
+	value := os.Getenv(envVar)
+	if value == "" {
+		return nil, nil
+	}
+	return logger.StringField(key, value), nil
+}
+
+// jsonPrinterOptions returns the options for the JSON log printer from the
+// config's LogJSONRename ("old=new"), LogJSONDrop, LogJSONStaticFields
+// ("key=value") and LogJSONFlatten (with LogJSONFlattenDelimiter) options, if
+// it has them
+func jsonPrinterOptions(cfg any) (logger.JSONPrinterOptions, error) {
+	var opts logger.JSONPrinterOptions
+
+	if renameCfg, err := reflections.GetField(cfg, "LogJSONRename"); err == nil {
+		renames, _ := renameCfg.([]string)
+		for _, rename := range renames {
+			from, to, ok := strings.Cut(rename, "=")
+			if !ok || from == "" || to == "" {
+				return opts, fmt.Errorf("invalid JSON log field rename %q, expected old=new", rename)
+			}
+			if opts.Rename == nil {
+				opts.Rename = make(map[string]string)
+			}
+			opts.Rename[from] = to
+		}
+	}
+
+	if dropCfg, err := reflections.GetField(cfg, "LogJSONDrop"); err == nil {
+		opts.Drop, _ = dropCfg.([]string)
+	}
+
+	if staticCfg, err := reflections.GetField(cfg, "LogJSONStaticFields"); err == nil {
+		fields, _ := staticCfg.([]string)
+		for _, field := range fields {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok || key == "" {
+				return opts, fmt.Errorf("invalid JSON log static field %q, expected key=value", field)
+			}
+			if opts.StaticFields == nil {
+				opts.StaticFields = make(map[string]string)
+			}
+			opts.StaticFields[key] = value
+		}
+	}
+
+	if flatten, err := reflections.GetField(cfg, "LogJSONFlatten"); err == nil && flatten == true {
+		opts.FlattenDelimiter = "."
+		if delimiter, err := reflections.GetField(cfg, "LogJSONFlattenDelimiter"); err == nil && delimiter != "" {
+			opts.FlattenDelimiter = delimiter.(string)
+		}
+	}
+
+	return opts, nil
+}
+
 func CreateLogger(cfg any) logger.Logger {
 	var l logger.Logger
 	logFormat := "text"
 
+	correlationField, err := logCorrelationField(cfg)
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		os.Exit(1)
+	}
+
 	// Check the LogFormat config field
 	if logFormatCfg, err := reflections.GetField(cfg, "LogFormat"); err == nil {
 		if logFormatString, ok := logFormatCfg.(string); ok {
@@ -102,53 +318,188 @@ func CreateLogger(cfg any) logger.Logger {
 		}
 	}
 
-	// Create a logger based on the type
+	// Create a printer based on the type, for whichever stream it's needed
+	var newPrinter func(w io.Writer) logger.Printer
+	var defaultWriter io.Writer
 	switch logFormat {
 	case "text", "":
-		printer := logger.NewTextPrinter(os.Stderr)
-
-		// Show agent fields as a prefix
-		printer.IsPrefixFn = func(field logger.Field) bool {
-			switch field.Key() {
-			case "agent", "hook":
-				return true
-			default:
-				return false
-			}
-		}
+		prefixFields := logPrefixFields(cfg, correlationField)
 
 		// Turn off color if a NoColor option is present
-		noColor, err := reflections.GetField(cfg, "NoColor")
-		if noColor == true && err == nil {
-			printer.Colors = false
-		} else {
-			printer.Colors = true
+		colors := true
+		if noColor, err := reflections.GetField(cfg, "NoColor"); noColor == true && err == nil {
+			colors = false
 		}
 
-		l = logger.NewConsoleLogger(printer, os.Exit)
+		newPrinter = func(w io.Writer) logger.Printer {
+			printer := logger.NewTextPrinter(w)
+			printer.IsPrefixFn = logger.PrefixFields(prefixFields...)
+			printer.Colors = colors
+			return printer
+		}
+		defaultWriter = os.Stderr
 	case "json":
-		l = logger.NewConsoleLogger(logger.NewJSONPrinter(os.Stdout), os.Exit)
+		// Customise the fields if LogJSON* options are present
+		options, err := jsonPrinterOptions(cfg)
+		if err != nil {
+			fmt.Printf("%s\n", err)
+			os.Exit(1)
+		}
+
+		newPrinter = func(w io.Writer) logger.Printer {
+			printer := logger.NewJSONPrinter(w)
+			printer.Options = options
+			return printer
+		}
+		defaultWriter = os.Stdout
 	default:
 		fmt.Printf("Unknown log-format of %q, try text or json\n", logFormat)
 		os.Exit(1)
 	}
 
+	// Split the log between stderr and stdout by level if a LogStderrLevel
+	// option is present and set
+	printer := newPrinter(defaultWriter)
+	if levelCfg, err := reflections.GetField(cfg, "LogStderrLevel"); err == nil {
+		if levelString, ok := levelCfg.(string); ok && levelString != "" {
+			level, err := logger.LevelFromString(levelString)
+			if err != nil {
+				fmt.Printf("Invalid log-stderr-level: %v\n", err)
+				os.Exit(1)
+			}
+			printer = logger.NewLevelRoutingPrinter(level, newPrinter(os.Stderr), newPrinter(os.Stdout))
+		}
+	}
+
+	// Also log to a file if a LogFile option is present and set, at its own
+	// level. The console is filtered to the usual level, set below, and the
+	// logger's level is lowered to the file's if that's lower.
+	var consolePrinter *logger.LevelFilterPrinter
+	filePrinter, fileLevel, err := logFilePrinter(cfg, correlationField)
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		os.Exit(1)
+	}
+	if filePrinter != nil {
+		consolePrinter = logger.NewLevelFilterPrinter(logger.DEBUG, printer)
+		printer = logger.MultiPrinter{consolePrinter, filePrinter}
+	}
+
+	// Redact the values of sensitive environment variables, if a
+	// RedactedVars option is present
+	if redact := logRedactor(cfg); redact != nil {
+		printer = logger.NewRedactingPrinter(printer, redact)
+	}
+
+	l = logger.NewConsoleLogger(printer, os.Exit)
+
+	if correlationField != nil {
+		l = l.WithFields(correlationField)
+	}
+
 	l.SetLevel(logger.NOTICE)
 
-	err := handleLogLevelFlag(l, cfg)
+	err = handleLogLevelFlag(l, cfg)
 	if err != nil {
 		l.Warn("Error when setting log level: %v. Defaulting log level to NOTICE", err)
 	}
 
-	// Enable debugging if a Debug option is present
+	// Only show warnings and errors if a Quiet option is present
+	quietI, _ := reflections.GetField(cfg, "Quiet")
+	if quiet, ok := quietI.(bool); ok && quiet {
+		l.SetLevel(logger.WARN)
+	}
+
+	// Enable debugging if a Debug option is present, this takes precedence
+	// over both Quiet and LogLevel
 	debugI, _ := reflections.GetField(cfg, "Debug")
 	if debug, ok := debugI.(bool); ok && debug {
 		l.SetLevel(logger.DEBUG)
 	}
 
+	if consolePrinter != nil {
+		consolePrinter.Level = l.Level()
+		if fileLevel < l.Level() {
+			l.SetLevel(fileLevel)
+		}
+	}
+
 	return l
 }
 
+// logPrefixFields returns the keys of the fields shown as a prefix in text
+// logs: the agent fields, or whichever fields are configured by a PrefixFields
+// option if one is present, after the correlation field if there is one
+func logPrefixFields(cfg any, correlationField logger.Field) []string {
+	prefixFields := DefaultLogPrefixFields
+	if prefixFieldsCfg, err := reflections.GetField(cfg, "PrefixFields"); err == nil {
+		if keys, ok := prefixFieldsCfg.([]string); ok && len(keys) > 0 {
+			prefixFields = keys
+		}
+	}
+	if correlationField != nil {
+		prefixFields = append([]string{correlationField.Key()}, prefixFields...)
+	}
+	return prefixFields
+}
+
+// logFilePrinter returns a printer for the file named by a LogFile option, if
+// one is present and set, in the format of the LogFileFormat option (JSON by
+// default), along with the level of the LogFileLevel option (DEBUG by
+// default). Text logs have the same prefix fields as the console.
+func logFilePrinter(cfg any, correlationField logger.Field) (logger.Printer, logger.Level, error) {
+	fileCfg, err := reflections.GetField(cfg, "LogFile")
+	if err != nil {
+		return nil, 0, nil
+	}
+	path, ok := fileCfg.(string)
+	if !ok || path == "" {
+		return nil, 0, nil
+	}
+
+	level := logger.DEBUG
+	if levelCfg, err := reflections.GetField(cfg, "LogFileLevel"); err == nil {
+		if levelString, ok := levelCfg.(string); ok && levelString != "" {
+			if level, err = logger.LevelFromString(levelString); err != nil {
+				return nil, 0, fmt.Errorf("Invalid log-file-level: %w", err)
+			}
+		}
+	}
+
+	format := "json"
+	if formatCfg, err := reflections.GetField(cfg, "LogFileFormat"); err == nil {
+		if formatString, ok := formatCfg.(string); ok && formatString != "" {
+			format = formatString
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Failed to open log-file: %w", err)
+	}
+
+	var printer logger.Printer
+	switch format {
+	case "text":
+		textPrinter := logger.NewTextPrinter(f)
+		textPrinter.IsPrefixFn = logger.PrefixFields(logPrefixFields(cfg, correlationField)...)
+		textPrinter.Colors = false
+		printer = textPrinter
+	case "json":
+		options, err := jsonPrinterOptions(cfg)
+		if err != nil {
+			return nil, 0, err
+		}
+		jsonPrinter := logger.NewJSONPrinter(f)
+		jsonPrinter.Options = options
+		printer = jsonPrinter
+	default:
+		return nil, 0, fmt.Errorf("Unknown log-file-format of %q, try text or json", format)
+	}
+
+	return logger.NewLevelFilterPrinter(level, printer), level, nil
+}
+
 func HandleProfileFlag(l logger.Logger, cfg any) func() {
 	// Enable profiling a profiling mode if Profile is present
 	modeField, _ := reflections.GetField(cfg, "Profile")
@@ -218,7 +569,13 @@ func UnsetConfigFromEnvironment(c *cli.Context) error {
 	return nil
 }
 
-func loadAPIClientConfig(cfg any, tokenField string) api.Config {
+// loadAPIClientConfig builds an api.Config from a config struct that has
+// already been populated by a cliconfig.Loader. The endpoint and token in it
+// are resolved by the loader in order of precedence: a flag on the command
+// line, then its environment variable, then the config file given with
+// --config (or found in a default location), then the flag's default. An
+// endpoint that isn't an http or https URL, or a region token, is an error.
+func loadAPIClientConfig(cfg any, tokenField string) (api.Config, error) {
 	conf := api.Config{
 		UserAgent: version.UserAgent(),
 	}
@@ -232,6 +589,9 @@ func loadAPIClientConfig(cfg any, tokenField string) api.Config {
 	endpoint, err := reflections.GetField(cfg, "Endpoint")
 	if endpoint != "" && err == nil {
 		conf.Endpoint = endpoint.(string)
+		if err := validateEndpoint(conf.Endpoint); err != nil {
+			return conf, err
+		}
 	}
 
 	token, err := reflections.GetField(cfg, tokenField)
@@ -244,5 +604,47 @@ func loadAPIClientConfig(cfg any, tokenField string) api.Config {
 		conf.DisableHTTP2 = noHTTP2.(bool)
 	}
 
-	return conf
+	// The flag is parsed as a duration, but a value from a config file isn't
+	maxAPIWaitI, _ := reflections.GetField(cfg, "MaxAPIWait")
+	if maxAPIWait, ok := maxAPIWaitI.(string); ok && maxAPIWait != "" {
+		maxWait, err := time.ParseDuration(maxAPIWait)
+		if err != nil {
+			return conf, fmt.Errorf("invalid max-api-wait %q: %w", maxAPIWait, err)
+		}
+		if maxWait < 0 {
+			return conf, fmt.Errorf("invalid max-api-wait %q: it must be positive", maxAPIWait)
+		}
+		conf.MaxWait = maxWait
+	}
+
+	if maxIdle, err := reflections.GetField(cfg, "APIMaxIdleConnsPerHost"); err == nil {
+		conf.MaxIdleConnsPerHost = maxIdle.(int)
+	}
+
+	if maxConns, err := reflections.GetField(cfg, "APIMaxConnsPerHost"); err == nil {
+		conf.MaxConnsPerHost = maxConns.(int)
+	}
+
+	return conf, nil
+}
+
+// validateEndpoint returns an error if the endpoint isn't an http or https URL
+// with a host, or one of the region tokens api.NewClient resolves, so that a
+// mistyped endpoint fails at startup rather than on the first request
+func validateEndpoint(endpoint string) error {
+	if endpoint == api.EndpointAuto || strings.HasPrefix(endpoint, api.EndpointRegionPrefix) {
+		return nil
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid endpoint %q: %w", endpoint, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid endpoint %q: it must be an http or https URL, e.g. %s", endpoint, DefaultEndpoint)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid endpoint %q: it has no host", endpoint)
+	}
+	return nil
 }
@@ -0,0 +1,263 @@
+package clicommand
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent/v3/cliconfig"
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli"
+)
+
+type apiClientTestConfig struct {
+	Config           string `cli:"config"`
+	Endpoint         string `cli:"endpoint"`
+	AgentAccessToken string `cli:"agent-access-token"`
+}
+
+// loadAPIClientConfigFromArgs runs a command with the given arguments through
+// a cliconfig.Loader, and returns the endpoint and token loadAPIClientConfig
+// resolves from the result
+func loadAPIClientConfigFromArgs(t *testing.T, args ...string) (endpoint, token string) {
+	t.Helper()
+
+	app := cli.NewApp()
+	app.Commands = []cli.Command{
+		{
+			Name: "test",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "config"},
+				EndpointFlag,
+				AgentAccessTokenFlag,
+			},
+			Action: func(c *cli.Context) error {
+				cfg := apiClientTestConfig{}
+				loader := cliconfig.Loader{CLI: c, Config: &cfg}
+				if _, err := loader.Load(); err != nil {
+					return err
+				}
+
+				conf, err := loadAPIClientConfig(cfg, "AgentAccessToken")
+				endpoint, token = conf.Endpoint, conf.Token
+				return err
+			},
+		},
+	}
+
+	if err := app.Run(append([]string{"buildkite-agent", "test"}, args...)); err != nil {
+		t.Fatalf("app.Run() error = %v", err)
+	}
+	return endpoint, token
+}
+
+func TestLoadAPIClientConfigPrecedence(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "buildkite-agent.cfg")
+	config := "endpoint=https://file.example.com/v3\nagent-access-token=file-token\n"
+	if err := os.WriteFile(configPath, []byte(config), 0o600); err != nil {
+		t.Fatalf("os.WriteFile() error = %v", err)
+	}
+
+	t.Run("default", func(t *testing.T) {
+		endpoint, token := loadAPIClientConfigFromArgs(t)
+		assert.Equal(t, DefaultEndpoint, endpoint)
+		assert.Equal(t, "", token)
+	})
+
+	t.Run("file over default", func(t *testing.T) {
+		endpoint, token := loadAPIClientConfigFromArgs(t, "--config", configPath)
+		assert.Equal(t, "https://file.example.com/v3", endpoint)
+		assert.Equal(t, "file-token", token)
+	})
+
+	t.Run("env over file", func(t *testing.T) {
+		t.Setenv("BUILDKITE_AGENT_ENDPOINT", "https://env.example.com/v3")
+		t.Setenv("BUILDKITE_AGENT_ACCESS_TOKEN", "env-token")
+
+		endpoint, token := loadAPIClientConfigFromArgs(t, "--config", configPath)
+		assert.Equal(t, "https://env.example.com/v3", endpoint)
+		assert.Equal(t, "env-token", token)
+	})
+
+	t.Run("flag over env", func(t *testing.T) {
+		t.Setenv("BUILDKITE_AGENT_ENDPOINT", "https://env.example.com/v3")
+		t.Setenv("BUILDKITE_AGENT_ACCESS_TOKEN", "env-token")
+
+		endpoint, token := loadAPIClientConfigFromArgs(t,
+			"--config", configPath,
+			"--endpoint", "https://flag.example.com/v3",
+			"--agent-access-token", "flag-token",
+		)
+		assert.Equal(t, "https://flag.example.com/v3", endpoint)
+		assert.Equal(t, "flag-token", token)
+	})
+}
+
+func TestLoadAPIClientConfigEndpointValidation(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		endpoint string
+		wantErr  bool
+	}{
+		{endpoint: "https://agent.buildkite.com/v3"},
+		{endpoint: "http://localhost:3000/v3"},
+		{endpoint: "auto"},
+		{endpoint: "region:eu"},
+		{endpoint: "agent.buildkite.com/v3", wantErr: true},
+		{endpoint: "file:///tmp/agent", wantErr: true},
+		{endpoint: "https:///v3", wantErr: true},
+		{endpoint: "https://agent.buildkite.com/%zz", wantErr: true},
+	} {
+		_, err := loadAPIClientConfig(apiClientTestConfig{Endpoint: tc.endpoint}, "AgentAccessToken")
+		if (err != nil) != tc.wantErr {
+			t.Errorf("loadAPIClientConfig(endpoint: %q) error = %v, wantErr %t", tc.endpoint, err, tc.wantErr)
+		}
+		if err != nil {
+			assert.Contains(t, err.Error(), tc.endpoint)
+		}
+	}
+}
+
+type maxAPIWaitTestConfig struct {
+	MaxAPIWait string `cli:"max-api-wait"`
+}
+
+func TestLoadAPIClientConfigMaxAPIWait(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		maxAPIWait string
+		want       time.Duration
+		wantErr    bool
+	}{
+		{maxAPIWait: "", want: 0},
+		{maxAPIWait: "90s", want: 90 * time.Second},
+		{maxAPIWait: "5m0s", want: 5 * time.Minute},
+		{maxAPIWait: "ninety", wantErr: true},
+		{maxAPIWait: "90", wantErr: true},
+		{maxAPIWait: "-1m", wantErr: true},
+	} {
+		conf, err := loadAPIClientConfig(maxAPIWaitTestConfig{MaxAPIWait: tc.maxAPIWait}, "AgentAccessToken")
+		if (err != nil) != tc.wantErr {
+			t.Errorf("loadAPIClientConfig(max-api-wait: %q) error = %v, wantErr %t", tc.maxAPIWait, err, tc.wantErr)
+			continue
+		}
+		if err != nil {
+			assert.Contains(t, err.Error(), tc.maxAPIWait)
+			continue
+		}
+		if conf.MaxWait != tc.want {
+			t.Errorf("loadAPIClientConfig(max-api-wait: %q).MaxWait = %s, want %s", tc.maxAPIWait, conf.MaxWait, tc.want)
+		}
+	}
+}
+
+type logPrefixFieldsTestConfig struct {
+	LogLevel      string
+	LogFile       string
+	LogFileFormat string
+	PrefixFields  []string
+}
+
+func TestLogPrefixFields(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, []string{"agent", "hook"}, logPrefixFields(logPrefixFieldsTestConfig{}, nil))
+	assert.Equal(t, []string{"pipeline"}, logPrefixFields(logPrefixFieldsTestConfig{PrefixFields: []string{"pipeline"}}, nil))
+	assert.Equal(t, []string{"job_id", "pipeline"}, logPrefixFields(
+		logPrefixFieldsTestConfig{PrefixFields: []string{"pipeline"}},
+		logger.StringField("job_id", "0186b5a4"),
+	))
+}
+
+func TestCreateLoggerLogFilePrefixFields(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "agent.log")
+	l := CreateLogger(logPrefixFieldsTestConfig{
+		LogFile:       path,
+		LogFileFormat: "text",
+		PrefixFields:  []string{"pipeline"},
+	})
+
+	l.WithFields(logger.StringField("pipeline", "alpacas")).Notice("llamas rock")
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error = %v", path, err)
+	}
+	assert.Contains(t, string(b), "NOTICE alpacas llamas rock")
+}
+
+type logRedactorTestConfig struct {
+	RedactedVars     []string
+	RedactedVarsFile string
+}
+
+func TestLogRedactorMergesRedactedVarsFile(t *testing.T) {
+	t.Setenv("LLAMA_SPIT", "llamasecret")
+
+	path := filepath.Join(t.TempDir(), "redacted-vars")
+	if err := os.WriteFile(path, []byte("LLAMA_SPIT\n"), 0o600); err != nil {
+		t.Fatalf("os.WriteFile(%q) error = %v", path, err)
+	}
+
+	redact := logRedactor(logRedactorTestConfig{
+		RedactedVars:     []string{"*_PASSWORD"},
+		RedactedVarsFile: path,
+	})
+	if redact == nil {
+		t.Fatal("logRedactor() = nil, want a redactor for the patterns in the file")
+	}
+	assert.Equal(t, "spat [REDACTED]", redact("spat llamasecret"))
+
+	// The file's patterns reach the logger too, not just job output
+	logPath := filepath.Join(t.TempDir(), "agent.log")
+	l := CreateLogger(struct {
+		LogLevel         string
+		LogFile          string
+		RedactedVars     []string
+		RedactedVarsFile string
+	}{
+		LogLevel:         "notice",
+		LogFile:          logPath,
+		RedactedVarsFile: path,
+	})
+	l.Notice("spat llamasecret")
+
+	b, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error = %v", logPath, err)
+	}
+	assert.NotContains(t, string(b), "llamasecret")
+	assert.Contains(t, string(b), "spat [REDACTED]")
+}
+
+type connectionPoolTestConfig struct {
+	APIMaxIdleConnsPerHost int `cli:"api-max-idle-conns-per-host"`
+	APIMaxConnsPerHost     int `cli:"api-max-conns-per-host"`
+}
+
+func TestLoadAPIClientConfigConnectionPool(t *testing.T) {
+	t.Parallel()
+
+	conf, err := loadAPIClientConfig(connectionPoolTestConfig{}, "AgentAccessToken")
+	if err != nil {
+		t.Fatalf("loadAPIClientConfig() error = %v", err)
+	}
+	assert.Equal(t, 0, conf.MaxIdleConnsPerHost)
+	assert.Equal(t, 0, conf.MaxConnsPerHost)
+
+	conf, err = loadAPIClientConfig(connectionPoolTestConfig{
+		APIMaxIdleConnsPerHost: 8,
+		APIMaxConnsPerHost:     16,
+	}, "AgentAccessToken")
+	if err != nil {
+		t.Fatalf("loadAPIClientConfig() error = %v", err)
+	}
+	assert.Equal(t, 8, conf.MaxIdleConnsPerHost)
+	assert.Equal(t, 16, conf.MaxConnsPerHost)
+}
@@ -52,26 +52,30 @@ Example:
    $ ./script/dynamic_step_generator | buildkite-agent pipeline upload`
 
 type PipelineUploadConfig struct {
-	FilePath        string   `cli:"arg:0" label:"upload paths"`
-	Replace         bool     `cli:"replace"`
-	Job             string   `cli:"job"`
-	DryRun          bool     `cli:"dry-run"`
-	NoInterpolation bool     `cli:"no-interpolation"`
-	RedactedVars    []string `cli:"redacted-vars" normalize:"list"`
-	RejectSecrets   bool     `cli:"reject-secrets"`
+	FilePath         string   `cli:"arg:0" label:"upload paths"`
+	Replace          bool     `cli:"replace"`
+	Job              string   `cli:"job"`
+	DryRun           bool     `cli:"dry-run"`
+	NoInterpolation  bool     `cli:"no-interpolation"`
+	RedactedVars     []string `cli:"redacted-vars" normalize:"list"`
+	RedactedVarsFile string   `cli:"redacted-vars-file" normalize:"filepath"`
+	RejectSecrets    bool     `cli:"reject-secrets"`
 
 	// Global flags
-	Debug       bool     `cli:"debug"`
-	LogLevel    string   `cli:"log-level"`
-	NoColor     bool     `cli:"no-color"`
-	Experiments []string `cli:"experiment" normalize:"list"`
-	Profile     string   `cli:"profile"`
+	Debug        bool     `cli:"debug"`
+	Quiet        bool     `cli:"quiet"`
+	LogLevel     string   `cli:"log-level"`
+	PrefixFields []string `cli:"log-prefix-fields" normalize:"list"`
+	NoColor      bool     `cli:"no-color"`
+	Experiments  []string `cli:"experiment" normalize:"list"`
+	Profile      string   `cli:"profile"`
 
 	// API config
 	DebugHTTP        bool   `cli:"debug-http"`
 	AgentAccessToken string `cli:"agent-access-token" validate:"required"`
 	Endpoint         string `cli:"endpoint" validate:"required"`
 	NoHTTP2          bool   `cli:"no-http2"`
+	MaxAPIWait       string `cli:"max-api-wait"`
 }
 
 var PipelineUploadCommand = cli.Command{
@@ -110,15 +114,19 @@ var PipelineUploadCommand = cli.Command{
 		AgentAccessTokenFlag,
 		EndpointFlag,
 		NoHTTP2Flag,
+		MaxAPIWaitFlag,
 		DebugHTTPFlag,
 
 		// Global flags
 		NoColorFlag,
 		DebugFlag,
+		QuietFlag,
 		LogLevelFlag,
+		LogPrefixFieldsFlag,
 		ExperimentsFlag,
 		ProfileFlag,
 		RedactedVars,
+		RedactedVarsFile,
 	},
 	Action: func(c *cli.Context) {
 		ctx := context.Background()
@@ -245,6 +253,11 @@ var PipelineUploadCommand = cli.Command{
 			l.Fatal("Pipeline parsing of \"%s\" failed (%s)", src, err)
 		}
 
+		cfg.RedactedVars, err = mergeRedactedVarsFile(cfg.RedactedVars, cfg.RedactedVarsFile)
+		if err != nil {
+			l.Fatal("Failed to load redacted vars file: %v", err)
+		}
+
 		if len(cfg.RedactedVars) > 0 {
 			needles := redaction.GetKeyValuesToRedact(shell.StderrLogger, cfg.RedactedVars, env.FromSlice(os.Environ()).Dump())
 
@@ -297,8 +310,13 @@ var PipelineUploadCommand = cli.Command{
 			l.Fatal("Missing agent-access-token parameter. Usually this is set in the environment for a Buildkite job via BUILDKITE_AGENT_ACCESS_TOKEN.")
 		}
 
+		apiConf, err := loadAPIClientConfig(cfg, "AgentAccessToken")
+		if err != nil {
+			l.Fatal("%s", err)
+		}
+
 		uploader := &agent.PipelineUploader{
-			Client: api.NewClient(l, loadAPIClientConfig(cfg, "AgentAccessToken")),
+			Client: api.NewClient(l, apiConf),
 			JobID:  cfg.Job,
 			Change: &api.PipelineChange{
 				UUID:     api.NewUUID(),
@@ -4,10 +4,13 @@ import (
 	"bufio"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/buildkite/agent/v3/utils"
+	"gopkg.in/yaml.v3"
 )
 
 type File struct {
@@ -16,6 +19,20 @@ type File struct {
 
 	// A map of key/values that was loaded from the file
 	Config map[string]string
+
+	// Whether the file was YAML or JSON, rather than lines of key=value
+	structured bool
+}
+
+// IsStructured returns whether the file's path has a YAML or JSON extension,
+// in which case it's loaded as a YAML mapping rather than lines of key=value
+func (f File) IsStructured() bool {
+	switch strings.ToLower(filepath.Ext(f.Path)) {
+	case ".yml", ".yaml", ".json":
+		return true
+	default:
+		return false
+	}
 }
 
 func (f *File) Load() error {
@@ -37,6 +54,11 @@ func (f *File) Load() error {
 	// Make sure the config file is closed when this function finishes
 	defer file.Close()
 
+	if f.IsStructured() {
+		f.structured = true
+		return f.loadStructured(file)
+	}
+
 	// Get all the lines in the file
 	var lines []string
 	scanner := bufio.NewScanner(file)
@@ -59,6 +81,39 @@ func (f *File) Load() error {
 	return nil
 }
 
+// loadStructured loads a YAML (or JSON, which is YAML too) mapping of keys to
+// values. Lists are joined with commas, the same as list values in lines of
+// key=value.
+func (f *File) loadStructured(file *os.File) error {
+	var parsed map[string]any
+	if err := yaml.NewDecoder(file).Decode(&parsed); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("parsing config file %s: %w", f.Path, err)
+	}
+
+	for key, value := range parsed {
+		switch value := value.(type) {
+		case nil:
+			f.Config[key] = ""
+		case []any:
+			items := make([]string, 0, len(value))
+			for _, item := range value {
+				switch item.(type) {
+				case []any, map[string]any:
+					return fmt.Errorf("config option %q in %s must be a list of strings", key, f.Path)
+				}
+				items = append(items, fmt.Sprint(item))
+			}
+			f.Config[key] = strings.Join(items, ",")
+		case map[string]any:
+			return fmt.Errorf("config option %q in %s must be a string or a list of strings", key, f.Path)
+		default:
+			f.Config[key] = fmt.Sprint(value)
+		}
+	}
+
+	return nil
+}
+
 func (f File) AbsolutePath() (string, error) {
 	return utils.NormalizeFilePath(f.Path)
 }
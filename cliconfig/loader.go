@@ -8,6 +8,7 @@ import (
 	"os"
 	"reflect"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -71,6 +72,11 @@ func (l *Loader) Load() (warnings []string, err error) {
 		if err := l.File.Load(); err != nil {
 			return warnings, fmt.Errorf("loading config file: %w", err)
 		}
+
+		// YAML and JSON files are newer, so we can be stricter about them
+		if l.File.structured {
+			warnings = append(warnings, l.unknownFileKeys()...)
+		}
 	}
 
 	// Now it's onto actually setting the fields. We start by getting all
@@ -207,6 +213,15 @@ func (l Loader) setFieldValueFromCLI(fieldName string, cliName string) error {
 				}
 			}
 		}
+
+		// Otherwise see if it's in the config file, under the name in the
+		// field's config tag
+		if value == nil && l.File != nil {
+			configName, _ := reflections.GetFieldTag(l.Config, fieldName, "config")
+			if configFileValue, ok := l.File.Config[configName]; ok && configName != "" {
+				value = configFileValue
+			}
+		}
 	} else {
 		// If the cli name didn't have the special format, then we need to
 		// either load from the context's flags, or from a config file.
@@ -260,6 +275,30 @@ func (l Loader) setFieldValueFromCLI(fieldName string, cliName string) error {
 	return nil
 }
 
+// unknownFileKeys returns warnings for the keys in the config file that don't
+// match the cli or config tag of any of the config's fields
+func (l Loader) unknownFileKeys() []string {
+	known := make(map[string]bool)
+	fields, _ := reflections.Fields(l.Config)
+	for _, fieldName := range fields {
+		for _, tag := range []string{"cli", "config"} {
+			if name, _ := reflections.GetFieldTag(l.Config, fieldName, tag); name != "" {
+				known[name] = true
+			}
+		}
+	}
+
+	var warnings []string
+	for key := range l.File.Config {
+		if !known[key] {
+			warnings = append(warnings, fmt.Sprintf("Ignoring unknown config option `%s` in %s", key, l.File.Path))
+		}
+	}
+	sort.Strings(warnings)
+
+	return warnings
+}
+
 func (l Loader) Errorf(format string, v ...any) error {
 	suffix := fmt.Sprintf(" See: `%s %s --help`", l.CLI.App.Name, l.CLI.Command.Name)
 
@@ -277,11 +316,15 @@ func (l Loader) cliValueIsSet(cliName string) bool {
 			name, _ := reflections.GetField(flag, "Name")
 			envVar, _ := reflections.GetField(flag, "EnvVar")
 			if name == cliName && envVar != "" {
-				// Make sure envVar is a string
+				// Make sure envVar is a string. It may be a comma separated
+				// list of names, any of which counts as being set.
 				if envVarStr, ok := envVar.(string); ok {
-					envVarStr = strings.TrimSpace(string(envVarStr))
-
-					return os.Getenv(envVarStr) != ""
+					for _, name := range strings.Split(envVarStr, ",") {
+						if os.Getenv(strings.TrimSpace(name)) != "" {
+							return true
+						}
+					}
+					return false
 				}
 			}
 		}
@@ -0,0 +1,189 @@
+package cliconfig
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli"
+)
+
+type testConfig struct {
+	Paths string   `cli:"arg:0" config:"paths"`
+	Name  string   `cli:"name"`
+	Tags  []string `cli:"tags" normalize:"list"`
+	Debug bool     `cli:"debug"`
+	Count int      `cli:"count"`
+}
+
+// load loads a testConfig from the args (after the command name) the way
+// a command's action would, with the config file at path
+func load(t *testing.T, path string, args ...string) (testConfig, []string) {
+	t.Helper()
+
+	var cfg testConfig
+	var warnings []string
+	var loadErr error
+
+	app := cli.NewApp()
+	app.Name = "buildkite-agent"
+	app.Commands = []cli.Command{{
+		Name: "llamas",
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "config"},
+			cli.StringFlag{Name: "name", EnvVar: "BUILDKITE_TEST_LLAMA_NAME"},
+			cli.StringSliceFlag{Name: "tags", Value: &cli.StringSlice{}},
+			cli.BoolFlag{Name: "debug"},
+			cli.IntFlag{Name: "count"},
+		},
+		Action: func(c *cli.Context) error {
+			loader := Loader{CLI: c, Config: &cfg, Logger: logger.Discard}
+			warnings, loadErr = loader.Load()
+			return nil
+		},
+	}}
+
+	if path != "" {
+		args = append([]string{"--config", path}, args...)
+	}
+	if err := app.Run(append([]string{"buildkite-agent", "llamas"}, args...)); err != nil {
+		t.Fatalf("app.Run() error = %v", err)
+	}
+	if loadErr != nil {
+		t.Fatalf("loader.Load() error = %v", loadErr)
+	}
+
+	return cfg, warnings
+}
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("os.WriteFile(%s) error = %v", name, err)
+	}
+	return path
+}
+
+func TestLoadKeyValueFile(t *testing.T) {
+	path := writeConfigFile(t, "llamas.cfg", "name=\"Kuzco\"\ntags=fluffy,spitting\ndebug=true\ncount=3\n# a comment\n")
+
+	cfg, warnings := load(t, path)
+
+	assert.Equal(t, testConfig{Name: "Kuzco", Tags: []string{"fluffy", "spitting"}, Debug: true, Count: 3}, cfg)
+	assert.Empty(t, warnings)
+}
+
+func TestLoadYAMLFile(t *testing.T) {
+	path := writeConfigFile(t, "llamas.yml", "name: Kuzco\ndebug: true\ncount: 3\n")
+
+	cfg, warnings := load(t, path)
+
+	assert.Equal(t, testConfig{Name: "Kuzco", Tags: []string{}, Debug: true, Count: 3}, cfg)
+	assert.Empty(t, warnings)
+}
+
+func TestLoadJSONFile(t *testing.T) {
+	path := writeConfigFile(t, "llamas.json", `{"name": "Kuzco", "debug": true, "count": 3}`)
+
+	cfg, warnings := load(t, path)
+
+	assert.Equal(t, testConfig{Name: "Kuzco", Tags: []string{}, Debug: true, Count: 3}, cfg)
+	assert.Empty(t, warnings)
+}
+
+func TestLoadStructuredFileLists(t *testing.T) {
+	for _, test := range []struct {
+		name, content string
+	}{
+		{name: "llamas.yaml", content: "tags:\n  - fluffy\n  - spitting\n"},
+		{name: "llamas.json", content: `{"tags": ["fluffy", "spitting"]}`},
+		{name: "llamas.yml", content: "tags: fluffy,spitting\n"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, _ := load(t, writeConfigFile(t, test.name, test.content))
+
+			assert.Equal(t, []string{"fluffy", "spitting"}, cfg.Tags)
+		})
+	}
+}
+
+func TestLoadStructuredFileNestedValue(t *testing.T) {
+	file := File{Path: writeConfigFile(t, "llamas.yml", "tags:\n  colour: brown\n")}
+
+	if err := file.Load(); err == nil {
+		t.Fatal("file.Load() error = nil, want an error for a mapping value")
+	}
+}
+
+func TestLoadStructuredFileUnknownKeys(t *testing.T) {
+	path := writeConfigFile(t, "llamas.yml", "name: Kuzco\nalpaca: true\ncamel: false\n")
+
+	cfg, warnings := load(t, path)
+
+	assert.Equal(t, "Kuzco", cfg.Name)
+	assert.Equal(t, []string{
+		"Ignoring unknown config option `alpaca` in " + path,
+		"Ignoring unknown config option `camel` in " + path,
+	}, warnings)
+}
+
+func TestLoadKeyValueFileUnknownKeys(t *testing.T) {
+	// Lines of key=value are often shared with other tools, so they aren't
+	// checked for unknown keys
+	path := writeConfigFile(t, "llamas.cfg", "name=Kuzco\nalpaca=true\n")
+
+	_, warnings := load(t, path)
+
+	assert.Empty(t, warnings)
+}
+
+func TestLoadPositionalFieldFromFile(t *testing.T) {
+	path := writeConfigFile(t, "llamas.yml", "paths: \"*.txt\"\n")
+
+	cfg, warnings := load(t, path)
+	assert.Equal(t, "*.txt", cfg.Paths)
+	assert.Empty(t, warnings, "the config tag of a positional field is a known key")
+
+	// The argument takes precedence over the file
+	cfg, _ = load(t, path, "*.log")
+	assert.Equal(t, "*.log", cfg.Paths)
+}
+
+func TestLoadCLIAndEnvOverrideFile(t *testing.T) {
+	path := writeConfigFile(t, "llamas.yml", "name: Kuzco\ntags: [fluffy]\ndebug: false\ncount: 3\n")
+
+	cfg, _ := load(t, path, "--name", "Pacha", "--tags", "spitting", "--debug", "--count", "5")
+	assert.Equal(t, testConfig{Name: "Pacha", Tags: []string{"spitting"}, Debug: true, Count: 5}, cfg)
+
+	t.Setenv("BUILDKITE_TEST_LLAMA_NAME", "Yzma")
+	cfg, _ = load(t, path)
+	assert.Equal(t, "Yzma", cfg.Name)
+	assert.Equal(t, 3, cfg.Count)
+}
+
+func TestLoadMissingConfigFile(t *testing.T) {
+	var loadErr error
+
+	app := cli.NewApp()
+	app.Commands = []cli.Command{{
+		Name:  "llamas",
+		Flags: []cli.Flag{cli.StringFlag{Name: "config"}},
+		Action: func(c *cli.Context) error {
+			loader := Loader{CLI: c, Config: &testConfig{}, Logger: logger.Discard}
+			_, loadErr = loader.Load()
+			return nil
+		},
+	}}
+
+	path := filepath.Join(t.TempDir(), "missing.yml")
+	if err := app.Run([]string{"buildkite-agent", "llamas", "--config", path}); err != nil {
+		t.Fatalf("app.Run() error = %v", err)
+	}
+	if loadErr == nil {
+		t.Fatal("loader.Load() error = nil, want an error for the missing file")
+	}
+}
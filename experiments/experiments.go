@@ -1,9 +1,12 @@
 // Package experiments provides a global registry of enabled and disabled
-// experiments.
+// experiments, and scopes in which more experiments can be enabled for just
+// part of the agent.
 //
 // It is intended for internal use by buildkite-agent only.
 package experiments
 
+import "context"
+
 var (
 	Available = map[string]struct{}{
 		"job-api":                       {},
@@ -14,6 +17,7 @@ var (
 		"resolve-commit-after-checkout": {},
 		"descending-spawn-priority":     {},
 		"inbuilt-status-page":           {},
+		"redact-high-entropy":           {},
 	}
 
 	experiments = make(map[string]bool, len(Available))
@@ -46,3 +50,54 @@ func Enabled() []string {
 	}
 	return keys
 }
+
+// Snapshot captures which experiments are enabled, and returns a function that
+// restores them to that, undoing any enabling or disabling in between. It's
+// intended for tests, e.g.
+//
+//	defer experiments.Snapshot()()
+//	experiments.Enable("llamas")
+func Snapshot() (restore func()) {
+	saved := make(map[string]bool, len(experiments))
+	for key, enabled := range experiments {
+		saved[key] = enabled
+	}
+	return func() {
+		experiments = saved
+	}
+}
+
+// Reset disables every experiment.
+func Reset() {
+	experiments = make(map[string]bool, len(Available))
+}
+
+// scopeKey is the context key for the experiments enabled in a scope
+type scopeKey struct{}
+
+// WithScope returns a copy of ctx in which the named experiments are enabled,
+// as well as those enabled in any scope ctx is already in. They aren't enabled
+// globally, so they only apply to code that checks them with IsEnabledCtx and
+// a context derived from the returned one, e.g. a single command's handler.
+func WithScope(ctx context.Context, keys ...string) context.Context {
+	parent, _ := ctx.Value(scopeKey{}).(map[string]bool)
+
+	scoped := make(map[string]bool, len(parent)+len(keys))
+	for key := range parent {
+		scoped[key] = true
+	}
+	for _, key := range keys {
+		scoped[key] = true
+	}
+
+	return context.WithValue(ctx, scopeKey{}, scoped)
+}
+
+// IsEnabledCtx reports whether the named experiment is enabled in the scope
+// ctx is in, or failing that, globally.
+func IsEnabledCtx(ctx context.Context, key string) bool {
+	if scoped, ok := ctx.Value(scopeKey{}).(map[string]bool); ok && scoped[key] {
+		return true
+	}
+	return IsEnabled(key)
+}
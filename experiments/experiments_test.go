@@ -0,0 +1,83 @@
+package experiments_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/buildkite/agent/v3/experiments"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithScopeEnablesExperiments(t *testing.T) {
+	defer experiments.Snapshot()()
+	experiments.Reset()
+
+	ctx := experiments.WithScope(context.Background(), "llamas")
+
+	assert.True(t, experiments.IsEnabledCtx(ctx, "llamas"))
+	assert.False(t, experiments.IsEnabledCtx(ctx, "alpacas"))
+}
+
+func TestWithScopeInheritsParentScope(t *testing.T) {
+	defer experiments.Snapshot()()
+	experiments.Reset()
+
+	parent := experiments.WithScope(context.Background(), "llamas")
+	child := experiments.WithScope(parent, "alpacas")
+
+	assert.True(t, experiments.IsEnabledCtx(child, "llamas"))
+	assert.True(t, experiments.IsEnabledCtx(child, "alpacas"))
+
+	// The child's experiments don't leak back into the parent's scope
+	assert.False(t, experiments.IsEnabledCtx(parent, "alpacas"))
+}
+
+func TestIsEnabledCtxWithoutScope(t *testing.T) {
+	defer experiments.Snapshot()()
+	experiments.Reset()
+
+	ctx := context.Background()
+	assert.False(t, experiments.IsEnabledCtx(ctx, "llamas"))
+
+	experiments.Enable("llamas")
+	assert.True(t, experiments.IsEnabledCtx(ctx, "llamas"))
+
+	// Experiments enabled globally are also enabled in every scope
+	assert.True(t, experiments.IsEnabledCtx(experiments.WithScope(ctx, "alpacas"), "llamas"))
+}
+
+func TestWithScopeDoesNotEnableGlobally(t *testing.T) {
+	defer experiments.Snapshot()()
+	experiments.Reset()
+
+	experiments.WithScope(context.Background(), "llamas")
+
+	assert.False(t, experiments.IsEnabled("llamas"))
+	assert.Empty(t, experiments.Enabled())
+}
+
+func TestSnapshotRestoresExperiments(t *testing.T) {
+	defer experiments.Snapshot()()
+	experiments.Reset()
+	experiments.Enable("llamas")
+
+	restore := experiments.Snapshot()
+	experiments.Enable("alpacas")
+	experiments.Disable("llamas")
+	restore()
+
+	assert.True(t, experiments.IsEnabled("llamas"))
+	assert.False(t, experiments.IsEnabled("alpacas"))
+}
+
+func TestResetDisablesEveryExperiment(t *testing.T) {
+	defer experiments.Snapshot()()
+	experiments.Enable("llamas")
+	experiments.Enable("job-api")
+
+	experiments.Reset()
+
+	assert.False(t, experiments.IsEnabled("llamas"))
+	assert.False(t, experiments.IsEnabled("job-api"))
+	assert.Empty(t, experiments.Enabled())
+}
@@ -1,6 +1,7 @@
 package logger
 
 import (
+	"encoding/json"
 	"fmt"
 	"time"
 )
@@ -63,3 +64,30 @@ func DurationField(key string, value time.Duration) Field {
 		format: "%v",
 	}
 }
+
+// JSONField returns a field whose value is written as structured JSON in JSON
+// logs. It's left out of text logs, so the message should also describe it in
+// a human-friendly way.
+func JSONField(key string, value any) Field {
+	return jsonField{
+		key:   key,
+		value: value,
+	}
+}
+
+type jsonField struct {
+	key   string
+	value any
+}
+
+func (f jsonField) Key() string {
+	return f.key
+}
+
+func (f jsonField) String() string {
+	b, err := json.Marshal(f.value)
+	if err != nil {
+		return fmt.Sprintf("%v", f.value)
+	}
+	return string(b)
+}
@@ -2,6 +2,7 @@ package logger
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 )
 
@@ -25,6 +26,23 @@ var levelNames = []string{
 	"FATAL",
 }
 
+// syslogLevels maps numeric syslog severities (RFC 5424) to levels. Syslog
+// has more severe levels than we do, so emergency, alert and critical are all
+// treated as fatal.
+var syslogLevels = []Level{
+	FATAL,  // 0 emergency
+	FATAL,  // 1 alert
+	FATAL,  // 2 critical
+	ERROR,  // 3 error
+	WARN,   // 4 warning
+	NOTICE, // 5 notice
+	INFO,   // 6 informational
+	DEBUG,  // 7 debug
+}
+
+// LevelFromString parses a level name, such as "debug" or "warn". Some common
+// aliases from other logging ecosystems (e.g. "warning", "err") and numeric
+// syslog severities (0-7) are also accepted.
 func LevelFromString(s string) (Level, error) {
 	switch strings.ToLower(s) {
 	case "debug":
@@ -35,13 +53,20 @@ func LevelFromString(s string) (Level, error) {
 		return INFO, nil
 	case "warn", "warning":
 		return WARN, nil
-	case "error":
+	case "error", "err":
 		return ERROR, nil
-	case "fatal":
+	case "fatal", "crit", "critical", "alert", "emerg":
 		return FATAL, nil
-	default:
-		return -1, fmt.Errorf("invalid log level: %s. Valid levels are: %v", s, levelNames)
 	}
+
+	if n, err := strconv.Atoi(s); err == nil {
+		if n >= 0 && n < len(syslogLevels) {
+			return syslogLevels[n], nil
+		}
+		return -1, fmt.Errorf("invalid log level: %s. Numeric levels must be syslog severities between 0 and %d", s, len(syslogLevels)-1)
+	}
+
+	return -1, fmt.Errorf("invalid log level: %s. Valid levels are: %v", s, levelNames)
 }
 
 // String returns the string representation of a logging level.
@@ -0,0 +1,47 @@
+package logger_test
+
+import (
+	"testing"
+
+	"github.com/buildkite/agent/v3/logger"
+)
+
+func TestLevelFromString(t *testing.T) {
+	tests := []struct {
+		input string
+		want  logger.Level
+	}{
+		{"debug", logger.DEBUG},
+		{"NOTICE", logger.NOTICE},
+		{"info", logger.INFO},
+		{"warn", logger.WARN},
+		{"warning", logger.WARN},
+		{"error", logger.ERROR},
+		{"err", logger.ERROR},
+		{"fatal", logger.FATAL},
+		{"crit", logger.FATAL},
+		{"0", logger.FATAL},
+		{"3", logger.ERROR},
+		{"4", logger.WARN},
+		{"5", logger.NOTICE},
+		{"6", logger.INFO},
+		{"7", logger.DEBUG},
+	}
+
+	for _, test := range tests {
+		got, err := logger.LevelFromString(test.input)
+		if err != nil {
+			t.Errorf("logger.LevelFromString(%q) error = %v", test.input, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("logger.LevelFromString(%q) = %v, want %v", test.input, got, test.want)
+		}
+	}
+
+	for _, input := range []string{"llamas", "8", "-1", ""} {
+		if _, err := logger.LevelFromString(input); err == nil {
+			t.Errorf("logger.LevelFromString(%q) error = nil, want an error", input)
+		}
+	}
+}
@@ -6,10 +6,12 @@
 package logger
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -134,25 +136,40 @@ func NewTextPrinter(w io.Writer) *TextPrinter {
 	}
 }
 
+// PrefixFields returns a function suitable for TextPrinter.IsPrefixFn that
+// reports whether a field's key is one of the given keys.
+func PrefixFields(keys ...string) func(Field) bool {
+	prefixes := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		prefixes[key] = struct{}{}
+	}
+
+	return func(field Field) bool {
+		_, ok := prefixes[field.Key()]
+		return ok
+	}
+}
+
 func (l *TextPrinter) Print(level Level, msg string, fields Fields) {
 	now := time.Now().Format(DateFormat)
 
 	var line string
-	var prefix string
+	var prefixes []string
 	var fieldStrs []string
 
 	if l.IsPrefixFn != nil {
 		for _, f := range fields {
 			// Skip invisible fields
-			if l.IsVisibleFn != nil && !l.IsVisibleFn(f) {
+			if !l.isVisible(f) {
 				continue
 			}
 			// Allow some fields to be shown as prefixes
 			if l.IsPrefixFn(f) {
-				prefix += f.String()
+				prefixes = append(prefixes, f.String())
 			}
 		}
 	}
+	prefix := strings.Join(prefixes, " ")
 
 	if l.Colors {
 		levelColor := green
@@ -183,7 +200,7 @@ func (l *TextPrinter) Print(level Level, msg string, fields Fields) {
 		}
 
 		for _, field := range fields {
-			if l.IsVisibleFn != nil && !l.IsVisibleFn(field) {
+			if !l.isVisible(field) {
 				continue
 			}
 			if l.IsPrefixFn != nil && l.IsPrefixFn(field) {
@@ -200,7 +217,7 @@ func (l *TextPrinter) Print(level Level, msg string, fields Fields) {
 		}
 
 		for _, field := range fields {
-			if l.IsVisibleFn != nil && !l.IsVisibleFn(field) {
+			if !l.isVisible(field) {
 				continue
 			}
 			if l.IsPrefixFn != nil && l.IsPrefixFn(field) {
@@ -220,6 +237,15 @@ func (l *TextPrinter) Print(level Level, msg string, fields Fields) {
 	mutex.Unlock()
 }
 
+// isVisible reports whether a field should be shown in text logs. Fields only
+// meant for JSON logs never are.
+func (l *TextPrinter) isVisible(field Field) bool {
+	if _, ok := field.(jsonField); ok {
+		return false
+	}
+	return l.IsVisibleFn == nil || l.IsVisibleFn(field)
+}
+
 func ColorsSupported() bool {
 	// Color support for windows is set in init
 	if runtime.GOOS == "windows" && !windowsColors {
@@ -236,6 +262,30 @@ func ColorsSupported() bool {
 
 type JSONPrinter struct {
 	Writer io.Writer
+
+	// Customises the fields written on each line
+	Options JSONPrinterOptions
+}
+
+// JSONPrinterOptions customise the fields a JSONPrinter writes. The zero value
+// writes the standard "ts", "level" and "msg" fields as they are.
+type JSONPrinterOptions struct {
+	// New keys for the standard fields, keyed by their usual key, e.g.
+	// {"msg": "message"}
+	Rename map[string]string
+
+	// Standard fields to leave out, by their usual key, e.g. "level"
+	Drop []string
+
+	// Fields written on every line, e.g. {"source": "buildkite-agent"}. They
+	// take the place of any logged fields with the same key.
+	StaticFields map[string]string
+
+	// If set, structured fields (see JSONField) that are objects are
+	// flattened into top-level keys joined by it, rather than written as
+	// nested objects, e.g. with ".", {"error":{"path":"x"}} is written as
+	// {"error.path":"x"}. Arrays are left as they are.
+	FlattenDelimiter string
 }
 
 func NewJSONPrinter(w io.Writer) *JSONPrinter {
@@ -247,11 +297,35 @@ func NewJSONPrinter(w io.Writer) *JSONPrinter {
 func (p *JSONPrinter) Print(level Level, msg string, fields Fields) {
 	var b strings.Builder
 
-	b.WriteString(fmt.Sprintf(`"ts":%q,`, time.Now().Format(time.RFC3339)))
-	b.WriteString(fmt.Sprintf(`"level":%q,`, level.String()))
-	b.WriteString(fmt.Sprintf(`"msg":%q,`, msg))
+	p.writeStandardField(&b, "ts", time.Now().Format(time.RFC3339))
+	p.writeStandardField(&b, "level", level.String())
+	p.writeStandardField(&b, "msg", msg)
+
+	staticKeys := make([]string, 0, len(p.Options.StaticFields))
+	for key := range p.Options.StaticFields {
+		staticKeys = append(staticKeys, key)
+	}
+	sort.Strings(staticKeys)
+	for _, key := range staticKeys {
+		b.WriteString(fmt.Sprintf("%q:%q,", key, p.Options.StaticFields[key]))
+	}
 
 	for _, field := range fields {
+		if _, ok := p.Options.StaticFields[field.Key()]; ok {
+			continue
+		}
+
+		// Structured fields are written as JSON, rather than as a string
+		if jf, ok := field.(jsonField); ok {
+			if value, err := json.Marshal(jf.value); err == nil {
+				if p.Options.FlattenDelimiter != "" {
+					p.writeFlattened(&b, field.Key(), value)
+				} else {
+					b.WriteString(fmt.Sprintf("%q:%s,", field.Key(), value))
+				}
+				continue
+			}
+		}
 		b.WriteString(fmt.Sprintf("%q:%q,", field.Key(), field.String()))
 	}
 
@@ -261,6 +335,199 @@ func (p *JSONPrinter) Print(level Level, msg string, fields Fields) {
 	mutex.Unlock()
 }
 
+// writeStandardField writes one of the fields on every line, unless it's been
+// dropped, under its new key if it's been renamed
+func (p *JSONPrinter) writeStandardField(b *strings.Builder, key, value string) {
+	for _, drop := range p.Options.Drop {
+		if drop == key {
+			return
+		}
+	}
+	if renamed := p.Options.Rename[key]; renamed != "" {
+		key = renamed
+	}
+	b.WriteString(fmt.Sprintf("%q:%q,", key, value))
+}
+
+// writeFlattened writes a JSON value under key, or if it's an object with
+// anything in it, each of its values under the key and their own joined by the
+// FlattenDelimiter, in order of their keys
+func (p *JSONPrinter) writeFlattened(b *strings.Builder, key string, value json.RawMessage) {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(value, &object); err != nil || len(object) == 0 {
+		b.WriteString(fmt.Sprintf("%q:%s,", key, value))
+		return
+	}
+
+	keys := make([]string, 0, len(object))
+	for k := range object {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		p.writeFlattened(b, key+p.Options.FlattenDelimiter+k, object[k])
+	}
+}
+
+// LevelRoutingPrinter sends entries at or above a level to one Printer, and
+// the rest to another, e.g. warnings and errors to stderr and everything else
+// to stdout.
+type LevelRoutingPrinter struct {
+	// The lowest level that's sent to AtOrAbove
+	Threshold Level
+
+	// Where entries at or above the Threshold are printed
+	AtOrAbove Printer
+
+	// Where entries below the Threshold are printed
+	Below Printer
+}
+
+func NewLevelRoutingPrinter(threshold Level, atOrAbove, below Printer) *LevelRoutingPrinter {
+	return &LevelRoutingPrinter{
+		Threshold: threshold,
+		AtOrAbove: atOrAbove,
+		Below:     below,
+	}
+}
+
+func (p *LevelRoutingPrinter) Print(level Level, msg string, fields Fields) {
+	if level >= p.Threshold {
+		p.AtOrAbove.Print(level, msg, fields)
+	} else {
+		p.Below.Print(level, msg, fields)
+	}
+}
+
+// LevelFilterPrinter only passes on entries at or above a level to another
+// Printer. A ConsoleLogger's level applies to everything it prints, so this
+// is how one of the Printers of a MultiPrinter can print fewer entries than
+// the others.
+type LevelFilterPrinter struct {
+	// The lowest level that's printed
+	Level Level
+
+	Printer Printer
+}
+
+func NewLevelFilterPrinter(level Level, printer Printer) *LevelFilterPrinter {
+	return &LevelFilterPrinter{
+		Level:   level,
+		Printer: printer,
+	}
+}
+
+func (p *LevelFilterPrinter) Print(level Level, msg string, fields Fields) {
+	if level >= p.Level {
+		p.Printer.Print(level, msg, fields)
+	}
+}
+
+// MultiPrinter prints each entry with all of its Printers, e.g. as text to
+// stderr and as JSON to a file. Entries only reach it if they're at or above
+// the level of the logger, so to print different levels with each Printer,
+// set the logger's level to the lowest of them, and wrap the others in
+// LevelFilterPrinters.
+type MultiPrinter []Printer
+
+func (p MultiPrinter) Print(level Level, msg string, fields Fields) {
+	for _, printer := range p {
+		printer.Print(level, msg, fields)
+	}
+}
+
+// RedactingPrinter redacts the message and each of the fields of log entries,
+// before passing them on to another Printer. Fields are redacted before the
+// Printer formats them, so values in them aren't missed by being quoted or
+// escaped, e.g. by a JSONPrinter.
+type RedactingPrinter struct {
+	Printer Printer
+
+	// Returns its argument with any sensitive values replaced
+	Redact func(string) string
+}
+
+func NewRedactingPrinter(printer Printer, redact func(string) string) *RedactingPrinter {
+	return &RedactingPrinter{
+		Printer: printer,
+		Redact:  redact,
+	}
+}
+
+func (p *RedactingPrinter) Print(level Level, msg string, fields Fields) {
+	redacted := make(Fields, 0, len(fields))
+	for _, field := range fields {
+		redacted = append(redacted, p.redactField(field))
+	}
+	p.Printer.Print(level, p.Redact(msg), redacted)
+}
+
+func (p *RedactingPrinter) redactField(field Field) Field {
+	jf, ok := field.(jsonField)
+	if !ok {
+		value := field.String()
+		if redacted := p.Redact(value); redacted != value {
+			return StringField(field.Key(), redacted)
+		}
+		return field
+	}
+
+	// Structured fields are redacted string by string in their decoded form,
+	// since the escaping done by json.Marshal (e.g. of & and <) would stop
+	// secrets containing those characters from matching
+	b, err := json.Marshal(jf.value)
+	if err != nil {
+		value := jf.String()
+		if redacted := p.Redact(value); redacted != value {
+			return StringField(field.Key(), redacted)
+		}
+		return field
+	}
+	var decoded any
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		return field
+	}
+	redacted, changed := p.redactValue(decoded)
+	if !changed {
+		return field
+	}
+	return JSONField(field.Key(), redacted)
+}
+
+// redactValue redacts the strings within a value decoded from JSON, including
+// the keys and values of objects and the elements of arrays. It reports
+// whether anything was redacted.
+func (p *RedactingPrinter) redactValue(value any) (any, bool) {
+	switch v := value.(type) {
+	case string:
+		redacted := p.Redact(v)
+		return redacted, redacted != v
+
+	case []any:
+		changed := false
+		for i, elem := range v {
+			var c bool
+			v[i], c = p.redactValue(elem)
+			changed = changed || c
+		}
+		return v, changed
+
+	case map[string]any:
+		changed := false
+		redacted := make(map[string]any, len(v))
+		for key, elem := range v {
+			redactedKey := p.Redact(key)
+			redactedElem, c := p.redactValue(elem)
+			redacted[redactedKey] = redactedElem
+			changed = changed || c || redactedKey != key
+		}
+		return redacted, changed
+
+	default:
+		return value, false
+	}
+}
+
 var Discard = &ConsoleLogger{
 	printer: &TextPrinter{
 		Writer: io.Discard,
@@ -80,7 +80,11 @@ func main() {
 				clicommand.ArtifactUploadCommand,
 				clicommand.ArtifactDownloadCommand,
 				clicommand.ArtifactSearchCommand,
+				clicommand.ArtifactExistsCommand,
 				clicommand.ArtifactShasumCommand,
+				clicommand.ArtifactRenameCommand,
+				clicommand.ArtifactVerifyCommand,
+				clicommand.ArtifactBackfillCommand,
 			},
 		},
 		{
@@ -0,0 +1,254 @@
+package redaction
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// entropyMaxBuffered is how much of a line without a line ending the
+// EntropyRedactor will hold back before redacting it anyway
+const entropyMaxBuffered = 65536
+
+// entropyFlushDelay is how long the EntropyRedactor will hold back a line
+// without a line ending, e.g. a prompt, when nothing more is written
+const entropyFlushDelay = 250 * time.Millisecond
+
+// EntropyConfig tunes which tokens the EntropyRedactor considers to be secrets.
+type EntropyConfig struct {
+	// Tokens shorter than this are never redacted
+	MinLength int
+
+	// The minimum Shannon entropy, in bits per byte, of a token to redact
+	MinEntropy float64
+
+	// Tokens matching any of these are never redacted, even if they look
+	// random
+	SafePatterns []*regexp.Regexp
+}
+
+// DefaultEntropyConfig redacts tokens of at least 24 bytes with at least 4.5
+// bits of entropy per byte, which catches most random API tokens while leaving
+// words, paths and hex strings (which have at most 4 bits per byte) alone. Git
+// SHAs, checksums and UUIDs are never redacted.
+var DefaultEntropyConfig = EntropyConfig{
+	MinLength:  24,
+	MinEntropy: 4.5,
+	SafePatterns: []*regexp.Regexp{
+		// Git SHAs and hex checksums
+		regexp.MustCompile(`^[0-9a-fA-F]+$`),
+		// UUIDs
+		regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`),
+	},
+}
+
+// EntropyRedactor is an io.Writer that replaces tokens that look like secrets,
+// based on how random they are, before passing output on. Unlike Redactor it
+// doesn't need to know the secrets in advance, but it can have false
+// positives.
+//
+// Output is redacted a line at a time, ending with either \n or \r so that
+// progress bars aren't held back. An incomplete line is written once nothing
+// more has been written for a short while, and the end of the input should be
+// written with Flush.
+type EntropyRedactor struct {
+	mu          sync.Mutex
+	conf        EntropyConfig
+	replacement []byte
+
+	// The incomplete last line of the previous Write
+	buf []byte
+
+	// Flushes an incomplete line once nothing more has been written for
+	// flushDelay. Each Write counts as a new generation, so a timer that
+	// fired as a Write came in doesn't flush what that Write held back.
+	flushDelay time.Duration
+	flushTimer *time.Timer
+	flushGen   int
+
+	// An error writing output from the flushTimer, returned by the next
+	// Write or Flush
+	err error
+
+	// Wrapped Writer that we'll send redacted output to
+	output io.Writer
+
+	// How many tokens have been redacted
+	redactions int
+}
+
+func NewEntropyRedactor(output io.Writer, replacement string, conf EntropyConfig) *EntropyRedactor {
+	return &EntropyRedactor{
+		conf:        conf,
+		replacement: []byte(replacement),
+		output:      output,
+		flushDelay:  entropyFlushDelay,
+	}
+}
+
+func (r *EntropyRedactor) Write(input []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.takeErr(); err != nil {
+		return 0, err
+	}
+
+	r.buf = append(r.buf, input...)
+
+	// Redact up to the last line ending, holding back the rest in case it
+	// has the start of a token that continues in the next Write
+	end := bytes.LastIndexAny(r.buf, "\r\n") + 1
+	if end == 0 && len(r.buf) >= entropyMaxBuffered {
+		end = len(r.buf)
+	}
+
+	var err error
+	if end > 0 {
+		_, err = r.output.Write(r.redact(r.buf[:end]))
+		r.buf = append(r.buf[:0], r.buf[end:]...)
+	}
+
+	// Restart the wait for more of the line held back, if there is one
+	if r.flushTimer != nil {
+		r.flushTimer.Stop()
+	}
+	r.flushGen++
+	if len(r.buf) > 0 {
+		gen := r.flushGen
+		r.flushTimer = time.AfterFunc(r.flushDelay, func() { r.flushHeldBack(gen) })
+	}
+
+	return len(input), err
+}
+
+// flushHeldBack writes the line held back once nothing more has been written
+// for flushDelay since the Write of generation gen
+func (r *EntropyRedactor) flushHeldBack(gen int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if gen != r.flushGen {
+		return
+	}
+	if err := r.flush(); err != nil && r.err == nil {
+		r.err = err
+	}
+}
+
+// Flush should be called after the final Write. This will redact and Write()
+// anything held back waiting for the end of a line.
+func (r *EntropyRedactor) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.flushTimer != nil {
+		r.flushTimer.Stop()
+	}
+	if err := r.takeErr(); err != nil {
+		return err
+	}
+	return r.flush()
+}
+
+func (r *EntropyRedactor) flush() error {
+	if len(r.buf) == 0 {
+		return nil
+	}
+	_, err := r.output.Write(r.redact(r.buf))
+	r.buf = r.buf[:0]
+	return err
+}
+
+// takeErr returns, and clears, an error from writing output after flushDelay
+func (r *EntropyRedactor) takeErr() error {
+	err := r.err
+	r.err = nil
+	return err
+}
+
+// Redactions returns how many tokens the EntropyRedactor has redacted
+func (r *EntropyRedactor) Redactions() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.redactions
+}
+
+// redact returns a copy of input with secret looking tokens replaced
+func (r *EntropyRedactor) redact(input []byte) []byte {
+	output := make([]byte, 0, len(input))
+
+	start := -1
+	for i := 0; i <= len(input); i++ {
+		if i < len(input) && isTokenByte(input[i]) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+
+		if start >= 0 {
+			if token := input[start:i]; r.isSecret(token) {
+				output = append(output, r.replacement...)
+				r.redactions++
+			} else {
+				output = append(output, token...)
+			}
+			start = -1
+		}
+
+		if i < len(input) {
+			output = append(output, input[i])
+		}
+	}
+
+	return output
+}
+
+func (r *EntropyRedactor) isSecret(token []byte) bool {
+	if len(token) < r.conf.MinLength {
+		return false
+	}
+	for _, re := range r.conf.SafePatterns {
+		if re.Match(token) {
+			return false
+		}
+	}
+	return Entropy(token) >= r.conf.MinEntropy
+}
+
+// isTokenByte reports whether c can be part of a token, which are made up of
+// the characters used by base64 (both standard and URL encodings) apart from
+// padding
+func isTokenByte(c byte) bool {
+	return 'a' <= c && c <= 'z' ||
+		'A' <= c && c <= 'Z' ||
+		'0' <= c && c <= '9' ||
+		c == '+' || c == '/' || c == '-' || c == '_'
+}
+
+// Entropy returns the Shannon entropy of b, in bits per byte
+func Entropy(b []byte) float64 {
+	if len(b) == 0 {
+		return 0
+	}
+
+	var counts [256]int
+	for _, c := range b {
+		counts[c]++
+	}
+
+	var entropy float64
+	for _, count := range counts {
+		if count == 0 {
+			continue
+		}
+		p := float64(count) / float64(len(b))
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
@@ -0,0 +1,158 @@
+package redaction
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEntropyRedactor(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "plain output",
+			input: "Installing node_modules/some-package/dist/index.js from /usr/local/bin/buildkite-agent\n",
+			want:  "Installing node_modules/some-package/dist/index.js from /usr/local/bin/buildkite-agent\n",
+		},
+		{
+			name:  "random token",
+			input: "token: xK9mP2qR7vL4nW8sT1yH6jF3bD5gC0aZ, done\n",
+			want:  "token: [REDACTED], done\n",
+		},
+		{
+			name:  "git sha",
+			input: "HEAD is now at 8ae46d975aa60d1ae0e2cc0bff7a43d3bf960935\n",
+			want:  "HEAD is now at 8ae46d975aa60d1ae0e2cc0bff7a43d3bf960935\n",
+		},
+		{
+			name:  "uuid",
+			input: "job 0186b5a4-8f2c-4b4e-9d7a-3c1e5f6a7b8d started\n",
+			want:  "job 0186b5a4-8f2c-4b4e-9d7a-3c1e5f6a7b8d started\n",
+		},
+		{
+			name:  "no trailing newline",
+			input: "xK9mP2qR7vL4nW8sT1yH6jF3bD5gC0aZ",
+			want:  "[REDACTED]",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			var buf bytes.Buffer
+			redactor := NewEntropyRedactor(&buf, "[REDACTED]", DefaultEntropyConfig)
+
+			fmt.Fprint(redactor, test.input)
+			redactor.Flush()
+
+			if got := buf.String(); got != test.want {
+				t.Errorf("post-redaction buf.String() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestEntropyRedactorSplitWrites(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	redactor := NewEntropyRedactor(&buf, "[REDACTED]", DefaultEntropyConfig)
+
+	fmt.Fprint(redactor, "token: xK9mP2qR7vL4nW8")
+	if got := buf.String(); got != "" {
+		t.Errorf("buf.String() before end of line = %q, want empty", got)
+	}
+	fmt.Fprint(redactor, "sT1yH6jF3bD5gC0aZ\nnext")
+	redactor.Flush()
+
+	if got, want := buf.String(), "token: [REDACTED]\nnext"; got != want {
+		t.Errorf("post-redaction buf.String() = %q, want %q", got, want)
+	}
+}
+
+func TestEntropyRedactorCarriageReturn(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	redactor := NewEntropyRedactor(&buf, "[REDACTED]", DefaultEntropyConfig)
+
+	// Progress bars end their lines with \r, and shouldn't be held back
+	fmt.Fprint(redactor, "Downloading... 42%\r")
+	if got, want := buf.String(), "Downloading... 42%\r"; got != want {
+		t.Errorf("buf.String() = %q, want %q", got, want)
+	}
+}
+
+// syncBuffer is a bytes.Buffer that's safe to read while the EntropyRedactor
+// writes to it from its flush timer
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestEntropyRedactorPartialLine(t *testing.T) {
+	t.Parallel()
+
+	var buf syncBuffer
+	redactor := NewEntropyRedactor(&buf, "[REDACTED]", DefaultEntropyConfig)
+	redactor.flushDelay = 10 * time.Millisecond
+
+	// A prompt waiting for input doesn't end its line, but is still written
+	// once nothing more comes
+	fmt.Fprint(redactor, "Password for xK9mP2qR7vL4nW8sT1yH6jF3bD5gC0aZ: ")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for buf.String() == "" && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got, want := buf.String(), "Password for [REDACTED]: "; got != want {
+		t.Errorf("buf.String() = %q, want %q", got, want)
+	}
+
+	// Nothing's written twice
+	fmt.Fprint(redactor, "hunter2\n")
+	if err := redactor.Flush(); err != nil {
+		t.Fatalf("redactor.Flush() error = %v", err)
+	}
+	if got, want := buf.String(), "Password for [REDACTED]: hunter2\n"; got != want {
+		t.Errorf("buf.String() = %q, want %q", got, want)
+	}
+}
+
+func TestEntropyRedactorTuning(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	redactor := NewEntropyRedactor(&buf, "[REDACTED]", EntropyConfig{
+		MinLength:  8,
+		MinEntropy: 2.5,
+	})
+
+	fmt.Fprint(redactor, "s3cr3t-t0k3n and 8ae46d975aa60d1a\n")
+	redactor.Flush()
+
+	if got, want := buf.String(), "[REDACTED] and [REDACTED]\n"; got != want {
+		t.Errorf("post-redaction buf.String() = %q, want %q", got, want)
+	}
+}
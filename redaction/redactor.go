@@ -4,9 +4,15 @@
 package redaction
 
 import (
+	"bufio"
 	"bytes"
+	"fmt"
 	"io"
+	"os"
 	"path"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/buildkite/agent/v3/bootstrap/shell"
 )
@@ -18,9 +24,23 @@ import (
 // from being redacted from useful log output.
 const RedactLengthMin = 6
 
+// DefaultReplacement is what redacted values are replaced with by default.
+// It's also used for values that aren't known by name.
+const DefaultReplacement = "[REDACTED]"
+
+// ReplacementNamePlaceholder is replaced with the name of the variable a
+// redacted value came from in a replacement template, e.g.
+// "[REDACTED:%name]". The value itself never appears in the replacement.
+const ReplacementNamePlaceholder = "%name"
+
 type Redactor struct {
+	// What values are replaced with, when they're not known by name
 	replacement []byte
 
+	// The template for replacing values known by name, see
+	// ReplacementNamePlaceholder
+	template string
+
 	// Current offset from the start of the next input segment
 	offset int
 
@@ -31,7 +51,7 @@ type Redactor struct {
 	// Table of Boyer-Moore skip distances, and values to redact matching this end byte
 	table [256]struct {
 		skip    int
-		needles [][]byte
+		needles []needle
 	}
 
 	// Internal buffer for building redacted input into
@@ -41,6 +61,15 @@ type Redactor struct {
 
 	// Wrapped Writer that we'll send redacted output to
 	output io.Writer
+
+	// How many values have been redacted, across all Resets
+	redactions int
+}
+
+// needle is a value to redact, and what to replace it with
+type needle struct {
+	value       []byte
+	replacement []byte
 }
 
 type RedactorMux []*Redactor
@@ -55,17 +84,82 @@ func NewRedactor(output io.Writer, replacement string, needles []string) *Redact
 	return redactor
 }
 
+// NewNamedRedactor constructs a Redactor for values keyed by the name of the
+// variable they came from. Each value is replaced with template, with any
+// ReplacementNamePlaceholder substituted for the variable's name. If template
+// is empty, DefaultReplacement is used.
+func NewNamedRedactor(output io.Writer, template string, needles map[string]string) *Redactor {
+	if template == "" {
+		template = DefaultReplacement
+	}
+	redactor := &Redactor{
+		replacement: []byte(Replacement(template, "")),
+		template:    template,
+		output:      output,
+	}
+	redactor.ResetNamed(needles)
+	return redactor
+}
+
+// Replacement returns the replacement text for a value of the variable name,
+// given a replacement template. If the name isn't known, the placeholder is
+// dropped from the template, and if that leaves nothing useful,
+// DefaultReplacement is returned instead.
+func Replacement(template, name string) string {
+	if template == "" {
+		return DefaultReplacement
+	}
+	if name != "" {
+		return strings.ReplaceAll(template, ReplacementNamePlaceholder, name)
+	}
+	if !strings.Contains(template, ReplacementNamePlaceholder) {
+		return template
+	}
+	return DefaultReplacement
+}
+
 // We re-use the same Redactor between different hooks and the command
 // We need to reset and update the list of needles between each phase
 func (redactor *Redactor) Reset(needles []string) {
+	ns := make([]needle, 0, len(needles))
+	for _, value := range needles {
+		ns = append(ns, needle{value: []byte(value), replacement: redactor.replacement})
+	}
+	redactor.reset(ns)
+}
+
+// ResetNamed is like Reset, but for values keyed by the name of the variable
+// they came from, which are replaced according to the Redactor's template.
+// Redactors not created with NewNamedRedactor replace them like Reset would.
+func (redactor *Redactor) ResetNamed(needles map[string]string) {
+	// Sort the names, so a value shared by several variables is always
+	// replaced using the same name
+	names := make([]string, 0, len(needles))
+	for name := range needles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ns := make([]needle, 0, len(needles))
+	for _, name := range names {
+		replacement := redactor.replacement
+		if redactor.template != "" {
+			replacement = []byte(Replacement(redactor.template, name))
+		}
+		ns = append(ns, needle{value: []byte(needles[name]), replacement: replacement})
+	}
+	redactor.reset(ns)
+}
+
+func (redactor *Redactor) reset(needles []needle) {
 	minNeedleLen := 0
 	maxNeedleLen := 0
 	for _, needle := range needles {
-		if len(needle) < minNeedleLen || minNeedleLen == 0 {
-			minNeedleLen = len(needle)
+		if len(needle.value) < minNeedleLen || minNeedleLen == 0 {
+			minNeedleLen = len(needle.value)
 		}
-		if len(needle) > maxNeedleLen {
-			maxNeedleLen = len(needle)
+		if len(needle.value) > maxNeedleLen {
+			maxNeedleLen = len(needle.value)
 		}
 	}
 
@@ -100,17 +194,17 @@ func (redactor *Redactor) Reset(needles []string) {
 	}
 
 	for _, needle := range needles {
-		for i, ch := range []byte(needle) {
+		for i, ch := range needle.value {
 			// For bytes that do exist in search strings, find the shortest distance
 			// between that byte appearing to the end of the same search string
-			skip := len(needle) - i - 1
+			skip := len(needle.value) - i - 1
 			if skip < redactor.table[ch].skip {
 				redactor.table[ch].skip = skip
 			}
 
 			// Build a cache of which search substrings end in which bytes
 			if skip == 0 {
-				redactor.table[ch].needles = append(redactor.table[ch].needles, []byte(needle))
+				redactor.table[ch].needles = append(redactor.table[ch].needles, needle)
 			}
 		}
 	}
@@ -190,7 +284,7 @@ func (redactor *Redactor) Write(input []byte) (int, error) {
 		for _, needle := range redactor.table[ch].needles {
 			// Since we're working backwards from what may be the end of a
 			// string, it's possible that the start would be out of bounds
-			startSubstr := cursor - len(needle)
+			startSubstr := cursor - len(needle.value)
 			var candidate []byte
 
 			if startSubstr >= 0 {
@@ -199,7 +293,7 @@ func (redactor *Redactor) Write(input []byte) (int, error) {
 			} else if -startSubstr <= len(redactor.outbuf) {
 				// If the candidate crosses the Write boundary, we need to
 				// concatenate the two sections to compare against
-				candidate = make([]byte, 0, len(needle))
+				candidate = make([]byte, 0, len(needle.value))
 				candidate = append(candidate, redactor.outbuf[len(redactor.outbuf)+startSubstr:]...)
 				candidate = append(candidate, input[:cursor]...)
 			} else {
@@ -209,7 +303,7 @@ func (redactor *Redactor) Write(input []byte) (int, error) {
 				continue
 			}
 
-			if bytes.Equal(needle, candidate) {
+			if bytes.Equal(needle.value, candidate) {
 				if startSubstr < 0 {
 					// If we accepted a negative startSubstr, the output buffer
 					// needs to be truncated to remove the partial match
@@ -218,8 +312,9 @@ func (redactor *Redactor) Write(input []byte) (int, error) {
 					// First, copy over anything behind the matched substring unmodified
 					redactor.outbuf = append(redactor.outbuf, input[doneTo:startSubstr]...)
 				}
-				// Then, write a fixed string into the output, and move doneTo past the redaction
-				redactor.outbuf = append(redactor.outbuf, redactor.replacement...)
+				// Then, write the replacement into the output, and move doneTo past the redaction
+				redactor.outbuf = append(redactor.outbuf, needle.replacement...)
+				redactor.redactions++
 				doneTo = cursor
 
 				// The next end-of-string will be at least this far away so
@@ -278,6 +373,42 @@ func (redactor *Redactor) Flush() error {
 	return err
 }
 
+// Redactions returns how many values the Redactor has redacted. The count
+// isn't cleared by Reset, so a Redactor that's reused between phases reports
+// the total for all of them.
+func (redactor *Redactor) Redactions() int {
+	return redactor.redactions
+}
+
+// StringRedactor redacts whole strings, such as log messages and their
+// fields, rather than a stream of output, but matches values the same way as
+// Redactor. It's safe for concurrent use.
+type StringRedactor struct {
+	mu       sync.Mutex
+	buf      bytes.Buffer
+	redactor *Redactor
+}
+
+func NewStringRedactor(replacement string, needles []string) *StringRedactor {
+	r := &StringRedactor{}
+	r.redactor = NewRedactor(&r.buf, replacement, needles)
+	return r
+}
+
+// Redact returns s with any of the values to redact replaced
+func (r *StringRedactor) Redact(s string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Each string is independent, so nothing's carried over from the last
+	r.buf.Reset()
+	r.redactor.offset = r.redactor.minlen - 1
+
+	r.redactor.Write([]byte(s))
+	r.redactor.Flush()
+	return r.buf.String()
+}
+
 // Flush flushes all redactors
 func (mux RedactorMux) Flush() error {
 	var errs []error
@@ -292,6 +423,15 @@ func (mux RedactorMux) Flush() error {
 	return nil
 }
 
+// Redactions returns how many values all the redactors have redacted
+func (mux RedactorMux) Redactions() int {
+	total := 0
+	for _, r := range mux {
+		total += r.Redactions()
+	}
+	return total
+}
+
 // Reset resets all redactors with new needles (secrets)
 func (mux RedactorMux) Reset(needles []string) {
 	for _, r := range mux {
@@ -299,6 +439,18 @@ func (mux RedactorMux) Reset(needles []string) {
 	}
 }
 
+// ResetNamed resets all redactors with new needles (secrets) keyed by the
+// names of the variables they came from
+func (mux RedactorMux) ResetNamed(needles map[string]string) {
+	for _, r := range mux {
+		r.ResetNamed(needles)
+	}
+}
+
+// GetValuesToRedact returns the values of the variables in environment that
+// should be redacted. Only variables with names matching one of the patterns
+// are considered, and values shorter than RedactLengthMin are left alone, so
+// that common values like "true" aren't scrubbed from the rest of the output.
 func GetValuesToRedact(logger shell.Logger, patterns []string, environment map[string]string) []string {
 	var valuesToRedact []string
 	for _, varValue := range GetKeyValuesToRedact(logger, patterns, environment) {
@@ -309,15 +461,16 @@ func GetValuesToRedact(logger shell.Logger, patterns []string, environment map[s
 }
 
 // Given a redaction config string and an environment map, return the list of values to be redacted.
-// Lifted out of Bootstrap.setupRedactors to facilitate testing
+// Lifted out of Bootstrap.setupRedactors to facilitate testing. Variable names
+// are matched against the patterns with MatchVarName.
 func GetKeyValuesToRedact(logger shell.Logger, patterns []string, environment map[string]string) map[string]string {
 	valuesToRedact := make(map[string]string)
 
 	for varName, varValue := range environment {
 		for _, pattern := range patterns {
-			matched, err := path.Match(pattern, varName)
+			matched, err := MatchVarName(pattern, varName)
 			if err != nil {
-				// path.ErrBadPattern is the only error returned by path.Match
+				// path.ErrBadPattern is the only error returned by MatchVarName
 				logger.Warningf("Bad redacted vars pattern: %s", pattern)
 				continue
 			}
@@ -337,3 +490,80 @@ func GetKeyValuesToRedact(logger shell.Logger, patterns []string, environment ma
 
 	return valuesToRedact
 }
+
+// MatchVarName reports whether an environment variable name matches a redacted
+// vars pattern. Patterns are globs in which * matches any run of characters
+// (including none) in any position, so *_TOKEN, AWS_*_KEY and *TOKEN* match
+// suffixes, infixes and substrings alike, and ? matches any single character.
+// Patterns with character classes, like [AB]_SECRET, are matched with
+// path.Match, and a malformed one is an error (path.ErrBadPattern). Every
+// other character only matches itself. Matching is case sensitive, and
+// whitespace around the pattern is ignored.
+func MatchVarName(patternStr, nameStr string) (bool, error) {
+	patternStr = strings.TrimSpace(patternStr)
+	if strings.ContainsRune(patternStr, '[') {
+		return path.Match(patternStr, nameStr)
+	}
+
+	pattern, name := []rune(patternStr), []rune(nameStr)
+
+	// The classic backtracking wildcard match: remember the most recent * and
+	// where in name it started matching, and when we get stuck, let it
+	// swallow one more character
+	p, n := 0, 0
+	star, starN := -1, 0
+	for n < len(name) {
+		switch {
+		case p < len(pattern) && pattern[p] == '*':
+			star, starN = p, n
+			p++
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == name[n]):
+			p++
+			n++
+		case star >= 0:
+			starN++
+			p, n = star+1, starN
+		default:
+			return false, nil
+		}
+	}
+
+	// Any trailing *s match the empty end of the name
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern), nil
+}
+
+// ReadPatternsFile reads redacted vars patterns from a file, one per line.
+// Blank lines, and lines starting with #, are ignored.
+func ReadPatternsFile(filename string) ([]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("opening redacted vars file: %w", err)
+	}
+	defer f.Close()
+
+	return ReadPatterns(f)
+}
+
+// ReadPatterns reads newline-delimited redacted vars patterns from r. Blank
+// lines, and lines starting with #, are ignored.
+func ReadPatterns(r io.Reader) ([]string, error) {
+	var patterns []string
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading redacted vars patterns: %w", err)
+	}
+
+	return patterns, nil
+}